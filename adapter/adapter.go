@@ -4,11 +4,14 @@ import (
 	"net"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/lumavpn/luma/metadata"
 )
 
 // ConnContext is the default interface to adapt connections
 type ConnContext interface {
 	ID() uuid.UUID
+	// Metadata returns the metadata of the session the connection belongs to
+	Metadata() *metadata.Metadata
 }
 
 // TCPConn implements the ConnContext and net.Conn interfaces.
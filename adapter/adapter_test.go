@@ -0,0 +1,76 @@
+package adapter
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTCPConn(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 40000}
+	conn := NewTCPConn(right, &M.Metadata{Host: "example.com", DstPort: 443},
+		WithInType(proto.Inbound_SOCKS5), WithInName("socks-in"), WithSrcAddr(src))
+
+	metadata := conn.Metadata()
+	assert.Equal(t, M.TCP, metadata.Network)
+	assert.Equal(t, proto.Inbound_SOCKS5, metadata.InboundType)
+	assert.Equal(t, "socks-in", metadata.InboundName)
+	assert.Equal(t, "192.168.1.2:40000", metadata.SourceAddress())
+	assert.NotEqual(t, conn.ID(), NewTCPConn(right, &M.Metadata{}).ID())
+}
+
+func TestPacketConn(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}
+	var replies [][]byte
+	writeBack := WriteBackFunc(func(b []byte, addr net.Addr) (int, error) {
+		replies = append(replies, append([]byte(nil), b...))
+		return len(b), nil
+	})
+
+	nat := NewNatTable()
+	conn, created := nat.GetOrCreate("key", func() *PacketConn {
+		return NewPacketConn(&M.Metadata{DstIP: net.ParseIP("1.1.1.1"), DstPort: 53}, nil, client, writeBack)
+	})
+	require.True(t, created)
+	assert.Equal(t, M.UDP, conn.Metadata().Network)
+	assert.Equal(t, "127.0.0.1:50000", conn.Metadata().SourceAddress())
+
+	assert.True(t, conn.Deliver([]byte("query")))
+	buf := make([]byte, 64)
+	n, addr, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+	assert.Equal(t, "1.1.1.1:53", addr.String())
+
+	// packets to another destination are read with it
+	assert.True(t, conn.DeliverTo([]byte("other"), &net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}))
+	n, other, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(buf[:n]))
+	assert.Equal(t, "8.8.8.8:53", other.String())
+
+	_, err = conn.WriteTo([]byte("answer"), addr)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("answer")}, replies)
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = conn.ReadFrom(buf)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	conn.Close()
+	assert.False(t, conn.Deliver([]byte("late")))
+	_, created = nat.GetOrCreate("key", func() *PacketConn {
+		return NewPacketConn(&M.Metadata{}, nil, client, writeBack)
+	})
+	assert.True(t, created)
+}
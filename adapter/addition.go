@@ -0,0 +1,80 @@
+package adapter
+
+import (
+	"net"
+	"net/netip"
+
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+)
+
+// Addition sets information about the inbound a connection was accepted by on its metadata
+type Addition func(metadata *M.Metadata)
+
+// Apply applies the addition to metadata
+func (a Addition) Apply(metadata *M.Metadata) {
+	a(metadata)
+}
+
+// WithInName sets the name of the inbound
+func WithInName(name string) Addition {
+	return func(metadata *M.Metadata) {
+		metadata.InboundName = name
+	}
+}
+
+// WithInType sets the type of the inbound
+func WithInType(t proto.Inbound) Addition {
+	return func(metadata *M.Metadata) {
+		metadata.InboundType = t
+	}
+}
+
+// WithSpecialProxy sets the proxy the session must use, bypassing proxy selection
+func WithSpecialProxy(name string) Addition {
+	return func(metadata *M.Metadata) {
+		metadata.SpecialProxy = name
+	}
+}
+
+// WithSrcAddr sets the source of the session from addr
+func WithSrcAddr(addr net.Addr) Addition {
+	return func(metadata *M.Metadata) {
+		if addrPort, ok := parseAddrPort(addr); ok {
+			metadata.SrcIP = addrPort.Addr().AsSlice()
+			metadata.SrcPort = addrPort.Port()
+		}
+	}
+}
+
+// WithDstAddr sets the destination of the session from addr
+func WithDstAddr(addr net.Addr) Addition {
+	return func(metadata *M.Metadata) {
+		if addrPort, ok := parseAddrPort(addr); ok {
+			metadata.DstIP = addrPort.Addr().AsSlice()
+			metadata.DstPort = addrPort.Port()
+		}
+	}
+}
+
+func parseAddrPort(addr net.Addr) (netip.AddrPort, bool) {
+	if addr == nil {
+		return netip.AddrPort{}, false
+	}
+	var addrPort netip.AddrPort
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		addrPort = a.AddrPort()
+	case *net.UDPAddr:
+		addrPort = a.AddrPort()
+	default:
+		var err error
+		if addrPort, err = netip.ParseAddrPort(addr.String()); err != nil {
+			return netip.AddrPort{}, false
+		}
+	}
+	if !addrPort.IsValid() {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()), true
+}
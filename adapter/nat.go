@@ -0,0 +1,52 @@
+package adapter
+
+import "sync"
+
+// NatTable maps the clients of a UDP inbound to their sessions
+type NatTable struct {
+	mu       sync.Mutex
+	sessions map[string]*PacketConn
+}
+
+// NewNatTable returns a new NatTable
+func NewNatTable() *NatTable {
+	return &NatTable{sessions: make(map[string]*PacketConn)}
+}
+
+// GetOrCreate returns the session of key, creating it with create if there is none. It
+// reports whether the session was created. The session is removed from the table once closed
+func (t *NatTable) GetOrCreate(key string, create func() *PacketConn) (*PacketConn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if conn, ok := t.sessions[key]; ok {
+		return conn, false
+	}
+	conn := create()
+	conn.onClose = func() {
+		t.Delete(key)
+	}
+	t.sessions[key] = conn
+	return conn, true
+}
+
+// Delete removes the session of key
+func (t *NatTable) Delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sessions, key)
+}
+
+// Range calls f for each session until it returns false
+func (t *NatTable) Range(f func(key string, conn *PacketConn) bool) {
+	t.mu.Lock()
+	sessions := make(map[string]*PacketConn, len(t.sessions))
+	for k, v := range t.sessions {
+		sessions[k] = v
+	}
+	t.mu.Unlock()
+	for k, v := range sessions {
+		if !f(k, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package adapter
+
+import (
+	"net"
+
+	"github.com/gofrs/uuid/v5"
+	M "github.com/lumavpn/luma/metadata"
+)
+
+type tcpConn struct {
+	net.Conn
+	id       uuid.UUID
+	metadata *M.Metadata
+}
+
+// NewTCPConn wraps a connection accepted by an inbound with the metadata of its session. The
+// source defaults to the remote address of conn and can be overridden with additions
+func NewTCPConn(conn net.Conn, metadata *M.Metadata, additions ...Addition) TCPConn {
+	metadata.Network = M.TCP
+	WithSrcAddr(conn.RemoteAddr()).Apply(metadata)
+	for _, addition := range additions {
+		addition.Apply(metadata)
+	}
+	return &tcpConn{
+		Conn:     conn,
+		id:       uuid.Must(uuid.NewV4()),
+		metadata: metadata,
+	}
+}
+
+func (c *tcpConn) ID() uuid.UUID {
+	return c.id
+}
+
+func (c *tcpConn) Metadata() *M.Metadata {
+	return c.metadata
+}
@@ -0,0 +1,241 @@
+package adapter
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	M "github.com/lumavpn/luma/metadata"
+)
+
+// udpQueueSize is the number of packets buffered per session before new ones are dropped
+const udpQueueSize = 64
+
+// WriteBack sends the replies of a UDP session to its client. It is closed with the session if
+// it implements io.Closer
+type WriteBack interface {
+	// WriteBack sends b to the client as a reply from addr
+	WriteBack(b []byte, addr net.Addr) (n int, err error)
+}
+
+// WriteBackFunc is a function implementing WriteBack
+type WriteBackFunc func(b []byte, addr net.Addr) (int, error)
+
+func (f WriteBackFunc) WriteBack(b []byte, addr net.Addr) (int, error) {
+	return f(b, addr)
+}
+
+// PacketConn is a UDPConn for the session of one client. The inbound delivers the packets of
+// the client to it and replies go back to the client through WriteBack
+type PacketConn struct {
+	id         uuid.UUID
+	metadata   *M.Metadata
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	writeBack  WriteBack
+	onClose    func()
+
+	packets      chan packet
+	done         chan struct{}
+	closeOnce    sync.Once
+	readDeadline *deadline
+}
+
+// NewPacketConn returns a new UDP session of the client at remoteAddr, accepted by an inbound
+// listening on localAddr. The source defaults to remoteAddr and can be overridden with additions
+func NewPacketConn(metadata *M.Metadata, localAddr, remoteAddr net.Addr, writeBack WriteBack, additions ...Addition) *PacketConn {
+	metadata.Network = M.UDP
+	WithSrcAddr(remoteAddr).Apply(metadata)
+	for _, addition := range additions {
+		addition.Apply(metadata)
+	}
+	return &PacketConn{
+		id:           uuid.Must(uuid.NewV4()),
+		metadata:     metadata,
+		localAddr:    localAddr,
+		remoteAddr:   remoteAddr,
+		writeBack:    writeBack,
+		packets:      make(chan packet, udpQueueSize),
+		done:         make(chan struct{}),
+		readDeadline: newDeadline(),
+	}
+}
+
+// packet is a packet sent by the client
+type packet struct {
+	data []byte
+	// destination is where the client sent the packet, the destination of the session if nil
+	destination net.Addr
+}
+
+// Deliver queues a packet sent by the client to the destination of the session. It reports
+// false if the packet was dropped
+func (c *PacketConn) Deliver(b []byte) bool {
+	return c.DeliverTo(b, nil)
+}
+
+// DeliverTo queues a packet sent by the client to destination, which may differ from the
+// destination of the session. It reports false if the packet was dropped
+func (c *PacketConn) DeliverTo(b []byte, destination net.Addr) bool {
+	p := packet{data: make([]byte, len(b)), destination: destination}
+	copy(p.data, b)
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.packets <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *PacketConn) ID() uuid.UUID {
+	return c.id
+}
+
+func (c *PacketConn) Metadata() *M.Metadata {
+	return c.metadata
+}
+
+// ReadFrom reads the next packet of the client. The returned address is the destination of the
+// packet
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.packets:
+		if p.destination != nil {
+			return copy(b, p.data), p.destination, nil
+		}
+		return copy(b, p.data), c.dstAddr(), nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *PacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// WriteTo sends b to the client as a reply from addr
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.writeBack.WriteBack(b, addr)
+}
+
+func (c *PacketConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.dstAddr())
+}
+
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if closer, ok := c.writeBack.(io.Closer); ok {
+			closer.Close()
+		}
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *PacketConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline is a no-op, writes never block on the session
+func (c *PacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *PacketConn) dstAddr() net.Addr {
+	if addr := c.metadata.UDPAddr(); addr != nil {
+		return addr
+	}
+	return nil
+}
+
+// deadline is a read deadline that can be waited on, modelled on the one of net.Pipe
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline. A zero t clears it
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		d.timer = time.AfterFunc(dur, func() {
+			close(d.cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline is exceeded
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Authenticator verifies the credentials of inbound clients
+type Authenticator interface {
+	Verify(user string, pass string) bool
+	Users() []string
+}
+
+// AuthUser is a user and its password
+type AuthUser struct {
+	User string
+	Pass string
+}
+
+type inMemoryAuthenticator struct {
+	storage   *sync.Map
+	usernames []string
+}
+
+func (au *inMemoryAuthenticator) Verify(user string, pass string) bool {
+	realPass, ok := au.storage.Load(user)
+	return ok && realPass == pass
+}
+
+func (au *inMemoryAuthenticator) Users() []string { return au.usernames }
+
+// NewAuthenticator returns an Authenticator for the given users, or nil if there are none
+func NewAuthenticator(users []AuthUser) Authenticator {
+	if len(users) == 0 {
+		return nil
+	}
+
+	au := &inMemoryAuthenticator{storage: &sync.Map{}}
+	for _, user := range users {
+		au.storage.Store(user.User, user.Pass)
+	}
+	usernames := make([]string, 0, len(users))
+	au.storage.Range(func(key, value any) bool {
+		usernames = append(usernames, key.(string))
+		return true
+	})
+	au.usernames = usernames
+
+	return au
+}
+
+// ParseAuthUsers parses users of the form user:pass
+func ParseAuthUsers(users []string) ([]AuthUser, error) {
+	authUsers := make([]AuthUser, 0, len(users))
+	for _, s := range users {
+		user, pass, ok := strings.Cut(s, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid authentication %q, expected user:pass", s)
+		}
+		authUsers = append(authUsers, AuthUser{User: user, Pass: pass})
+	}
+	return authUsers, nil
+}
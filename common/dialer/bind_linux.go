@@ -0,0 +1,20 @@
+//go:build linux
+
+package dialer
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func bindToInterface(c syscall.RawConn, iface string) error {
+	var innerErr error
+	err := c.Control(func(fd uintptr) {
+		innerErr = unix.BindToDevice(int(fd), iface)
+	})
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package dialer
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindToInterface(c syscall.RawConn, iface string) error {
+	return errors.New("interface binding not supported on current platform")
+}
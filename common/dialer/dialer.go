@@ -0,0 +1,331 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lumavpn/luma/common/atomic"
+)
+
+var (
+	// defaultInterface is the interface outbound sockets are bound to, empty for none
+	defaultInterface atomic.TypedValue[string]
+	// defaultSettings are the settings of sockets not overridden by a dialer
+	defaultSettings atomic.TypedValue[settings]
+	// lookupIP resolves the hosts of dialed addresses
+	lookupIP atomic.TypedValue[func(ctx context.Context, host string) ([]netip.Addr, error)]
+)
+
+var ErrNoAddress = errors.New("no address to dial")
+
+// Options are the settings of outbound sockets
+type Options struct {
+	// Interface binds sockets to the interface, overriding the default interface
+	Interface string `yaml:"interface-name"`
+	// RoutingMark sets the SO_MARK of sockets, Linux only
+	RoutingMark int `yaml:"routing-mark"`
+	// SourceAddress is the local address of the sockets of its family
+	SourceAddress string `yaml:"source-address"`
+	// IPVersion selects the addresses of a host which are dialed: dual, ipv4, ipv6,
+	// ipv4-prefer or ipv6-prefer. Dual races the families in the order they are resolved
+	IPVersion string `yaml:"ip-version"`
+	// TCPKeepAlive is how many seconds a TCP connection is idle before keepalive probes are
+	// sent, disabling them if negative
+	TCPKeepAlive int `yaml:"tcp-keep-alive"`
+	// TCPKeepAliveInterval is how many seconds separate keepalive probes
+	TCPKeepAliveInterval int `yaml:"tcp-keep-alive-interval"`
+}
+
+// Validate checks that the options are valid
+func (o Options) Validate() error {
+	_, err := o.settings()
+	return err
+}
+
+// settings returns the parsed options
+func (o Options) settings() (settings, error) {
+	s := settings{
+		iface:             o.Interface,
+		mark:              o.RoutingMark,
+		keepAlive:         time.Duration(o.TCPKeepAlive) * time.Second,
+		keepAliveInterval: time.Duration(o.TCPKeepAliveInterval) * time.Second,
+	}
+	if o.RoutingMark < 0 {
+		return s, fmt.Errorf("invalid routing-mark %d", o.RoutingMark)
+	}
+	if o.TCPKeepAliveInterval < 0 {
+		return s, fmt.Errorf("invalid tcp-keep-alive-interval %d", o.TCPKeepAliveInterval)
+	}
+	if o.SourceAddress != "" {
+		source, err := netip.ParseAddr(o.SourceAddress)
+		if err != nil {
+			return s, fmt.Errorf("invalid source-address %q", o.SourceAddress)
+		}
+		s.source = source.Unmap()
+	}
+	preference, err := parseIPPreference(o.IPVersion)
+	if err != nil {
+		return s, err
+	}
+	s.preference = preference
+	return s, nil
+}
+
+// ipPreference selects the address families dialed
+type ipPreference int
+
+const (
+	preferenceUnset ipPreference = iota
+	dualStack
+	ipv4Only
+	ipv6Only
+	preferIPv4
+	preferIPv6
+)
+
+func parseIPPreference(s string) (ipPreference, error) {
+	switch s {
+	case "":
+		return preferenceUnset, nil
+	case "dual":
+		return dualStack, nil
+	case "ipv4":
+		return ipv4Only, nil
+	case "ipv6":
+		return ipv6Only, nil
+	case "ipv4-prefer":
+		return preferIPv4, nil
+	case "ipv6-prefer":
+		return preferIPv6, nil
+	default:
+		return preferenceUnset, fmt.Errorf("invalid ip-version %q", s)
+	}
+}
+
+// settings are the parsed Options, zero values are unset
+type settings struct {
+	iface             string
+	mark              int
+	source            netip.Addr
+	preference        ipPreference
+	keepAlive         time.Duration
+	keepAliveInterval time.Duration
+}
+
+// merge returns the settings with the unset values taken from base
+func (s settings) merge(base settings) settings {
+	if s.iface == "" {
+		s.iface = base.iface
+	}
+	if s.mark == 0 {
+		s.mark = base.mark
+	}
+	if !s.source.IsValid() {
+		s.source = base.source
+	}
+	if s.preference == preferenceUnset {
+		s.preference = base.preference
+	}
+	if s.keepAlive == 0 {
+		s.keepAlive = base.keepAlive
+	}
+	if s.keepAliveInterval == 0 {
+		s.keepAliveInterval = base.keepAliveInterval
+	}
+	return s
+}
+
+// SetDefaultInterface binds the sockets opened afterwards to the interface name. An empty name
+// removes the binding
+func SetDefaultInterface(name string) {
+	defaultInterface.Store(name)
+}
+
+// DefaultInterface returns the interface outbound sockets are bound to
+func DefaultInterface() string {
+	return defaultInterface.Load()
+}
+
+// SetDefaultOptions sets the options of the sockets opened afterwards, which dialers can
+// override. Its interface takes precedence over the default interface
+func SetDefaultOptions(options Options) error {
+	s, err := options.settings()
+	if err != nil {
+		return err
+	}
+	defaultSettings.Store(s)
+	return nil
+}
+
+// SetResolver sets the function resolving the hosts of dialed addresses. A nil function falls
+// back to the system resolver
+func SetResolver(lookup func(ctx context.Context, host string) ([]netip.Addr, error)) {
+	lookupIP.Store(lookup)
+}
+
+// Dialer opens outbound sockets with its options, falling back to the default options
+type Dialer struct {
+	settings settings
+}
+
+// New returns a dialer with options
+func New(options Options) (*Dialer, error) {
+	s, err := options.settings()
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer{settings: s}, nil
+}
+
+// DialContext connects to address on the named network with the default options
+func DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return (&Dialer{}).DialContext(ctx, network, address)
+}
+
+// ListenPacket announces on the local network address with the default options
+func ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	return (&Dialer{}).ListenPacket(ctx, network, address)
+}
+
+// effective returns the settings of the dialer merged with the defaults
+func (d *Dialer) effective() settings {
+	s := d.settings.merge(defaultSettings.Load())
+	if s.iface == "" {
+		s.iface = DefaultInterface()
+	}
+	return s
+}
+
+// DialContext connects to address on the named network like net.Dialer.DialContext. The
+// host of address is resolved and its addresses are dialed according to the IP version
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	s := d.effective()
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	addrs, err := d.lookup(ctx, host, s.preference)
+	if err != nil {
+		return nil, err
+	}
+	return dialParallel(ctx, addrs, func(ctx context.Context, addr netip.Addr) (net.Conn, error) {
+		return s.dialer(network, addr).DialContext(ctx, network, netip.AddrPortFrom(addr, uint16(portNum)).String())
+	})
+}
+
+// ListenPacket announces on the local network address like net.ListenConfig.ListenPacket,
+// on the source address if address has no host
+func (d *Dialer) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	s := d.effective()
+	if network == "udp" {
+		switch {
+		case s.preference == ipv4Only, s.source.Is4():
+			network = "udp4"
+		case s.preference == ipv6Only, s.source.Is6():
+			network = "udp6"
+		}
+	}
+	if s.source.IsValid() {
+		if host, port, err := net.SplitHostPort(address); err == nil && host == "" {
+			address = net.JoinHostPort(s.source.String(), port)
+		} else if address == "" {
+			address = net.JoinHostPort(s.source.String(), "0")
+		}
+	}
+	lc := net.ListenConfig{Control: s.control()}
+	return lc.ListenPacket(ctx, network, address)
+}
+
+// Resolve returns the first address of host dialed according to the IP version
+func (d *Dialer) Resolve(ctx context.Context, host string) (netip.Addr, error) {
+	addrs, err := d.lookup(ctx, host, d.effective().preference)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addrs[0], nil
+}
+
+// lookup returns the addresses of host allowed by preference, the preferred ones first
+func (d *Dialer) lookup(ctx context.Context, host string, preference ipPreference) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{ip.Unmap()}
+	} else if lookup := lookupIP.Load(); lookup != nil {
+		if addrs, err = lookup(ctx, host); err != nil {
+			return nil, err
+		}
+	} else {
+		if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+			return nil, err
+		}
+	}
+
+	var primary, fallback []netip.Addr
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		switch {
+		case preference == ipv4Only && !addr.Is4(), preference == ipv6Only && !addr.Is6():
+		case preference == preferIPv4 && !addr.Is4(), preference == preferIPv6 && !addr.Is6():
+			fallback = append(fallback, addr)
+		default:
+			primary = append(primary, addr)
+		}
+	}
+	if addrs = append(primary, fallback...); len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoAddress, host)
+	}
+	return addrs, nil
+}
+
+// dialer returns the net.Dialer of the settings connecting to addr on network
+func (s settings) dialer(network string, addr netip.Addr) *net.Dialer {
+	d := &net.Dialer{Control: s.control()}
+	if s.source.IsValid() && s.source.Is4() == addr.Is4() {
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: s.source.AsSlice()}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: s.source.AsSlice()}
+		}
+	}
+	if s.keepAlive < 0 {
+		d.KeepAlive = -1
+	} else if s.keepAlive > 0 || s.keepAliveInterval > 0 {
+		d.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     s.keepAlive,
+			Interval: s.keepAliveInterval,
+		}
+	}
+	return d
+}
+
+// control returns the socket control function applying the settings, nil if there is none
+func (s settings) control() func(network, address string, c syscall.RawConn) error {
+	if s.iface == "" && s.mark == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if s.iface != "" {
+			if err := bindToInterface(c, s.iface); err != nil {
+				return err
+			}
+		}
+		if s.mark != 0 {
+			if err := setRoutingMark(c, s.mark); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,140 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	for _, options := range []Options{
+		{RoutingMark: -1},
+		{SourceAddress: "localhost"},
+		{IPVersion: "ipv5"},
+		{TCPKeepAliveInterval: -1},
+	} {
+		assert.Error(t, options.Validate(), "%+v", options)
+	}
+
+	require.NoError(t, SetDefaultOptions(Options{Interface: "eth0", RoutingMark: 1, IPVersion: "ipv4"}))
+	t.Cleanup(func() { SetDefaultOptions(Options{}) })
+	d, err := New(Options{RoutingMark: 2, SourceAddress: "::ffff:10.0.0.1", TCPKeepAlive: -1})
+	require.NoError(t, err)
+	assert.Equal(t, settings{
+		iface:      "eth0",
+		mark:       2,
+		source:     netip.MustParseAddr("10.0.0.1"),
+		preference: ipv4Only,
+		keepAlive:  -time.Second,
+	}, d.effective())
+}
+
+func TestLookup(t *testing.T) {
+	v4, v6 := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1")
+	SetResolver(func(context.Context, string) ([]netip.Addr, error) {
+		return []netip.Addr{v4, v6}, nil
+	})
+	t.Cleanup(func() { SetResolver(nil) })
+
+	for _, test := range []struct {
+		ipVersion string
+		addrs     []netip.Addr
+	}{
+		{"", []netip.Addr{v4, v6}},
+		{"dual", []netip.Addr{v4, v6}},
+		{"ipv4", []netip.Addr{v4}},
+		{"ipv6", []netip.Addr{v6}},
+		{"ipv4-prefer", []netip.Addr{v4, v6}},
+		{"ipv6-prefer", []netip.Addr{v6, v4}},
+	} {
+		d, err := New(Options{IPVersion: test.ipVersion})
+		require.NoError(t, err)
+		addrs, err := d.lookup(context.Background(), "example.com", d.effective().preference)
+		require.NoError(t, err)
+		assert.Equal(t, test.addrs, addrs, test.ipVersion)
+	}
+
+	d, err := New(Options{IPVersion: "ipv6"})
+	require.NoError(t, err)
+	_, err = d.Resolve(context.Background(), "127.0.0.1")
+	assert.ErrorIs(t, err, ErrNoAddress)
+}
+
+func TestDialParallel(t *testing.T) {
+	v4, v6 := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1")
+	errUnreachable := errors.New("unreachable")
+
+	// the preferred family hangs, the other one is raced after the fallback delay
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), []netip.Addr{v6, v4}, func(ctx context.Context, addr netip.Addr) (net.Conn, error) {
+		if addr.Is6() {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	})
+	require.NoError(t, err)
+	conn.Close()
+	assert.GreaterOrEqual(t, time.Since(start), fallbackDelay)
+
+	// the preferred family fails, the other one is dialed right away
+	start = time.Now()
+	var dialed []netip.Addr
+	conn, err = dialParallel(context.Background(), []netip.Addr{v6, v4}, func(ctx context.Context, addr netip.Addr) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if addr.Is6() {
+			return nil, errUnreachable
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	})
+	require.NoError(t, err)
+	conn.Close()
+	assert.Less(t, time.Since(start), fallbackDelay)
+	assert.Equal(t, []netip.Addr{v6, v4}, dialed)
+
+	_, err = dialParallel(context.Background(), []netip.Addr{v4}, func(context.Context, netip.Addr) (net.Conn, error) {
+		return nil, errUnreachable
+	})
+	assert.ErrorIs(t, err, errUnreachable)
+}
+
+func TestSourceAddress(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only a loopback address on linux")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	d, err := New(Options{SourceAddress: "127.0.0.2", TCPKeepAlive: 30, TCPKeepAliveInterval: 5})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "127.0.0.2", conn.LocalAddr().(*net.TCPAddr).IP.String())
+
+	pc, err := d.ListenPacket(ctx, "udp", "")
+	require.NoError(t, err)
+	defer pc.Close()
+	assert.Equal(t, "127.0.0.2", pc.LocalAddr().(*net.UDPAddr).IP.String())
+}
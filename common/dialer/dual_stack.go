@@ -0,0 +1,101 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// fallbackDelay is how long the first address family is dialed alone before the other one
+// races it, as recommended by RFC 8305
+const fallbackDelay = 300 * time.Millisecond
+
+// dialParallel dials addrs, the preferred ones first, with dial. The addresses of the other
+// family than the first one are raced after fallbackDelay, or as soon as the first family
+// fails, and the first connection established is returned
+func dialParallel(ctx context.Context, addrs []netip.Addr, dial func(ctx context.Context, addr netip.Addr) (net.Conn, error)) (net.Conn, error) {
+	var primary, fallback []netip.Addr
+	for _, addr := range addrs {
+		if addr.Is4() == addrs[0].Is4() {
+			primary = append(primary, addr)
+		} else {
+			fallback = append(fallback, addr)
+		}
+	}
+	if len(fallback) == 0 {
+		return dialSerial(ctx, primary, dial)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan result)
+	start := func(addrs []netip.Addr, primary bool) {
+		conn, err := dialSerial(ctx, addrs, dial)
+		select {
+		case results <- result{conn, err, primary}:
+		case <-ctx.Done():
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	go start(primary, true)
+
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+	var firstErr error
+	for pending, fallbackStarted := 1, false; ; {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go start(fallback, false)
+			}
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			pending--
+			if firstErr == nil || res.primary {
+				firstErr = res.err
+			}
+			if !fallbackStarted {
+				// the preferred family failed, try the other one right away
+				fallbackStarted = true
+				pending++
+				go start(fallback, false)
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial dials addrs in order and returns the first connection established
+func dialSerial(ctx context.Context, addrs []netip.Addr, dial func(ctx context.Context, addr netip.Addr) (net.Conn, error)) (net.Conn, error) {
+	var firstErr error
+	for _, addr := range addrs {
+		conn, err := dial(ctx, addr)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = ErrNoAddress
+	}
+	return nil, firstErr
+}
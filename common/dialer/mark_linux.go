@@ -0,0 +1,20 @@
+//go:build linux
+
+package dialer
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func setRoutingMark(c syscall.RawConn, mark int) error {
+	var innerErr error
+	err := c.Control(func(fd uintptr) {
+		innerErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, mark)
+	})
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
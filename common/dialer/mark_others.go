@@ -0,0 +1,12 @@
+//go:build !linux
+
+package dialer
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setRoutingMark(c syscall.RawConn, mark int) error {
+	return errors.New("routing mark not supported on current platform")
+}
@@ -0,0 +1,58 @@
+package geoip
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Reader looks up the country of IP addresses in a MaxMind (mmdb) country database. It is safe
+// to close a Reader while lookups are running
+type Reader struct {
+	mu     sync.RWMutex
+	reader *maxminddb.Reader
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Open opens the mmdb database at path
+func Open(path string) (*Reader, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{reader: reader}, nil
+}
+
+// LookupCode returns the lowercase ISO 3166 country code of ip, or an empty string
+// if ip is not in the database or the Reader is closed
+func (r *Reader) LookupCode(ip netip.Addr) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.reader == nil {
+		return ""
+	}
+	var record countryRecord
+	if err := r.reader.Lookup(ip.Unmap()).Decode(&record); err != nil {
+		return ""
+	}
+	return strings.ToLower(record.Country.ISOCode)
+}
+
+// Close releases the database
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
@@ -0,0 +1,128 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeDatabase writes an IPv4 country database mapping each prefix to an ISO code and returns
+// its path
+func writeDatabase(t *testing.T, countries map[string]string) string {
+	type node struct{ children [2]*node }
+	root := &node{}
+	leaves := map[*node]string{}
+	for prefix, code := range countries {
+		p := netip.MustParsePrefix(prefix)
+		ip := p.Addr().As4()
+		n := root
+		for i := 0; i < p.Bits(); i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &node{}
+			}
+			n = n.children[bit]
+		}
+		leaves[n] = code
+	}
+
+	// number the inner nodes breadth first, the root being node 0
+	var nodes []*node
+	index := map[*node]int{}
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			if child != nil && leaves[child] == "" {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var data []byte
+	record := func(child *node) uint32 {
+		switch {
+		case child == nil:
+			return uint32(len(nodes))
+		case leaves[child] != "":
+			offset := len(data)
+			data = appendCountry(data, leaves[child])
+			return uint32(len(nodes) + 16 + offset)
+		default:
+			return uint32(index[child])
+		}
+	}
+	var tree []byte
+	for _, n := range nodes {
+		for _, child := range n.children {
+			r := record(child)
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	db := append(tree, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = append(db, 0xe5) // map of 5 entries
+	db = appendString(db, "node_count")
+	db = append(db, 0xc4)
+	db = binary.BigEndian.AppendUint32(db, uint32(len(nodes)))
+	db = appendString(db, "record_size")
+	db = append(db, 0xa1, 24)
+	db = appendString(db, "ip_version")
+	db = append(db, 0xa1, 4)
+	db = appendString(db, "binary_format_major_version")
+	db = append(db, 0xa1, 2)
+	db = appendString(db, "database_type")
+	db = appendString(db, "Test-Country")
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	require.NoError(t, os.WriteFile(path, db, 0o600))
+	return path
+}
+
+// appendCountry appends the record {"country": {"iso_code": code}}
+func appendCountry(b []byte, code string) []byte {
+	b = append(b, 0xe1)
+	b = appendString(b, "country")
+	b = append(b, 0xe1)
+	b = appendString(b, "iso_code")
+	return appendString(b, code)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(append(b, 0x40|byte(len(s))), s...)
+}
+
+func TestLookupCode(t *testing.T) {
+	reader, err := Open(writeDatabase(t, map[string]string{
+		"1.0.0.0/8":  "CN",
+		"8.8.8.0/24": "US",
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "cn", reader.LookupCode(netip.MustParseAddr("1.2.3.4")))
+	assert.Equal(t, "us", reader.LookupCode(netip.MustParseAddr("8.8.8.8")))
+	assert.Equal(t, "us", reader.LookupCode(netip.MustParseAddr("::ffff:8.8.8.8")))
+	assert.Empty(t, reader.LookupCode(netip.MustParseAddr("8.8.4.4")))
+
+	require.NoError(t, reader.Close())
+	assert.Empty(t, reader.LookupCode(netip.MustParseAddr("1.2.3.4")))
+	assert.NoError(t, reader.Close())
+}
+
+func TestOpenInvalid(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o600))
+	_, err = Open(path)
+	assert.Error(t, err)
+}
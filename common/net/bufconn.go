@@ -0,0 +1,39 @@
+package net
+
+import (
+	"bufio"
+	"net"
+)
+
+// BufferedConn is a net.Conn whose first bytes can be peeked without consuming them
+type BufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewBufferedConn returns a BufferedConn reading from c. c is returned as is if it already is one
+func NewBufferedConn(c net.Conn) *BufferedConn {
+	if bc, ok := c.(*BufferedConn); ok {
+		return bc
+	}
+	return &BufferedConn{Conn: c, r: bufio.NewReader(c)}
+}
+
+// Reader returns the buffered reader of the connection
+func (c *BufferedConn) Reader() *bufio.Reader {
+	return c.r
+}
+
+// Peek returns the next n bytes without advancing the reader
+func (c *BufferedConn) Peek(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
+
+func (c *BufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Buffered returns the number of bytes that can be read from the buffer
+func (c *BufferedConn) Buffered() int {
+	return c.r.Buffered()
+}
@@ -0,0 +1,33 @@
+package net
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lumavpn/luma/log"
+)
+
+// maxAcceptDelay is the longest a listener waits before accepting again after an error
+const maxAcceptDelay = time.Second
+
+// Serve accepts connections on l and runs handle on each of them in a new goroutine until l is
+// closed. Other accept errors, such as running out of file descriptors, are retried with an
+// increasing delay
+func Serve(l net.Listener, handle func(net.Conn)) {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+			log.Debugf("accept on %s: %v, retrying in %s", l.Addr(), err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go handle(c)
+	}
+}
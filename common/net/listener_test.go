@@ -0,0 +1,85 @@
+package net
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingListener fails every Accept with err until it is closed
+type failingListener struct {
+	net.Listener
+	err     error
+	accepts atomic.Int32
+	closed  atomic.Bool
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	if l.closed.Load() {
+		return nil, net.ErrClosed
+	}
+	return nil, l.err
+}
+
+func (l *failingListener) Close() error {
+	l.closed.Store(true)
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		Serve(l, func(c net.Conn) {
+			c.Write([]byte("hello"))
+			c.Close()
+		})
+		close(done)
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = c.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	l.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the listener was closed")
+	}
+}
+
+func TestServeBackoff(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tl.Close()
+	l := &failingListener{Listener: tl, err: errors.New("too many open files")}
+
+	done := make(chan struct{})
+	go func() {
+		Serve(l, func(c net.Conn) {})
+		close(done)
+	}()
+
+	// 5ms, 10ms, 20ms, 40ms... leave room for a handful of attempts only
+	time.Sleep(100 * time.Millisecond)
+	assert.Less(t, l.accepts.Load(), int32(10))
+
+	l.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after the listener was closed")
+	}
+}
@@ -0,0 +1,149 @@
+// Package tls is the client TLS configuration shared by the outbounds using TLS
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// echVersion is the version of the ECHConfig structures crypto/tls supports
+const echVersion = 0xfe0d
+
+var ErrInvalidECHConfig = errors.New("invalid ech config")
+
+// Option is the TLS configuration of an outbound
+type Option struct {
+	// SNI is the server name of the TLS handshake, the server if empty
+	SNI  string   `yaml:"sni"`
+	ALPN []string `yaml:"alpn"`
+	// Fingerprint is the SHA256 fingerprint of the server certificate, which is then trusted
+	// instead of verifying its chain
+	Fingerprint    string `yaml:"fingerprint"`
+	SkipCertVerify bool   `yaml:"skip-cert-verify"`
+	// ClientFingerprint is the browser whose ClientHello is mimicked, none if empty
+	ClientFingerprint string    `yaml:"client-fingerprint"`
+	ECHOpts           ECHOption `yaml:"ech-opts"`
+}
+
+// ECHOption is the Encrypted ClientHello configuration of an outbound
+type ECHOption struct {
+	Enable bool `yaml:"enable"`
+	// Config is the base64 encoded ECHConfigList of the server. If empty, it is looked up in
+	// the HTTPS record of the server name with the dns resolver
+	Config string `yaml:"config"`
+}
+
+// Validate checks that the option is valid
+func (o Option) Validate() error {
+	if o.Fingerprint != "" {
+		if _, err := VerifyFingerprint(o.Fingerprint); err != nil {
+			return err
+		}
+	}
+	if err := validateClientFingerprint(o.ClientFingerprint); err != nil {
+		return err
+	}
+	if o.ECHOpts.Enable && o.ClientHelloID() != nil {
+		return errors.New("client-fingerprint cannot be used with ech")
+	}
+	if o.ECHOpts.Enable && o.ECHOpts.Config != "" {
+		if _, err := o.ECHOpts.ConfigList(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClientConfig returns the client TLS configuration of the option for server, negotiating
+// alpn unless the option has its own ALPN. The ECHConfigList of the server must be set on it
+// if ECH is enabled without a config
+func (o Option) ClientConfig(server string, alpn []string) (*tls.Config, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		ServerName:         o.SNI,
+		NextProtos:         o.ALPN,
+		InsecureSkipVerify: o.SkipCertVerify,
+	}
+	if config.ServerName == "" {
+		config.ServerName = server
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = alpn
+	}
+	if o.Fingerprint != "" {
+		verify, _ := VerifyFingerprint(o.Fingerprint)
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verify
+	}
+	if o.ECHOpts.Enable {
+		// ECH is only defined for TLS 1.3
+		config.MinVersion = tls.VersionTLS13
+		if o.ECHOpts.Config != "" {
+			config.EncryptedClientHelloConfigList, _ = o.ECHOpts.ConfigList()
+		}
+	}
+	return config, nil
+}
+
+// ConfigList returns the decoded ECHConfigList of the option
+func (o ECHOption) ConfigList() ([]byte, error) {
+	list, err := base64.StdEncoding.DecodeString(o.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidECHConfig, err)
+	}
+	if err := CheckECHConfigList(list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CheckECHConfigList checks that list is a well formed ECHConfigList with a config of the
+// version crypto/tls supports
+func CheckECHConfigList(list []byte) error {
+	if len(list) < 2 || int(binary.BigEndian.Uint16(list)) != len(list)-2 {
+		return fmt.Errorf("%w: bad length", ErrInvalidECHConfig)
+	}
+	supported := false
+	for b := list[2:]; len(b) > 0; {
+		if len(b) < 4 {
+			return fmt.Errorf("%w: truncated config", ErrInvalidECHConfig)
+		}
+		version, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+length {
+			return fmt.Errorf("%w: truncated config", ErrInvalidECHConfig)
+		}
+		supported = supported || version == echVersion
+		b = b[4+length:]
+	}
+	if !supported {
+		return fmt.Errorf("%w: no config of version %#x", ErrInvalidECHConfig, echVersion)
+	}
+	return nil
+}
+
+// VerifyFingerprint returns a certificate verification function accepting only the leaf
+// certificate with the hex encoded SHA256 fingerprint, which may be separated by colons
+func VerifyFingerprint(fingerprint string) (func([][]byte, [][]*x509.Certificate) error, error) {
+	expected, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(expected) != sha256.Size {
+		return nil, fmt.Errorf("invalid fingerprint %q", fingerprint)
+	}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no server certificate")
+		}
+		if hash := sha256.Sum256(rawCerts[0]); string(hash[:]) != string(expected) {
+			return fmt.Errorf("server certificate fingerprint %x does not match", hash)
+		}
+		return nil
+	}, nil
+}
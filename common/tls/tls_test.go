@@ -0,0 +1,80 @@
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	// an ECHConfigList holding a config of an unsupported version and an empty supported one
+	list := []byte{0, 8, 0xfe, 0x0a, 0, 0, 0xfe, 0x0d, 0, 0}
+	for _, test := range []struct {
+		name   string
+		option Option
+		err    error
+	}{
+		{"empty", Option{}, nil},
+		{"none", Option{ClientFingerprint: "none"}, nil},
+		{"chrome", Option{ClientFingerprint: "Chrome"}, nil},
+		{"random", Option{ClientFingerprint: "random"}, nil},
+		{"ech", Option{ECHOpts: ECHOption{Enable: true, Config: base64.StdEncoding.EncodeToString(list)}}, nil},
+		{"ech lookup", Option{ECHOpts: ECHOption{Enable: true}}, nil},
+		{"ech not base64", Option{ECHOpts: ECHOption{Enable: true, Config: "!"}}, ErrInvalidECHConfig},
+		{"ech bad length", Option{ECHOpts: ECHOption{Enable: true, Config: base64.StdEncoding.EncodeToString(list[:9])}}, ErrInvalidECHConfig},
+		{"ech unsupported", Option{ECHOpts: ECHOption{Enable: true, Config: base64.StdEncoding.EncodeToString([]byte{0, 4, 0xfe, 0x0a, 0, 0})}}, ErrInvalidECHConfig},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.option.Validate()
+			if test.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+	assert.ErrorContains(t, Option{Fingerprint: "abcd"}.Validate(), "invalid fingerprint")
+	assert.ErrorContains(t, Option{ClientFingerprint: "netscape"}.Validate(), "unknown client-fingerprint")
+	assert.ErrorContains(t, Option{ClientFingerprint: "chrome", ECHOpts: ECHOption{Enable: true}}.Validate(), "cannot be used with ech")
+}
+
+func TestClientHelloID(t *testing.T) {
+	assert.Nil(t, Option{}.ClientHelloID())
+	assert.Nil(t, Option{ClientFingerprint: "none"}.ClientHelloID())
+	id := Option{ClientFingerprint: "Firefox"}.ClientHelloID()
+	require.NotNil(t, id)
+	assert.Equal(t, clientFingerprints["firefox"], *id)
+	assert.NotNil(t, Option{ClientFingerprint: "random"}.ClientHelloID())
+}
+
+func TestClientConfig(t *testing.T) {
+	config, err := Option{ALPN: []string{"http/1.1"}, ECHOpts: ECHOption{Enable: true}}.ClientConfig("example.com", []string{"h2"})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", config.ServerName)
+	assert.Equal(t, []string{"http/1.1"}, config.NextProtos)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+
+	config, err = Option{SNI: "sni.example.com"}.ClientConfig("example.com", []string{"h2"})
+	require.NoError(t, err)
+	assert.Equal(t, "sni.example.com", config.ServerName)
+	assert.Equal(t, []string{"h2"}, config.NextProtos)
+}
+
+func TestVerifyFingerprint(t *testing.T) {
+	cert := []byte("certificate")
+	hash := sha256.Sum256(cert)
+	fingerprint := hex.EncodeToString(hash[:])
+	for i := len(fingerprint) - 2; i > 0; i -= 2 {
+		fingerprint = fingerprint[:i] + ":" + fingerprint[i:]
+	}
+	verify, err := VerifyFingerprint(fingerprint)
+	require.NoError(t, err)
+	assert.NoError(t, verify([][]byte{cert}, nil))
+	assert.Error(t, verify([][]byte{[]byte("other")}, nil))
+	assert.Error(t, verify(nil, nil))
+}
@@ -0,0 +1,79 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// clientFingerprints are the browsers whose ClientHello can be mimicked
+var clientFingerprints = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"ios":     utls.HelloIOS_Auto,
+	"android": utls.HelloAndroid_11_OkHttp,
+	"edge":    utls.HelloEdge_Auto,
+}
+
+// ClientFingerprints are the values of client-fingerprint, random picking one of the browsers
+var ClientFingerprints = []string{"chrome", "firefox", "safari", "ios", "android", "edge", "random"}
+
+// validateClientFingerprint checks that fingerprint is empty, none or one of ClientFingerprints
+func validateClientFingerprint(fingerprint string) error {
+	if fingerprint == "" || fingerprint == "none" || slices.Contains(ClientFingerprints, strings.ToLower(fingerprint)) {
+		return nil
+	}
+	return fmt.Errorf("unknown client-fingerprint %q", fingerprint)
+}
+
+// ClientHelloID returns the browser ClientHello the option mimics, nil if it does not. random
+// picks a browser on each call
+func (o Option) ClientHelloID() *utls.ClientHelloID {
+	fingerprint := strings.ToLower(o.ClientFingerprint)
+	if fingerprint == "random" {
+		names := ClientFingerprints[:len(ClientFingerprints)-1]
+		fingerprint = names[rand.IntN(len(names))]
+	}
+	if id, ok := clientFingerprints[fingerprint]; ok {
+		return &id
+	}
+	return nil
+}
+
+// UClient performs the TLS handshake of config over conn with the ClientHello of the browser
+// id. The ALPN of the browser is replaced by the one of config
+func UClient(ctx context.Context, conn net.Conn, config *tls.Config, id utls.ClientHelloID) (net.Conn, error) {
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.NextProtos) > 0 {
+		for _, extension := range spec.Extensions {
+			if alpn, ok := extension.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = config.NextProtos
+			}
+		}
+	}
+	uConn := utls.UClient(conn, &utls.Config{
+		ServerName:            config.ServerName,
+		NextProtos:            config.NextProtos,
+		RootCAs:               config.RootCAs,
+		InsecureSkipVerify:    config.InsecureSkipVerify,
+		VerifyPeerCertificate: config.VerifyPeerCertificate,
+		MinVersion:            config.MinVersion,
+	}, utls.HelloCustom)
+	if err := uConn.ApplyPreset(&spec); err != nil {
+		return nil, err
+	}
+	if err := uConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return uConn, nil
+}
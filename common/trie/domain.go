@@ -0,0 +1,145 @@
+package trie
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	wildcard        = "*"
+	dotWildcard     = ""
+	complexWildcard = "+"
+	domainStep      = "."
+)
+
+var (
+	// ErrInvalidDomain means insert domain is invalid
+	ErrInvalidDomain = errors.New("invalid domain")
+)
+
+// DomainTrie contains the main logic for adding and searching nodes for domain segments.
+// It supports the following patterns:
+//
+//	example.com   matches the domain exactly
+//	*.example.com matches exactly one level of subdomains
+//	.example.com  matches every subdomain but not the domain itself
+//	+.example.com matches the domain and every subdomain
+type DomainTrie[T any] struct {
+	root *node[T]
+}
+
+type node[T any] struct {
+	children map[string]*node[T]
+	data     T
+	inited   bool
+}
+
+func newNode[T any]() *node[T] {
+	return &node[T]{
+		children: map[string]*node[T]{},
+	}
+}
+
+// New returns a new, empty DomainTrie
+func New[T any]() *DomainTrie[T] {
+	return &DomainTrie[T]{root: newNode[T]()}
+}
+
+// validAndSplitDomain lowercases the domain and splits it into its segments
+func validAndSplitDomain(domain string) ([]string, bool) {
+	if domain != "" && domain[len(domain)-1] == '.' {
+		return nil, false
+	}
+	domain = strings.ToLower(domain)
+	parts := strings.Split(domain, domainStep)
+	if len(parts) == 1 {
+		if parts[0] == "" {
+			return nil, false
+		}
+		return parts, true
+	}
+
+	for _, part := range parts[1:] {
+		if part == "" {
+			return nil, false
+		}
+	}
+	return parts, true
+}
+
+// Insert adds a node to the trie
+func (t *DomainTrie[T]) Insert(domain string, data T) error {
+	if strings.HasPrefix(domain, complexWildcard+domainStep) {
+		domain = domain[len(complexWildcard):]
+		if err := t.insert(domain[1:], data); err != nil {
+			return err
+		}
+		// + wildcard also matches every subdomain
+	}
+	return t.insert(domain, data)
+}
+
+func (t *DomainTrie[T]) insert(domain string, data T) error {
+	parts, valid := validAndSplitDomain(domain)
+	if !valid {
+		return ErrInvalidDomain
+	}
+
+	n := t.root
+	for i := len(parts) - 1; i >= 0; i-- {
+		part := parts[i]
+		child, ok := n.children[part]
+		if !ok {
+			child = newNode[T]()
+			n.children[part] = child
+		}
+		n = child
+	}
+	n.data = data
+	n.inited = true
+	return nil
+}
+
+// Search finds the data associated with the given domain using the priority
+// exact match > wildcard (*) > dot wildcard (.)
+func (t *DomainTrie[T]) Search(domain string) (T, bool) {
+	var zero T
+	parts, valid := validAndSplitDomain(domain)
+	if !valid || parts[0] == "" {
+		return zero, false
+	}
+
+	n := t.search(t.root, parts)
+	if n == nil || !n.inited {
+		return zero, false
+	}
+	return n.data, true
+}
+
+func (t *DomainTrie[T]) search(n *node[T], parts []string) *node[T] {
+	if len(parts) == 0 {
+		return n
+	}
+
+	if c, ok := n.children[parts[len(parts)-1]]; ok {
+		if c = t.search(c, parts[:len(parts)-1]); c != nil && c.inited {
+			return c
+		}
+	}
+
+	if c, ok := n.children[wildcard]; ok {
+		if c = t.search(c, parts[:len(parts)-1]); c != nil && c.inited {
+			return c
+		}
+	}
+
+	if c, ok := n.children[dotWildcard]; ok && c.inited {
+		return c
+	}
+	return nil
+}
+
+// IsEmpty reports whether the trie has no entries
+func (t *DomainTrie[T]) IsEmpty() bool {
+	return t == nil || len(t.root.children) == 0
+}
@@ -0,0 +1,71 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainTrie_Exact(t *testing.T) {
+	tree := New[int]()
+	assert.NoError(t, tree.Insert("example.com", 1))
+	assert.NoError(t, tree.Insert("Luma.net", 2))
+
+	v, ok := tree.Search("example.com")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	v, ok = tree.Search("luma.NET")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	_, ok = tree.Search("www.example.com")
+	assert.False(t, ok)
+}
+
+func TestDomainTrie_Wildcard(t *testing.T) {
+	tree := New[int]()
+	assert.NoError(t, tree.Insert("*.example.com", 1))
+	assert.NoError(t, tree.Insert(".luma.net", 2))
+	assert.NoError(t, tree.Insert("+.corp.internal", 3))
+
+	_, ok := tree.Search("www.example.com")
+	assert.True(t, ok)
+	_, ok = tree.Search("a.www.example.com")
+	assert.False(t, ok)
+	_, ok = tree.Search("example.com")
+	assert.False(t, ok)
+
+	_, ok = tree.Search("a.b.luma.net")
+	assert.True(t, ok)
+	_, ok = tree.Search("luma.net")
+	assert.False(t, ok)
+
+	v, ok := tree.Search("corp.internal")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+	_, ok = tree.Search("git.eu.corp.internal")
+	assert.True(t, ok)
+}
+
+func TestDomainTrie_Priority(t *testing.T) {
+	tree := New[int]()
+	assert.NoError(t, tree.Insert(".example.com", 1))
+	assert.NoError(t, tree.Insert("*.example.com", 2))
+	assert.NoError(t, tree.Insert("www.example.com", 3))
+
+	v, _ := tree.Search("www.example.com")
+	assert.Equal(t, 3, v)
+	v, _ = tree.Search("api.example.com")
+	assert.Equal(t, 2, v)
+	v, _ = tree.Search("a.api.example.com")
+	assert.Equal(t, 1, v)
+}
+
+func TestDomainTrie_Invalid(t *testing.T) {
+	tree := New[int]()
+	assert.ErrorIs(t, tree.Insert("", 1), ErrInvalidDomain)
+	assert.ErrorIs(t, tree.Insert("example..com", 1), ErrInvalidDomain)
+	assert.ErrorIs(t, tree.Insert("example.com.", 1), ErrInvalidDomain)
+	_, ok := tree.Search("")
+	assert.False(t, ok)
+}
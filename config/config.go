@@ -7,19 +7,73 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/lumavpn/luma/common/dialer"
+	T "github.com/lumavpn/luma/common/tls"
 	"github.com/lumavpn/luma/log"
+	"github.com/lumavpn/luma/stack"
+	"github.com/lumavpn/luma/tun"
+	"github.com/lumavpn/luma/tunnel"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	// General configuration
 	LogLevel log.LogLevel `yaml:"loglevel"`
+
+	// Inbound configuration
+	Port      int `yaml:"port"`
+	SocksPort int `yaml:"socks-port"`
+	// MixedPort serves SOCKS4, SOCKS5 and HTTP proxy clients on a single port
+	MixedPort int `yaml:"mixed-port"`
+	// RedirPort accepts TCP connections redirected by netfilter, Linux only
+	RedirPort int `yaml:"redir-port"`
+	// TProxyPort accepts TCP and UDP traffic intercepted by the netfilter TPROXY target, Linux only
+	TProxyPort int `yaml:"tproxy-port"`
+	// AllowLan allows connections to the inbounds from other hosts
+	AllowLan bool `yaml:"allow-lan"`
+	// BindAddress is the address inbounds listen on when AllowLan is set, * for all addresses
+	BindAddress string `yaml:"bind-address"`
+	// Authentication is the list of user:pass credentials accepted by the inbounds
+	Authentication []string `yaml:"authentication"`
+	// Listeners are the server mode inbounds serving SOCKS5, Shadowsocks or Trojan clients,
+	// each described by its name, type and type specific options. Their sessions leave
+	// through DIRECT
+	Listeners []map[string]any `yaml:"listeners"`
+
+	// Proxies are the outbounds traffic can be sent through, each described by its name, type
+	// and type specific options
+	Proxies []map[string]any `yaml:"proxies"`
+	// Dialer are the settings of outbound sockets, which proxies can override
+	Dialer dialer.Options `yaml:",inline"`
+
+	// TUN configuration
+	Tun Tun `yaml:"tun"`
+	// DNS configuration
+	DNS DNS `yaml:"dns"`
+	// Sniffer configuration
+	Sniffer Sniffer `yaml:"sniffer"`
 }
 
 // New returns a new instance of Config with default values
 func New() *Config {
 	return &Config{
-		LogLevel: log.DebugLevel,
+		LogLevel:    log.DebugLevel,
+		BindAddress: "*",
+		Tun: Tun{
+			Device:         "luma0",
+			Stack:          stack.TunSystem,
+			MTU:            tun.DefaultMTU,
+			Inet4Address:   []string{"198.18.0.1/30"},
+			TableIndex:     tun.DefaultTableIndex,
+			RouteStateFile: tun.DefaultRouteStateFile,
+		},
+		DNS: DNS{
+			CacheSize:   4096,
+			FakeIPRange: "198.18.0.1/16",
+			FallbackFilter: FallbackFilter{
+				GeoIPCode: "CN",
+			},
+		},
 	}
 }
 
@@ -49,9 +103,78 @@ func (c *Config) Validate() error {
 	default:
 		return fmt.Errorf("unsupported loglevel:%s", c.LogLevel.String())
 	}
+	for _, p := range []struct {
+		name string
+		port int
+	}{
+		{"port", c.Port},
+		{"socks-port", c.SocksPort},
+		{"mixed-port", c.MixedPort},
+		{"redir-port", c.RedirPort},
+		{"tproxy-port", c.TProxyPort},
+	} {
+		if p.port < 0 || p.port > 65535 {
+			return fmt.Errorf("invalid %s: %d", p.name, p.port)
+		}
+	}
+	if c.Tun.AutoRoute && !c.Tun.AutoDetectInterface {
+		// outbound sockets would be routed back into the device
+		return errors.New("auto-route requires auto-detect-interface")
+	}
+	for _, uid := range c.Tun.ExcludeUID {
+		if _, err := tun.ParseUIDRange(uid); err != nil {
+			return err
+		}
+	}
+	if c.DNS.Enable && len(c.DNS.NameServer) == 0 {
+		return errors.New("dns is enabled but no nameserver is configured")
+	}
+	if _, err := tunnel.ParseDNSHijack(c.DNS.Hijack); err != nil {
+		return err
+	}
+	if c.DNS.FallbackFilter.GeoIP && c.DNS.FallbackFilter.GeoIPDatabase == "" {
+		return errors.New("geoip fallback filter requires geoip-database")
+	}
+	if c.DNS.FallbackFilter.GeoIP && c.DNS.FallbackFilter.GeoIPCode == "" {
+		return errors.New("geoip fallback filter requires geoip-code")
+	}
+	if err := c.Dialer.Validate(); err != nil {
+		return err
+	}
+	if err := c.Sniffer.Validate(); err != nil {
+		return err
+	}
+	for _, mapping := range c.Proxies {
+		if err := validateProxy(mapping, c.DNS.Enable); err != nil {
+			return fmt.Errorf("proxy %v: %w", mapping["name"], err)
+		}
+	}
 	return nil
 }
 
+// validateProxy checks the TLS and dialer options of the proxy described by mapping. Looking up
+// the ECH config of its server requires dnsEnabled
+func validateProxy(mapping map[string]any, dnsEnabled bool) error {
+	data, err := yaml.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	var option struct {
+		TLS    T.Option       `yaml:",inline"`
+		Dialer dialer.Options `yaml:",inline"`
+	}
+	if err := yaml.Unmarshal(data, &option); err != nil {
+		return err
+	}
+	if err := option.TLS.Validate(); err != nil {
+		return err
+	}
+	if option.TLS.ECHOpts.Enable && option.TLS.ECHOpts.Config == "" && !dnsEnabled {
+		return errors.New("ech-opts without config requires dns to be enabled")
+	}
+	return option.Dialer.Validate()
+}
+
 // ParseBytes unmarshals the given bytes into a Config
 func ParseBytes(data []byte) (*Config, error) {
 	cfg := New()
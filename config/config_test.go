@@ -3,8 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
 )
@@ -23,3 +25,88 @@ func TestParseBytes(t *testing.T) {
 	require.NoError(t, err)
 	fmt.Println(string(b))
 }
+
+func TestValidateProxy(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		proxy string
+		err   string
+	}{
+		{"no tls", `{name: a, type: socks5, server: 127.0.0.1, port: 1080}`, ""},
+		{"pinned", `{name: a, type: trojan, fingerprint: "` + strings.Repeat("ab", 32) + `"}`, ""},
+		{"bad fingerprint", `{name: a, type: trojan, fingerprint: abc}`, `proxy a: invalid fingerprint "abc"`},
+		{"unknown client fingerprint", `{name: a, type: trojan, client-fingerprint: netscape}`, `proxy a: unknown client-fingerprint "netscape"`},
+		{"bad ech config", `{name: a, type: trojan, ech-opts: {enable: true, config: "!"}}`, "proxy a: invalid ech config"},
+		{"ech lookup without dns", `{name: a, type: trojan, ech-opts: {enable: true}}`, "proxy a: ech-opts without config requires dns"},
+		{"bad ip-version", `{name: a, type: trojan, ip-version: ipv5}`, `proxy a: invalid ip-version "ipv5"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := ParseBytes([]byte("proxies:\n  - " + test.proxy))
+			require.NoError(t, err)
+			err = cfg.Validate()
+			if test.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestValidateDialer(t *testing.T) {
+	cfg, err := ParseBytes([]byte("interface-name: eth0\nrouting-mark: 255\nip-version: ipv4-prefer"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "eth0", cfg.Dialer.Interface)
+	assert.Equal(t, 255, cfg.Dialer.RoutingMark)
+
+	cfg, err = ParseBytes([]byte("source-address: localhost"))
+	require.NoError(t, err)
+	require.EqualError(t, cfg.Validate(), `invalid source-address "localhost"`)
+}
+
+func TestValidateAutoRoute(t *testing.T) {
+	cfg, err := ParseBytes([]byte("tun: {enable: true, auto-route: true}"))
+	require.NoError(t, err)
+	require.EqualError(t, cfg.Validate(), "auto-route requires auto-detect-interface")
+
+	cfg, err = ParseBytes([]byte("tun: {enable: true, auto-route: true, auto-detect-interface: true}"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "/run/luma/route.json", cfg.Tun.RouteStateFile)
+}
+
+func TestValidateGeoIP(t *testing.T) {
+	cfg, err := ParseBytes([]byte("dns: {enable: true, nameserver: [1.1.1.1], fallback-filter: {geoip: true, geoip-database: country.mmdb}}"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "CN", cfg.DNS.FallbackFilter.GeoIPCode)
+
+	cfg, err = ParseBytes([]byte(`dns: {enable: true, nameserver: [1.1.1.1], fallback-filter: {geoip: true, geoip-database: country.mmdb, geoip-code: ""}}`))
+	require.NoError(t, err)
+	require.EqualError(t, cfg.Validate(), "geoip fallback filter requires geoip-code")
+}
+
+func TestValidateDNSHijack(t *testing.T) {
+	cfg, err := ParseBytes([]byte("dns: {enable: true, nameserver: [1.1.1.1], hijack: [any:53, udp://8.8.8.8:53]}"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	cfg, err = ParseBytes([]byte("dns: {enable: true, nameserver: [1.1.1.1], hijack: [any]}"))
+	require.NoError(t, err)
+	require.ErrorContains(t, cfg.Validate(), "invalid dns hijack address any")
+}
+
+func TestValidateSniffer(t *testing.T) {
+	cfg, err := ParseBytes([]byte("sniffer: {enable: true, sniff: {tls: {ports: [443, 8000-9000]}, http: {}}}"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	cfg, err = ParseBytes([]byte("sniffer: {enable: true, sniff: {tls: {ports: [9000-8000]}}}"))
+	require.NoError(t, err)
+	require.ErrorContains(t, cfg.Validate(), "invalid sniffer ports for tls")
+
+	cfg, err = ParseBytes([]byte("sniffer: {enable: true, sniff: {ftp: {}}}"))
+	require.NoError(t, err)
+	require.ErrorContains(t, cfg.Validate(), "invalid sniffer ftp")
+}
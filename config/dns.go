@@ -0,0 +1,66 @@
+package config
+
+import M "github.com/lumavpn/luma/metadata"
+
+// DNS is the configuration of the built-in DNS resolver
+type DNS struct {
+	Enable bool `yaml:"enable"`
+	IPv6   bool `yaml:"ipv6"`
+	// Listen is the UDP and TCP address the local DNS server listens on. The server is disabled when empty
+	Listen string `yaml:"listen"`
+	// Hijack is a list of ip:port or any:port addresses whose DNS traffic is answered by luma
+	Hijack []string `yaml:"hijack"`
+	// NameServer is the list of upstreams queried concurrently for every lookup
+	NameServer []string `yaml:"nameserver"`
+	// DefaultNameServer is the list of plain IP nameservers used to resolve the hosts of NameServer
+	DefaultNameServer []string `yaml:"default-nameserver"`
+	// CacheSize is the maximum number of answers kept in the cache
+	CacheSize int `yaml:"cache-size"`
+	// Hosts is a static table of domains to IP addresses
+	Hosts map[string]string `yaml:"hosts"`
+	// EnhancedMode selects how queries from clients are answered: normal or fake-ip
+	EnhancedMode M.DNSMode `yaml:"enhanced-mode"`
+	// FakeIPRange is the IPv4 range fake IPs are allocated from
+	FakeIPRange string `yaml:"fake-ip-range"`
+	// FakeIPFilter is a list of domains that are answered with their real IPs in fake-ip mode
+	FakeIPFilter []string `yaml:"fake-ip-filter"`
+	// FakeIPStore is the path of a file the fake IP mapping is persisted to across restarts
+	FakeIPStore string `yaml:"fake-ip-store"`
+	// NameServerPolicy maps domain patterns to the nameservers that resolve them instead of NameServer
+	NameServerPolicy map[string]StringSlice `yaml:"nameserver-policy"`
+	// Fallback is the list of nameservers used when FallbackFilter rejects an answer of NameServer
+	Fallback []string `yaml:"fallback"`
+	// FallbackFilter decides when answers of NameServer can not be trusted
+	FallbackFilter FallbackFilter `yaml:"fallback-filter"`
+}
+
+// FallbackFilter is the configuration of the filters that reject poisoned answers
+type FallbackFilter struct {
+	// GeoIP rejects answers whose addresses are outside of the country GeoIPCode, CN by default
+	GeoIP     bool   `yaml:"geoip"`
+	GeoIPCode string `yaml:"geoip-code"`
+	// GeoIPDatabase is the path of the MaxMind country database used by the GeoIP filter
+	GeoIPDatabase string `yaml:"geoip-database"`
+	// IPCIDR rejects answers with addresses in any of the given ranges
+	IPCIDR []string `yaml:"ipcidr"`
+	// Domain is a list of domains that are only resolved by the fallback nameservers
+	Domain []string `yaml:"domain"`
+}
+
+// StringSlice is a list of strings that may also be written as a single string in yaml
+type StringSlice []string
+
+// UnmarshalYAML unserialize StringSlice with yaml
+func (s *StringSlice) UnmarshalYAML(unmarshal func(any) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*s = StringSlice{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lumavpn/luma/sniffer"
+)
+
+// Sniffer is the configuration of the protocol sniffer, which recovers the domain of
+// connections made to IP addresses
+type Sniffer struct {
+	Enable bool `yaml:"enable"`
+	// Timeout is how long to wait for the first bytes of a connection
+	Timeout time.Duration `yaml:"timeout"`
+	// Sniff maps protocols (tls, http, quic) to the destination ports they are sniffed on
+	Sniff map[string]SniffProtocol `yaml:"sniff"`
+}
+
+// SniffProtocol is the configuration of a single sniffed protocol
+type SniffProtocol struct {
+	// Ports is a list of ports or port ranges (8000-9000). Every port is sniffed when empty
+	Ports []string `yaml:"ports"`
+}
+
+// Validate checks the ports and protocols of an enabled sniffer
+func (s Sniffer) Validate() error {
+	if !s.Enable {
+		return nil
+	}
+	for protocol, sc := range s.Sniff {
+		ports, err := sniffer.ParsePortRanges(sc.Ports)
+		if err != nil {
+			return fmt.Errorf("invalid sniffer ports for %s: %w", protocol, err)
+		}
+		if _, err := sniffer.New(protocol, ports); err != nil {
+			return fmt.Errorf("invalid sniffer %s: %w", protocol, err)
+		}
+	}
+	return nil
+}
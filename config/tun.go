@@ -0,0 +1,34 @@
+package config
+
+import "github.com/lumavpn/luma/stack"
+
+// Tun is the configuration of the TUN inbound
+type Tun struct {
+	Enable bool `yaml:"enable"`
+	// Device is the name of the TUN interface
+	Device string `yaml:"device"`
+	// Stack is the network stack terminating the flows of the device
+	Stack stack.StackType `yaml:"stack"`
+	MTU   uint32          `yaml:"mtu"`
+	// Inet4Address and Inet6Address are the prefixes assigned to the device
+	Inet4Address []string `yaml:"inet4-address"`
+	Inet6Address []string `yaml:"inet6-address"`
+	// TCPReceiveBufferSize and TCPSendBufferSize are the TCP buffer sizes of the gvisor stack in bytes
+	TCPReceiveBufferSize int `yaml:"tcp-receive-buffer-size"`
+	TCPSendBufferSize    int `yaml:"tcp-send-buffer-size"`
+
+	// AutoRoute routes all traffic through the device with a dedicated routing table and ip
+	// rules, Linux only. It requires AutoDetectInterface
+	AutoRoute bool `yaml:"auto-route"`
+	// AutoDetectInterface binds outbound sockets to the interface of the default route so that
+	// they bypass the device
+	AutoDetectInterface bool `yaml:"auto-detect-interface"`
+	// RouteExcludeAddress are the destinations bypassing the device when AutoRoute is set
+	RouteExcludeAddress []string `yaml:"route-exclude-address"`
+	// ExcludeUID are the user IDs, or start-end ranges of them, whose traffic bypasses the device
+	ExcludeUID []string `yaml:"exclude-uid"`
+	// TableIndex is the routing table holding the routes through the device
+	TableIndex int `yaml:"table-index"`
+	// RouteStateFile records the installed rules so that they are removed even after a crash
+	RouteStateFile string `yaml:"route-state-file"`
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/lumavpn/luma/common/dialer"
+	D "github.com/miekg/dns"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// dnsClient is a single upstream DNS server
+type dnsClient interface {
+	ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error)
+	Address() string
+}
+
+// client is a DNS client for plain UDP, TCP and DNS-over-TLS upstreams
+type client struct {
+	*D.Client
+	host string
+	port string
+	// bootstrap resolves the host of the upstream when it is not an IP address
+	bootstrap Resolver
+}
+
+func newClient(ns NameServer, bootstrap Resolver) (*client, error) {
+	host, port, err := net.SplitHostPort(ns.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		Client: &D.Client{
+			Net:     ns.Net,
+			Timeout: defaultTimeout,
+			UDPSize: 4096,
+		},
+		host:      host,
+		port:      port,
+		bootstrap: bootstrap,
+	}
+	if ns.Net == "tls" {
+		c.Client.Net = "tcp-tls"
+		c.Client.TLSConfig = &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	return c, nil
+}
+
+func (c *client) Address() string {
+	if c.Client.Net == "tcp-tls" {
+		return fmt.Sprintf("tls://%s", net.JoinHostPort(c.host, c.port))
+	}
+	return fmt.Sprintf("%s://%s", c.Client.Net, net.JoinHostPort(c.host, c.port))
+}
+
+func (c *client) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	ip, err := resolveUpstream(ctx, c.host, c.bootstrap)
+	if err != nil {
+		return nil, fmt.Errorf("resolve upstream %s: %w", c.host, err)
+	}
+
+	network := "udp"
+	if c.Client.Net != "udp" {
+		network = "tcp"
+	}
+	conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), c.port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if c.Client.Net == "tcp-tls" {
+		conn = tls.Client(conn, c.Client.TLSConfig)
+	}
+
+	type result struct {
+		msg *D.Msg
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		msg, _, err := c.Client.ExchangeWithConn(m, &D.Conn{Conn: conn, UDPSize: c.Client.UDPSize})
+		ch <- result{msg, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case ret := <-ch:
+		return ret.msg, ret.err
+	}
+}
+
+// resolveUpstream returns the address of an upstream server, using the bootstrap
+// resolver (or the system resolver if there is none) when host is a domain name
+func resolveUpstream(ctx context.Context, host string, bootstrap Resolver) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip, nil
+	}
+	if bootstrap != nil {
+		ips, err := bootstrap.LookupIP(ctx, host)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return ips[0], nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if len(ips) == 0 {
+		return netip.Addr{}, ErrIPNotFound
+	}
+	return ips[0].Unmap(), nil
+}
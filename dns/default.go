@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+var (
+	defaultResolver   Resolver
+	defaultResolverMu sync.RWMutex
+)
+
+// SetDefaultResolver sets the resolver used by outbounds to resolve destinations. A nil
+// resolver falls back to the system resolver. The ECH configs looked up with the previous
+// resolver are dropped
+func SetDefaultResolver(r Resolver) {
+	defaultResolverMu.Lock()
+	defer defaultResolverMu.Unlock()
+	defaultResolver = r
+	echCache.Clear()
+}
+
+// DefaultResolver returns the resolver set by SetDefaultResolver
+func DefaultResolver() Resolver {
+	defaultResolverMu.RLock()
+	defer defaultResolverMu.RUnlock()
+	return defaultResolver
+}
+
+// LookupIP returns the addresses of host with the default resolver, or the system resolver if
+// there is none
+func LookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	if r := DefaultResolver(); r != nil {
+		return r.LookupIP(ctx, host)
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, ErrIPNotFound
+	}
+	return ips, nil
+}
+
+// ResolveIP returns an address of host with the default resolver, or the system resolver if
+// there is none
+func ResolveIP(ctx context.Context, host string) (netip.Addr, error) {
+	return resolveUpstream(ctx, host, DefaultResolver())
+}
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/lumavpn/luma/common/dialer"
+	D "github.com/miekg/dns"
+)
+
+const dohMimeType = "application/dns-message"
+
+// dohClient is a DNS-over-HTTPS (RFC 8484) client
+type dohClient struct {
+	url       string
+	transport *http.Transport
+}
+
+func newDoHClient(ns NameServer, bootstrap Resolver) (*dohClient, error) {
+	u, err := url.Parse(ns.Addr)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	transport := &http.Transport{
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			ip, err := resolveUpstream(ctx, host, bootstrap)
+			if err != nil {
+				return nil, fmt.Errorf("resolve upstream %s: %w", host, err)
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		},
+	}
+	return &dohClient{
+		url:       ns.Addr,
+		transport: transport,
+	}, nil
+}
+
+func (dc *dohClient) Address() string {
+	return dc.url
+}
+
+func (dc *dohClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	// In the wire format the DNS ID should be zero to be more cache friendly
+	q := m.Copy()
+	q.Id = 0
+	buf, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dc.url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMimeType)
+	req.Header.Set("Accept", dohMimeType)
+
+	resp, err := dc.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh server %s returned status %d", dc.url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, D.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	msg := &D.Msg{}
+	if err := msg.Unpack(body); err != nil {
+		return nil, err
+	}
+	msg.Id = m.Id
+	return msg, nil
+}
@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lumavpn/luma/common/cache"
+	D "github.com/miekg/dns"
+)
+
+// echCacheSize is the number of hosts whose ECHConfigList is cached
+const echCacheSize = 1024
+
+var (
+	ErrECHConfigNotFound = errors.New("couldn't find ech config")
+	ErrECHNoResolver     = errors.New("ech config lookup requires dns to be enabled")
+)
+
+// echCache holds the ECHConfigList of hosts until the TTL of their HTTPS record expires
+var echCache = cache.New(cache.WithSize[string, []byte](echCacheSize))
+
+// LookupECHConfig returns the ECHConfigList published in the HTTPS record of host. The record
+// is queried with the default resolver, whose nameservers are dialed with the outbound options,
+// and the list is cached for its TTL
+func LookupECHConfig(ctx context.Context, host string) ([]byte, error) {
+	host = D.Fqdn(host)
+	if list, ok := echCache.Get(host); ok {
+		return list, nil
+	}
+
+	r := DefaultResolver()
+	if r == nil {
+		return nil, ErrECHNoResolver
+	}
+	m := &D.Msg{}
+	m.SetQuestion(host, D.TypeHTTPS)
+	m.RecursionDesired = true
+	msg, err := r.ExchangeContext(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	list, ttl, err := echConfigOf(msg)
+	if err != nil {
+		return nil, err
+	}
+	echCache.SetWithExpire(host, list, time.Now().Add(time.Duration(max(ttl, minTTL))*time.Second))
+	return list, nil
+}
+
+// echConfigOf returns the ECHConfigList of the first HTTPS record of msg having one, and the
+// TTL of the record
+func echConfigOf(msg *D.Msg) ([]byte, uint32, error) {
+	for _, rr := range msg.Answer {
+		record, ok := rr.(*D.HTTPS)
+		if !ok {
+			continue
+		}
+		for _, value := range record.Value {
+			if ech, ok := value.(*D.SVCBECHConfig); ok && len(ech.ECH) > 0 {
+				return ech.ECH, record.Hdr.Ttl, nil
+			}
+		}
+	}
+	return nil, 0, ErrECHConfigNotFound
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startHTTPSServer starts a UDP DNS server answering every HTTPS query with list
+func startHTTPSServer(t *testing.T, list []byte, queries *atomic.Int32) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &D.Server{
+		PacketConn: pc,
+		Handler: D.HandlerFunc(func(w D.ResponseWriter, r *D.Msg) {
+			queries.Add(1)
+			msg := &D.Msg{}
+			msg.SetReply(r)
+			if q := r.Question[0]; q.Qtype == D.TypeHTTPS {
+				msg.Answer = append(msg.Answer, &D.HTTPS{SVCB: D.SVCB{
+					Hdr:      D.RR_Header{Name: q.Name, Rrtype: D.TypeHTTPS, Class: D.ClassINET, Ttl: 60},
+					Priority: 1,
+					Target:   ".",
+					Value:    []D.SVCBKeyValue{&D.SVCBECHConfig{ECH: list}},
+				}})
+			}
+			w.WriteMsg(msg)
+		}),
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestLookupECHConfig(t *testing.T) {
+	SetDefaultResolver(nil)
+	_, err := LookupECHConfig(context.Background(), "example.com")
+	assert.ErrorIs(t, err, ErrECHNoResolver)
+
+	var queries atomic.Int32
+	list := []byte{0, 1, 2}
+	addr := startHTTPSServer(t, list, &queries)
+	r, err := NewResolver(Config{Main: []NameServer{{Net: "udp", Addr: addr}}})
+	require.NoError(t, err)
+	SetDefaultResolver(r)
+	t.Cleanup(func() { SetDefaultResolver(nil) })
+
+	for i := 0; i < 3; i++ {
+		got, err := LookupECHConfig(context.Background(), "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, list, got)
+	}
+	assert.Equal(t, int32(1), queries.Load())
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/lumavpn/luma/dns/fakeip"
+	M "github.com/lumavpn/luma/metadata"
+	D "github.com/miekg/dns"
+)
+
+// fakeIPTTL is the TTL of fake answers. It is kept low so that clients ask again
+// rather than caching an address whose mapping may be recycled
+const fakeIPTTL = 1
+
+// Enhancer answers DNS queries on behalf of clients according to the DNS mode.
+// In fake-ip mode A queries are answered with addresses from the fake IP pool, which
+// the tunnel later maps back to the original domain
+type Enhancer struct {
+	mode     M.DNSMode
+	fakePool *fakeip.Pool
+	resolver Resolver
+}
+
+// NewEnhancer returns a new Enhancer. pool is required for the fake-ip mode
+func NewEnhancer(mode M.DNSMode, resolver Resolver, pool *fakeip.Pool) (*Enhancer, error) {
+	if mode == M.DNSFakeIP && pool == nil {
+		return nil, errors.New("fake-ip mode requires a fake-ip pool")
+	}
+	return &Enhancer{
+		mode:     mode,
+		fakePool: pool,
+		resolver: resolver,
+	}, nil
+}
+
+// FakeIPEnabled reports whether queries are answered with fake IPs
+func (e *Enhancer) FakeIPEnabled() bool {
+	return e != nil && e.mode == M.DNSFakeIP
+}
+
+// IsFakeIP reports whether ip belongs to the fake IP range
+func (e *Enhancer) IsFakeIP(ip netip.Addr) bool {
+	if !e.FakeIPEnabled() {
+		return false
+	}
+	return e.fakePool.IPNet().Contains(ip.Unmap()) && ip != e.fakePool.Gateway()
+}
+
+// FindHostByIP returns the domain the fake ip was allocated to
+func (e *Enhancer) FindHostByIP(ip netip.Addr) (string, bool) {
+	if !e.FakeIPEnabled() {
+		return "", false
+	}
+	return e.fakePool.LookBack(ip)
+}
+
+// ExchangeContext answers m, with a fake IP if the mode and the question allow it
+func (e *Enhancer) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	if len(m.Question) == 0 {
+		return nil, errors.New("should have one question at least")
+	}
+	q := m.Question[0]
+	host := strings.TrimSuffix(q.Name, ".")
+
+	if !e.FakeIPEnabled() || e.fakePool.ShouldSkipped(host) {
+		return e.resolver.ExchangeContext(ctx, m)
+	}
+
+	switch q.Qtype {
+	case D.TypeA:
+		ip := e.fakePool.Lookup(host)
+		msg := &D.Msg{}
+		msg.SetReply(m)
+		msg.Authoritative = true
+		msg.RecursionAvailable = true
+		msg.Answer = []D.RR{&D.A{
+			Hdr: D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: fakeIPTTL},
+			A:   ip.AsSlice(),
+		}}
+		return msg, nil
+	case D.TypeAAAA:
+		// the fake IP range is IPv4, so clients must fall back to the A record
+		return handleMsgWithEmptyAnswer(m), nil
+	default:
+		return e.resolver.ExchangeContext(ctx, m)
+	}
+}
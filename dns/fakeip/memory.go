@@ -0,0 +1,72 @@
+package fakeip
+
+import (
+	"net/netip"
+
+	"github.com/lumavpn/luma/common/cache"
+)
+
+// memoryStore keeps the domain<->IP mapping in a pair of LRU caches
+type memoryStore struct {
+	cacheIP   *cache.LruCache[string, netip.Addr]
+	cacheHost *cache.LruCache[netip.Addr, string]
+}
+
+func newMemoryStore(size int) *memoryStore {
+	return &memoryStore{
+		cacheIP:   cache.New[string, netip.Addr](cache.WithSize[string, netip.Addr](size)),
+		cacheHost: cache.New[netip.Addr, string](cache.WithSize[netip.Addr, string](size)),
+	}
+}
+
+// GetByHost returns the fake IP of host
+func (m *memoryStore) GetByHost(host string) (netip.Addr, bool) {
+	if ip, exist := m.cacheIP.Get(host); exist {
+		// ensure ip --> host on head of linked list
+		m.cacheHost.Get(ip)
+		return ip, true
+	}
+
+	return netip.Addr{}, false
+}
+
+// PutByHost maps host to ip in both directions
+func (m *memoryStore) PutByHost(host string, ip netip.Addr) {
+	m.cacheIP.Set(host, ip)
+	m.cacheHost.Set(ip, host)
+}
+
+// GetByIP returns the host of the fake IP
+func (m *memoryStore) GetByIP(ip netip.Addr) (string, bool) {
+	if host, exist := m.cacheHost.Get(ip); exist {
+		// ensure host --> ip on head of linked list
+		m.cacheIP.Get(host)
+		return host, true
+	}
+
+	return "", false
+}
+
+// DelByIP removes the mapping of ip in both directions
+func (m *memoryStore) DelByIP(ip netip.Addr) {
+	if host, exist := m.cacheHost.Get(ip); exist {
+		m.cacheIP.Delete(host)
+	}
+	m.cacheHost.Delete(ip)
+}
+
+// Exist reports whether ip is mapped to a host
+func (m *memoryStore) Exist(ip netip.Addr) bool {
+	return m.cacheHost.Exist(ip)
+}
+
+// Clear removes every mapping
+func (m *memoryStore) Clear() {
+	m.cacheIP.Clear()
+	m.cacheHost.Clear()
+}
+
+// Range calls f for each mapping from least to most recently used
+func (m *memoryStore) Range(f func(ip netip.Addr, host string) bool) {
+	m.cacheHost.Range(f)
+}
@@ -0,0 +1,78 @@
+package fakeip
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+)
+
+// snapshot is the on-disk representation of a Pool
+type snapshot struct {
+	IPNet   netip.Prefix `json:"ipnet"`
+	Offset  netip.Addr   `json:"offset"`
+	Cycle   bool         `json:"cycle"`
+	Records []record     `json:"records"`
+}
+
+type record struct {
+	IP   netip.Addr `json:"ip"`
+	Host string     `json:"host"`
+}
+
+// load restores the mapping from the persist file. A missing file or one written
+// for a different range is ignored
+func (p *Pool) load() error {
+	data, err := os.ReadFile(p.persist)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s.IPNet != p.ipnet {
+		return nil
+	}
+
+	for _, r := range s.Records {
+		if p.ipnet.Contains(r.IP) && r.Host != "" {
+			p.store.PutByHost(r.Host, r.IP)
+		}
+	}
+	if p.ipnet.Contains(s.Offset) {
+		p.offset = s.Offset
+		p.cycle = s.Cycle
+	}
+	return nil
+}
+
+// save writes the mapping to the persist file
+func (p *Pool) save() error {
+	s := snapshot{
+		IPNet:  p.ipnet,
+		Offset: p.offset,
+		Cycle:  p.cycle,
+	}
+	p.store.Range(func(ip netip.Addr, host string) bool {
+		s.Records = append(s.Records, record{IP: ip, Host: host})
+		return true
+	})
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.persist), 0o755); err != nil {
+		return err
+	}
+	tmp := p.persist + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.persist)
+}
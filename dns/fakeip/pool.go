@@ -0,0 +1,198 @@
+package fakeip
+
+import (
+	"errors"
+	"math/big"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/lumavpn/luma/common/trie"
+)
+
+// Options is the configuration used to create a Pool
+type Options struct {
+	// IPNet is the range fake IPs are allocated from
+	IPNet netip.Prefix
+	// Host is a trie of domains that must not get a fake IP
+	Host *trie.DomainTrie[struct{}]
+	// Size sets the maximum number of mappings kept. Defaults to the size of IPNet
+	Size int
+	// Persist is the path of a file the mapping is restored from and saved to.
+	// The mapping is only kept in memory when it is empty
+	Persist string
+}
+
+// Pool is an implementation of a fake IP pool. It allocates addresses from IPNet to
+// domains and keeps a bidirectional, least recently used mapping between them
+type Pool struct {
+	gateway netip.Addr
+	first   netip.Addr
+	last    netip.Addr
+	offset  netip.Addr
+	cycle   bool
+	mux     sync.Mutex
+	host    *trie.DomainTrie[struct{}]
+	ipnet   netip.Prefix
+	store   *memoryStore
+	persist string
+}
+
+// New returns a new Pool for the given Options
+func New(options Options) (*Pool, error) {
+	ipnet := options.IPNet.Masked()
+	var (
+		hostAddr = ipnet.Addr()
+		gateway  = hostAddr.Next()
+		first    = gateway.Next().Next().Next() // default start with 198.18.0.4
+		last     = lastAddr(ipnet)
+	)
+
+	if !ipnet.IsValid() || !first.IsValid() || !first.Less(last) {
+		return nil, errors.New("ipnet don't have valid ip")
+	}
+	if !ipnet.Addr().Is4() {
+		return nil, errors.New("fake-ip range must be an IPv4 prefix")
+	}
+
+	size := options.Size
+	if size <= 0 {
+		size = rangeSize(first, last)
+	}
+
+	pool := &Pool{
+		gateway: gateway,
+		first:   first,
+		last:    last,
+		offset:  first.Prev(),
+		host:    options.Host,
+		ipnet:   ipnet,
+		store:   newMemoryStore(size),
+		persist: options.Persist,
+	}
+	if pool.persist != "" {
+		if err := pool.load(); err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+// Lookup return a fake ip with host
+func (p *Pool) Lookup(host string) netip.Addr {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	host = strings.ToLower(host)
+	if ip, ok := p.store.GetByHost(host); ok {
+		return ip
+	}
+
+	ip := p.get()
+	p.store.PutByHost(host, ip)
+	return ip
+}
+
+// LookBack return host with the fake ip
+func (p *Pool) LookBack(ip netip.Addr) (string, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	ip = ip.Unmap()
+	if !p.ipnet.Contains(ip) {
+		return "", false
+	}
+	return p.store.GetByIP(ip)
+}
+
+// ShouldSkipped return if domain should be skipped
+func (p *Pool) ShouldSkipped(domain string) bool {
+	if p.host == nil {
+		return false
+	}
+	_, ok := p.host.Search(domain)
+	return ok
+}
+
+// Exist returns if given ip exists in fake-ip pool
+func (p *Pool) Exist(ip netip.Addr) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	ip = ip.Unmap()
+	if !p.ipnet.Contains(ip) {
+		return false
+	}
+	return p.store.Exist(ip)
+}
+
+// Gateway return gateway ip
+func (p *Pool) Gateway() netip.Addr {
+	return p.gateway
+}
+
+// IPNet return raw ipnet
+func (p *Pool) IPNet() netip.Prefix {
+	return p.ipnet
+}
+
+// FlushFakeIP clears every mapping of the pool
+func (p *Pool) FlushFakeIP() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.store.Clear()
+	p.offset = p.first.Prev()
+	p.cycle = false
+}
+
+// Close saves the mapping to the persist file, if there is one
+func (p *Pool) Close() error {
+	if p.persist == "" {
+		return nil
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.save()
+}
+
+// get allocates the next address of the range, reusing addresses in order (and
+// dropping their old mapping) once the whole range has been handed out
+func (p *Pool) get() netip.Addr {
+	p.offset = p.offset.Next()
+
+	if !p.offset.Less(p.last) {
+		p.cycle = true
+		p.offset = p.first
+	}
+
+	if p.cycle || p.store.Exist(p.offset) {
+		p.store.DelByIP(p.offset)
+	}
+	return p.offset
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	bits := prefix.Bits()
+	for i := range b {
+		remaining := bits - i*8
+		switch {
+		case remaining >= 8:
+		case remaining <= 0:
+			b[i] = 0xff
+		default:
+			b[i] |= 0xff >> remaining
+		}
+	}
+	last, _ := netip.AddrFromSlice(b)
+	return last
+}
+
+func rangeSize(first, last netip.Addr) int {
+	n := new(big.Int).Sub(new(big.Int).SetBytes(last.AsSlice()), new(big.Int).SetBytes(first.AsSlice()))
+	if !n.IsInt64() || n.Int64() > 1<<20 {
+		return 1 << 20
+	}
+	return int(n.Int64())
+}
@@ -0,0 +1,84 @@
+package fakeip
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/lumavpn/luma/common/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool_Basic(t *testing.T) {
+	pool, err := New(Options{IPNet: netip.MustParsePrefix("192.168.0.0/28")})
+	require.NoError(t, err)
+
+	first := pool.Lookup("foo.com")
+	last := pool.Lookup("bar.com")
+	assert.Equal(t, netip.MustParseAddr("192.168.0.4"), first)
+	assert.Equal(t, netip.MustParseAddr("192.168.0.5"), last)
+	assert.Equal(t, first, pool.Lookup("FOO.com"))
+
+	host, ok := pool.LookBack(last)
+	assert.True(t, ok)
+	assert.Equal(t, "bar.com", host)
+	assert.True(t, pool.Exist(first))
+	assert.False(t, pool.Exist(netip.MustParseAddr("192.168.0.6")))
+	assert.Equal(t, netip.MustParseAddr("192.168.0.1"), pool.Gateway())
+}
+
+func TestPool_Cycle(t *testing.T) {
+	pool, err := New(Options{IPNet: netip.MustParsePrefix("192.168.0.0/29")})
+	require.NoError(t, err)
+
+	// 192.168.0.4 - 192.168.0.6 are usable
+	first := pool.Lookup("a.com")
+	pool.Lookup("b.com")
+	pool.Lookup("c.com")
+	same := pool.Lookup("d.com")
+	assert.Equal(t, first, same)
+
+	_, ok := pool.LookBack(first)
+	assert.True(t, ok)
+	host, _ := pool.LookBack(first)
+	assert.Equal(t, "d.com", host)
+	assert.NotEqual(t, first, pool.Lookup("a.com"))
+}
+
+func TestPool_Skip(t *testing.T) {
+	tree := trie.New[struct{}]()
+	require.NoError(t, tree.Insert("+.lan", struct{}{}))
+	pool, err := New(Options{IPNet: netip.MustParsePrefix("192.168.0.0/28"), Host: tree})
+	require.NoError(t, err)
+
+	assert.True(t, pool.ShouldSkipped("nas.lan"))
+	assert.False(t, pool.ShouldSkipped("example.com"))
+}
+
+func TestPool_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fakeip.json")
+	ipnet := netip.MustParsePrefix("198.18.0.1/16")
+
+	pool, err := New(Options{IPNet: ipnet, Persist: path})
+	require.NoError(t, err)
+	foo := pool.Lookup("foo.com")
+	bar := pool.Lookup("bar.com")
+	require.NoError(t, pool.Close())
+
+	restored, err := New(Options{IPNet: ipnet, Persist: path})
+	require.NoError(t, err)
+	host, ok := restored.LookBack(foo)
+	assert.True(t, ok)
+	assert.Equal(t, "foo.com", host)
+	assert.Equal(t, bar, restored.Lookup("bar.com"))
+	assert.NotEqual(t, bar, restored.Lookup("baz.com"))
+	assert.NotEqual(t, foo, restored.Lookup("baz.com"))
+}
+
+func TestPool_Invalid(t *testing.T) {
+	_, err := New(Options{IPNet: netip.MustParsePrefix("192.168.0.0/31")})
+	assert.Error(t, err)
+	_, err = New(Options{IPNet: netip.MustParsePrefix("fc00::/18")})
+	assert.Error(t, err)
+}
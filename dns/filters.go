@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/lumavpn/luma/common/geoip"
+	"github.com/lumavpn/luma/common/trie"
+)
+
+// FallbackFilter decides when the answer of the main nameservers can not be trusted and the
+// answer of the fallback nameservers is used instead
+type FallbackFilter struct {
+	// GeoIP enables the GeoIP filter, which rejects answers outside of GeoIPCode
+	GeoIP bool
+	// GeoIPCode is the ISO 3166 code of the country answers are expected in
+	GeoIPCode string
+	// GeoIPReader is the country database used by the GeoIP filter
+	GeoIPReader *geoip.Reader
+	// IPCIDR is a list of ranges that are never valid answers
+	IPCIDR []netip.Prefix
+	// Domain is a list of domains that are only ever resolved by the fallback nameservers
+	Domain []string
+}
+
+type fallbackIPFilter interface {
+	Match(netip.Addr) bool
+}
+
+type geoipFilter struct {
+	code   string
+	reader *geoip.Reader
+}
+
+// Match reports whether ip is outside of the expected country. Private addresses are never
+// matched since they have no country
+func (gf *geoipFilter) Match(ip netip.Addr) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	return gf.reader.LookupCode(ip) != gf.code
+}
+
+type ipnetFilter struct {
+	ipnet netip.Prefix
+}
+
+// Match reports whether ip is inside the filtered range
+func (inf *ipnetFilter) Match(ip netip.Addr) bool {
+	return inf.ipnet.Contains(ip.Unmap())
+}
+
+// newFallbackFilters returns the IP and domain filters described by ff
+func newFallbackFilters(ff FallbackFilter) ([]fallbackIPFilter, *trie.DomainTrie[struct{}], error) {
+	var ipFilters []fallbackIPFilter
+	if ff.GeoIP && ff.GeoIPCode == "" {
+		// every public answer would be outside of the country
+		return nil, nil, errors.New("geoip filter requires a country code")
+	}
+	if ff.GeoIP && ff.GeoIPReader != nil {
+		ipFilters = append(ipFilters, &geoipFilter{
+			code:   strings.ToLower(ff.GeoIPCode),
+			reader: ff.GeoIPReader,
+		})
+	}
+	for _, ipnet := range ff.IPCIDR {
+		ipFilters = append(ipFilters, &ipnetFilter{ipnet: ipnet})
+	}
+
+	var domainFilters *trie.DomainTrie[struct{}]
+	if len(ff.Domain) > 0 {
+		domainFilters = trie.New[struct{}]()
+		for _, domain := range ff.Domain {
+			if err := domainFilters.Insert(domain, struct{}{}); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return ipFilters, domainFilters, nil
+}
@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// NameServer is an upstream DNS server the resolver can send queries to
+type NameServer struct {
+	// Net is the transport used to reach the server: udp, tcp, tls or https
+	Net string
+	// Addr is host:port for udp, tcp and tls servers and the full URL for https servers
+	Addr string
+}
+
+func (ns NameServer) String() string {
+	if ns.Net == "https" {
+		return ns.Addr
+	}
+	return fmt.Sprintf("%s://%s", ns.Net, ns.Addr)
+}
+
+// ParseNameServer parses a nameserver in one of the following forms:
+//
+//	8.8.8.8
+//	udp://8.8.8.8:53
+//	tcp://8.8.8.8
+//	tls://dns.google:853
+//	https://dns.google/dns-query
+func ParseNameServer(s string) (NameServer, error) {
+	if !strings.Contains(s, "://") {
+		s = "udp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return NameServer{}, fmt.Errorf("invalid nameserver %s: %w", s, err)
+	}
+	if u.Hostname() == "" {
+		return NameServer{}, fmt.Errorf("invalid nameserver %s: missing host", s)
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		return NameServer{Net: u.Scheme, Addr: hostWithDefaultPort(u.Host, "53")}, nil
+	case "tls":
+		return NameServer{Net: "tls", Addr: hostWithDefaultPort(u.Host, "853")}, nil
+	case "https":
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		return NameServer{Net: "https", Addr: u.String()}, nil
+	default:
+		return NameServer{}, fmt.Errorf("unsupported nameserver scheme: %s", u.Scheme)
+	}
+}
+
+// ParseNameServers parses each of the given nameservers
+func ParseNameServers(servers []string) ([]NameServer, error) {
+	nameservers := make([]NameServer, 0, len(servers))
+	for _, s := range servers {
+		ns, err := ParseNameServer(s)
+		if err != nil {
+			return nil, err
+		}
+		nameservers = append(nameservers, ns)
+	}
+	return nameservers, nil
+}
+
+func hostWithDefaultPort(host string, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+}
@@ -0,0 +1,377 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/lumavpn/luma/common/cache"
+	"github.com/lumavpn/luma/common/trie"
+	D "github.com/miekg/dns"
+)
+
+const defaultCacheSize = 4096
+
+var (
+	ErrIPNotFound   = errors.New("couldn't find ip")
+	ErrIPVersion    = errors.New("ip version error")
+	ErrNoNameServer = errors.New("no nameserver configured")
+)
+
+// Exchanger answers DNS queries
+type Exchanger interface {
+	ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error)
+}
+
+// Resolver looks up domain names using the configured upstream nameservers
+type Resolver interface {
+	Exchanger
+	// LookupIP returns the IPv4 and (if enabled) IPv6 addresses of host
+	LookupIP(ctx context.Context, host string) ([]netip.Addr, error)
+	// LookupIPv4 returns the IPv4 addresses of host
+	LookupIPv4(ctx context.Context, host string) ([]netip.Addr, error)
+	// LookupIPv6 returns the IPv6 addresses of host
+	LookupIPv6(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// Config is the configuration used to create a Resolver
+type Config struct {
+	// Main is the list of nameservers queried for every lookup
+	Main []NameServer
+	// Default is the list of nameservers used to resolve the hosts of Main
+	Default []NameServer
+	// IPv6 enables AAAA lookups
+	IPv6 bool
+	// CacheSize is the maximum number of cached answers
+	CacheSize int
+	// Hosts is a static table of domains to addresses
+	Hosts *trie.DomainTrie[[]netip.Addr]
+	// Policy maps domain patterns to the nameservers that resolve them instead of Main
+	Policy []Policy
+	// Fallback is the list of nameservers used when FallbackFilter rejects the answer of Main
+	Fallback []NameServer
+	// FallbackFilter decides when the answer of Main is replaced by the answer of Fallback
+	FallbackFilter FallbackFilter
+}
+
+// Policy routes queries for Domain to NameServers
+type Policy struct {
+	// Domain is a domain pattern as accepted by trie.DomainTrie
+	Domain      string
+	NameServers []NameServer
+}
+
+type resolver struct {
+	ipv6     bool
+	hosts    *trie.DomainTrie[[]netip.Addr]
+	main     []dnsClient
+	lruCache *cache.LruCache[string, *D.Msg]
+
+	policy                *trie.DomainTrie[[]dnsClient]
+	fallback              []dnsClient
+	fallbackIPFilters     []fallbackIPFilter
+	fallbackDomainFilters *trie.DomainTrie[struct{}]
+}
+
+// NewResolver creates a new Resolver from the given Config
+func NewResolver(cfg Config) (Resolver, error) {
+	if len(cfg.Main) == 0 {
+		return nil, ErrNoNameServer
+	}
+
+	var bootstrap Resolver
+	if len(cfg.Default) > 0 {
+		for _, ns := range cfg.Default {
+			if _, err := netip.ParseAddr(hostOf(ns)); err != nil {
+				return nil, errors.New("default nameserver should be a pure IP: " + ns.String())
+			}
+		}
+		r, err := newResolver(cfg.Default, nil, cfg.IPv6, cfg.CacheSize, nil)
+		if err != nil {
+			return nil, err
+		}
+		bootstrap = r
+	}
+	r, err := newResolver(cfg.Main, bootstrap, cfg.IPv6, cfg.CacheSize, cfg.Hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Policy) > 0 {
+		r.policy = trie.New[[]dnsClient]()
+		for _, policy := range cfg.Policy {
+			clients, err := transform(policy.NameServers, bootstrap)
+			if err != nil {
+				return nil, err
+			}
+			if err := r.policy.Insert(policy.Domain, clients); err != nil {
+				return nil, fmt.Errorf("invalid nameserver policy %s: %w", policy.Domain, err)
+			}
+		}
+	}
+
+	if len(cfg.Fallback) > 0 {
+		if r.fallback, err = transform(cfg.Fallback, bootstrap); err != nil {
+			return nil, err
+		}
+		r.fallbackIPFilters, r.fallbackDomainFilters, err = newFallbackFilters(cfg.FallbackFilter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback filter: %w", err)
+		}
+	}
+	return r, nil
+}
+
+func newResolver(servers []NameServer, bootstrap Resolver, ipv6 bool, cacheSize int,
+	hosts *trie.DomainTrie[[]netip.Addr]) (*resolver, error) {
+	clients, err := transform(servers, bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	if cacheSize <= 0 {
+		cacheSize = defaultCacheSize
+	}
+	return &resolver{
+		ipv6:     ipv6,
+		hosts:    hosts,
+		main:     clients,
+		lruCache: cache.New(cache.WithSize[string, *D.Msg](cacheSize), cache.WithStale[string, *D.Msg](true)),
+	}, nil
+}
+
+// transform creates a dnsClient for each of the given nameservers
+func transform(servers []NameServer, bootstrap Resolver) ([]dnsClient, error) {
+	clients := make([]dnsClient, 0, len(servers))
+	for _, ns := range servers {
+		switch ns.Net {
+		case "https":
+			c, err := newDoHClient(ns, bootstrap)
+			if err != nil {
+				return nil, err
+			}
+			clients = append(clients, c)
+		default:
+			c, err := newClient(ns, bootstrap)
+			if err != nil {
+				return nil, err
+			}
+			clients = append(clients, c)
+		}
+	}
+	return clients, nil
+}
+
+// LookupIP request with TypeA and TypeAAAA, priority return TypeA
+func (r *resolver) LookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	if !r.ipv6 {
+		return r.LookupIPv4(ctx, host)
+	}
+
+	ch := make(chan []netip.Addr, 1)
+	go func() {
+		ips, _ := r.lookupIP(ctx, host, D.TypeAAAA)
+		ch <- ips
+	}()
+
+	ips, err := r.lookupIP(ctx, host, D.TypeA)
+	if err == nil && len(ips) > 0 {
+		if ipv6s := <-ch; len(ipv6s) > 0 {
+			ips = append(ips, ipv6s...)
+		}
+		return ips, nil
+	}
+
+	if ipv6s := <-ch; len(ipv6s) > 0 {
+		return ipv6s, nil
+	}
+	if err == nil {
+		err = ErrIPNotFound
+	}
+	return nil, err
+}
+
+// LookupIPv4 request with TypeA
+func (r *resolver) LookupIPv4(ctx context.Context, host string) ([]netip.Addr, error) {
+	return r.lookupIP(ctx, host, D.TypeA)
+}
+
+// LookupIPv6 request with TypeAAAA
+func (r *resolver) LookupIPv6(ctx context.Context, host string) ([]netip.Addr, error) {
+	if !r.ipv6 {
+		return nil, ErrIPVersion
+	}
+	return r.lookupIP(ctx, host, D.TypeAAAA)
+}
+
+// ExchangeContext answers the DNS query m from the hosts table, the cache or the upstream nameservers
+func (r *resolver) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	if len(m.Question) == 0 {
+		return nil, errors.New("should have one question at least")
+	}
+	q := m.Question[0]
+
+	if msg := r.exchangeHosts(m, q); msg != nil {
+		return msg, nil
+	}
+	if q.Qtype == D.TypeAAAA && !r.ipv6 {
+		return handleMsgWithEmptyAnswer(m), nil
+	}
+
+	cacheKey := q.String()
+	if cached, expireTime, ok := r.lruCache.GetWithExpire(cacheKey); ok && time.Now().Before(expireTime) {
+		msg := cached.Copy()
+		msg.Id = m.Id
+		setMsgTTL(msg, uint32(time.Until(expireTime).Seconds()))
+		return msg, nil
+	}
+
+	msg, err := r.exchangeWithoutCache(ctx, m, q)
+	if err != nil {
+		return nil, err
+	}
+	putMsgToCache(r.lruCache, cacheKey, msg)
+	return msg, nil
+}
+
+// exchangeWithoutCache sends m to the nameservers selected by the policy and the fallback filters
+func (r *resolver) exchangeWithoutCache(ctx context.Context, m *D.Msg, q D.Question) (*D.Msg, error) {
+	domain := strings.TrimSuffix(q.Name, ".")
+	if clients := r.matchPolicy(domain); len(clients) > 0 {
+		return batchExchange(ctx, clients, m)
+	}
+	if len(r.fallback) == 0 {
+		return batchExchange(ctx, r.main, m)
+	}
+	if r.shouldOnlyQueryFallback(domain) {
+		return batchExchange(ctx, r.fallback, m)
+	}
+	if q.Qtype != D.TypeA && q.Qtype != D.TypeAAAA {
+		return batchExchange(ctx, r.main, m)
+	}
+	return r.ipExchange(ctx, m)
+}
+
+// ipExchange queries the main and fallback nameservers concurrently and only returns the
+// main answer if none of its addresses are rejected by the fallback filters
+func (r *resolver) ipExchange(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	type result struct {
+		msg *D.Msg
+		err error
+	}
+	fallbackCh := make(chan result, 1)
+	go func() {
+		msg, err := batchExchange(ctx, r.fallback, m)
+		fallbackCh <- result{msg, err}
+	}()
+
+	msg, err := batchExchange(ctx, r.main, m)
+	if err == nil {
+		if ips := msgToIP(msg); !r.shouldIPFallback(ips) {
+			return msg, nil
+		}
+	}
+
+	ret := <-fallbackCh
+	if ret.err != nil && err == nil {
+		// the fallback nameservers failed, so the suspicious main answer is still better than nothing
+		return msg, nil
+	}
+	return ret.msg, ret.err
+}
+
+// matchPolicy returns the nameservers of the policy domain matches, if any
+func (r *resolver) matchPolicy(domain string) []dnsClient {
+	if r.policy == nil {
+		return nil
+	}
+	clients, _ := r.policy.Search(domain)
+	return clients
+}
+
+func (r *resolver) shouldOnlyQueryFallback(domain string) bool {
+	if r.fallbackDomainFilters == nil {
+		return false
+	}
+	_, ok := r.fallbackDomainFilters.Search(domain)
+	return ok
+}
+
+func (r *resolver) shouldIPFallback(ips []netip.Addr) bool {
+	for _, ip := range ips {
+		for _, filter := range r.fallbackIPFilters {
+			if filter.Match(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exchangeHosts returns an answer for m from the static hosts table, if there is one
+func (r *resolver) exchangeHosts(m *D.Msg, q D.Question) *D.Msg {
+	if r.hosts == nil || (q.Qtype != D.TypeA && q.Qtype != D.TypeAAAA) {
+		return nil
+	}
+	ips, ok := r.hosts.Search(strings.TrimSuffix(q.Name, "."))
+	if !ok {
+		return nil
+	}
+
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	msg.Authoritative = true
+	for _, ip := range ips {
+		switch {
+		case q.Qtype == D.TypeA && ip.Is4():
+			msg.Answer = append(msg.Answer, &D.A{
+				Hdr: D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 10},
+				A:   ip.AsSlice(),
+			})
+		case q.Qtype == D.TypeAAAA && ip.Is6():
+			msg.Answer = append(msg.Answer, &D.AAAA{
+				Hdr:  D.RR_Header{Name: q.Name, Rrtype: D.TypeAAAA, Class: D.ClassINET, Ttl: 10},
+				AAAA: ip.AsSlice(),
+			})
+		}
+	}
+	return msg
+}
+
+func (r *resolver) lookupIP(ctx context.Context, host string, dnsType uint16) ([]netip.Addr, error) {
+	ip, err := netip.ParseAddr(host)
+	if err == nil {
+		isIPv4 := ip.Is4() || ip.Is4In6()
+		if dnsType == D.TypeAAAA && !isIPv4 {
+			return []netip.Addr{ip}, nil
+		} else if dnsType == D.TypeA && isIPv4 {
+			return []netip.Addr{ip.Unmap()}, nil
+		}
+		return nil, ErrIPVersion
+	}
+
+	query := &D.Msg{}
+	query.SetQuestion(D.Fqdn(host), dnsType)
+
+	msg, err := r.ExchangeContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := msgToIP(msg)
+	if len(ips) == 0 {
+		return nil, ErrIPNotFound
+	}
+	return ips, nil
+}
+
+func hostOf(ns NameServer) string {
+	host, _, err := net.SplitHostPort(ns.Addr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
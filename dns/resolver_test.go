@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lumavpn/luma/common/trie"
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer starts a UDP DNS server answering every A query with ip
+func startServer(t *testing.T, ip string, queries *atomic.Int32) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &D.Server{
+		PacketConn: pc,
+		Handler: D.HandlerFunc(func(w D.ResponseWriter, r *D.Msg) {
+			queries.Add(1)
+			msg := &D.Msg{}
+			msg.SetReply(r)
+			if r.Question[0].Qtype == D.TypeA {
+				msg.Answer = append(msg.Answer, &D.A{
+					Hdr: D.RR_Header{Name: r.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip),
+				})
+			}
+			w.WriteMsg(msg)
+		}),
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestParseNameServer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want NameServer
+	}{
+		{"8.8.8.8", NameServer{Net: "udp", Addr: "8.8.8.8:53"}},
+		{"tcp://1.1.1.1:5353", NameServer{Net: "tcp", Addr: "1.1.1.1:5353"}},
+		{"tls://dns.google", NameServer{Net: "tls", Addr: "dns.google:853"}},
+		{"https://dns.google", NameServer{Net: "https", Addr: "https://dns.google/dns-query"}},
+		{"udp://[2001:4860:4860::8888]", NameServer{Net: "udp", Addr: "[2001:4860:4860::8888]:53"}},
+	}
+	for _, tt := range tests {
+		ns, err := ParseNameServer(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, ns)
+	}
+
+	_, err := ParseNameServer("quic://dns.adguard.com")
+	assert.Error(t, err)
+}
+
+func TestResolver_LookupAndCache(t *testing.T) {
+	var queries atomic.Int32
+	addr := startServer(t, "10.1.2.3", &queries)
+
+	r, err := NewResolver(Config{Main: []NameServer{{Net: "udp", Addr: addr}}})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		ips, err := r.LookupIP(context.Background(), "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.1.2.3")}, ips)
+	}
+	assert.Equal(t, int32(1), queries.Load())
+}
+
+func TestResolver_FirstAnswerWins(t *testing.T) {
+	var queries atomic.Int32
+	good := startServer(t, "10.1.2.3", &queries)
+
+	// nothing listens on the second upstream
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := pc.LocalAddr().String()
+	pc.Close()
+
+	r, err := NewResolver(Config{Main: []NameServer{{Net: "udp", Addr: dead}, {Net: "udp", Addr: good}}})
+	require.NoError(t, err)
+
+	ips, err := r.LookupIPv4(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.1.2.3")}, ips)
+}
+
+func TestResolver_Hosts(t *testing.T) {
+	var queries atomic.Int32
+	addr := startServer(t, "10.1.2.3", &queries)
+
+	hosts := trie.New[[]netip.Addr]()
+	require.NoError(t, hosts.Insert("+.luma.lan", []netip.Addr{netip.MustParseAddr("192.168.1.1")}))
+
+	r, err := NewResolver(Config{Main: []NameServer{{Net: "udp", Addr: addr}}, Hosts: hosts})
+	require.NoError(t, err)
+
+	ips, err := r.LookupIP(context.Background(), "router.luma.lan")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.1.1")}, ips)
+	assert.Equal(t, int32(0), queries.Load())
+}
+
+func TestResolver_Policy(t *testing.T) {
+	var mainQueries, corpQueries atomic.Int32
+	main := startServer(t, "10.1.2.3", &mainQueries)
+	corp := startServer(t, "172.16.0.10", &corpQueries)
+
+	r, err := NewResolver(Config{
+		Main:   []NameServer{{Net: "udp", Addr: main}},
+		Policy: []Policy{{Domain: "+.corp.internal", NameServers: []NameServer{{Net: "udp", Addr: corp}}}},
+	})
+	require.NoError(t, err)
+
+	ips, err := r.LookupIPv4(context.Background(), "git.corp.internal")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("172.16.0.10")}, ips)
+	assert.Equal(t, int32(0), mainQueries.Load())
+
+	ips, err = r.LookupIPv4(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.1.2.3")}, ips)
+	assert.Equal(t, int32(1), corpQueries.Load())
+}
+
+func TestResolver_Fallback(t *testing.T) {
+	var mainQueries, fallbackQueries atomic.Int32
+	poisoned := startServer(t, "240.0.0.1", &mainQueries)
+	fallback := startServer(t, "93.184.216.34", &fallbackQueries)
+
+	r, err := NewResolver(Config{
+		Main:     []NameServer{{Net: "udp", Addr: poisoned}},
+		Fallback: []NameServer{{Net: "udp", Addr: fallback}},
+		FallbackFilter: FallbackFilter{
+			IPCIDR: []netip.Prefix{netip.MustParsePrefix("240.0.0.0/4")},
+			Domain: []string{"+.google.com"},
+		},
+	})
+	require.NoError(t, err)
+
+	ips, err := r.LookupIPv4(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, ips)
+
+	mainQueries.Store(0)
+	_, err = r.LookupIPv4(context.Background(), "www.google.com")
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), mainQueries.Load())
+}
+
+func TestResolver_FallbackGeoIPCode(t *testing.T) {
+	_, err := NewResolver(Config{
+		Main:           []NameServer{{Net: "udp", Addr: "127.0.0.1:53"}},
+		Fallback:       []NameServer{{Net: "udp", Addr: "127.0.0.1:5353"}},
+		FallbackFilter: FallbackFilter{GeoIP: true},
+	})
+	assert.ErrorContains(t, err, "geoip filter requires a country code")
+}
@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/lumavpn/luma/log"
+	D "github.com/miekg/dns"
+)
+
+// Server is a DNS server listening on both UDP and TCP that answers queries with an Exchanger
+type Server struct {
+	addr      string
+	handler   Exchanger
+	udpServer *D.Server
+	tcpServer *D.Server
+}
+
+// NewServer returns a new Server for the given address. Call Start to begin serving
+func NewServer(addr string, handler Exchanger) *Server {
+	return &Server{
+		addr:    addr,
+		handler: handler,
+	}
+}
+
+// Address returns the address the server listens on
+func (s *Server) Address() string {
+	return s.addr
+}
+
+// Start starts listening on the UDP and TCP address of the server
+func (s *Server) Start() error {
+	if s.handler == nil {
+		return errors.New("dns server requires a handler")
+	}
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	s.addr = pc.LocalAddr().String()
+
+	s.udpServer = &D.Server{PacketConn: pc, Handler: s}
+	s.tcpServer = &D.Server{Listener: l, Handler: s}
+	go serve(s.udpServer)
+	go serve(s.tcpServer)
+
+	log.Infof("DNS server listening at: %s", s.addr)
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	var errs []error
+	if s.udpServer != nil {
+		errs = append(errs, s.udpServer.Shutdown())
+	}
+	if s.tcpServer != nil {
+		errs = append(errs, s.tcpServer.Shutdown())
+	}
+	return errors.Join(errs...)
+}
+
+// ServeDNS implements the miekg/dns Handler interface
+func (s *Server) ServeDNS(w D.ResponseWriter, r *D.Msg) {
+	msg, err := HandleMsg(context.Background(), s.handler, r)
+	if err != nil {
+		log.Debugf("[DNS Server] query %s from %s: %v", questionOf(r), w.RemoteAddr(), err)
+	}
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		msg.Truncate(UDPSize(r))
+	}
+	w.WriteMsg(msg)
+}
+
+// HandleMsg answers r with handler and always returns a message that can be sent back
+// to the client, with SERVFAIL set if the query could not be answered
+func HandleMsg(ctx context.Context, handler Exchanger, r *D.Msg) (*D.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	msg, err := handler.ExchangeContext(ctx, r)
+	if err != nil {
+		m := &D.Msg{}
+		m.SetRcode(r, D.RcodeServerFailure)
+		return m, err
+	}
+	msg.Id = r.Id
+	msg.Compress = true
+	return msg, nil
+}
+
+func serve(server *D.Server) {
+	if err := server.ActivateAndServe(); err != nil {
+		log.Debugf("[DNS Server] stopped: %v", err)
+	}
+}
+
+func questionOf(m *D.Msg) string {
+	if len(m.Question) == 0 {
+		return ""
+	}
+	return m.Question[0].Name
+}
@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lumavpn/luma/dns/fakeip"
+	M "github.com/lumavpn/luma/metadata"
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_FakeIP(t *testing.T) {
+	var queries atomic.Int32
+	upstream := startServer(t, "10.1.2.3", &queries)
+
+	r, err := NewResolver(Config{Main: []NameServer{{Net: "udp", Addr: upstream}}})
+	require.NoError(t, err)
+	pool, err := fakeip.New(fakeip.Options{IPNet: netip.MustParsePrefix("198.18.0.1/16")})
+	require.NoError(t, err)
+	enhancer, err := NewEnhancer(M.DNSFakeIP, r, pool)
+	require.NoError(t, err)
+
+	server := NewServer("127.0.0.1:0", enhancer)
+	require.NoError(t, server.Start())
+	defer server.Close()
+
+	for _, network := range []string{"udp", "tcp"} {
+		m := &D.Msg{}
+		m.SetQuestion("example.com.", D.TypeA)
+		c := &D.Client{Net: network}
+		msg, _, err := c.Exchange(m, server.Address())
+		require.NoError(t, err, network)
+		require.Len(t, msg.Answer, 1)
+
+		ip, _ := netip.AddrFromSlice(msg.Answer[0].(*D.A).A)
+		assert.True(t, enhancer.IsFakeIP(ip.Unmap()))
+		host, ok := enhancer.FindHostByIP(ip.Unmap())
+		assert.True(t, ok)
+		assert.Equal(t, "example.com", host)
+	}
+	assert.Equal(t, int32(0), queries.Load())
+
+	m := &D.Msg{}
+	m.SetQuestion("example.com.", D.TypeMX)
+	_, _, err = (&D.Client{}).Exchange(m, server.Address())
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), queries.Load())
+}
@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/lumavpn/luma/common/cache"
+	"github.com/lumavpn/luma/log"
+	D "github.com/miekg/dns"
+)
+
+// minTTL is the lowest TTL an answer is cached for
+const minTTL = 1
+
+func putMsgToCache(c *cache.LruCache[string, *D.Msg], key string, msg *D.Msg) {
+	var ttl uint32
+	switch {
+	case len(msg.Answer) != 0:
+		ttl = minAnswerTTL(msg.Answer)
+	case len(msg.Ns) != 0:
+		ttl = minAnswerTTL(msg.Ns)
+	case len(msg.Extra) != 0:
+		ttl = minAnswerTTL(msg.Extra)
+	default:
+		log.Debugf("[DNS] response msg empty: %#v", msg)
+		return
+	}
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+
+	c.SetWithExpire(key, msg.Copy(), time.Now().Add(time.Duration(ttl)*time.Second))
+}
+
+func minAnswerTTL(records []D.RR) uint32 {
+	ttl := records[0].Header().Ttl
+	for _, rr := range records[1:] {
+		if t := rr.Header().Ttl; t < ttl {
+			ttl = t
+		}
+	}
+	return ttl
+}
+
+func setMsgTTL(msg *D.Msg, ttl uint32) {
+	for _, answer := range msg.Answer {
+		answer.Header().Ttl = ttl
+	}
+	for _, ns := range msg.Ns {
+		ns.Header().Ttl = ttl
+	}
+	for _, extra := range msg.Extra {
+		extra.Header().Ttl = ttl
+	}
+}
+
+func handleMsgWithEmptyAnswer(r *D.Msg) *D.Msg {
+	msg := &D.Msg{}
+	msg.Answer = []D.RR{}
+
+	msg.SetRcode(r, D.RcodeSuccess)
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+
+	return msg
+}
+
+func msgToIP(msg *D.Msg) []netip.Addr {
+	ips := []netip.Addr{}
+
+	for _, answer := range msg.Answer {
+		switch ans := answer.(type) {
+		case *D.AAAA:
+			if ip, ok := netip.AddrFromSlice(ans.AAAA); ok {
+				ips = append(ips, ip)
+			}
+		case *D.A:
+			if ip, ok := netip.AddrFromSlice(ans.A); ok {
+				ips = append(ips, ip.Unmap())
+			}
+		}
+	}
+
+	return ips
+}
+
+// batchExchange sends m to every client concurrently and returns the first usable answer
+func batchExchange(ctx context.Context, clients []dnsClient, m *D.Msg) (*D.Msg, error) {
+	if len(clients) == 0 {
+		return nil, ErrNoNameServer
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	type result struct {
+		msg *D.Msg
+		err error
+	}
+	ch := make(chan result, len(clients))
+	for _, c := range clients {
+		go func(c dnsClient) {
+			msg, err := c.ExchangeContext(ctx, m)
+			if err == nil && (msg.Rcode == D.RcodeServerFailure || msg.Rcode == D.RcodeRefused) {
+				err = fmt.Errorf("server failure: %s", D.RcodeToString[msg.Rcode])
+			}
+			if err != nil {
+				err = fmt.Errorf("%s: %w", c.Address(), err)
+			}
+			ch <- result{msg, err}
+		}(c)
+	}
+
+	var errs []error
+	for range clients {
+		ret := <-ch
+		if ret.err == nil {
+			return ret.msg, nil
+		}
+		log.Debugf("[DNS] exchange with %v", ret.err)
+		errs = append(errs, ret.err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// UDPSize returns the largest response the sender of m accepts over UDP
+func UDPSize(m *D.Msg) int {
+	if opt := m.IsEdns0(); opt != nil && opt.UDPSize() > D.MinMsgSize {
+		return int(opt.UDPSize())
+	}
+	return D.MinMsgSize
+}
@@ -0,0 +1,66 @@
+// Package testutil holds the servers shared by the tests of several packages
+package testutil
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ServeEcho echoes the data of the connections accepted by l until it is closed
+func ServeEcho(l interface{ Accept() (net.Conn, error) }) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			io.Copy(c, c)
+		}()
+	}
+}
+
+// ServeEchoPackets sends the packets read from pc back to their sender until pc is closed
+func ServeEchoPackets(pc net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo(buf[:n], addr)
+	}
+}
+
+// StartTCPEcho starts a TCP echo server on loopback
+func StartTCPEcho(t testing.TB) *net.TCPAddr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go ServeEcho(l)
+	return l.Addr().(*net.TCPAddr)
+}
+
+// StartUDPEcho starts a UDP echo server on loopback
+func StartUDPEcho(t testing.TB) *net.UDPAddr {
+	return startUDPEcho(t, "127.0.0.1:0")
+}
+
+// StartEchoServers starts TCP and UDP echo servers on the same loopback port
+func StartEchoServers(t testing.TB) int {
+	port := StartTCPEcho(t).Port
+	startUDPEcho(t, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	return port
+}
+
+func startUDPEcho(t testing.TB, addr string) *net.UDPAddr {
+	pc, err := net.ListenPacket("udp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go ServeEchoPackets(pc)
+	return pc.LocalAddr().(*net.UDPAddr)
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/tunnel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startListener(t *testing.T, authenticator auth.Authenticator) *Listener {
+	tun := tunnel.New()
+	tun.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	l, err := New("127.0.0.1:0", authenticator, tun)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestListener_Forward(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Proxy-Connection"))
+		assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "user", Pass: "pass"}})
+	l := startListener(t, authenticator)
+
+	proxyURL := &url.URL{Scheme: "http", Host: l.Address(), User: url.UserPassword("user", "pass")}
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	defer client.CloseIdleConnections()
+
+	for _, path := range []string{"/a", "/b"} {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "hello "+path, string(body))
+	}
+
+	proxyURL.User = url.UserPassword("user", "wrong")
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+}
+
+func TestListener_KeepAliveTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer server.Close()
+
+	tun := tunnel.New()
+	tun.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	// the timeout is shortened, the header still advertises it in whole seconds
+	conn, serverConn := net.Pipe()
+	defer conn.Close()
+	done := make(chan struct{})
+	go func() {
+		handleConn(serverConn, bufio.NewReader(serverConn), nil, tun, 100*time.Millisecond)
+		close(done)
+	}()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, request.WriteProxy(conn))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, request)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+
+	// the idle connection is closed once the advertised timeout is over
+	start := time.Now()
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	<-done
+}
+
+func TestListener_Connect(t *testing.T) {
+	echo := testutil.StartTCPEcho(t)
+
+	l := startListener(t, nil)
+	conn, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echo, echo)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(br, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
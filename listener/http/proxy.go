@@ -0,0 +1,186 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+)
+
+// keepAliveTimeout is how long an idle keep-alive connection waits for its next request
+const keepAliveTimeout = 4 * time.Second
+
+// HandleConn serves the HTTP proxy requests read from conn. CONNECT requests hand the rest of
+// the connection to handler, plain requests are forwarded through handler one at a time
+func HandleConn(c net.Conn, authenticator auth.Authenticator, handler adapter.TransportHandler) {
+	HandleBufferedConn(c, bufio.NewReader(c), authenticator, handler)
+}
+
+// HandleBufferedConn is HandleConn for a connection whose first bytes were already read into br
+func HandleBufferedConn(c net.Conn, br *bufio.Reader, authenticator auth.Authenticator, handler adapter.TransportHandler) {
+	handleConn(c, br, authenticator, handler, keepAliveTimeout)
+}
+
+// handleConn is HandleBufferedConn closing keep-alive connections idle for idleTimeout
+func handleConn(c net.Conn, br *bufio.Reader, authenticator auth.Authenticator, handler adapter.TransportHandler, idleTimeout time.Duration) {
+	conn := &bufferedConn{Conn: c, r: br}
+	additions := []adapter.Addition{
+		adapter.WithInType(proto.Inbound_HTTP),
+		adapter.WithSrcAddr(c.RemoteAddr()),
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			metadata := &M.Metadata{}
+			if err := metadata.SetRemoteAddress(address); err != nil {
+				return nil, err
+			}
+			left, right := net.Pipe()
+			handler.HandleTCP(adapter.NewTCPConn(right, metadata, additions...))
+			return left, nil
+		},
+		// the body of responses is relayed as is
+		DisableCompression: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	keepAlive := true
+	for keepAlive {
+		request, err := http.ReadRequest(br)
+		if err != nil {
+			break
+		}
+		c.SetReadDeadline(time.Time{})
+		keepAlive = !request.Close
+
+		if authenticator != nil && !authenticate(request, authenticator) {
+			writeResponse(conn, request, responseWith(request, http.StatusProxyAuthRequired))
+			break
+		}
+
+		if request.Method == http.MethodConnect {
+			if _, err := fmt.Fprintf(conn, "HTTP/%d.%d %03d %s\r\n\r\n", request.ProtoMajor, request.ProtoMinor,
+				http.StatusOK, "Connection established"); err != nil {
+				break
+			}
+			metadata := &M.Metadata{}
+			if err := metadata.SetRemoteAddress(hostPort(request.URL.Host, "443")); err != nil {
+				break
+			}
+			handler.HandleTCP(adapter.NewTCPConn(conn, metadata, additions...))
+			return
+		}
+
+		if request.URL.Scheme != "http" || request.URL.Host == "" {
+			writeResponse(conn, request, responseWith(request, http.StatusBadRequest))
+			break
+		}
+
+		removeHopByHopHeaders(request.Header)
+		removeExtraHTTPHostPort(request)
+		request.RequestURI = ""
+
+		resp, err := transport.RoundTrip(request)
+		if err != nil {
+			log.Debugf("[HTTP] %s --> %s: %v", c.RemoteAddr(), request.URL.Host, err)
+			resp = responseWith(request, http.StatusBadGateway)
+		} else {
+			removeHopByHopHeaders(resp.Header)
+		}
+
+		if keepAlive {
+			resp.Header.Set("Proxy-Connection", "keep-alive")
+			resp.Header.Set("Connection", "keep-alive")
+			resp.Header.Set("Keep-Alive", "timeout="+strconv.Itoa(int(idleTimeout/time.Second)))
+		}
+		resp.Close = !keepAlive
+
+		if err := writeResponse(conn, request, resp); err != nil {
+			break
+		}
+		c.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+
+	conn.Close()
+}
+
+// writeResponse writes resp to conn and closes its body
+func writeResponse(conn net.Conn, request *http.Request, resp *http.Response) error {
+	defer resp.Body.Close()
+	if request.Body != nil {
+		request.Body.Close()
+	}
+	return resp.Write(conn)
+}
+
+func authenticate(request *http.Request, authenticator auth.Authenticator) bool {
+	credential := parseBasicProxyAuthorization(request)
+	if credential == "" {
+		return false
+	}
+	user, pass, ok := decodeBasicProxyAuthorization(credential)
+	return ok && authenticator.Verify(user, pass)
+}
+
+func responseWith(request *http.Request, statusCode int) *http.Response {
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		Proto:      request.Proto,
+		ProtoMajor: request.ProtoMajor,
+		ProtoMinor: request.ProtoMinor,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+	}
+	if statusCode == http.StatusProxyAuthRequired {
+		resp.Header.Set("Proxy-Authenticate", "Basic")
+	}
+	return resp
+}
+
+// parseBasicProxyAuthorization returns the credentials of a Basic Proxy-Authorization header
+func parseBasicProxyAuthorization(request *http.Request) string {
+	value := request.Header.Get("Proxy-Authorization")
+	scheme, credential, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return ""
+	}
+	return credential
+}
+
+func decodeBasicProxyAuthorization(credential string) (string, string, bool) {
+	plain, err := base64.StdEncoding.DecodeString(credential)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(plain), ":")
+}
+
+// hostPort adds defaultPort to host if it has no port
+func hostPort(host string, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+}
+
+// bufferedConn reads the bytes already buffered by the request reader before the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/common/auth"
+	N "github.com/lumavpn/luma/common/net"
+)
+
+// Listener accepts HTTP proxy connections and hands their flows to a TransportHandler
+type Listener struct {
+	listener net.Listener
+	addr     string
+}
+
+// New starts an HTTP proxy listener on addr. Clients must authenticate if authenticator is not nil
+func New(addr string, authenticator auth.Authenticator, handler adapter.TransportHandler) (*Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hl := &Listener{
+		listener: l,
+		addr:     addr,
+	}
+	go N.Serve(l, func(c net.Conn) { HandleConn(c, authenticator, handler) })
+
+	return hl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *Listener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *Listener) Address() string {
+	return l.listener.Addr().String()
+}
+
+// Close stops the listener
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
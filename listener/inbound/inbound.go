@@ -0,0 +1,261 @@
+// Package inbound builds the server mode listeners of the listeners section of the config,
+// whose sessions leave through DIRECT
+package inbound
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/listener/shadowsocks"
+	"github.com/lumavpn/luma/listener/socks"
+	"github.com/lumavpn/luma/listener/trojan"
+	ss "github.com/lumavpn/luma/transport/shadowsocks"
+	"gopkg.in/yaml.v3"
+)
+
+// Listener is a running server mode listener
+type Listener interface {
+	// Name is the name of the listener
+	Name() string
+	// Address is the address the listener is bound to
+	Address() string
+	// Close stops the listener
+	Close() error
+}
+
+// BaseOption are the settings shared by all listeners
+type BaseOption struct {
+	Name string `yaml:"name"`
+	// Listen is the address to listen on, all addresses if empty
+	Listen string `yaml:"listen"`
+	Port   int    `yaml:"port"`
+}
+
+// SocksUser is the credentials of a SOCKS5 client
+type SocksUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// SocksOption is the configuration of a SOCKS5 listener, which requires authentication
+type SocksOption struct {
+	BaseOption `yaml:",inline"`
+	Users      []SocksUser `yaml:"users"`
+	UDP        bool        `yaml:"udp"`
+}
+
+// ShadowsocksOption is the configuration of a Shadowsocks listener
+type ShadowsocksOption struct {
+	BaseOption `yaml:",inline"`
+	Cipher     string `yaml:"cipher"`
+	Password   string `yaml:"password"`
+	UDP        bool   `yaml:"udp"`
+}
+
+// TrojanOption is the configuration of a Trojan listener
+type TrojanOption struct {
+	BaseOption `yaml:",inline"`
+	Password   string `yaml:"password"`
+	// Certificate and PrivateKey are PEM encoded or the paths of PEM files
+	Certificate string   `yaml:"certificate"`
+	PrivateKey  string   `yaml:"private-key"`
+	ALPN        []string `yaml:"alpn"`
+}
+
+// ParseListener starts the listener described by mapping, an entry of the listeners section
+// of the config, handing its sessions to handler
+func ParseListener(mapping map[string]any, handler adapter.TransportHandler) (Listener, error) {
+	name, _ := mapping["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("missing listener name")
+	}
+	listenerType, _ := mapping["type"].(string)
+
+	var l Listener
+	var err error
+	switch strings.ToLower(listenerType) {
+	case "socks5":
+		option := &SocksOption{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+		l, err = NewSocks(*option, handler)
+	case "shadowsocks":
+		option := &ShadowsocksOption{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+		l, err = NewShadowsocks(*option, handler)
+	case "trojan":
+		option := &TrojanOption{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+		l, err = NewTrojan(*option, handler)
+	default:
+		return nil, fmt.Errorf("listener %s: unsupported type %q", name, listenerType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: %w", name, err)
+	}
+	return l, nil
+}
+
+// NewSocks starts a SOCKS5 listener
+func NewSocks(option SocksOption, handler adapter.TransportHandler) (Listener, error) {
+	addr, err := option.address()
+	if err != nil {
+		return nil, err
+	}
+	if len(option.Users) == 0 {
+		return nil, errors.New("missing users")
+	}
+	users := make([]auth.AuthUser, 0, len(option.Users))
+	for _, user := range option.Users {
+		if user.Username == "" || len(user.Username) > 255 || len(user.Password) > 255 {
+			return nil, fmt.Errorf("invalid user %q", user.Username)
+		}
+		users = append(users, auth.AuthUser{User: user.Username, Pass: user.Password})
+	}
+
+	additions := option.additions()
+	tcpListener, err := socks.New(addr, auth.NewAuthenticator(users), handler, additions...)
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{name: option.Name, tcp: tcpListener}
+	if option.UDP {
+		if l.udp, err = tcpListener.ListenUDP(handler, additions...); err != nil {
+			tcpListener.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// NewShadowsocks starts a Shadowsocks listener
+func NewShadowsocks(option ShadowsocksOption, handler adapter.TransportHandler) (Listener, error) {
+	addr, err := option.address()
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := ss.NewCipher(option.Cipher, option.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	additions := option.additions()
+	tcpListener, err := shadowsocks.New(addr, cipher, handler, additions...)
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{name: option.Name, tcp: tcpListener}
+	if option.UDP {
+		// the relay shares the port of the TCP listener
+		if l.udp, err = shadowsocks.NewUDP(tcpListener.Address(), cipher, handler, additions...); err != nil {
+			tcpListener.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// NewTrojan starts a Trojan listener
+func NewTrojan(option TrojanOption, handler adapter.TransportHandler) (Listener, error) {
+	addr, err := option.address()
+	if err != nil {
+		return nil, err
+	}
+	if option.Password == "" {
+		return nil, errors.New("missing password")
+	}
+	certificate, err := loadPEM(option.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("certificate: %w", err)
+	}
+	privateKey, err := loadPEM(option.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certificate, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   option.ALPN,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	tcpListener, err := trojan.New(addr, tlsConfig, option.Password, handler, option.additions()...)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{name: option.Name, tcp: tcpListener}, nil
+}
+
+// address returns the address to listen on
+func (o BaseOption) address() (string, error) {
+	if o.Port <= 0 || o.Port > 65535 {
+		return "", fmt.Errorf("invalid port %d", o.Port)
+	}
+	return net.JoinHostPort(o.Listen, strconv.Itoa(o.Port)), nil
+}
+
+// additions returns the additions of the sessions of the listener, which leave through DIRECT
+func (o BaseOption) additions() []adapter.Addition {
+	return []adapter.Addition{adapter.WithInName(o.Name), adapter.WithSpecialProxy("DIRECT")}
+}
+
+// listener is a TCP listener and its optional UDP relay
+type listener struct {
+	name string
+	tcp  interface {
+		Address() string
+		Close() error
+	}
+	udp interface{ Close() error }
+}
+
+func (l *listener) Name() string {
+	return l.name
+}
+
+func (l *listener) Address() string {
+	return l.tcp.Address()
+}
+
+func (l *listener) Close() error {
+	err := l.tcp.Close()
+	if l.udp != nil {
+		err = errors.Join(err, l.udp.Close())
+	}
+	return err
+}
+
+// loadPEM returns s if it is PEM encoded, or the content of the file at path s
+func loadPEM(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("missing")
+	}
+	if strings.Contains(s, "-----BEGIN") {
+		return []byte(s), nil
+	}
+	return os.ReadFile(s)
+}
+
+// decodeOption decodes the fields of mapping into the yaml tagged struct option
+func decodeOption(mapping map[string]any, option any) error {
+	data, err := yaml.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, option)
+}
@@ -0,0 +1,194 @@
+package inbound
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/internal/testutil"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/tunnel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler records the metadata of the sessions it hands to a tunnel
+type recordingHandler struct {
+	tunnel.Tunnel
+	mu       sync.Mutex
+	sessions []*M.Metadata
+}
+
+func newRecordingHandler() *recordingHandler {
+	t := tunnel.New()
+	t.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	return &recordingHandler{Tunnel: t}
+}
+
+func (h *recordingHandler) HandleTCP(conn adapter.TCPConn) {
+	h.mu.Lock()
+	h.sessions = append(h.sessions, conn.Metadata())
+	h.mu.Unlock()
+	h.Tunnel.HandleTCP(conn)
+}
+
+func (h *recordingHandler) HandleUDP(conn adapter.UDPConn) {
+	h.mu.Lock()
+	h.sessions = append(h.sessions, conn.Metadata())
+	h.mu.Unlock()
+	h.Tunnel.HandleUDP(conn)
+}
+
+// testCertificate returns a self-signed certificate and its key, PEM encoded
+func testCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+// testEcho checks TCP, and UDP if udp, through p to the echo servers on port
+func testEcho(t *testing.T, p proxy.Proxy, port int, udp bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := &M.Metadata{Network: M.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(port)}
+	c, err := p.DialContext(ctx, m)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	if !udp {
+		return
+	}
+	m = &M.Metadata{Network: M.UDP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(port)}
+	pc, err := p.ListenPacketContext(ctx, m)
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = pc.WriteTo([]byte("query"), m.UDPAddr())
+	require.NoError(t, err)
+	buf = make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+	assert.Equal(t, m.UDPAddr().String(), from.String())
+}
+
+func TestListeners(t *testing.T) {
+	echoPort := testutil.StartEchoServers(t)
+	certificate, privateKey := testCertificate(t)
+
+	for _, test := range []struct {
+		name     string
+		listener map[string]any
+		proxy    map[string]any
+		udp      bool
+	}{
+		{
+			name: "socks5",
+			listener: map[string]any{
+				"type": "socks5", "udp": true,
+				"users": []any{map[string]any{"username": "user", "password": "pass"}},
+			},
+			proxy: map[string]any{"type": "socks5", "username": "user", "password": "pass", "udp": true},
+			udp:   true,
+		},
+		{
+			name:     "shadowsocks",
+			listener: map[string]any{"type": "shadowsocks", "cipher": "chacha20-ietf-poly1305", "password": "password", "udp": true},
+			proxy:    map[string]any{"type": "shadowsocks", "cipher": "chacha20-ietf-poly1305", "password": "password", "udp": true},
+			udp:      true,
+		},
+		{
+			name:     "shadowsocks with smux",
+			listener: map[string]any{"type": "shadowsocks", "cipher": "aes-128-gcm", "password": "password"},
+			proxy: map[string]any{
+				"type": "shadowsocks", "cipher": "aes-128-gcm", "password": "password",
+				"smux": map[string]any{"enabled": true, "padding": true},
+			},
+		},
+		{
+			name: "trojan",
+			listener: map[string]any{
+				"type": "trojan", "password": "password", "certificate": certificate, "private-key": privateKey,
+			},
+			proxy: map[string]any{"type": "trojan", "password": "password", "sni": "example.com", "skip-cert-verify": true, "udp": true},
+			udp:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newRecordingHandler()
+			test.listener["name"] = test.name
+			test.listener["listen"] = "127.0.0.1"
+			// pick a free port, the listener needs a fixed one
+			probe, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			port := probe.Addr().(*net.TCPAddr).Port
+			probe.Close()
+			test.listener["port"] = port
+
+			l, err := ParseListener(test.listener, handler)
+			require.NoError(t, err)
+			defer l.Close()
+			assert.Equal(t, test.name, l.Name())
+
+			test.proxy["name"] = test.name
+			test.proxy["server"] = "127.0.0.1"
+			test.proxy["port"] = port
+			p, err := proxy.ParseProxy(test.proxy)
+			require.NoError(t, err)
+			testEcho(t, p, echoPort, test.udp)
+
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			require.NotEmpty(t, handler.sessions)
+			for _, m := range handler.sessions {
+				assert.Equal(t, test.name, m.InboundName)
+				assert.Equal(t, "DIRECT", m.SpecialProxy)
+				assert.Equal(t, uint16(echoPort), m.DstPort)
+			}
+		})
+	}
+}
+
+func TestParseListener(t *testing.T) {
+	handler := newRecordingHandler()
+	for _, mapping := range []map[string]any{
+		{"type": "socks5", "port": 1080},
+		{"name": "socks", "type": "socks5", "port": 1080},
+		{"name": "ss", "type": "shadowsocks", "port": 8388, "cipher": "rc4-md5", "password": "password"},
+		{"name": "trojan", "type": "trojan", "port": 443, "password": "password"},
+		{"name": "http", "type": "http", "port": 8080},
+		{"name": "ss", "type": "shadowsocks", "port": 70000, "cipher": "aes-128-gcm", "password": "password"},
+	} {
+		_, err := ParseListener(mapping, handler)
+		assert.Error(t, err, mapping)
+	}
+}
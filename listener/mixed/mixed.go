@@ -0,0 +1,87 @@
+package mixed
+
+import (
+	"net"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/common/auth"
+	N "github.com/lumavpn/luma/common/net"
+	"github.com/lumavpn/luma/listener/http"
+	"github.com/lumavpn/luma/listener/socks"
+	"github.com/lumavpn/luma/transport/socks4"
+	"github.com/lumavpn/luma/transport/socks5"
+)
+
+// handshakeTimeout bounds waiting for the request of a client, so that idle connections do not
+// stay open
+const handshakeTimeout = 10 * time.Second
+
+// Listener accepts SOCKS4, SOCKS5 and HTTP proxy connections on a single port
+type Listener struct {
+	listener     net.Listener
+	addr         string
+	associations *socks.Associations
+}
+
+// New starts a mixed listener on addr. Clients must authenticate if authenticator is not nil
+func New(addr string, authenticator auth.Authenticator, handler adapter.TransportHandler) (*Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ml := &Listener{
+		listener:     l,
+		addr:         addr,
+		associations: socks.NewAssociations(),
+	}
+	go N.Serve(l, func(c net.Conn) { handleConn(c, authenticator, handler, ml.associations, handshakeTimeout) })
+
+	return ml, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *Listener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *Listener) Address() string {
+	return l.listener.Addr().String()
+}
+
+// ListenUDP starts a SOCKS5 UDP relay on the address of the listener, accepting only the
+// packets of the clients holding a UDP association with it
+func (l *Listener) ListenUDP(handler adapter.TransportHandler) (*socks.UDPListener, error) {
+	return socks.NewUDP(l.Address(), l.associations, handler)
+}
+
+// Close stops the listener
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// handleConn dispatches conn to the handler of the protocol given by its first byte. The handlers
+// clear the read deadline of timeout once they have read the request
+func handleConn(conn net.Conn, authenticator auth.Authenticator, handler adapter.TransportHandler, associations *socks.Associations, timeout time.Duration) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	bufConn := N.NewBufferedConn(conn)
+	head, err := bufConn.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	switch head[0] {
+	case socks4.Version:
+		socks.HandleSocks4(bufConn, authenticator, handler)
+	case socks5.Version:
+		socks.HandleSocks5(bufConn, authenticator, handler, associations)
+	default:
+		http.HandleBufferedConn(bufConn, bufConn.Reader(), authenticator, handler)
+	}
+}
@@ -0,0 +1,140 @@
+package mixed
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/tunnel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xproxy "golang.org/x/net/proxy"
+)
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+// dialSocks4a sends a SOCKS4a CONNECT request for localhost:port to addr
+func dialSocks4a(t *testing.T, addr, userID string, port int) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := []byte{4, 1, byte(port >> 8), byte(port), 0, 0, 0, 1}
+	req = append(append(req, userID...), 0)
+	req = append(append(req, "localhost"...), 0)
+	_, err = conn.Write(req)
+	require.NoError(t, err)
+	return conn
+}
+
+func TestListener(t *testing.T) {
+	echo := testutil.StartTCPEcho(t)
+	tun := tunnel.New()
+	tun.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	l, err := New("127.0.0.1:0", nil, tun)
+	require.NoError(t, err)
+	defer l.Close()
+
+	t.Run("socks4a", func(t *testing.T) {
+		conn := dialSocks4a(t, l.Address(), "luma", echo.Port)
+		defer conn.Close()
+		reply := make([]byte, 8)
+		_, err := io.ReadFull(conn, reply)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x5a), reply[1])
+		assertEcho(t, conn)
+	})
+
+	t.Run("socks5", func(t *testing.T) {
+		dialer, err := xproxy.SOCKS5("tcp", l.Address(), nil, xproxy.Direct)
+		require.NoError(t, err)
+		conn, err := dialer.Dial("tcp", echo.String())
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEcho(t, conn)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("pong"))
+		})}
+		hl, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go server.Serve(hl)
+		defer server.Close()
+
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: l.Address()})},
+			Timeout:   5 * time.Second,
+		}
+		resp, err := client.Get("http://" + hl.Addr().String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(body))
+	})
+}
+
+func TestListenerSocks4Auth(t *testing.T) {
+	echo := testutil.StartTCPEcho(t)
+	tun := tunnel.New()
+	tun.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	// a user without password does not let SOCKS4 through either
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "luma"}})
+	l, err := New("127.0.0.1:0", authenticator, tun)
+	require.NoError(t, err)
+	defer l.Close()
+
+	conn := dialSocks4a(t, l.Address(), "luma", echo.Port)
+	defer conn.Close()
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Len(t, reply, 8)
+	assert.Equal(t, byte(0x5b), reply[1])
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	tun := tunnel.New()
+	tun.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+
+	for _, test := range []struct {
+		name string
+		data string
+	}{
+		{"idle", ""},
+		{"partial http request", "GET http://example.com/ HTTP/1.1\r\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			done := make(chan struct{})
+			go func() {
+				handleConn(server, nil, tun, nil, 50*time.Millisecond)
+				close(done)
+			}()
+
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+			if test.data != "" {
+				_, err := client.Write([]byte(test.data))
+				require.NoError(t, err)
+			}
+			_, err := io.ReadAll(client)
+			assert.NoError(t, err)
+			<-done
+		})
+	}
+}
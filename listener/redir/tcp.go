@@ -0,0 +1,62 @@
+package redir
+
+import (
+	"net"
+
+	"github.com/lumavpn/luma/adapter"
+	N "github.com/lumavpn/luma/common/net"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+)
+
+// Listener accepts connections redirected to it by the REDIRECT target of netfilter
+type Listener struct {
+	listener net.Listener
+	addr     string
+}
+
+// New starts a redirect listener on addr
+func New(addr string, handler adapter.TransportHandler) (*Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := &Listener{
+		listener: l,
+		addr:     addr,
+	}
+	go N.Serve(l, func(c net.Conn) { handleRedir(c, handler) })
+
+	return rl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *Listener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *Listener) Address() string {
+	return l.listener.Addr().String()
+}
+
+// Close stops the listener
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+func handleRedir(conn net.Conn, handler adapter.TransportHandler) {
+	target, err := parserPacket(conn)
+	if err != nil {
+		log.Debugf("[Redir] original destination of %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+	}
+	handler.HandleTCP(adapter.NewTCPConn(conn, &M.Metadata{},
+		adapter.WithInType(proto.Inbound_REDIR), adapter.WithDstAddr(net.TCPAddrFromAddrPort(target))))
+}
@@ -0,0 +1,68 @@
+//go:build linux && !386
+
+package redir
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// soOriginalDst and ip6tSoOriginalDst are SO_ORIGINAL_DST from linux/netfilter_ipv4.h and
+	// IP6T_SO_ORIGINAL_DST from linux/netfilter_ipv6/ip6_tables.h
+	soOriginalDst     = 80
+	ip6tSoOriginalDst = 80
+)
+
+// parserPacket returns the destination conn had before it was redirected
+func parserPacket(conn net.Conn) (netip.AddrPort, error) {
+	c, ok := conn.(*net.TCPConn)
+	if !ok {
+		return netip.AddrPort{}, errors.New("only work with TCP connection")
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	ipv6 := false
+	if local, ok := c.LocalAddr().(*net.TCPAddr); ok {
+		ipv6 = local.IP.To4() == nil
+	}
+
+	var addr netip.AddrPort
+	if cErr := rc.Control(func(fd uintptr) {
+		addr, err = getOrigDst(fd, ipv6)
+	}); cErr != nil {
+		return netip.AddrPort{}, cErr
+	}
+	return addr, err
+}
+
+// getOrigDst calls getsockopt with SO_ORIGINAL_DST, which fills a sockaddr_in or sockaddr_in6
+func getOrigDst(fd uintptr, ipv6 bool) (netip.AddrPort, error) {
+	var raw [unix.SizeofSockaddrInet6]byte
+	size := uint32(unix.SizeofSockaddrInet4)
+	level, opt := uintptr(unix.SOL_IP), uintptr(soOriginalDst)
+	if ipv6 {
+		size = unix.SizeofSockaddrInet6
+		level, opt = unix.SOL_IPV6, ip6tSoOriginalDst
+	}
+
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, fd, level, opt,
+		uintptr(unsafe.Pointer(&raw[0])), uintptr(unsafe.Pointer(&size)), 0); errno != 0 {
+		return netip.AddrPort{}, errno
+	}
+
+	// sin_port and sin6_port are in network byte order at the same offset
+	port := binary.BigEndian.Uint16(raw[2:4])
+	if ipv6 {
+		return netip.AddrPortFrom(netip.AddrFrom16([16]byte(raw[8:24])).Unmap(), port), nil
+	}
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte(raw[4:8])), port), nil
+}
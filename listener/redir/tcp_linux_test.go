@@ -0,0 +1,123 @@
+//go:build linux && !386
+
+package redir
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/lumavpn/luma/adapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const netnsEnv = "LUMA_TEST_NETNS"
+
+// runInNetns runs the calling test again in a new network namespace. It reports true in the
+// namespace, where the test must run, and false in the parent, which is done
+func runInNetns(t *testing.T) bool {
+	if os.Getenv(netnsEnv) != "" {
+		return true
+	}
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+	unshare, err := exec.LookPath("unshare")
+	if err != nil {
+		t.Skip("requires unshare")
+	}
+
+	cmd := exec.Command(unshare, "--net", os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), netnsEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return false
+}
+
+// routeToLoopback brings the loopback interface up and routes dsts through it
+func routeToLoopback(t *testing.T, dsts ...string) {
+	lo, err := netlink.LinkByName("lo")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetUp(lo))
+	for _, dst := range dsts {
+		ipnet, err := netlink.ParseIPNet(dst)
+		require.NoError(t, err)
+		require.NoError(t, netlink.RouteAdd(&netlink.Route{LinkIndex: lo.Attrs().Index, Dst: ipnet}))
+	}
+}
+
+// redirectPort redirects the TCP connections sent to dport to the local port toPort, like
+// nft add rule inet nat output tcp dport <dport> redirect to :<toPort>
+func redirectPort(t *testing.T, dport, toPort uint16) {
+	c, err := nftables.New()
+	require.NoError(t, err)
+	table := c.AddTable(&nftables.Table{Family: nftables.TableFamilyINet, Name: "nat"})
+	chain := c.AddChain(&nftables.Chain{
+		Name:     "output",
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityNATDest,
+	})
+	c.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(dport)},
+		&expr.Immediate{Register: 1, Data: binaryutil.BigEndian.PutUint16(toPort)},
+		&expr.Redir{RegisterProtoMin: 1},
+	}})
+	require.NoError(t, c.Flush())
+}
+
+type tcpHandler chan adapter.TCPConn
+
+func (h tcpHandler) HandleTCP(conn adapter.TCPConn) { h <- conn }
+
+func (h tcpHandler) HandleUDP(conn adapter.UDPConn) { conn.Close() }
+
+func TestRedir(t *testing.T) {
+	if !runInNetns(t) {
+		return
+	}
+	routeToLoopback(t, "10.1.2.3/32", "2001:db8::3/128")
+
+	conns := make(tcpHandler, 1)
+	l, err := New(":0", conns)
+	require.NoError(t, err)
+	defer l.Close()
+	redirectPort(t, 80, uint16(l.listener.Addr().(*net.TCPAddr).Port))
+
+	for _, target := range []string{"10.1.2.3:80", "[2001:db8::3]:80"} {
+		t.Run(target, func(t *testing.T) {
+			c, err := net.DialTimeout("tcp", target, time.Second)
+			require.NoError(t, err)
+			defer c.Close()
+
+			var conn adapter.TCPConn
+			select {
+			case conn = <-conns:
+			case <-time.After(time.Second):
+				t.Fatal("redirected connection was not handled")
+			}
+			defer conn.Close()
+			assert.Equal(t, target, conn.Metadata().RemoteAddress())
+
+			_, err = c.Write([]byte("ping"))
+			require.NoError(t, err)
+			buf := make([]byte, 4)
+			_, err = conn.Read(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(buf))
+		})
+	}
+
+}
@@ -0,0 +1,13 @@
+//go:build !linux || 386
+
+package redir
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+func parserPacket(conn net.Conn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, errors.New("redir not supported on current platform")
+}
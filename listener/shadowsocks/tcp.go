@@ -0,0 +1,91 @@
+package shadowsocks
+
+import (
+	"net"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	N "github.com/lumavpn/luma/common/net"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/mux"
+	"github.com/lumavpn/luma/transport/shadowsocks"
+)
+
+// handshakeTimeout bounds the reading of the request header, so that clients that never send
+// one do not hold connections open
+var handshakeTimeout = 10 * time.Second
+
+// Listener accepts Shadowsocks connections and hands them to a TransportHandler
+type Listener struct {
+	listener net.Listener
+	addr     string
+}
+
+// New starts a Shadowsocks listener on addr decrypting connections with cipher. additions
+// are applied to the metadata of the accepted sessions
+func New(addr string, cipher *shadowsocks.Cipher, handler adapter.TransportHandler, additions ...adapter.Addition) (*Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &Listener{
+		listener: l,
+		addr:     addr,
+	}
+	additions = append([]adapter.Addition{adapter.WithInType(proto.Inbound_SHADOWSOCKS)}, additions...)
+	go N.Serve(l, func(c net.Conn) { handleConn(c, cipher, handler, additions) })
+
+	return sl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *Listener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *Listener) Address() string {
+	return l.listener.Addr().String()
+}
+
+// Close stops the listener
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+func handleConn(conn net.Conn, cipher *shadowsocks.Cipher, handler adapter.TransportHandler, additions []adapter.Addition) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+	}
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	sc, err := cipher.ServerConn(conn)
+	if err != nil {
+		log.Debugf("[Shadowsocks] handshake from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	handleStream(sc, sc.Target(), handler, additions)
+}
+
+// handleStream hands the stream conn to target to handler, serving the streams it carries if
+// it is a multiplexed connection
+func handleStream(conn net.Conn, target M.SocksAddr, handler adapter.TransportHandler, additions []adapter.Addition) {
+	if mux.IsDestination(target) {
+		if err := mux.ServeConn(conn, func(stream net.Conn, target M.SocksAddr) {
+			handleStream(stream, target, handler, additions)
+		}); err != nil {
+			log.Debugf("[Shadowsocks] mux session from %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	metadata := &M.Metadata{}
+	if err := metadata.SetSocksAddr(target); err != nil {
+		conn.Close()
+		return
+	}
+	handler.HandleTCP(adapter.NewTCPConn(conn, metadata, additions...))
+}
@@ -0,0 +1,112 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/transport/shadowsocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tcpHandler chan adapter.TCPConn
+
+func (h tcpHandler) HandleTCP(conn adapter.TCPConn) { h <- conn }
+
+func (h tcpHandler) HandleUDP(conn adapter.UDPConn) { conn.Close() }
+
+// recordingConn copies the bytes written to the connection to w
+type recordingConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.w.Write(b)
+	return c.Conn.Write(b)
+}
+
+func startListener(t *testing.T, password string) (*Listener, tcpHandler) {
+	cipher, err := shadowsocks.NewCipher("aes-128-gcm", password)
+	require.NoError(t, err)
+	h := make(tcpHandler, 1)
+	l, err := New("127.0.0.1:0", cipher, h)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l, h
+}
+
+// assertRejected checks that the listener closes c without handing a connection to h
+func assertRejected(t *testing.T, c net.Conn, h tcpHandler) {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.Copy(io.Discard, c)
+	var ne net.Error
+	assert.False(t, errors.As(err, &ne) && ne.Timeout(), "connection was not closed")
+	assert.Empty(t, h)
+}
+
+func TestListener(t *testing.T) {
+	l, h := startListener(t, "password")
+	target := M.ParseSocksAddr("example.com:443")
+
+	var recorded bytes.Buffer
+	raw, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	defer raw.Close()
+	client, err := shadowsocks.NewCipher("aes-128-gcm", "password")
+	require.NoError(t, err)
+	c := client.StreamConn(&recordingConn{Conn: raw, w: &recorded}, target)
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	var conn adapter.TCPConn
+	select {
+	case conn = <-h:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not handled")
+	}
+	defer conn.Close()
+	assert.Equal(t, "example.com:443", conn.Metadata().RemoteAddress())
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	t.Run("bad password", func(t *testing.T) {
+		raw, err := net.Dial("tcp", l.Address())
+		require.NoError(t, err)
+		defer raw.Close()
+		wrong, err := shadowsocks.NewCipher("aes-128-gcm", "wrong")
+		require.NoError(t, err)
+		_, err = wrong.StreamConn(raw, target).Write([]byte("ping"))
+		require.NoError(t, err)
+		assertRejected(t, raw, h)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		raw, err := net.Dial("tcp", l.Address())
+		require.NoError(t, err)
+		defer raw.Close()
+		_, err = raw.Write(recorded.Bytes())
+		require.NoError(t, err)
+		assertRejected(t, raw, h)
+	})
+}
+
+func TestListenerHandshakeTimeout(t *testing.T) {
+	timeout := handshakeTimeout
+	handshakeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { handshakeTimeout = timeout })
+
+	l, h := startListener(t, "password")
+	raw, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	defer raw.Close()
+	assertRejected(t, raw, h)
+}
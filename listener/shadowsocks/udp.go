@@ -0,0 +1,97 @@
+package shadowsocks
+
+import (
+	"errors"
+	"net"
+
+	"github.com/lumavpn/luma/adapter"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/shadowsocks"
+)
+
+// UDPListener relays the packets of Shadowsocks clients
+type UDPListener struct {
+	packetConn *shadowsocks.ServerPacketConn
+	addr       string
+	nat        *adapter.NatTable
+	additions  []adapter.Addition
+}
+
+// NewUDP starts a Shadowsocks UDP relay on addr decrypting packets with cipher. additions are
+// applied to the metadata of the sessions
+func NewUDP(addr string, cipher *shadowsocks.Cipher, handler adapter.TransportHandler, additions ...adapter.Addition) (*UDPListener, error) {
+	l, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &UDPListener{
+		packetConn: cipher.ServerPacketConn(l),
+		addr:       addr,
+		nat:        adapter.NewNatTable(),
+		additions:  append([]adapter.Addition{adapter.WithInType(proto.Inbound_SHADOWSOCKS)}, additions...),
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, client, target, err := sl.packetConn.ReadPacket(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					break
+				}
+				continue
+			}
+			sl.handlePacket(buf[:n], client, target, handler)
+		}
+	}()
+
+	return sl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *UDPListener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *UDPListener) Address() string {
+	return l.packetConn.LocalAddr().String()
+}
+
+// Close stops the listener and closes its sessions
+func (l *UDPListener) Close() error {
+	err := l.packetConn.Close()
+	l.nat.Range(func(_ string, conn *adapter.PacketConn) bool {
+		conn.Close()
+		return true
+	})
+	return err
+}
+
+func (l *UDPListener) handlePacket(payload []byte, client net.Addr, target M.SocksAddr, handler adapter.TransportHandler) {
+	key := client.String() + "-" + target.String()
+	conn, created := l.nat.GetOrCreate(key, func() *adapter.PacketConn {
+		// target may point into the read buffer of the listener
+		target := append(M.SocksAddr(nil), target...)
+		metadata := &M.Metadata{}
+		metadata.SetSocksAddr(target)
+		writeBack := adapter.WriteBackFunc(func(b []byte, addr net.Addr) (int, error) {
+			from := target
+			if addr != nil {
+				if a := M.ParseSocksAddr(addr.String()); a != nil {
+					from = a
+				}
+			}
+			if err := l.packetConn.WritePacket(b, client, from); err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		})
+		return adapter.NewPacketConn(metadata, l.packetConn.LocalAddr(), client, writeBack, l.additions...)
+	})
+	conn.Deliver(payload)
+	if created {
+		handler.HandleUDP(conn)
+	}
+}
@@ -0,0 +1,61 @@
+package socks
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// Associations counts the UDP associations of each client address. They restrict UDP relays
+// to the clients which authenticated and requested an association on a TCP port
+type Associations struct {
+	mu      sync.Mutex
+	clients map[netip.Addr]int
+}
+
+// NewAssociations returns an empty set of associations
+func NewAssociations() *Associations {
+	return &Associations{clients: make(map[netip.Addr]int)}
+}
+
+// add records an association of the client at addr and returns the function removing it
+func (a *Associations) add(addr net.Addr) func() {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return func() {}
+	}
+	a.mu.Lock()
+	a.clients[ip]++
+	a.mu.Unlock()
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.clients[ip]--; a.clients[ip] <= 0 {
+			delete(a.clients, ip)
+		}
+	}
+}
+
+// contains reports whether the client at addr has an association
+func (a *Associations) contains(addr net.Addr) bool {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.clients[ip] > 0
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var addrPort netip.AddrPort
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		addrPort = a.AddrPort()
+	case *net.UDPAddr:
+		addrPort = a.AddrPort()
+	default:
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), addrPort.IsValid()
+}
@@ -0,0 +1,176 @@
+package socks
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/internal/testutil"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/transport/socks5"
+	"github.com/lumavpn/luma/tunnel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xproxy "golang.org/x/net/proxy"
+)
+
+func newTunnel() tunnel.Tunnel {
+	t := tunnel.New()
+	t.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	return t
+}
+
+func TestListener_Connect(t *testing.T) {
+	echo := testutil.StartTCPEcho(t).String()
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "user", Pass: "pass"}})
+	l, err := New("127.0.0.1:0", authenticator, newTunnel())
+	require.NoError(t, err)
+	defer l.Close()
+
+	dialer, err := xproxy.SOCKS5("tcp", l.Address(), &xproxy.Auth{User: "user", Password: "pass"}, xproxy.Direct)
+	require.NoError(t, err)
+	conn, err := dialer.Dial("tcp", echo)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	badDialer, err := xproxy.SOCKS5("tcp", l.Address(), &xproxy.Auth{User: "user", Password: "wrong"}, xproxy.Direct)
+	require.NoError(t, err)
+	_, err = badDialer.Dial("tcp", echo)
+	assert.Error(t, err)
+}
+
+func TestListener_Mux(t *testing.T) {
+	echo := testutil.StartTCPEcho(t).String()
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "user", Pass: "pass"}})
+	l, err := New("127.0.0.1:0", authenticator, newTunnel())
+	require.NoError(t, err)
+	defer l.Close()
+
+	addr := l.listener.Addr().(*net.TCPAddr)
+	p, err := proxy.ParseProxy(map[string]any{
+		"name": "socks", "type": "socks5", "server": "127.0.0.1", "port": addr.Port,
+		"username": "user", "password": "pass",
+		"smux": map[string]any{"enabled": true},
+	})
+	require.NoError(t, err)
+	x, ok := p.(*proxy.Mux)
+	require.True(t, ok)
+	defer x.Close()
+
+	target, err := net.ResolveTCPAddr("tcp", echo)
+	require.NoError(t, err)
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := p.DialContext(ctx, &M.Metadata{Network: M.TCP, DstIP: target.IP, DstPort: uint16(target.Port)})
+		cancel()
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		conn.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(buf))
+	}
+	assert.Equal(t, 1, x.Stats().Sessions)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		handleSocks5(server, nil, newTunnel(), NewAssociations(), 50*time.Millisecond, nil)
+		close(done)
+	}()
+
+	// a client that never sends its greeting is disconnected
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	<-done
+}
+
+func TestUDPListener(t *testing.T) {
+	echo := testutil.StartUDPEcho(t).String()
+	associations := NewAssociations()
+	l, err := NewUDP("127.0.0.1:0", associations, newTunnel())
+	require.NoError(t, err)
+	defer l.Close()
+
+	conn, err := net.Dial("udp", l.Address())
+	require.NoError(t, err)
+	defer conn.Close()
+	defer associations.add(conn.LocalAddr())()
+
+	target := M.ParseSocksAddr(echo)
+	packet, err := socks5.EncodeUDPPacket(target, []byte("ping"))
+	require.NoError(t, err)
+	_, err = conn.Write(packet)
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	from, payload, err := socks5.DecodeUDPPacket(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, echo, from.String())
+	assert.Equal(t, "ping", string(payload))
+}
+
+func TestListenUDP(t *testing.T) {
+	echo := testutil.StartUDPEcho(t).String()
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "user", Pass: "pass"}})
+	l, err := New("127.0.0.1:0", authenticator, newTunnel())
+	require.NoError(t, err)
+	defer l.Close()
+	ul, err := l.ListenUDP(newTunnel())
+	require.NoError(t, err)
+	defer ul.Close()
+
+	conn, err := net.Dial("udp", ul.Address())
+	require.NoError(t, err)
+	defer conn.Close()
+	packet, err := socks5.EncodeUDPPacket(M.ParseSocksAddr(echo), []byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+
+	// packets are dropped without an association
+	_, err = conn.Write(packet)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = conn.Read(buf)
+	assert.Error(t, err)
+
+	control, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	defer control.Close()
+	_, err = socks5.ClientHandshake(control, M.ParseSocksAddr("0.0.0.0:0"), socks5.CmdUDPAssociate, &socks5.User{Username: "user", Password: "pass"})
+	require.NoError(t, err)
+
+	// the association is recorded right after the reply of the handshake
+	assert.Eventually(t, func() bool {
+		conn.Write(packet)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return false
+		}
+		_, payload, err := socks5.DecodeUDPPacket(buf[:n])
+		return err == nil && string(payload) == "ping"
+	}, 5*time.Second, 10*time.Millisecond)
+}
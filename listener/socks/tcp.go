@@ -0,0 +1,141 @@
+package socks
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/common/auth"
+	N "github.com/lumavpn/luma/common/net"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/mux"
+	"github.com/lumavpn/luma/transport/socks4"
+	"github.com/lumavpn/luma/transport/socks5"
+)
+
+// handshakeTimeout bounds the reading of the handshake, so that clients that never finish it do
+// not hold connections open
+const handshakeTimeout = 10 * time.Second
+
+// Listener accepts SOCKS5 connections and hands them to a TransportHandler
+type Listener struct {
+	listener     net.Listener
+	addr         string
+	associations *Associations
+}
+
+// New starts a SOCKS5 listener on addr. Clients must authenticate if authenticator is not nil.
+// additions are applied to the metadata of the accepted sessions
+func New(addr string, authenticator auth.Authenticator, handler adapter.TransportHandler, additions ...adapter.Addition) (*Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &Listener{
+		listener:     l,
+		addr:         addr,
+		associations: NewAssociations(),
+	}
+	go N.Serve(l, func(c net.Conn) { HandleSocks5(c, authenticator, handler, sl.associations, additions...) })
+
+	return sl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *Listener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *Listener) Address() string {
+	return l.listener.Addr().String()
+}
+
+// ListenUDP starts a SOCKS5 UDP relay on the address of the listener, accepting only the
+// packets of the clients holding a UDP association with it. additions are applied to the
+// metadata of the sessions
+func (l *Listener) ListenUDP(handler adapter.TransportHandler, additions ...adapter.Addition) (*UDPListener, error) {
+	return NewUDP(l.Address(), l.associations, handler, additions...)
+}
+
+// Close stops the listener
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// HandleSocks4 performs the SOCKS4 handshake on conn and hands the CONNECT request to handler
+func HandleSocks4(conn net.Conn, authenticator auth.Authenticator, handler adapter.TransportHandler) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+	}
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	target, _, err := socks4.ServerHandshake(conn, authenticator)
+	if err != nil {
+		log.Debugf("[SOCKS4] handshake from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	metadata := &M.Metadata{}
+	if err := metadata.SetSocksAddr(target); err != nil {
+		conn.Close()
+		return
+	}
+	handler.HandleTCP(adapter.NewTCPConn(conn, metadata, adapter.WithInType(proto.Inbound_SOCKS4)))
+}
+
+// HandleSocks5 performs the SOCKS5 handshake on conn and hands CONNECT requests to handler.
+// UDP associations are recorded in associations for as long as conn is open
+func HandleSocks5(conn net.Conn, authenticator auth.Authenticator, handler adapter.TransportHandler, associations *Associations, additions ...adapter.Addition) {
+	handleSocks5(conn, authenticator, handler, associations, handshakeTimeout, additions)
+}
+
+// handleSocks5 is HandleSocks5 closing conn if the handshake takes longer than timeout
+func handleSocks5(conn net.Conn, authenticator auth.Authenticator, handler adapter.TransportHandler, associations *Associations,
+	timeout time.Duration, additions []adapter.Addition) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	target, command, _, err := socks5.ServerHandshake(conn, authenticator)
+	if err != nil {
+		log.Debugf("[SOCKS5] handshake from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if command == socks5.CmdUDPAssociate {
+		// the association lasts as long as the control connection
+		defer conn.Close()
+		defer associations.add(conn.RemoteAddr())()
+		io.Copy(io.Discard, conn)
+		return
+	}
+
+	additions = append([]adapter.Addition{adapter.WithInType(proto.Inbound_SOCKS5)}, additions...)
+	handleStream(conn, target, handler, additions)
+}
+
+// handleStream hands the stream conn to target to handler, serving the streams it carries if
+// it is a multiplexed connection
+func handleStream(conn net.Conn, target M.SocksAddr, handler adapter.TransportHandler, additions []adapter.Addition) {
+	if mux.IsDestination(target) {
+		if err := mux.ServeConn(conn, func(stream net.Conn, target M.SocksAddr) {
+			handleStream(stream, target, handler, additions)
+		}); err != nil {
+			log.Debugf("[SOCKS5] mux session from %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	metadata := &M.Metadata{}
+	if err := metadata.SetSocksAddr(target); err != nil {
+		conn.Close()
+		return
+	}
+	handler.HandleTCP(adapter.NewTCPConn(conn, metadata, additions...))
+}
@@ -0,0 +1,114 @@
+package socks
+
+import (
+	"errors"
+	"net"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/socks5"
+)
+
+// UDPListener relays the datagrams of SOCKS5 UDP associations
+type UDPListener struct {
+	packetConn net.PacketConn
+	addr       string
+	nat        *adapter.NatTable
+	additions  []adapter.Addition
+	// associations restricts the relay to the clients holding an association
+	associations *Associations
+}
+
+// NewUDP starts a SOCKS5 UDP relay on addr, accepting only the packets of the clients holding
+// one of associations. additions are applied to the metadata of the sessions
+func NewUDP(addr string, associations *Associations, handler adapter.TransportHandler, additions ...adapter.Addition) (*UDPListener, error) {
+	l, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &UDPListener{
+		packetConn:   l,
+		addr:         addr,
+		nat:          adapter.NewNatTable(),
+		additions:    append([]adapter.Addition{adapter.WithInType(proto.Inbound_SOCKS5)}, additions...),
+		associations: associations,
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, remoteAddr, err := l.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					break
+				}
+				continue
+			}
+			if !associations.contains(remoteAddr) {
+				continue
+			}
+			sl.handleSocksUDP(buf[:n], remoteAddr, handler)
+		}
+	}()
+
+	return sl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *UDPListener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *UDPListener) Address() string {
+	return l.packetConn.LocalAddr().String()
+}
+
+// Close stops the listener and closes its sessions
+func (l *UDPListener) Close() error {
+	err := l.packetConn.Close()
+	l.nat.Range(func(_ string, conn *adapter.PacketConn) bool {
+		conn.Close()
+		return true
+	})
+	return err
+}
+
+func (l *UDPListener) handleSocksUDP(packet []byte, remoteAddr net.Addr, handler adapter.TransportHandler) {
+	target, payload, err := socks5.DecodeUDPPacket(packet)
+	if err != nil {
+		log.Debugf("[SOCKS5] invalid udp packet from %s: %v", remoteAddr, err)
+		return
+	}
+
+	key := remoteAddr.String() + "-" + target.String()
+	conn, created := l.nat.GetOrCreate(key, func() *adapter.PacketConn {
+		// target points into the read buffer of the listener
+		target := append(M.SocksAddr(nil), target...)
+		metadata := &M.Metadata{}
+		metadata.SetSocksAddr(target)
+		writeBack := adapter.WriteBackFunc(func(b []byte, addr net.Addr) (int, error) {
+			from := target
+			if addr != nil {
+				if a := M.ParseSocksAddr(addr.String()); a != nil {
+					from = a
+				}
+			}
+			packet, err := socks5.EncodeUDPPacket(from, b)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := l.packetConn.WriteTo(packet, remoteAddr); err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		})
+		return adapter.NewPacketConn(metadata, l.packetConn.LocalAddr(), remoteAddr, writeBack, l.additions...)
+	})
+	conn.Deliver(payload)
+	if created {
+		handler.HandleUDP(conn)
+	}
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package tproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// controlTransparent allows the socket to accept connections to and send from non-local addresses
+func controlTransparent(network, address string, c syscall.RawConn) error {
+	var innerErr error
+	err := c.Control(func(fd uintptr) {
+		innerErr = setTransparent(int(fd), network)
+	})
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
+
+// controlTransparentUDP is controlTransparent which also requests the original destination of
+// received packets as ancillary data
+func controlTransparentUDP(network, address string, c syscall.RawConn) error {
+	var innerErr error
+	err := c.Control(func(fd uintptr) {
+		if innerErr = setTransparent(int(fd), network); innerErr != nil {
+			return
+		}
+		if innerErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1); innerErr != nil {
+			return
+		}
+		if !isIPv4Network(network) {
+			innerErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return innerErr
+}
+
+func setTransparent(fd int, network string) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+		return err
+	}
+	if isIPv4Network(network) {
+		return nil
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+}
+
+func isIPv4Network(network string) bool {
+	return network == "tcp4" || network == "udp4"
+}
+
+// getOrigDst returns the original destination carried in the IP_ORIGDSTADDR or
+// IPV6_ORIGDSTADDR control message of a received packet
+func getOrigDst(oob []byte) (netip.AddrPort, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_ORIGDSTADDR:
+			if len(msg.Data) < unix.SizeofSockaddrInet4 {
+				continue
+			}
+			ip := netip.AddrFrom4([4]byte(msg.Data[4:8]))
+			return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(msg.Data[2:4])), nil
+		case msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_ORIGDSTADDR:
+			if len(msg.Data) < unix.SizeofSockaddrInet6 {
+				continue
+			}
+			ip := netip.AddrFrom16([16]byte(msg.Data[8:24])).Unmap()
+			return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(msg.Data[2:4])), nil
+		}
+	}
+
+	return netip.AddrPort{}, errors.New("cannot find origDst")
+}
+
+// dialUDP returns a transparent UDP socket bound to the non-local address lAddr
+func dialUDP(lAddr netip.AddrPort) (*net.UDPConn, error) {
+	network := "udp6"
+	if lAddr.Addr().Is4() {
+		network = "udp4"
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var innerErr error
+			err := c.Control(func(fd uintptr) {
+				if innerErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); innerErr != nil {
+					return
+				}
+				innerErr = setTransparent(int(fd), network)
+			})
+			if err != nil {
+				return err
+			}
+			return innerErr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, lAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
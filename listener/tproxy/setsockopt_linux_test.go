@@ -0,0 +1,48 @@
+//go:build linux
+
+package tproxy
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func controlMessage(level, typ int32, data []byte) []byte {
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = level
+	h.Type = typ
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+	return b
+}
+
+func TestGetOrigDst(t *testing.T) {
+	sa4 := make([]byte, unix.SizeofSockaddrInet4)
+	binary.LittleEndian.PutUint16(sa4[0:2], unix.AF_INET)
+	binary.BigEndian.PutUint16(sa4[2:4], 53)
+	copy(sa4[4:8], []byte{8, 8, 8, 8})
+
+	addr, err := getOrigDst(controlMessage(unix.SOL_IP, unix.IP_ORIGDSTADDR, sa4))
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("8.8.8.8:53"), addr)
+
+	sa6 := make([]byte, unix.SizeofSockaddrInet6)
+	binary.LittleEndian.PutUint16(sa6[0:2], unix.AF_INET6)
+	binary.BigEndian.PutUint16(sa6[2:4], 443)
+	ip6 := netip.MustParseAddr("2001:db8::1").As16()
+	copy(sa6[8:24], ip6[:])
+
+	addr, err = getOrigDst(controlMessage(unix.SOL_IPV6, unix.IPV6_ORIGDSTADDR, sa6))
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:443"), addr)
+
+	_, err = getOrigDst(nil)
+	assert.Error(t, err)
+}
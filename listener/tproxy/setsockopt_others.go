@@ -0,0 +1,28 @@
+//go:build !linux
+
+package tproxy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+var errNotSupported = errors.New("tproxy not supported on current platform")
+
+func controlTransparent(network, address string, c syscall.RawConn) error {
+	return errNotSupported
+}
+
+func controlTransparentUDP(network, address string, c syscall.RawConn) error {
+	return errNotSupported
+}
+
+func getOrigDst(oob []byte) (netip.AddrPort, error) {
+	return netip.AddrPort{}, errNotSupported
+}
+
+func dialUDP(lAddr netip.AddrPort) (*net.UDPConn, error) {
+	return nil, errNotSupported
+}
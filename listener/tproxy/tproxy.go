@@ -0,0 +1,58 @@
+package tproxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/lumavpn/luma/adapter"
+	N "github.com/lumavpn/luma/common/net"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+)
+
+// Listener accepts TCP connections intercepted by the TPROXY target of netfilter
+type Listener struct {
+	listener net.Listener
+	addr     string
+}
+
+// New starts a TPROXY TCP listener on addr
+func New(addr string, handler adapter.TransportHandler) (*Listener, error) {
+	lc := net.ListenConfig{Control: controlTransparent}
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tl := &Listener{
+		listener: l,
+		addr:     addr,
+	}
+	go N.Serve(l, func(c net.Conn) { handleTProxy(c, handler) })
+
+	return tl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *Listener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *Listener) Address() string {
+	return l.listener.Addr().String()
+}
+
+// Close stops the listener
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// handleTProxy hands conn to handler. The local address of a TPROXY connection is its original destination
+func handleTProxy(conn net.Conn, handler adapter.TransportHandler) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+	}
+	handler.HandleTCP(adapter.NewTCPConn(conn, &M.Metadata{},
+		adapter.WithInType(proto.Inbound_TPROXY), adapter.WithDstAddr(conn.LocalAddr())))
+}
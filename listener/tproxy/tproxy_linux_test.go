@@ -0,0 +1,177 @@
+//go:build linux
+
+package tproxy
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/lumavpn/luma/adapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	netnsEnv = "LUMA_TEST_NETNS"
+	// tproxyMark and tproxyTable deliver the marked packets locally, as TPROXY requires
+	tproxyMark  = 1
+	tproxyTable = 100
+)
+
+// runInNetns runs the calling test again in a new network namespace. It reports true in the
+// namespace, where the test must run, and false in the parent, which is done
+func runInNetns(t *testing.T) bool {
+	if os.Getenv(netnsEnv) != "" {
+		return true
+	}
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+	unshare, err := exec.LookPath("unshare")
+	if err != nil {
+		t.Skip("requires unshare")
+	}
+
+	cmd := exec.Command(unshare, "--net", os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), netnsEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return false
+}
+
+// interceptDst routes the packets sent to dst through the loopback interface and intercepts
+// them with TPROXY on port, like
+//
+//	ip rule add fwmark 1 lookup 100
+//	ip route add local 0.0.0.0/0 dev lo table 100
+//	nft add rule ip mangle prerouting ip daddr <dst> meta mark set 1 tproxy to :<port>
+func interceptDst(t *testing.T, dst net.IP, port uint16) {
+	lo, err := netlink.LinkByName("lo")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetUp(lo))
+	require.NoError(t, netlink.RouteAdd(&netlink.Route{
+		LinkIndex: lo.Attrs().Index,
+		Dst:       &net.IPNet{IP: dst, Mask: net.CIDRMask(32, 32)},
+		Src:       net.IPv4(127, 0, 0, 1),
+	}))
+	rule := netlink.NewRule()
+	rule.Mark = tproxyMark
+	rule.Table = tproxyTable
+	require.NoError(t, netlink.RuleAdd(rule))
+	require.NoError(t, netlink.RouteAdd(&netlink.Route{
+		LinkIndex: lo.Attrs().Index,
+		Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		Type:      unix.RTN_LOCAL,
+		Scope:     netlink.SCOPE_HOST,
+		Table:     tproxyTable,
+	}))
+
+	c, err := nftables.New()
+	require.NoError(t, err)
+	table := c.AddTable(&nftables.Table{Family: nftables.TableFamilyIPv4, Name: "mangle"})
+	chain := c.AddChain(&nftables.Chain{
+		Name:     "prerouting",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookPrerouting,
+		Priority: nftables.ChainPriorityMangle,
+	})
+	c.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 16, Len: 4},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: dst.To4()},
+		&expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint32(tproxyMark)},
+		&expr.Meta{Key: expr.MetaKeyMARK, SourceRegister: true, Register: 1},
+		&expr.Immediate{Register: 1, Data: binaryutil.BigEndian.PutUint16(port)},
+		&expr.TProxy{Family: byte(nftables.TableFamilyIPv4), TableFamily: byte(nftables.TableFamilyIPv4), RegPort: 1},
+	}})
+	require.NoError(t, c.Flush())
+}
+
+type handler struct {
+	tcp chan adapter.TCPConn
+	udp chan adapter.UDPConn
+}
+
+func (h *handler) HandleTCP(conn adapter.TCPConn) { h.tcp <- conn }
+
+func (h *handler) HandleUDP(conn adapter.UDPConn) { h.udp <- conn }
+
+func TestTProxy(t *testing.T) {
+	if !runInNetns(t) {
+		return
+	}
+	h := &handler{tcp: make(chan adapter.TCPConn, 1), udp: make(chan adapter.UDPConn, 1)}
+	l, err := New("127.0.0.1:0", h)
+	require.NoError(t, err)
+	defer l.Close()
+	port := l.listener.Addr().(*net.TCPAddr).Port
+	ul, err := NewUDP(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), h)
+	require.NoError(t, err)
+	defer ul.Close()
+	interceptDst(t, net.IPv4(10, 1, 2, 3), uint16(port))
+
+	t.Run("tcp", func(t *testing.T) {
+		c, err := net.DialTimeout("tcp", "10.1.2.3:80", time.Second)
+		require.NoError(t, err)
+		defer c.Close()
+
+		var conn adapter.TCPConn
+		select {
+		case conn = <-h.tcp:
+		case <-time.After(time.Second):
+			t.Fatal("intercepted connection was not handled")
+		}
+		defer conn.Close()
+		assert.Equal(t, "10.1.2.3:80", conn.Metadata().RemoteAddress())
+
+		_, err = conn.Write([]byte("pong"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = c.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(buf))
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer client.Close()
+		target := &net.UDPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 53}
+		_, err = client.WriteTo([]byte("ping"), target)
+		require.NoError(t, err)
+
+		var conn adapter.UDPConn
+		select {
+		case conn = <-h.udp:
+		case <-time.After(time.Second):
+			t.Fatal("intercepted packet was not handled")
+		}
+		defer conn.Close()
+		assert.Equal(t, "10.1.2.3:53", conn.Metadata().RemoteAddress())
+		buf := make([]byte, 64)
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf[:n]))
+
+		// replies come from the address they are written from, not only the original destination
+		cone := &net.UDPAddr{IP: net.IPv4(10, 9, 9, 9), Port: 9999}
+		for _, from := range []*net.UDPAddr{target, cone} {
+			_, err = conn.WriteTo([]byte("pong"), from)
+			require.NoError(t, err)
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			n, addr, err := client.ReadFromUDP(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "pong", string(buf[:n]))
+			assert.Equal(t, from.String(), addr.String())
+		}
+	})
+}
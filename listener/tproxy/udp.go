@@ -0,0 +1,141 @@
+package tproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+)
+
+// UDPListener receives UDP packets intercepted by the TPROXY target of netfilter
+type UDPListener struct {
+	packetConn *net.UDPConn
+	addr       string
+	nat        *adapter.NatTable
+}
+
+// NewUDP starts a TPROXY UDP listener on addr
+func NewUDP(addr string, handler adapter.TransportHandler) (*UDPListener, error) {
+	lc := net.ListenConfig{Control: controlTransparentUDP}
+	l, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ul := &UDPListener{
+		packetConn: l.(*net.UDPConn),
+		addr:       addr,
+		nat:        adapter.NewNatTable(),
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		oob := make([]byte, 1024)
+		for {
+			n, oobn, _, lAddr, err := ul.packetConn.ReadMsgUDPAddrPort(buf, oob)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					break
+				}
+				continue
+			}
+			rAddr, err := getOrigDst(oob[:oobn])
+			if err != nil {
+				log.Debugf("[TProxy] original destination of %s: %v", lAddr, err)
+				continue
+			}
+			ul.handlePacket(buf[:n], netip.AddrPortFrom(lAddr.Addr().Unmap(), lAddr.Port()), rAddr, handler)
+		}
+	}()
+
+	return ul, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *UDPListener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *UDPListener) Address() string {
+	return l.packetConn.LocalAddr().String()
+}
+
+// Close stops the listener and closes its sessions
+func (l *UDPListener) Close() error {
+	err := l.packetConn.Close()
+	l.nat.Range(func(_ string, conn *adapter.PacketConn) bool {
+		conn.Close()
+		return true
+	})
+	return err
+}
+
+// handlePacket delivers a packet sent by the client at lAddr to rAddr to its session
+func (l *UDPListener) handlePacket(packet []byte, lAddr, rAddr netip.AddrPort, handler adapter.TransportHandler) {
+	key := lAddr.String() + "-" + rAddr.String()
+	conn, created := l.nat.GetOrCreate(key, func() *adapter.PacketConn {
+		writeBack := &packetWriteBack{lAddr: lAddr, rAddr: rAddr}
+		return adapter.NewPacketConn(&M.Metadata{}, l.packetConn.LocalAddr(), net.UDPAddrFromAddrPort(lAddr), writeBack,
+			adapter.WithInType(proto.Inbound_TPROXY), adapter.WithDstAddr(net.UDPAddrFromAddrPort(rAddr)))
+	})
+	conn.Deliver(packet)
+	if created {
+		handler.HandleUDP(conn)
+	}
+}
+
+// packetWriteBack sends replies to the client at lAddr from the address they come from, its
+// original destination rAddr unless the remote replies from another one, through transparent
+// sockets bound to those addresses
+type packetWriteBack struct {
+	lAddr netip.AddrPort
+	rAddr netip.AddrPort
+
+	mu    sync.Mutex
+	conns map[netip.AddrPort]*net.UDPConn
+}
+
+func (w *packetWriteBack) WriteBack(b []byte, addr net.Addr) (int, error) {
+	from := w.rAddr
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr != nil {
+		if ap := udpAddr.AddrPort(); ap.Addr().IsValid() {
+			from = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+		}
+	}
+	if from.Addr().Is4() != w.lAddr.Addr().Is4() {
+		// the client cannot receive packets of the other family, drop the reply
+		return len(b), nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	conn, ok := w.conns[from]
+	if !ok {
+		var err error
+		if conn, err = dialUDP(from); err != nil {
+			return 0, err
+		}
+		if w.conns == nil {
+			w.conns = make(map[netip.AddrPort]*net.UDPConn)
+		}
+		w.conns[from] = conn
+	}
+	return conn.WriteToUDPAddrPort(b, w.lAddr)
+}
+
+func (w *packetWriteBack) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	for from, conn := range w.conns {
+		err = errors.Join(err, conn.Close())
+		delete(w.conns, from)
+	}
+	return err
+}
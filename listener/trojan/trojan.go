@@ -0,0 +1,142 @@
+package trojan
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	N "github.com/lumavpn/luma/common/net"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/mux"
+	"github.com/lumavpn/luma/transport/trojan"
+)
+
+// handshakeTimeout bounds the TLS handshake and the reading of the request header, so that
+// clients that never send one do not hold connections open
+var handshakeTimeout = 10 * time.Second
+
+// Listener accepts Trojan connections over TLS and hands them to a TransportHandler
+type Listener struct {
+	listener net.Listener
+	addr     string
+}
+
+// New starts a Trojan listener on addr serving TLS with tlsConfig to the clients knowing
+// password. additions are applied to the metadata of the accepted sessions
+func New(addr string, tlsConfig *tls.Config, password string, handler adapter.TransportHandler, additions ...adapter.Addition) (*Listener, error) {
+	l, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tl := &Listener{
+		listener: l,
+		addr:     addr,
+	}
+	key := trojan.Key(password)
+	additions = append([]adapter.Addition{adapter.WithInType(proto.Inbound_TROJAN)}, additions...)
+	go N.Serve(l, func(c net.Conn) { handleConn(c, key, handler, additions) })
+
+	return tl, nil
+}
+
+// RawAddress returns the address the listener was created with
+func (l *Listener) RawAddress() string {
+	return l.addr
+}
+
+// Address returns the address the listener is bound to
+func (l *Listener) Address() string {
+	return l.listener.Addr().String()
+}
+
+// Close stops the listener
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+func handleConn(conn net.Conn, key [trojan.KeyLength]byte, handler adapter.TransportHandler, additions []adapter.Addition) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	clientKey, command, target, err := trojan.ReadRequest(conn)
+	if err == nil && subtle.ConstantTimeCompare(clientKey[:], key[:]) != 1 {
+		err = trojan.ErrBadRequest
+	}
+	if err != nil {
+		log.Debugf("[Trojan] handshake from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	switch command {
+	case trojan.CommandTCP:
+		handleStream(conn, target, handler, additions)
+	case trojan.CommandUDP:
+		handlePackets(conn, handler, additions)
+	default:
+		conn.Close()
+	}
+}
+
+// handleStream hands the stream conn to target to handler, serving the streams it carries if
+// it is a multiplexed connection
+func handleStream(conn net.Conn, target M.SocksAddr, handler adapter.TransportHandler, additions []adapter.Addition) {
+	if mux.IsDestination(target) {
+		if err := mux.ServeConn(conn, func(stream net.Conn, target M.SocksAddr) {
+			handleStream(stream, target, handler, additions)
+		}); err != nil {
+			log.Debugf("[Trojan] mux session from %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	metadata := &M.Metadata{}
+	if err := metadata.SetSocksAddr(target); err != nil {
+		conn.Close()
+		return
+	}
+	handler.HandleTCP(adapter.NewTCPConn(conn, metadata, additions...))
+}
+
+// handlePackets relays the UDP packets framed on conn until it is closed
+func handlePackets(conn net.Conn, handler adapter.TransportHandler, additions []adapter.Addition) {
+	defer conn.Close()
+	pc := trojan.ServerPacketConn(conn)
+	nat := adapter.NewNatTable()
+	defer nat.Range(func(_ string, conn *adapter.PacketConn) bool {
+		conn.Close()
+		return true
+	})
+
+	buf := make([]byte, 65535)
+	for {
+		n, target, err := pc.ReadPacket(buf)
+		if err != nil {
+			return
+		}
+		session, created := nat.GetOrCreate(target.String(), func() *adapter.PacketConn {
+			metadata := &M.Metadata{}
+			metadata.SetSocksAddr(target)
+			writeBack := adapter.WriteBackFunc(func(b []byte, addr net.Addr) (int, error) {
+				from := target
+				if addr != nil {
+					if a := M.ParseSocksAddr(addr.String()); a != nil {
+						from = a
+					}
+				}
+				if err := pc.WritePacket(b, from); err != nil {
+					return 0, err
+				}
+				return len(b), nil
+			})
+			return adapter.NewPacketConn(metadata, conn.LocalAddr(), conn.RemoteAddr(), writeBack, additions...)
+		})
+		session.Deliver(buf[:n])
+		if created {
+			handler.HandleUDP(session)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package trojan
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/transport/trojan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tcpHandler chan adapter.TCPConn
+
+func (h tcpHandler) HandleTCP(conn adapter.TCPConn) { h <- conn }
+
+func (h tcpHandler) HandleUDP(conn adapter.UDPConn) { conn.Close() }
+
+// recordingConn copies the bytes written to the connection to w
+type recordingConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.w.Write(b)
+	return c.Conn.Write(b)
+}
+
+// testTLSConfig returns a server configuration with a self-signed certificate
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func startListener(t *testing.T) (*Listener, tcpHandler) {
+	h := make(tcpHandler, 1)
+	l, err := New("127.0.0.1:0", testTLSConfig(t), "password", h)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l, h
+}
+
+// dial sends a request for target with password to the listener, recording the bytes sent to w
+func dial(t *testing.T, l *Listener, password string, w io.Writer) net.Conn {
+	raw, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	t.Cleanup(func() { raw.Close() })
+	tlsConn := tls.Client(&recordingConn{Conn: raw, w: w}, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+	c := trojan.NewConn(tlsConn, trojan.Key(password), M.ParseSocksAddr("example.com:443"))
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	return c
+}
+
+// assertRejected checks that the listener closes c without handing a connection to h
+func assertRejected(t *testing.T, c net.Conn, h tcpHandler) {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.Copy(io.Discard, c)
+	var ne net.Error
+	assert.False(t, errors.As(err, &ne) && ne.Timeout(), "connection was not closed")
+	assert.Empty(t, h)
+}
+
+func TestListener(t *testing.T) {
+	l, h := startListener(t)
+
+	var recorded bytes.Buffer
+	dial(t, l, "password", &recorded)
+	var conn adapter.TCPConn
+	select {
+	case conn = <-h:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not handled")
+	}
+	defer conn.Close()
+	assert.Equal(t, "example.com:443", conn.Metadata().RemoteAddress())
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	t.Run("bad password", func(t *testing.T) {
+		assertRejected(t, dial(t, l, "wrong", io.Discard), h)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		raw, err := net.Dial("tcp", l.Address())
+		require.NoError(t, err)
+		defer raw.Close()
+		_, err = raw.Write(recorded.Bytes())
+		require.NoError(t, err)
+		assertRejected(t, raw, h)
+	})
+}
+
+func TestListenerHandshakeTimeout(t *testing.T) {
+	timeout := handshakeTimeout
+	handshakeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { handshakeTimeout = timeout })
+
+	l, h := startListener(t)
+	raw, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	defer raw.Close()
+	assertRejected(t, raw, h)
+}
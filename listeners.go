@@ -0,0 +1,194 @@
+package luma
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/config"
+	"github.com/lumavpn/luma/listener/http"
+	"github.com/lumavpn/luma/listener/inbound"
+	"github.com/lumavpn/luma/listener/mixed"
+	"github.com/lumavpn/luma/listener/redir"
+	"github.com/lumavpn/luma/listener/socks"
+	"github.com/lumavpn/luma/listener/tproxy"
+	"github.com/lumavpn/luma/log"
+)
+
+// startListeners starts the inbounds enabled in the config
+func (lu *Luma) startListeners(cfg *config.Config) error {
+	users, err := auth.ParseAuthUsers(cfg.Authentication)
+	if err != nil {
+		return err
+	}
+	authenticator := auth.NewAuthenticator(users)
+
+	lu.mu.Lock()
+	defer lu.mu.Unlock()
+	if cfg.Port != 0 {
+		if err := lu.startHTTPListener(listenAddress(cfg, cfg.Port), authenticator); err != nil {
+			return err
+		}
+	}
+	if cfg.SocksPort != 0 {
+		if err := lu.startSocksListener(listenAddress(cfg, cfg.SocksPort), authenticator); err != nil {
+			return err
+		}
+	}
+	if cfg.MixedPort != 0 {
+		if err := lu.startMixedListener(listenAddress(cfg, cfg.MixedPort), authenticator); err != nil {
+			return err
+		}
+	}
+	if cfg.RedirPort != 0 {
+		if err := lu.startRedirListener(listenAddress(cfg, cfg.RedirPort)); err != nil {
+			return err
+		}
+	}
+	if cfg.TProxyPort != 0 {
+		if err := lu.startTProxyListener(listenAddress(cfg, cfg.TProxyPort)); err != nil {
+			return err
+		}
+	}
+	return lu.startInbounds(cfg)
+}
+
+// startInbounds starts the server mode listeners of the config. It must be called with lu.mu
+// held
+func (lu *Luma) startInbounds(cfg *config.Config) error {
+	names := make(map[string]bool)
+	for _, mapping := range cfg.Listeners {
+		l, err := inbound.ParseListener(mapping, lu.tunnel)
+		if err != nil {
+			return err
+		}
+		lu.inbounds = append(lu.inbounds, l)
+		if names[l.Name()] {
+			return fmt.Errorf("duplicate listener name %s", l.Name())
+		}
+		names[l.Name()] = true
+		log.Infof("Listener %s listening at: %s", l.Name(), l.Address())
+	}
+	return nil
+}
+
+func (lu *Luma) startHTTPListener(addr string, authenticator auth.Authenticator) error {
+	l, err := http.New(addr, authenticator, lu.tunnel)
+	if err != nil {
+		return err
+	}
+	lu.httpListener = l
+	log.Infof("HTTP proxy listening at: %s", l.Address())
+	return nil
+}
+
+func (lu *Luma) startSocksListener(addr string, authenticator auth.Authenticator) error {
+	tcpListener, err := socks.New(addr, authenticator, lu.tunnel)
+	if err != nil {
+		return err
+	}
+	udpListener, err := tcpListener.ListenUDP(lu.tunnel)
+	if err != nil {
+		tcpListener.Close()
+		return err
+	}
+	lu.socksListener = tcpListener
+	lu.socksUDPListener = udpListener
+	log.Infof("SOCKS proxy listening at: %s", tcpListener.Address())
+	return nil
+}
+
+func (lu *Luma) startMixedListener(addr string, authenticator auth.Authenticator) error {
+	tcpListener, err := mixed.New(addr, authenticator, lu.tunnel)
+	if err != nil {
+		return err
+	}
+	udpListener, err := tcpListener.ListenUDP(lu.tunnel)
+	if err != nil {
+		tcpListener.Close()
+		return err
+	}
+	lu.mixedListener = tcpListener
+	lu.mixedUDPListener = udpListener
+	log.Infof("Mixed (HTTP+SOCKS) proxy listening at: %s", tcpListener.Address())
+	return nil
+}
+
+func (lu *Luma) startRedirListener(addr string) error {
+	l, err := redir.New(addr, lu.tunnel)
+	if err != nil {
+		return err
+	}
+	lu.redirListener = l
+	log.Infof("Redirect proxy listening at: %s", l.Address())
+	return nil
+}
+
+func (lu *Luma) startTProxyListener(addr string) error {
+	tcpListener, err := tproxy.New(addr, lu.tunnel)
+	if err != nil {
+		return err
+	}
+	udpListener, err := tproxy.NewUDP(addr, lu.tunnel)
+	if err != nil {
+		tcpListener.Close()
+		return err
+	}
+	lu.tproxyListener = tcpListener
+	lu.tproxyUDPListener = udpListener
+	log.Infof("TProxy server listening at: %s", tcpListener.Address())
+	return nil
+}
+
+// closeListeners stops the running inbounds. It must be called with lu.mu held
+func (lu *Luma) closeListeners() {
+	if lu.httpListener != nil {
+		lu.httpListener.Close()
+		lu.httpListener = nil
+	}
+	if lu.socksListener != nil {
+		lu.socksListener.Close()
+		lu.socksListener = nil
+	}
+	if lu.socksUDPListener != nil {
+		lu.socksUDPListener.Close()
+		lu.socksUDPListener = nil
+	}
+	if lu.mixedListener != nil {
+		lu.mixedListener.Close()
+		lu.mixedListener = nil
+	}
+	if lu.mixedUDPListener != nil {
+		lu.mixedUDPListener.Close()
+		lu.mixedUDPListener = nil
+	}
+	if lu.redirListener != nil {
+		lu.redirListener.Close()
+		lu.redirListener = nil
+	}
+	if lu.tproxyListener != nil {
+		lu.tproxyListener.Close()
+		lu.tproxyListener = nil
+	}
+	if lu.tproxyUDPListener != nil {
+		lu.tproxyUDPListener.Close()
+		lu.tproxyUDPListener = nil
+	}
+	for _, l := range lu.inbounds {
+		l.Close()
+	}
+	lu.inbounds = nil
+}
+
+// listenAddress returns the address inbounds listen on for port
+func listenAddress(cfg *config.Config, port int) string {
+	host := "127.0.0.1"
+	if cfg.AllowLan {
+		host = cfg.BindAddress
+		if host == "*" {
+			host = ""
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,77 @@
+package luma
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/internal/testutil"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/transport/socks5"
+	"github.com/lumavpn/luma/tunnel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLuma() *Luma {
+	lu := &Luma{tunnel: tunnel.New()}
+	lu.tunnel.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	return lu
+}
+
+// testUDPAssociation checks that the UDP relay at addr drops the datagrams of clients without
+// an authenticated association and relays them once the client authenticated
+func testUDPAssociation(t *testing.T, addr string) {
+	echo := testutil.StartUDPEcho(t).String()
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	packet, err := socks5.EncodeUDPPacket(M.ParseSocksAddr(echo), []byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+	relayed := func() bool {
+		conn.Write(packet)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return false
+		}
+		_, payload, err := socks5.DecodeUDPPacket(buf[:n])
+		return err == nil && string(payload) == "ping"
+	}
+
+	assert.False(t, relayed(), "datagram relayed without association")
+
+	// a failed handshake does not associate the client
+	control, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = socks5.ClientHandshake(control, M.ParseSocksAddr("0.0.0.0:0"), socks5.CmdUDPAssociate, &socks5.User{Username: "user", Password: "wrong"})
+	assert.Error(t, err)
+	control.Close()
+	assert.False(t, relayed(), "datagram relayed after a failed authentication")
+
+	control, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer control.Close()
+	_, err = socks5.ClientHandshake(control, M.ParseSocksAddr("0.0.0.0:0"), socks5.CmdUDPAssociate, &socks5.User{Username: "user", Password: "pass"})
+	require.NoError(t, err)
+	assert.Eventually(t, relayed, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSocksListenerUDP(t *testing.T) {
+	lu := newTestLuma()
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "user", Pass: "pass"}})
+	require.NoError(t, lu.startSocksListener("127.0.0.1:0", authenticator))
+	defer lu.closeListeners()
+	testUDPAssociation(t, lu.socksListener.Address())
+}
+
+func TestMixedListenerUDP(t *testing.T) {
+	lu := newTestLuma()
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "user", Pass: "pass"}})
+	require.NoError(t, lu.startMixedListener("127.0.0.1:0", authenticator))
+	defer lu.closeListeners()
+	testUDPAssociation(t, lu.mixedListener.Address())
+}
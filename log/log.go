@@ -28,7 +28,7 @@ func Level() LogLevel {
 }
 
 func Debug(message string) {
-	logf(DebugLevel, message)
+	logf(DebugLevel, "%s", message)
 }
 
 func Debugf(format string, args ...any) {
@@ -36,7 +36,7 @@ func Debugf(format string, args ...any) {
 }
 
 func Info(message string) {
-	logf(InfoLevel, message)
+	logf(InfoLevel, "%s", message)
 }
 
 func Infof(format string, args ...any) {
@@ -44,7 +44,7 @@ func Infof(format string, args ...any) {
 }
 
 func Warn(message string) {
-	logf(WarnLevel, message)
+	logf(WarnLevel, "%s", message)
 }
 
 func Warnf(format string, args ...any) {
@@ -53,7 +53,7 @@ func Warnf(format string, args ...any) {
 
 func Error(err error) {
 	if err != nil {
-		logf(ErrorLevel, err.Error())
+		logf(ErrorLevel, "%s", err.Error())
 	}
 }
 
@@ -2,11 +2,23 @@ package luma
 
 import (
 	"context"
+	"io"
 	"sync"
 
+	"github.com/lumavpn/luma/common/geoip"
 	"github.com/lumavpn/luma/config"
+	"github.com/lumavpn/luma/dns"
+	"github.com/lumavpn/luma/dns/fakeip"
+	"github.com/lumavpn/luma/listener/http"
+	"github.com/lumavpn/luma/listener/inbound"
+	"github.com/lumavpn/luma/listener/mixed"
+	"github.com/lumavpn/luma/listener/redir"
+	"github.com/lumavpn/luma/listener/socks"
+	"github.com/lumavpn/luma/listener/tproxy"
 	"github.com/lumavpn/luma/log"
 	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/stack"
+	"github.com/lumavpn/luma/tun"
 	"github.com/lumavpn/luma/tunnel"
 )
 
@@ -16,6 +28,41 @@ type Luma struct {
 	// proxies is a map of proxies that Luma is configured to proxy traffic through
 	proxies map[string]proxy.Proxy
 
+	// resolver is the built-in DNS resolver, nil if DNS is disabled
+	resolver dns.Resolver
+	// enhancer answers DNS queries from clients according to the enhanced mode
+	enhancer *dns.Enhancer
+	// geoipReader is the country database of the DNS fallback filter, nil if it is not used
+	geoipReader *geoip.Reader
+	// fakeIPPool allocates fake IPs in fake-ip mode, nil otherwise
+	fakeIPPool *fakeip.Pool
+	// dnsServer is the local DNS server, nil if it is not enabled
+	dnsServer *dns.Server
+
+	// socksListener and socksUDPListener serve the SOCKS5 inbound, nil if it is not enabled
+	socksListener    *socks.Listener
+	socksUDPListener *socks.UDPListener
+	// httpListener serves the HTTP proxy inbound, nil if it is not enabled
+	httpListener *http.Listener
+	// mixedListener and mixedUDPListener serve the mixed inbound, nil if it is not enabled
+	mixedListener    *mixed.Listener
+	mixedUDPListener *socks.UDPListener
+	// redirListener serves the transparent redirect inbound, nil if it is not enabled
+	redirListener *redir.Listener
+	// tproxyListener and tproxyUDPListener serve the TPROXY inbound, nil if it is not enabled
+	tproxyListener    *tproxy.Listener
+	tproxyUDPListener *tproxy.UDPListener
+	// inbounds are the server mode listeners
+	inbounds []inbound.Listener
+
+	// tunDevice and tunStack serve the TUN inbound, nil if it is not enabled
+	tunDevice tun.Device
+	tunStack  stack.Stack
+	// tunInterface is the outbound interface detected for the TUN inbound, empty if detection is off
+	tunInterface string
+	// tunRoutes are the routes installed for the TUN inbound, nil if auto-route is off
+	tunRoutes *tun.Routes
+
 	// Tunnel
 	tunnel tunnel.Tunnel
 
@@ -38,10 +85,59 @@ func (lu *Luma) Start(ctx context.Context) error {
 
 // Stop stops running the Luma engine
 func (lu *Luma) Stop() {
+	lu.mu.Lock()
+	defer lu.mu.Unlock()
+	lu.closeListeners()
+	lu.closeTun()
+	if lu.dnsServer != nil {
+		if err := lu.dnsServer.Close(); err != nil {
+			log.Debugf("Failed to stop DNS server: %v", err)
+		}
+		lu.dnsServer = nil
+	}
+	closeFakeIP(lu.fakeIPPool)
+	closeGeoIP(lu.geoipReader)
+	lu.geoipReader = nil
+	closeProxies(lu.proxies)
+}
 
+// closeProxies releases the connections and devices held by the proxies that have any
+func closeProxies(proxies map[string]proxy.Proxy) {
+	for name, p := range proxies {
+		if closer, ok := p.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Debugf("Failed to close proxy %s: %v", name, err)
+			}
+		}
+	}
 }
 
 // applyConfig applies the given Config to the instance of Luma to complete setup
 func (lu *Luma) applyConfig(cfg *config.Config) error {
+	log.SetLevel(cfg.LogLevel)
+	if err := lu.parseConfig(cfg); err != nil {
+		return err
+	}
+	if err := lu.startDNSServer(cfg.DNS.Listen); err != nil {
+		return err
+	}
+	if err := lu.startListeners(cfg); err != nil {
+		return err
+	}
+	return lu.startTun(cfg.Tun)
+}
+
+// startDNSServer starts the local DNS server on addr if it is set and DNS is enabled
+func (lu *Luma) startDNSServer(addr string) error {
+	lu.mu.Lock()
+	defer lu.mu.Unlock()
+	if addr == "" || lu.enhancer == nil {
+		return nil
+	}
+	server := dns.NewServer(addr, lu.enhancer)
+	if err := server.Start(); err != nil {
+		return err
+	}
+	lu.dnsServer = server
 	return nil
 }
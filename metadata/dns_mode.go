@@ -0,0 +1,74 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// DNSMode is how DNS queries of clients are answered
+type DNSMode uint8
+
+const (
+	// DNSNormal answers DNS queries with the real addresses of domains
+	DNSNormal DNSMode = iota
+	// DNSFakeIP answers DNS queries with addresses allocated from the fake-ip range
+	DNSFakeIP
+)
+
+var (
+	// DNSModeMapping is a mapping for the DNSMode enum
+	DNSModeMapping = map[string]DNSMode{
+		DNSNormal.String(): DNSNormal,
+		DNSFakeIP.String(): DNSFakeIP,
+	}
+)
+
+// UnmarshalYAML unserialize DNSMode with yaml
+func (e *DNSMode) UnmarshalYAML(unmarshal func(any) error) error {
+	var tp string
+	if err := unmarshal(&tp); err != nil {
+		return err
+	}
+	mode, exist := DNSModeMapping[strings.ToLower(tp)]
+	if !exist {
+		return errors.New("invalid dns mode")
+	}
+	*e = mode
+	return nil
+}
+
+// MarshalYAML serialize DNSMode with yaml
+func (e DNSMode) MarshalYAML() (any, error) {
+	return e.String(), nil
+}
+
+// UnmarshalJSON unserialize DNSMode with json
+func (e *DNSMode) UnmarshalJSON(data []byte) error {
+	var tp string
+	if err := json.Unmarshal(data, &tp); err != nil {
+		return err
+	}
+	mode, exist := DNSModeMapping[strings.ToLower(tp)]
+	if !exist {
+		return errors.New("invalid dns mode")
+	}
+	*e = mode
+	return nil
+}
+
+// MarshalJSON serialize DNSMode with json
+func (e DNSMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+func (e DNSMode) String() string {
+	switch e {
+	case DNSNormal:
+		return "normal"
+	case DNSFakeIP:
+		return "fake-ip"
+	default:
+		return "unknown"
+	}
+}
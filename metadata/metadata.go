@@ -1,7 +1,12 @@
 package metadata
 
 import (
+	"errors"
 	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/lumavpn/luma/proxy/proto"
 )
 
 // Metadata contains metadata of transport protocol sessions.
@@ -13,4 +18,82 @@ type Metadata struct {
 	SrcPort uint16  `json:"sourcePort"`
 	MidPort uint16  `json:"dialerPort"`
 	DstPort uint16  `json:"destinationPort"`
+	Host    string  `json:"host"`
+	// InboundType is the type of the inbound the session was accepted by
+	InboundType proto.Inbound `json:"inboundType"`
+	// InboundName is the name of the inbound the session was accepted by
+	InboundName string `json:"inboundName"`
+	// DNSMode is how the destination was resolved when the client looked it up through luma
+	DNSMode DNSMode `json:"dnsMode"`
+	// SpecialProxy is the name of the proxy the session must use, bypassing proxy selection
+	SpecialProxy string `json:"specialProxy"`
+}
+
+// RemoteAddress returns the destination of the session as host:port, preferring the domain
+func (m *Metadata) RemoteAddress() string {
+	return net.JoinHostPort(m.String(), strconv.FormatUint(uint64(m.DstPort), 10))
+}
+
+// SourceAddress returns the source of the session as ip:port
+func (m *Metadata) SourceAddress() string {
+	return net.JoinHostPort(m.SrcIP.String(), strconv.FormatUint(uint64(m.SrcPort), 10))
+}
+
+// Valid reports whether the session has a destination
+func (m *Metadata) Valid() bool {
+	return m.Host != "" || m.DstIP != nil
+}
+
+// Resolved reports whether the destination IP of the session is known
+func (m *Metadata) Resolved() bool {
+	return m.DstIP != nil
+}
+
+// DstAddr returns the destination IP of the session, which is invalid if it is not resolved
+func (m *Metadata) DstAddr() netip.Addr {
+	ip, _ := netip.AddrFromSlice(m.DstIP)
+	return ip.Unmap()
+}
+
+// UDPAddr returns the destination of a resolved UDP session
+func (m *Metadata) UDPAddr() *net.UDPAddr {
+	if m.Network != UDP || !m.Resolved() {
+		return nil
+	}
+	return &net.UDPAddr{
+		IP:   m.DstIP,
+		Port: int(m.DstPort),
+	}
+}
+
+// SetRemoteAddress sets the destination of the session from a host:port address
+func (m *Metadata) SetRemoteAddress(rawAddress string) error {
+	host, port, err := net.SplitHostPort(rawAddress)
+	if err != nil {
+		return err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return errors.New("invalid port: " + port)
+	}
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		m.Host = ""
+		m.DstIP = ip.Unmap().AsSlice()
+	} else {
+		m.Host = host
+		m.DstIP = nil
+	}
+	m.DstPort = uint16(p)
+	return nil
+}
+
+// String returns the domain of the destination, or its IP if there is no domain
+func (m *Metadata) String() string {
+	if m.Host != "" {
+		return m.Host
+	} else if m.DstIP != nil {
+		return m.DstIP.String()
+	}
+	return "<nil>"
 }
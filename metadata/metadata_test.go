@@ -0,0 +1,76 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMetadata_RemoteAddress(t *testing.T) {
+	m := &Metadata{DstIP: net.ParseIP("1.2.3.4"), DstPort: 443}
+	assert.True(t, m.Valid())
+	assert.True(t, m.Resolved())
+	assert.Equal(t, "1.2.3.4:443", m.RemoteAddress())
+
+	m.Host = "example.com"
+	assert.Equal(t, "example.com:443", m.RemoteAddress())
+
+	require.NoError(t, m.SetRemoteAddress("[2001:db8::1]:8080"))
+	assert.Equal(t, "", m.Host)
+	assert.Equal(t, "[2001:db8::1]:8080", m.RemoteAddress())
+
+	assert.False(t, (&Metadata{}).Valid())
+	assert.Error(t, m.SetRemoteAddress("example.com:http"))
+}
+
+func TestSocksAddr(t *testing.T) {
+	tests := []struct {
+		metadata *Metadata
+		addr     string
+	}{
+		{&Metadata{Host: "example.com", DstPort: 80}, "example.com:80"},
+		{&Metadata{DstIP: net.ParseIP("1.2.3.4"), DstPort: 53}, "1.2.3.4:53"},
+		{&Metadata{DstIP: net.ParseIP("2001:db8::1"), DstPort: 443}, "[2001:db8::1]:443"},
+	}
+	for _, tt := range tests {
+		addr := tt.metadata.SocksAddr()
+		require.NotNil(t, addr)
+		assert.Equal(t, tt.addr, addr.String())
+		assert.Equal(t, addr, ParseSocksAddr(tt.addr))
+
+		read, err := ReadSocksAddr(bytes.NewReader(append(addr, 0xff)))
+		require.NoError(t, err)
+		assert.Equal(t, addr, read)
+		assert.Equal(t, addr, SplitSocksAddr(append(addr, 0xff)))
+
+		m := &Metadata{}
+		require.NoError(t, m.SetSocksAddr(addr))
+		assert.Equal(t, tt.addr, m.RemoteAddress())
+	}
+
+	assert.Nil(t, SplitSocksAddr([]byte{AtypIPv4, 1, 2}))
+	for _, invalid := range []SocksAddr{nil, {}, {AtypIPv4, 1, 2}, {AtypIPv6, 1}, {AtypDomainName}, {AtypDomainName, 5, 'a'}, {0x02, 1, 2, 3, 4, 0, 53}} {
+		assert.Empty(t, invalid.String(), invalid)
+		assert.Equal(t, &net.UDPAddr{}, invalid.UDPAddr(), invalid)
+	}
+	_, err := ReadSocksAddr(bytes.NewReader([]byte{0x02}))
+	assert.ErrorIs(t, err, ErrInvalidSocksAddr)
+}
+
+func TestDNSMode(t *testing.T) {
+	var mode DNSMode
+	require.NoError(t, yaml.Unmarshal([]byte("Fake-IP"), &mode))
+	assert.Equal(t, DNSFakeIP, mode)
+	assert.Error(t, yaml.Unmarshal([]byte("mapping"), &mode))
+
+	data, err := json.Marshal(&Metadata{DNSMode: DNSFakeIP})
+	require.NoError(t, err)
+	var m Metadata
+	require.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, DNSFakeIP, m.DNSMode)
+}
@@ -0,0 +1,186 @@
+package metadata
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// SOCKS address types as defined in RFC 1928 section 5
+const (
+	AtypIPv4       = 1
+	AtypDomainName = 3
+	AtypIPv6       = 4
+)
+
+// MaxSocksAddrLen is the maximum size of a SOCKS address in bytes
+const MaxSocksAddrLen = 1 + 1 + 255 + 2
+
+var ErrInvalidSocksAddr = errors.New("invalid socks address")
+
+// SocksAddr represents a SOCKS address as defined in RFC 1928 section 5: ATYP | ADDR | PORT
+type SocksAddr []byte
+
+// String serializes SOCKS address a to host:port, or an empty string if it is invalid
+func (a SocksAddr) String() string {
+	a = SplitSocksAddr(a)
+	if a == nil {
+		return ""
+	}
+	port := strconv.Itoa(int(a[len(a)-2])<<8 | int(a[len(a)-1]))
+	switch a[0] {
+	case AtypDomainName:
+		return net.JoinHostPort(string(a[2:len(a)-2]), port)
+	default:
+		return net.JoinHostPort(net.IP(a[1:len(a)-2]).String(), port)
+	}
+}
+
+// UDPAddr returns a as a UDP address, resolving its domain if needed. The address is empty
+// if a is invalid or the domain cannot be resolved
+func (a SocksAddr) UDPAddr() *net.UDPAddr {
+	a = SplitSocksAddr(a)
+	if a == nil {
+		return &net.UDPAddr{}
+	}
+	if a[0] != AtypDomainName {
+		return &net.UDPAddr{IP: net.IP(a[1 : len(a)-2]), Port: int(a[len(a)-2])<<8 | int(a[len(a)-1])}
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", a.String())
+	if err != nil {
+		return &net.UDPAddr{}
+	}
+	return udpAddr
+}
+
+// ReadSocksAddr reads just enough bytes from r to get a valid SocksAddr
+func ReadSocksAddr(r io.Reader) (SocksAddr, error) {
+	b := make([]byte, MaxSocksAddrLen)
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return nil, err
+	}
+
+	switch b[0] {
+	case AtypDomainName:
+		if _, err := io.ReadFull(r, b[1:2]); err != nil {
+			return nil, err
+		}
+		domainLength := uint16(b[1])
+		_, err := io.ReadFull(r, b[2:2+domainLength+2])
+		return b[:1+1+domainLength+2], err
+	case AtypIPv4:
+		_, err := io.ReadFull(r, b[1:1+net.IPv4len+2])
+		return b[:1+net.IPv4len+2], err
+	case AtypIPv6:
+		_, err := io.ReadFull(r, b[1:1+net.IPv6len+2])
+		return b[:1+net.IPv6len+2], err
+	}
+
+	return nil, ErrInvalidSocksAddr
+}
+
+// SplitSocksAddr slices a SOCKS address from beginning of b. Returns nil if failed
+func SplitSocksAddr(b []byte) SocksAddr {
+	addrLen := 1
+	if len(b) < addrLen {
+		return nil
+	}
+
+	switch b[0] {
+	case AtypDomainName:
+		if len(b) < 2 {
+			return nil
+		}
+		addrLen = 1 + 1 + int(b[1]) + 2
+	case AtypIPv4:
+		addrLen = 1 + net.IPv4len + 2
+	case AtypIPv6:
+		addrLen = 1 + net.IPv6len + 2
+	default:
+		return nil
+	}
+
+	if len(b) < addrLen {
+		return nil
+	}
+
+	return b[:addrLen]
+}
+
+// ParseSocksAddr parses a host:port address into a SocksAddr. Returns nil if failed
+func ParseSocksAddr(s string) SocksAddr {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil
+	}
+	portnum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil
+	}
+	return serializeSocksAddr(host, nil, uint16(portnum))
+}
+
+// SocksAddr encodes the destination of the session as a SocksAddr, preferring the domain
+func (m *Metadata) SocksAddr() SocksAddr {
+	return serializeSocksAddr(m.Host, m.DstIP, m.DstPort)
+}
+
+// SetSocksAddr sets the destination of the session from a SocksAddr
+func (m *Metadata) SetSocksAddr(addr SocksAddr) error {
+	if len(SplitSocksAddr(addr)) != len(addr) {
+		return ErrInvalidSocksAddr
+	}
+	port := uint16(addr[len(addr)-2])<<8 | uint16(addr[len(addr)-1])
+
+	switch addr[0] {
+	case AtypDomainName:
+		host := string(addr[2 : 2+int(addr[1])])
+		if ip, err := netip.ParseAddr(host); err == nil {
+			m.Host = ""
+			m.DstIP = ip.Unmap().AsSlice()
+		} else {
+			m.Host = host
+			m.DstIP = nil
+		}
+	case AtypIPv4:
+		m.Host = ""
+		m.DstIP = net.IP(append([]byte(nil), addr[1:1+net.IPv4len]...))
+	case AtypIPv6:
+		m.Host = ""
+		ip, _ := netip.AddrFromSlice(addr[1 : 1+net.IPv6len])
+		m.DstIP = ip.Unmap().AsSlice()
+	}
+	m.DstPort = port
+	return nil
+}
+
+func serializeSocksAddr(host string, ip net.IP, port uint16) SocksAddr {
+	if host != "" {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			ip = addr.Unmap().AsSlice()
+		} else {
+			if len(host) > 255 {
+				return nil
+			}
+			buf := make([]byte, 0, 1+1+len(host)+2)
+			buf = append(buf, AtypDomainName, byte(len(host)))
+			buf = append(buf, host...)
+			return append(buf, byte(port>>8), byte(port))
+		}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		buf := make([]byte, 0, 1+net.IPv4len+2)
+		buf = append(buf, AtypIPv4)
+		buf = append(buf, ip4...)
+		return append(buf, byte(port>>8), byte(port))
+	} else if len(ip) == net.IPv6len {
+		buf := make([]byte, 0, 1+net.IPv6len+2)
+		buf = append(buf, AtypIPv6)
+		buf = append(buf, ip...)
+		return append(buf, byte(port>>8), byte(port))
+	}
+	return nil
+}
@@ -1,13 +1,36 @@
 package luma
 
 import (
+	"fmt"
+	"net/netip"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/common/geoip"
+	"github.com/lumavpn/luma/common/trie"
 	"github.com/lumavpn/luma/config"
+	"github.com/lumavpn/luma/dns"
+	"github.com/lumavpn/luma/dns/fakeip"
 	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
 	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/sniffer"
+	"github.com/lumavpn/luma/tunnel"
 )
 
 // parseConfig is used to parse the general configuration used by Luma
 func (lu *Luma) parseConfig(cfg *config.Config) error {
+	// everything that may fail is parsed before the running state is replaced
+	hijack, err := tunnel.ParseDNSHijack(cfg.DNS.Hijack)
+	if err != nil {
+		return err
+	}
+	dispatcher, err := parseSniffer(cfg)
+	if err != nil {
+		return err
+	}
+	if err := dialer.SetDefaultOptions(cfg.Dialer); err != nil {
+		return err
+	}
 	proxies, err := parseProxies(cfg)
 	if err != nil {
 		return err
@@ -15,14 +38,232 @@ func (lu *Luma) parseConfig(cfg *config.Config) error {
 
 	log.Debugf("Have %d proxies", len(proxies))
 
+	resolver, geoipReader, err := parseDNS(cfg)
+	if err != nil {
+		closeProxies(proxies)
+		return err
+	}
+	pool, err := parseFakeIP(cfg)
+	if err != nil {
+		closeProxies(proxies)
+		closeGeoIP(geoipReader)
+		return err
+	}
+	var enhancer *dns.Enhancer
+	if resolver != nil {
+		if enhancer, err = dns.NewEnhancer(cfg.DNS.EnhancedMode, resolver, pool); err != nil {
+			closeProxies(proxies)
+			closeGeoIP(geoipReader)
+			closeFakeIP(pool)
+			return err
+		}
+	}
+
 	lu.mu.Lock()
 	lu.proxies = proxies
+	lu.resolver = resolver
+	lu.enhancer = enhancer
+	lu.fakeIPPool = pool
+	oldGeoIP := lu.geoipReader
+	lu.geoipReader = geoipReader
 	lu.mu.Unlock()
+
+	dns.SetDefaultResolver(resolver)
+	dialer.SetResolver(dns.LookupIP)
+	// lookups still running on the previous resolver see an empty country after this
+	closeGeoIP(oldGeoIP)
+	lu.tunnel.SetProxies(proxies)
+	lu.tunnel.SetFakeIPPool(pool)
+	if enhancer != nil {
+		lu.tunnel.SetDNSHijack(hijack, enhancer)
+	} else {
+		if len(cfg.DNS.Hijack) > 0 {
+			log.Warnf("DNS hijack is ignored since dns is disabled")
+		}
+		lu.tunnel.SetDNSHijack(nil, nil)
+	}
+	lu.tunnel.SetSniffer(dispatcher)
 	return nil
 }
 
+// parseSniffer returns the sniffer dispatcher described by the config, or nil if sniffing is disabled
+func parseSniffer(cfg *config.Config) (*sniffer.Dispatcher, error) {
+	if !cfg.Sniffer.Enable {
+		return nil, nil
+	}
+	sniffers := make([]sniffer.Sniffer, 0, len(cfg.Sniffer.Sniff))
+	for protocol, sc := range cfg.Sniffer.Sniff {
+		ports, err := sniffer.ParsePortRanges(sc.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sniffer ports for %s: %w", protocol, err)
+		}
+		s, err := sniffer.New(protocol, ports)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sniffer %s: %w", protocol, err)
+		}
+		sniffers = append(sniffers, s)
+	}
+	return sniffer.NewDispatcher(sniffers, cfg.Sniffer.Timeout), nil
+}
+
 // parseProxies returns a map of proxies that are present in the config
 func parseProxies(cfg *config.Config) (map[string]proxy.Proxy, error) {
 	proxies := make(map[string]proxy.Proxy)
+	proxies["DIRECT"] = proxy.NewDirect()
+	for _, mapping := range cfg.Proxies {
+		p, err := proxy.ParseProxy(mapping)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := proxies[p.Name()]; ok {
+			return nil, fmt.Errorf("duplicate proxy name %s", p.Name())
+		}
+		proxies[p.Name()] = p
+	}
 	return proxies, nil
 }
+
+// parseDNS returns the DNS resolver described by the config, or nil if DNS is disabled, and the
+// GeoIP database opened for its fallback filter, which the caller must close
+func parseDNS(cfg *config.Config) (dns.Resolver, *geoip.Reader, error) {
+	if !cfg.DNS.Enable {
+		return nil, nil, nil
+	}
+	nameservers, err := dns.ParseNameServers(cfg.DNS.NameServer)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaultNameservers, err := dns.ParseNameServers(cfg.DNS.DefaultNameServer)
+	if err != nil {
+		return nil, nil, err
+	}
+	hosts, err := parseHosts(cfg.DNS.Hosts)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := parseNameServerPolicy(cfg.DNS.NameServerPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+	fallback, err := dns.ParseNameServers(cfg.DNS.Fallback)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fallbackFilter dns.FallbackFilter
+	if len(fallback) > 0 {
+		if fallbackFilter, err = parseFallbackFilter(cfg.DNS.FallbackFilter); err != nil {
+			return nil, nil, err
+		}
+	} else if cfg.DNS.FallbackFilter.GeoIP {
+		log.Warnf("[DNS] geoip fallback filter is ignored since no fallback nameserver is configured")
+	}
+	resolver, err := dns.NewResolver(dns.Config{
+		Main:           nameservers,
+		Default:        defaultNameservers,
+		IPv6:           cfg.DNS.IPv6,
+		CacheSize:      cfg.DNS.CacheSize,
+		Hosts:          hosts,
+		Policy:         policy,
+		Fallback:       fallback,
+		FallbackFilter: fallbackFilter,
+	})
+	if err != nil {
+		closeGeoIP(fallbackFilter.GeoIPReader)
+		return nil, nil, err
+	}
+	return resolver, fallbackFilter.GeoIPReader, nil
+}
+
+// parseNameServerPolicy returns the nameserver policies in the config
+func parseNameServerPolicy(policies map[string]config.StringSlice) ([]dns.Policy, error) {
+	result := make([]dns.Policy, 0, len(policies))
+	for domain, servers := range policies {
+		nameservers, err := dns.ParseNameServers(servers)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dns.Policy{Domain: domain, NameServers: nameservers})
+	}
+	return result, nil
+}
+
+// parseFallbackFilter returns the fallback filter in the config, opening the GeoIP database if needed
+func parseFallbackFilter(ff config.FallbackFilter) (dns.FallbackFilter, error) {
+	filter := dns.FallbackFilter{
+		GeoIP:     ff.GeoIP,
+		GeoIPCode: ff.GeoIPCode,
+		Domain:    ff.Domain,
+	}
+	for _, cidr := range ff.IPCIDR {
+		ipnet, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid fallback-filter ipcidr %s: %w", cidr, err)
+		}
+		filter.IPCIDR = append(filter.IPCIDR, ipnet)
+	}
+	if ff.GeoIP {
+		reader, err := geoip.Open(ff.GeoIPDatabase)
+		if err != nil {
+			return filter, fmt.Errorf("open geoip database: %w", err)
+		}
+		filter.GeoIPReader = reader
+	}
+	return filter, nil
+}
+
+// closeGeoIP closes reader if it is not nil
+func closeGeoIP(reader *geoip.Reader) {
+	if reader == nil {
+		return
+	}
+	if err := reader.Close(); err != nil {
+		log.Debugf("Failed to close geoip database: %v", err)
+	}
+}
+
+// closeFakeIP closes pool if it is not nil, saving its mapping
+func closeFakeIP(pool *fakeip.Pool) {
+	if pool == nil {
+		return
+	}
+	if err := pool.Close(); err != nil {
+		log.Errorf("Failed to save fake-ip mapping: %v", err)
+	}
+}
+
+// parseFakeIP returns the fake IP pool described by the config, or nil if fake-ip mode is disabled
+func parseFakeIP(cfg *config.Config) (*fakeip.Pool, error) {
+	if !cfg.DNS.Enable || cfg.DNS.EnhancedMode != M.DNSFakeIP {
+		return nil, nil
+	}
+	ipnet, err := netip.ParsePrefix(cfg.DNS.FakeIPRange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fake-ip-range: %w", err)
+	}
+	host := trie.New[struct{}]()
+	for _, domain := range cfg.DNS.FakeIPFilter {
+		if err := host.Insert(domain, struct{}{}); err != nil {
+			return nil, fmt.Errorf("invalid fake-ip-filter %s: %w", domain, err)
+		}
+	}
+	return fakeip.New(fakeip.Options{
+		IPNet:   ipnet,
+		Host:    host,
+		Persist: cfg.DNS.FakeIPStore,
+	})
+}
+
+// parseHosts returns a domain trie of the static hosts in the config
+func parseHosts(hosts map[string]string) (*trie.DomainTrie[[]netip.Addr], error) {
+	tree := trie.New[[]netip.Addr]()
+	for domain, addr := range hosts {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address for host %s: %w", domain, err)
+		}
+		if err := tree.Insert(domain, []netip.Addr{ip.Unmap()}); err != nil {
+			return nil, fmt.Errorf("invalid host %s: %w", domain, err)
+		}
+	}
+	return tree, nil
+}
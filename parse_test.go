@@ -0,0 +1,30 @@
+package luma
+
+import (
+	"testing"
+
+	"github.com/lumavpn/luma/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		yaml string
+	}{
+		{"dns hijack", "dns: {enable: true, nameserver: [127.0.0.1], enhanced-mode: fake-ip, hijack: [any]}"},
+		{"sniffer", "{dns: {enable: true, nameserver: [127.0.0.1], enhanced-mode: fake-ip}, sniffer: {enable: true, sniff: {ftp: {}}}}"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.ParseBytes([]byte(test.yaml))
+			require.NoError(t, err)
+			lu := newTestLuma()
+			// the running state is left untouched
+			assert.Error(t, lu.parseConfig(cfg))
+			assert.Nil(t, lu.proxies)
+			assert.Nil(t, lu.resolver)
+			assert.Nil(t, lu.fakeIPPool)
+		})
+	}
+}
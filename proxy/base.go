@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/lumavpn/luma/common/dialer"
+
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+)
+
+// ErrUDPNotSupported is returned by proxies that cannot relay UDP
+var ErrUDPNotSupported = errors.New("proxy does not support UDP")
+
+// DialerOption are the settings of the sockets of an outbound, overriding the global ones
+type DialerOption = dialer.Options
+
+// Base implements the parts of Proxy shared by all outbounds
+type Base struct {
+	name     string
+	addr     string
+	protocol proto.Protocol
+	udp      bool
+}
+
+// NewBase returns a new Base
+func NewBase(name string, addr string, protocol proto.Protocol, udp bool) *Base {
+	return &Base{
+		name:     name,
+		addr:     addr,
+		protocol: protocol,
+		udp:      udp,
+	}
+}
+
+func (b *Base) Name() string {
+	return b.name
+}
+
+func (b *Base) Addr() string {
+	return b.addr
+}
+
+func (b *Base) Protocol() proto.Protocol {
+	return b.protocol
+}
+
+func (b *Base) SupportUDP() bool {
+	return b.udp
+}
+
+func (b *Base) Unwrap(*metadata.Metadata, bool) Proxy {
+	return nil
+}
+
+func (b *Base) ListenPacketContext(context.Context, *metadata.Metadata) (net.PacketConn, error) {
+	return nil, ErrUDPNotSupported
+}
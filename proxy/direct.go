@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/dns"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+)
+
+// Direct connects to destinations without going through a proxy
+type Direct struct {
+	*Base
+}
+
+// NewDirect returns a new DIRECT proxy
+func NewDirect() *Direct {
+	return &Direct{
+		Base: NewBase("DIRECT", "", proto.Protocol_DIRECT, true),
+	}
+}
+
+func (d *Direct) DialContext(ctx context.Context, m *metadata.Metadata) (net.Conn, error) {
+	host := m.Host
+	if m.Resolved() {
+		host = m.DstAddr().String()
+	}
+	// the dialer races the addresses of the host
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.FormatUint(uint64(m.DstPort), 10)))
+}
+
+func (d *Direct) ListenPacketContext(ctx context.Context, m *metadata.Metadata) (net.PacketConn, error) {
+	return dialer.ListenPacket(ctx, "udp", "")
+}
+
+// resolveMetadata returns the destination IP of m, resolving its host if needed
+func resolveMetadata(ctx context.Context, m *metadata.Metadata) (netip.Addr, error) {
+	if m.Resolved() {
+		return m.DstAddr(), nil
+	}
+	return dns.ResolveIP(ctx, m.Host)
+}
+
+// resolveServer returns the address of the proxy server host:port dialed by d, resolving host
+// if needed
+func resolveServer(ctx context.Context, d *dialer.Dialer, host string, port int) (netip.AddrPort, error) {
+	ip, err := d.Resolve(ctx, host)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("resolve %s: %w", host, err)
+	}
+	return netip.AddrPortFrom(ip, uint16(port)), nil
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/lumavpn/luma/log"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/mux"
+)
+
+// MuxOption is the configuration of the multiplexing of an outbound, under its smux key
+type MuxOption struct {
+	Enabled  bool   `yaml:"enabled"`
+	Protocol string `yaml:"protocol"`
+	// MaxConnections and MinStreams bound the pool: a new connection is opened while there are
+	// less than MaxConnections and all carry at least MinStreams streams
+	MaxConnections int `yaml:"max-connections"`
+	MinStreams     int `yaml:"min-streams"`
+	// MaxStreams caps the streams of a connection instead, opening as many as needed
+	MaxStreams int  `yaml:"max-streams"`
+	Padding    bool `yaml:"padding"`
+	// IdleTimeout is how many seconds a connection without streams is kept open
+	IdleTimeout int `yaml:"idle-timeout"`
+}
+
+// Mux multiplexes the TCP connections of a proxy over a pool of its connections. UDP is
+// relayed by the proxy itself
+type Mux struct {
+	Proxy
+	client *mux.Client
+	// sessions is the number of connections of the pool when it was last logged
+	sessions atomic.Int64
+}
+
+// NewMux returns p multiplexed as described by option. The server of p must accept
+// multiplexed connections
+func NewMux(p Proxy, option MuxOption) (*Mux, error) {
+	switch p.Protocol() {
+	case proto.Protocol_DIRECT, proto.Protocol_SSH, proto.Protocol_WIREGUARD:
+		// the streams reach the destination without a proxy server to demultiplex them
+		return nil, fmt.Errorf("multiplexing is not supported by %s", p.Protocol())
+	}
+	protocol, err := mux.ParseProtocol(option.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	if option.MaxConnections < 0 || option.MinStreams < 0 || option.MaxStreams < 0 || option.IdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid multiplexing limits")
+	}
+
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return p.DialContext(ctx, &metadata.Metadata{
+			Network: metadata.TCP,
+			Host:    mux.DestinationHost,
+			DstPort: mux.DestinationPort,
+		})
+	}
+	return &Mux{
+		Proxy: p,
+		client: mux.NewClient(dial, mux.Options{
+			Protocol:       protocol,
+			MaxConnections: option.MaxConnections,
+			MinStreams:     option.MinStreams,
+			MaxStreams:     option.MaxStreams,
+			Padding:        option.Padding,
+			IdleTimeout:    time.Duration(option.IdleTimeout) * time.Second,
+		}),
+	}, nil
+}
+
+func (x *Mux) DialContext(ctx context.Context, m *metadata.Metadata) (net.Conn, error) {
+	c, err := x.client.DialContext(ctx, m.SocksAddr())
+	if err != nil {
+		return nil, err
+	}
+	if stats := x.Stats(); x.sessions.Swap(int64(stats.Sessions)) != int64(stats.Sessions) {
+		log.Debugf("[Mux] %s: %d connections carrying %d streams, %d connections and %d streams opened in total",
+			x.Name(), stats.Sessions, stats.Streams, stats.TotalSessions, stats.TotalStreams)
+	}
+	return c, nil
+}
+
+// Stats returns the usage of the connection pool
+func (x *Mux) Stats() mux.Stats {
+	return x.client.Stats()
+}
+
+// Close closes the pooled connections and the multiplexed proxy
+func (x *Mux) Close() error {
+	err := x.client.Close()
+	if closer, ok := x.Proxy.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMux(t *testing.T) {
+	echoPort := testutil.StartEchoServers(t)
+	trojanPort := startTrojanServer(t, testCertificate(t), "password", nil)
+
+	for _, test := range []struct {
+		name  string
+		proxy map[string]any
+	}{
+		{
+			name: "shadowsocks over smux",
+			proxy: map[string]any{
+				"type": "shadowsocks", "cipher": "aes-128-gcm", "password": "password",
+				"port": startShadowsocksServer(t, "aes-128-gcm", "password"),
+				"smux": map[string]any{"enabled": true, "max-streams": 2},
+			},
+		},
+		{
+			name: "trojan over yamux with padding",
+			proxy: map[string]any{
+				"type": "trojan", "password": "password", "skip-cert-verify": true, "port": trojanPort,
+				"smux": map[string]any{"enabled": true, "protocol": "yamux", "padding": true},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.proxy["name"] = test.name
+			test.proxy["server"] = "127.0.0.1"
+			p, err := ParseProxy(test.proxy)
+			require.NoError(t, err)
+			x, ok := p.(*Mux)
+			require.True(t, ok)
+			t.Cleanup(func() { x.Close() })
+
+			for range 3 {
+				testTCPEcho(t, p, echoPort)
+			}
+			stats := x.Stats()
+			assert.Equal(t, 1, stats.Sessions)
+			assert.Equal(t, uint64(3), stats.TotalStreams)
+		})
+	}
+}
+
+func TestParseMux(t *testing.T) {
+	p, err := ParseProxy(map[string]any{
+		"name": "ss", "type": "shadowsocks", "server": "127.0.0.1", "port": 8388, "cipher": "aes-128-gcm", "password": "password",
+		"smux": map[string]any{"enabled": false, "protocol": "yamux"},
+	})
+	require.NoError(t, err)
+	assert.IsType(t, &Shadowsocks{}, p)
+
+	p, err = ParseProxy(map[string]any{
+		"name": "ss", "type": "shadowsocks", "server": "127.0.0.1", "port": 8388, "cipher": "aes-128-gcm", "password": "password",
+		"udp": true, "smux": map[string]any{"enabled": true},
+	})
+	require.NoError(t, err)
+	p.(*Mux).Close()
+	assert.Equal(t, proto.Protocol_SHADOWSOCKS, p.Protocol())
+	assert.True(t, p.SupportUDP())
+
+	_, err = ParseProxy(map[string]any{
+		"name": "ss", "type": "shadowsocks", "server": "127.0.0.1", "port": 8388, "cipher": "aes-128-gcm", "password": "password",
+		"smux": map[string]any{"enabled": true, "protocol": "h2mux"},
+	})
+	assert.ErrorContains(t, err, "unsupported multiplexing protocol")
+
+	_, err = NewMux(NewDirect(), MuxOption{Enabled: true})
+	assert.ErrorContains(t, err, "multiplexing is not supported")
+}
+
+// closingProxy records whether it was closed
+type closingProxy struct {
+	Proxy
+	closed bool
+}
+
+func (p *closingProxy) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestMuxClose(t *testing.T) {
+	socks, err := NewSocks5(Socks5Option{Name: "socks", Server: "127.0.0.1", Port: 1080})
+	require.NoError(t, err)
+	p := &closingProxy{Proxy: socks}
+	x, err := NewMux(p, MuxOption{Enabled: true})
+	require.NoError(t, err)
+	require.NoError(t, x.Close())
+	assert.True(t, p.closed)
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lumavpn/luma/proxy/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseProxy returns the proxy described by mapping, an entry of the proxies section of the config
+func ParseProxy(mapping map[string]any) (Proxy, error) {
+	name, _ := mapping["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("missing proxy name")
+	}
+	proxyType, _ := mapping["type"].(string)
+	protocol, ok := proto.Protocol_value[strings.ToUpper(proxyType)]
+	if !ok {
+		return nil, fmt.Errorf("proxy %s: unsupported type %q", name, proxyType)
+	}
+
+	p, err := parseProtocol(name, proto.Protocol(protocol), mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	option := &struct {
+		Mux MuxOption `yaml:"smux"`
+	}{}
+	if err := decodeOption(mapping, option); err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", name, err)
+	}
+	if !option.Mux.Enabled {
+		return p, nil
+	}
+	m, err := NewMux(p, option.Mux)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// parseProtocol returns the proxy of protocol described by mapping
+func parseProtocol(name string, protocol proto.Protocol, mapping map[string]any) (Proxy, error) {
+	switch protocol {
+	case proto.Protocol_SOCKS5:
+		option := &Socks5Option{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", name, err)
+		}
+		return NewSocks5(*option)
+	case proto.Protocol_SHADOWSOCKS:
+		option := &ShadowsocksOption{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", name, err)
+		}
+		return NewShadowsocks(*option)
+	case proto.Protocol_TROJAN:
+		option := &TrojanOption{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", name, err)
+		}
+		return NewTrojan(*option)
+	case proto.Protocol_SSH:
+		option := &SSHOption{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", name, err)
+		}
+		return NewSSH(*option)
+	case proto.Protocol_WIREGUARD:
+		option := &WireGuardOption{}
+		if err := decodeOption(mapping, option); err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", name, err)
+		}
+		return NewWireGuard(*option)
+	default:
+		return nil, fmt.Errorf("proxy %s: unsupported type %s", name, protocol)
+	}
+}
+
+// decodeOption decodes the fields of mapping into the yaml tagged struct option
+func decodeOption(mapping map[string]any, option any) error {
+	data, err := yaml.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, option)
+}
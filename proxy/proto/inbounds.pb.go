@@ -0,0 +1,162 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: inbounds.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Inbound int32
+
+const (
+	Inbound_INBOUND_UNSET Inbound = 0
+	Inbound_HTTP          Inbound = 1
+	Inbound_HTTPS         Inbound = 2
+	Inbound_INNER         Inbound = 3
+	Inbound_SOCKS4        Inbound = 4
+	Inbound_SOCKS5        Inbound = 5
+	Inbound_TUN           Inbound = 6
+	Inbound_REDIR         Inbound = 7
+	Inbound_TPROXY        Inbound = 8
+	Inbound_SHADOWSOCKS   Inbound = 9
+	Inbound_TROJAN        Inbound = 10
+)
+
+// Enum value maps for Inbound.
+var (
+	Inbound_name = map[int32]string{
+		0:  "INBOUND_UNSET",
+		1:  "HTTP",
+		2:  "HTTPS",
+		3:  "INNER",
+		4:  "SOCKS4",
+		5:  "SOCKS5",
+		6:  "TUN",
+		7:  "REDIR",
+		8:  "TPROXY",
+		9:  "SHADOWSOCKS",
+		10: "TROJAN",
+	}
+	Inbound_value = map[string]int32{
+		"INBOUND_UNSET": 0,
+		"HTTP":          1,
+		"HTTPS":         2,
+		"INNER":         3,
+		"SOCKS4":        4,
+		"SOCKS5":        5,
+		"TUN":           6,
+		"REDIR":         7,
+		"TPROXY":        8,
+		"SHADOWSOCKS":   9,
+		"TROJAN":        10,
+	}
+)
+
+func (x Inbound) Enum() *Inbound {
+	p := new(Inbound)
+	*p = x
+	return p
+}
+
+func (x Inbound) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Inbound) Descriptor() protoreflect.EnumDescriptor {
+	return file_inbounds_proto_enumTypes[0].Descriptor()
+}
+
+func (Inbound) Type() protoreflect.EnumType {
+	return &file_inbounds_proto_enumTypes[0]
+}
+
+func (x Inbound) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Inbound.Descriptor instead.
+func (Inbound) EnumDescriptor() ([]byte, []int) {
+	return file_inbounds_proto_rawDescGZIP(), []int{0}
+}
+
+var File_inbounds_proto protoreflect.FileDescriptor
+
+var file_inbounds_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x69, 0x6e, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x08, 0x69, 0x6e, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x73, 0x2a, 0x91, 0x01, 0x0a, 0x07, 0x49,
+	0x6e, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x12, 0x11, 0x0a, 0x0d, 0x49, 0x4e, 0x42, 0x4f, 0x55, 0x4e,
+	0x44, 0x5f, 0x55, 0x4e, 0x53, 0x45, 0x54, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x48, 0x54, 0x54,
+	0x50, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x48, 0x54, 0x54, 0x50, 0x53, 0x10, 0x02, 0x12, 0x09,
+	0x0a, 0x05, 0x49, 0x4e, 0x4e, 0x45, 0x52, 0x10, 0x03, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x4f, 0x43,
+	0x4b, 0x53, 0x34, 0x10, 0x04, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x4f, 0x43, 0x4b, 0x53, 0x35, 0x10,
+	0x05, 0x12, 0x07, 0x0a, 0x03, 0x54, 0x55, 0x4e, 0x10, 0x06, 0x12, 0x09, 0x0a, 0x05, 0x52, 0x45,
+	0x44, 0x49, 0x52, 0x10, 0x07, 0x12, 0x0a, 0x0a, 0x06, 0x54, 0x50, 0x52, 0x4f, 0x58, 0x59, 0x10,
+	0x08, 0x12, 0x0f, 0x0a, 0x0b, 0x53, 0x48, 0x41, 0x44, 0x4f, 0x57, 0x53, 0x4f, 0x43, 0x4b, 0x53,
+	0x10, 0x09, 0x12, 0x0a, 0x0a, 0x06, 0x54, 0x52, 0x4f, 0x4a, 0x41, 0x4e, 0x10, 0x0a, 0x42, 0x25,
+	0x5a, 0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c, 0x75, 0x6d,
+	0x61, 0x76, 0x70, 0x6e, 0x2f, 0x6c, 0x75, 0x6d, 0x61, 0x2f, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x2f,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_inbounds_proto_rawDescOnce sync.Once
+	file_inbounds_proto_rawDescData = file_inbounds_proto_rawDesc
+)
+
+func file_inbounds_proto_rawDescGZIP() []byte {
+	file_inbounds_proto_rawDescOnce.Do(func() {
+		file_inbounds_proto_rawDescData = protoimpl.X.CompressGZIP(file_inbounds_proto_rawDescData)
+	})
+	return file_inbounds_proto_rawDescData
+}
+
+var file_inbounds_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_inbounds_proto_goTypes = []any{
+	(Inbound)(0), // 0: inbounds.Inbound
+}
+var file_inbounds_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_inbounds_proto_init() }
+func file_inbounds_proto_init() {
+	if File_inbounds_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_inbounds_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   0,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_inbounds_proto_goTypes,
+		DependencyIndexes: file_inbounds_proto_depIdxs,
+		EnumInfos:         file_inbounds_proto_enumTypes,
+	}.Build()
+	File_inbounds_proto = out.File
+	file_inbounds_proto_rawDesc = nil
+	file_inbounds_proto_goTypes = nil
+	file_inbounds_proto_depIdxs = nil
+}
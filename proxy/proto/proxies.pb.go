@@ -30,18 +30,28 @@ const (
 	Protocol_SOCKS4         Protocol = 4
 	Protocol_SOCKS5         Protocol = 5
 	Protocol_TUN            Protocol = 6
+	Protocol_DIRECT         Protocol = 7
+	Protocol_WIREGUARD      Protocol = 10
+	Protocol_SHADOWSOCKS    Protocol = 11
+	Protocol_TROJAN         Protocol = 12
+	Protocol_SSH            Protocol = 13
 )
 
 // Enum value maps for Protocol.
 var (
 	Protocol_name = map[int32]string{
-		0: "PROTOCOL_UNSET",
-		1: "HTTP",
-		2: "HTTPS",
-		3: "INNER",
-		4: "SOCKS4",
-		5: "SOCKS5",
-		6: "TUN",
+		0:  "PROTOCOL_UNSET",
+		1:  "HTTP",
+		2:  "HTTPS",
+		3:  "INNER",
+		4:  "SOCKS4",
+		5:  "SOCKS5",
+		6:  "TUN",
+		7:  "DIRECT",
+		10: "WIREGUARD",
+		11: "SHADOWSOCKS",
+		12: "TROJAN",
+		13: "SSH",
 	}
 	Protocol_value = map[string]int32{
 		"PROTOCOL_UNSET": 0,
@@ -51,6 +61,11 @@ var (
 		"SOCKS4":         4,
 		"SOCKS5":         5,
 		"TUN":            6,
+		"DIRECT":         7,
+		"WIREGUARD":      10,
+		"SHADOWSOCKS":    11,
+		"TROJAN":         12,
+		"SSH":            13,
 	}
 )
 
@@ -85,15 +100,21 @@ var File_proxies_proto protoreflect.FileDescriptor
 
 var file_proxies_proto_rawDesc = []byte{
 	0x0a, 0x0d, 0x70, 0x72, 0x6f, 0x78, 0x69, 0x65, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2a,
-	0x5f, 0x0a, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x12, 0x0a, 0x0e, 0x50,
-	0x52, 0x4f, 0x54, 0x4f, 0x43, 0x4f, 0x4c, 0x5f, 0x55, 0x4e, 0x53, 0x45, 0x54, 0x10, 0x00, 0x12,
-	0x08, 0x0a, 0x04, 0x48, 0x54, 0x54, 0x50, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x48, 0x54, 0x54,
-	0x50, 0x53, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x49, 0x4e, 0x4e, 0x45, 0x52, 0x10, 0x03, 0x12,
-	0x0a, 0x0a, 0x06, 0x53, 0x4f, 0x43, 0x4b, 0x53, 0x34, 0x10, 0x04, 0x12, 0x0a, 0x0a, 0x06, 0x53,
-	0x4f, 0x43, 0x4b, 0x53, 0x35, 0x10, 0x05, 0x12, 0x07, 0x0a, 0x03, 0x54, 0x55, 0x4e, 0x10, 0x06,
-	0x42, 0x25, 0x5a, 0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c,
-	0x75, 0x6d, 0x61, 0x76, 0x70, 0x6e, 0x2f, 0x6c, 0x75, 0x6d, 0x61, 0x2f, 0x70, 0x72, 0x6f, 0x78,
-	0x79, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0xbb, 0x01, 0x0a, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x12, 0x0a, 0x0e,
+	0x50, 0x52, 0x4f, 0x54, 0x4f, 0x43, 0x4f, 0x4c, 0x5f, 0x55, 0x4e, 0x53, 0x45, 0x54, 0x10, 0x00,
+	0x12, 0x08, 0x0a, 0x04, 0x48, 0x54, 0x54, 0x50, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x48, 0x54,
+	0x54, 0x50, 0x53, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x49, 0x4e, 0x4e, 0x45, 0x52, 0x10, 0x03,
+	0x12, 0x0a, 0x0a, 0x06, 0x53, 0x4f, 0x43, 0x4b, 0x53, 0x34, 0x10, 0x04, 0x12, 0x0a, 0x0a, 0x06,
+	0x53, 0x4f, 0x43, 0x4b, 0x53, 0x35, 0x10, 0x05, 0x12, 0x07, 0x0a, 0x03, 0x54, 0x55, 0x4e, 0x10,
+	0x06, 0x12, 0x0a, 0x0a, 0x06, 0x44, 0x49, 0x52, 0x45, 0x43, 0x54, 0x10, 0x07, 0x12, 0x0d, 0x0a,
+	0x09, 0x57, 0x49, 0x52, 0x45, 0x47, 0x55, 0x41, 0x52, 0x44, 0x10, 0x0a, 0x12, 0x0f, 0x0a, 0x0b,
+	0x53, 0x48, 0x41, 0x44, 0x4f, 0x57, 0x53, 0x4f, 0x43, 0x4b, 0x53, 0x10, 0x0b, 0x12, 0x0a, 0x0a,
+	0x06, 0x54, 0x52, 0x4f, 0x4a, 0x41, 0x4e, 0x10, 0x0c, 0x12, 0x07, 0x0a, 0x03, 0x53, 0x53, 0x48,
+	0x10, 0x0d, 0x22, 0x04, 0x08, 0x08, 0x10, 0x08, 0x22, 0x04, 0x08, 0x09, 0x10, 0x09, 0x2a, 0x05,
+	0x52, 0x45, 0x44, 0x49, 0x52, 0x2a, 0x06, 0x54, 0x50, 0x52, 0x4f, 0x58, 0x59, 0x42, 0x25, 0x5a,
+	0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c, 0x75, 0x6d, 0x61,
+	0x76, 0x70, 0x6e, 0x2f, 0x6c, 0x75, 0x6d, 0x61, 0x2f, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x2f, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
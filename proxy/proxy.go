@@ -1,6 +1,9 @@
 package proxy
 
 import (
+	"context"
+	"net"
+
 	"github.com/lumavpn/luma/metadata"
 	"github.com/lumavpn/luma/proxy/proto"
 )
@@ -15,4 +18,8 @@ type Proxy interface {
 	// SupportUDP returns whether or not the proxy supports UDP
 	SupportUDP() bool
 	Unwrap(*metadata.Metadata, bool) Proxy
+	// DialContext connects to the destination of metadata through the proxy
+	DialContext(context.Context, *metadata.Metadata) (net.Conn, error)
+	// ListenPacketContext returns a packet connection relaying UDP to the destination of metadata through the proxy
+	ListenPacketContext(context.Context, *metadata.Metadata) (net.PacketConn, error)
 }
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/shadowsocks"
+)
+
+// ShadowsocksOption is the configuration of a Shadowsocks outbound
+type ShadowsocksOption struct {
+	Name   string `yaml:"name"`
+	Server string `yaml:"server"`
+	Port   int    `yaml:"port"`
+	// Password is the base64 encoded key with the 2022 ciphers
+	Password string `yaml:"password"`
+	Cipher   string `yaml:"cipher"`
+	UDP      bool   `yaml:"udp"`
+	// TLS secures the connections to the server, usually along with a transport
+	TLS             bool `yaml:"tls"`
+	TLSOption       `yaml:",inline"`
+	TransportOption `yaml:",inline"`
+	DialerOption    `yaml:",inline"`
+}
+
+// Shadowsocks connects to destinations through a Shadowsocks server
+type Shadowsocks struct {
+	*Base
+	option ShadowsocksOption
+	cipher *shadowsocks.Cipher
+	dialer *streamDialer
+}
+
+// NewShadowsocks returns a new Shadowsocks proxy
+func NewShadowsocks(option ShadowsocksOption) (*Shadowsocks, error) {
+	if option.Server == "" || option.Port <= 0 || option.Port > 65535 {
+		return nil, errors.New("invalid server")
+	}
+	cipher, err := shadowsocks.NewCipher(option.Cipher, option.Password)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cipher: %w", err)
+	}
+	netDialer, err := dialer.New(option.DialerOption)
+	if err != nil {
+		return nil, err
+	}
+	streamDialer, err := newOptionalTLSStreamDialer(option.Server, option.Port, netDialer, option.TLS, option.TLSOption, option.TransportOption)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+	return &Shadowsocks{
+		Base:   NewBase(option.Name, addr, proto.Protocol_SHADOWSOCKS, option.UDP),
+		option: option,
+		cipher: cipher,
+		dialer: streamDialer,
+	}, nil
+}
+
+func (ss *Shadowsocks) DialContext(ctx context.Context, m *metadata.Metadata) (net.Conn, error) {
+	conn, err := ss.dialer.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ss.cipher.StreamConn(conn, m.SocksAddr()), nil
+}
+
+func (ss *Shadowsocks) ListenPacketContext(ctx context.Context, m *metadata.Metadata) (net.PacketConn, error) {
+	if ss.dialer.quic != nil {
+		// the packets are datagrams of the QUIC connection
+		pc, err := ss.dialer.quic.ListenPacket(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := ss.cipher.PacketConn(pc, pc.RemoteAddr())
+		if err != nil {
+			pc.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	server, err := ss.dialer.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := ss.dialer.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ss.cipher.PacketConn(pc, net.UDPAddrFromAddrPort(server))
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/transport/mux"
+	"github.com/lumavpn/luma/transport/shadowsocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startShadowsocksServer starts a Shadowsocks server relaying TCP and UDP to any destination
+// and returns its port
+func startShadowsocksServer(t *testing.T, method, password string) int {
+	cipher, err := shadowsocks.NewCipher(method, password)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveShadowsocksConn(cipher, c)
+		}
+	}()
+
+	port := l.Addr().(*net.TCPAddr).Port
+	pc, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go serveShadowsocksPackets(cipher, pc)
+	return port
+}
+
+// serveShadowsocksPackets relays the Shadowsocks packets received on pc to their destination
+func serveShadowsocksPackets(cipher *shadowsocks.Cipher, pc net.PacketConn) {
+	spc := cipher.ServerPacketConn(pc)
+	buf := make([]byte, 2048)
+	for {
+		n, client, target, err := spc.ReadPacket(buf)
+		if err != nil {
+			return
+		}
+		// answer synchronously, the echo server replies at once
+		relay, err := net.Dial("udp", target.String())
+		if err != nil {
+			continue
+		}
+		relay.SetDeadline(time.Now().Add(time.Second))
+		if _, err := relay.Write(buf[:n]); err == nil {
+			if n, err = relay.Read(buf); err == nil {
+				spc.WritePacket(buf[:n], client, target)
+			}
+		}
+		relay.Close()
+	}
+}
+
+// serveShadowsocksConn relays the Shadowsocks stream c to its destination
+func serveShadowsocksConn(cipher *shadowsocks.Cipher, c net.Conn) {
+	defer c.Close()
+	sc, err := cipher.ServerConn(c)
+	if err != nil {
+		return
+	}
+	relayTCP(sc, sc.Target())
+}
+
+// relayTCP relays c to target, or serves c as a multiplexed connection if target is the
+// destination of multiplexed connections
+func relayTCP(c net.Conn, target metadata.SocksAddr) {
+	defer c.Close()
+	if mux.IsDestination(target) {
+		mux.ServeConn(c, relayTCP)
+		return
+	}
+	dst, err := net.Dial("tcp", target.String())
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+	go io.Copy(dst, c)
+	io.Copy(c, dst)
+}
+
+func TestShadowsocks(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	echoPort := testutil.StartEchoServers(t)
+
+	for _, option := range []ShadowsocksOption{
+		{Cipher: shadowsocks.MethodChacha20IETFPoly1305, Password: "password"},
+		{Cipher: shadowsocks.Method2022Blake3AES256GCM, Password: base64.StdEncoding.EncodeToString(key)},
+	} {
+		t.Run(option.Cipher, func(t *testing.T) {
+			option.Name = "ss"
+			option.Server = "127.0.0.1"
+			option.Port = startShadowsocksServer(t, option.Cipher, option.Password)
+			option.UDP = true
+			ss, err := NewShadowsocks(option)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+			c, err := ss.DialContext(ctx, m)
+			require.NoError(t, err)
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			_, err = c.Write([]byte("ping"))
+			require.NoError(t, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(c, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(buf))
+
+			m = &metadata.Metadata{Network: metadata.UDP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+			pc, err := ss.ListenPacketContext(ctx, m)
+			require.NoError(t, err)
+			defer pc.Close()
+			pc.SetDeadline(time.Now().Add(5 * time.Second))
+			_, err = pc.WriteTo([]byte("query"), m.UDPAddr())
+			require.NoError(t, err)
+			buf = make([]byte, 64)
+			n, from, err := pc.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "query", string(buf[:n]))
+			assert.Equal(t, m.UDPAddr().String(), from.String())
+		})
+	}
+}
+
+func TestParseShadowsocks(t *testing.T) {
+	p, err := ParseProxy(map[string]any{
+		"name":     "ss",
+		"type":     "shadowsocks",
+		"server":   "127.0.0.1",
+		"port":     8388,
+		"cipher":   "aes-128-gcm",
+		"password": "password",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8388", p.Addr())
+	assert.False(t, p.SupportUDP())
+
+	_, err = ParseProxy(map[string]any{"name": "ss", "type": "shadowsocks", "server": "127.0.0.1", "port": 8388, "cipher": "rc4-md5", "password": "password"})
+	assert.ErrorIs(t, err, shadowsocks.ErrUnsupportedMethod)
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/socks5"
+)
+
+// Socks5Option is the configuration of a SOCKS5 outbound
+type Socks5Option struct {
+	Name     string `yaml:"name"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+	UDP      bool   `yaml:"udp"`
+	// TLS secures the connections to the server, usually along with a transport
+	TLS             bool `yaml:"tls"`
+	TLSOption       `yaml:",inline"`
+	TransportOption `yaml:",inline"`
+	DialerOption    `yaml:",inline"`
+}
+
+// Socks5 connects to destinations through a SOCKS5 server
+type Socks5 struct {
+	*Base
+	user   *socks5.User
+	dialer *streamDialer
+}
+
+// NewSocks5 returns a new SOCKS5 proxy
+func NewSocks5(option Socks5Option) (*Socks5, error) {
+	if option.Server == "" || option.Port <= 0 || option.Port > 65535 {
+		return nil, errors.New("invalid server")
+	}
+	if len(option.UserName) > 255 || len(option.Password) > 255 {
+		return nil, errors.New("username and password must be at most 255 bytes")
+	}
+	netDialer, err := dialer.New(option.DialerOption)
+	if err != nil {
+		return nil, err
+	}
+	streamDialer, err := newOptionalTLSStreamDialer(option.Server, option.Port, netDialer, option.TLS, option.TLSOption, option.TransportOption)
+	if err != nil {
+		return nil, err
+	}
+	if option.UDP && (streamDialer.wrap != nil || streamDialer.quic != nil) {
+		return nil, errors.New("udp is not supported with a transport")
+	}
+
+	var user *socks5.User
+	if option.UserName != "" {
+		user = &socks5.User{Username: option.UserName, Password: option.Password}
+	}
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+	return &Socks5{
+		Base:   NewBase(option.Name, addr, proto.Protocol_SOCKS5, option.UDP),
+		user:   user,
+		dialer: streamDialer,
+	}, nil
+}
+
+func (s *Socks5) DialContext(ctx context.Context, m *metadata.Metadata) (net.Conn, error) {
+	conn, _, err := s.handshake(ctx, m.SocksAddr(), socks5.CmdConnect)
+	return conn, err
+}
+
+func (s *Socks5) ListenPacketContext(ctx context.Context, m *metadata.Metadata) (net.PacketConn, error) {
+	conn, bound, err := s.handshake(ctx, metadata.ParseSocksAddr("0.0.0.0:0"), socks5.CmdUDPAssociate)
+	if err != nil {
+		return nil, err
+	}
+	relay := bound.UDPAddr()
+	if relay.IP == nil || relay.IP.IsUnspecified() {
+		// the relay is on the server itself
+		if server, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			relay.IP = server.IP
+		}
+	}
+	pc, err := s.dialer.ListenPacket(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go func() {
+		// the association lasts as long as the control connection
+		io.Copy(io.Discard, conn)
+		pc.Close()
+	}()
+	return &socks5PacketConn{PacketConn: pc, control: conn, relay: relay}, nil
+}
+
+// handshake connects to the server, requests command to target and returns the connection
+// and the address bound by the server
+func (s *Socks5) handshake(ctx context.Context, target metadata.SocksAddr, command socks5.Command) (net.Conn, metadata.SocksAddr, error) {
+	conn, err := s.dialer.DialContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	bound, err := socks5.ClientHandshake(conn, target, command, s.user)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, bound, nil
+}
+
+// socks5PacketConn relays UDP packets through the relay of a SOCKS5 UDP association
+type socks5PacketConn struct {
+	net.PacketConn
+	control net.Conn
+	relay   net.Addr
+}
+
+func (pc *socks5PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	packet, err := socks5.EncodeUDPPacket(metadata.ParseSocksAddr(addr.String()), b)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := pc.PacketConn.WriteTo(packet, pc.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (pc *socks5PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+metadata.MaxSocksAddrLen+3)
+	for {
+		n, _, err := pc.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		addr, payload, err := socks5.DecodeUDPPacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		return copy(b, payload), addr.UDPAddr(), nil
+	}
+}
+
+func (pc *socks5PacketConn) Close() error {
+	pc.control.Close()
+	return pc.PacketConn.Close()
+}
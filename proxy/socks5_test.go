@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/transport/socks5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSocks5Server starts a SOCKS5 server accepting user:pass and relaying TCP and UDP to any
+// destination, and returns its address
+func startSocks5Server(t *testing.T) net.Addr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	// the UDP relay is bound to the address of the listener
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	authenticator := auth.NewAuthenticator([]auth.AuthUser{{User: "user", Pass: "pass"}})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks5Conn(c, authenticator)
+		}
+	}()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, client, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			target, payload, err := socks5.DecodeUDPPacket(buf[:n])
+			if err != nil {
+				continue
+			}
+			relay, err := net.Dial("udp", target.String())
+			if err != nil {
+				continue
+			}
+			relay.SetDeadline(time.Now().Add(time.Second))
+			if _, err := relay.Write(payload); err == nil {
+				answer := make([]byte, 2048)
+				if n, err = relay.Read(answer); err == nil {
+					packet, _ := socks5.EncodeUDPPacket(target, answer[:n])
+					pc.WriteTo(packet, client)
+				}
+			}
+			relay.Close()
+		}
+	}()
+	return l.Addr()
+}
+
+// serveSocks5Conn relays the SOCKS5 connection c to its destination, or keeps it open during
+// its UDP association
+func serveSocks5Conn(c net.Conn, authenticator auth.Authenticator) {
+	defer c.Close()
+	target, command, _, err := socks5.ServerHandshake(c, authenticator)
+	if err != nil {
+		return
+	}
+	if command == socks5.CmdUDPAssociate {
+		io.Copy(io.Discard, c)
+		return
+	}
+	dst, err := net.Dial("tcp", target.String())
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+	go io.Copy(dst, c)
+	io.Copy(c, dst)
+}
+
+func TestSocks5(t *testing.T) {
+	addr := startSocks5Server(t)
+	echoPort := testutil.StartEchoServers(t)
+	port := addr.(*net.TCPAddr).Port
+
+	p, err := ParseProxy(map[string]any{
+		"name":     "socks",
+		"type":     "socks5",
+		"server":   "127.0.0.1",
+		"port":     port,
+		"username": "user",
+		"password": "pass",
+		"udp":      true,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	c, err := p.DialContext(ctx, m)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	m = &metadata.Metadata{Network: metadata.UDP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	pc, err := p.ListenPacketContext(ctx, m)
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = pc.WriteTo([]byte("query"), m.UDPAddr())
+	require.NoError(t, err)
+	buf = make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+	assert.Equal(t, m.UDPAddr().String(), from.String())
+
+	wrong, err := NewSocks5(Socks5Option{Server: "127.0.0.1", Port: port, UserName: "user", Password: "wrong"})
+	require.NoError(t, err)
+	_, err = wrong.DialContext(ctx, m)
+	assert.ErrorIs(t, err, socks5.ErrAuth)
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/log"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// sshKeepAliveInterval is the interval of the keepalives detecting dead SSH connections
+	sshKeepAliveInterval = 30 * time.Second
+	// sshHandshakeTimeout bounds establishing the SSH connection shared by all dials
+	sshHandshakeTimeout = 10 * time.Second
+)
+
+// SSHOption is the configuration of an SSH outbound
+type SSHOption struct {
+	Name     string `yaml:"name"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PrivateKey is a PEM encoded private key or the path of one
+	PrivateKey           string `yaml:"private-key"`
+	PrivateKeyPassphrase string `yaml:"private-key-passphrase"`
+	// KnownHosts is the path of the known_hosts file verifying the host key, ~/.ssh/known_hosts
+	// if empty
+	KnownHosts string `yaml:"known-hosts"`
+	// SkipHostKeyVerify accepts any host key when no known-hosts is set
+	SkipHostKeyVerify bool `yaml:"skip-host-key-verify"`
+	DialerOption      `yaml:",inline"`
+}
+
+// SSH connects to destinations through direct-tcpip channels of an SSH connection shared by
+// all of them, which is reestablished by the first connection after it is lost
+type SSH struct {
+	*Base
+	option    SSHOption
+	config    *ssh.ClientConfig
+	netDialer *dialer.Dialer
+
+	mu     sync.Mutex
+	client *ssh.Client
+	group  singleflight.Group
+}
+
+// NewSSH returns a new SSH proxy
+func NewSSH(option SSHOption) (*SSH, error) {
+	if option.Server == "" || option.Port <= 0 || option.Port > 65535 {
+		return nil, errors.New("invalid server")
+	}
+	if option.UserName == "" {
+		return nil, errors.New("missing username")
+	}
+	netDialer, err := dialer.New(option.DialerOption)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{User: option.UserName}
+	if option.PrivateKey != "" {
+		signer, err := parseSSHPrivateKey(option.PrivateKey, option.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private-key: %w", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if option.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(option.Password))
+	}
+	if len(config.Auth) == 0 {
+		return nil, errors.New("missing password or private-key")
+	}
+	if option.KnownHosts == "" && option.SkipHostKeyVerify {
+		log.Warnf("[SSH] %s: host key is not verified", option.Name)
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHosts := option.KnownHosts
+		if knownHosts == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("known-hosts is required unless skip-host-key-verify is set: %w", err)
+			}
+			knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		callback, err := knownhosts.New(knownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid known-hosts: %w", err)
+		}
+		config.HostKeyCallback = callback
+	}
+
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+	return &SSH{
+		Base:      NewBase(option.Name, addr, proto.Protocol_SSH, false),
+		option:    option,
+		config:    config,
+		netDialer: netDialer,
+	}, nil
+}
+
+func (s *SSH) DialContext(ctx context.Context, m *metadata.Metadata) (net.Conn, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialContext(ctx, "tcp", m.RemoteAddress())
+	var openErr *ssh.OpenChannelError
+	if err != nil && !errors.As(err, &openErr) {
+		// the connection may have been lost without being noticed yet
+		s.disconnect(client)
+		if client, err = s.connect(ctx); err != nil {
+			return nil, err
+		}
+		conn, err = client.DialContext(ctx, "tcp", m.RemoteAddress())
+	}
+	return conn, err
+}
+
+// Close closes the SSH connection
+func (s *SSH) Close() error {
+	s.mu.Lock()
+	client := s.client
+	s.client = nil
+	s.mu.Unlock()
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
+
+// connect returns the SSH connection, establishing it if there is none. Concurrent callers
+// share a single handshake which does not hold s.mu and is not canceled with ctx
+func (s *SSH) connect(ctx context.Context) (*ssh.Client, error) {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	ch := s.group.DoChan("", func() (any, error) {
+		return s.dial(context.WithoutCancel(ctx))
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(*ssh.Client), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dial establishes a new SSH connection and makes it the current one
+func (s *SSH) dial(ctx context.Context) (*ssh.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, sshHandshakeTimeout)
+	defer cancel()
+	conn, err := s.netDialer.DialContext(ctx, "tcp", s.Addr())
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.Addr(), s.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(c, chans, reqs)
+	s.mu.Lock()
+	s.client = client
+	s.mu.Unlock()
+
+	go s.keepAlive(client)
+	go func() {
+		err := client.Wait()
+		log.Debugf("[SSH] %s: connection closed: %v", s.Name(), err)
+		s.disconnect(client)
+	}()
+	return client, nil
+}
+
+// disconnect forgets client if it is the current SSH connection and closes it
+func (s *SSH) disconnect(client *ssh.Client) {
+	s.mu.Lock()
+	current := s.client == client
+	if current {
+		s.client = nil
+	}
+	s.mu.Unlock()
+	if current {
+		client.Close()
+	}
+}
+
+// keepAlive closes client when it does not answer keepalives anymore
+func (s *SSH) keepAlive(client *ssh.Client) {
+	ticker := time.NewTicker(sshKeepAliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// a dead connection may never answer
+		timer := time.AfterFunc(sshKeepAliveInterval, func() { s.disconnect(client) })
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		timer.Stop()
+		if err != nil {
+			s.disconnect(client)
+			return
+		}
+	}
+}
+
+// parseSSHPrivateKey parses the PEM encoded private key, read from the path key if it is not
+// PEM itself
+func parseSSHPrivateKey(key, passphrase string) (ssh.Signer, error) {
+	pemBytes := []byte(key)
+	if !strings.Contains(key, "PRIVATE KEY-----") {
+		var err error
+		if pemBytes, err = os.ReadFile(key); err != nil {
+			return nil, err
+		}
+	}
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(pemBytes)
+}
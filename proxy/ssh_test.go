@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is an SSH server opening direct-tcpip channels to any destination
+type testSSHServer struct {
+	port    int
+	hostKey ssh.PublicKey
+	// handshakes counts the established connections
+	handshakes atomic.Int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// dropConnections closes the established connections
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// startSSHServer starts an SSH server accepting the user luma with the password "password" or
+// the key clientKey
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) *testSSHServer {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "luma" && string(password) == "password" {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "luma" && clientKey != nil && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	server := &testSSHServer{port: l.Addr().(*net.TCPAddr).Port, hostKey: hostSigner.PublicKey()}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(c, config)
+				if err != nil {
+					c.Close()
+					return
+				}
+				server.handshakes.Add(1)
+				server.mu.Lock()
+				server.conns = append(server.conns, c)
+				server.mu.Unlock()
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					go handleDirectTCPIP(ch)
+				}
+			}()
+		}
+	}()
+	return server
+}
+
+func handleDirectTCPIP(ch ssh.NewChannel) {
+	if ch.ChannelType() != "direct-tcpip" {
+		ch.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		return
+	}
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(ch.ExtraData(), &payload); err != nil {
+		ch.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+	dst, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		ch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer dst.Close()
+	c, reqs, err := ch.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	go ssh.DiscardRequests(reqs)
+	go io.Copy(dst, c)
+	io.Copy(c, dst)
+}
+
+// sshEcho sends ping through s to the echo server on port and checks the answer
+func sshEcho(t *testing.T, s *SSH, port int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(port)}
+	c, err := s.DialContext(ctx, m)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestSSH(t *testing.T) {
+	server := startSSHServer(t, nil)
+	echoPort := testutil.StartEchoServers(t)
+
+	s, err := NewSSH(SSHOption{Name: "ssh", Server: "127.0.0.1", Port: server.port, UserName: "luma", Password: "password", SkipHostKeyVerify: true})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// concurrent connections share a single handshake
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Go(func() {
+			_, err := s.connect(context.Background())
+			errs <- err
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	for range 3 {
+		sshEcho(t, s, echoPort)
+	}
+	assert.EqualValues(t, 1, server.handshakes.Load())
+
+	// refused destinations keep it too
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.DialContext(ctx, &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: 1})
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, server.handshakes.Load())
+
+	// a lost connection is reestablished
+	server.dropConnections()
+	sshEcho(t, s, echoPort)
+	assert.EqualValues(t, 2, server.handshakes.Load())
+}
+
+func TestSSHPrivateKey(t *testing.T) {
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPublic, err := ssh.NewPublicKey(clientPublic)
+	require.NoError(t, err)
+	server := startSSHServer(t, sshPublic)
+	echoPort := testutil.StartEchoServers(t)
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(clientPrivate, "", []byte("secret"))
+	require.NoError(t, err)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+
+	addr := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port)))
+	knownHosts := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(knownHosts, []byte(knownhosts.Line([]string{addr}, server.hostKey)+"\n"), 0o600))
+
+	option := SSHOption{
+		Server:               "127.0.0.1",
+		Port:                 server.port,
+		UserName:             "luma",
+		PrivateKey:           keyFile,
+		PrivateKeyPassphrase: "secret",
+		KnownHosts:           knownHosts,
+	}
+	s, err := NewSSH(option)
+	require.NoError(t, err)
+	defer s.Close()
+	sshEcho(t, s, echoPort)
+
+	// an unknown host key is rejected
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublic, err := ssh.NewPublicKey(otherKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(knownHosts, []byte(knownhosts.Line([]string{addr}, otherPublic)+"\n"), 0o600))
+	s, err = NewSSH(option)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.DialContext(ctx, &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)})
+	var keyErr *knownhosts.KeyError
+	assert.ErrorAs(t, err, &keyErr)
+}
+
+func TestSSHDefaultKnownHosts(t *testing.T) {
+	server := startSSHServer(t, nil)
+	echoPort := testutil.StartEchoServers(t)
+	option := SSHOption{Server: "127.0.0.1", Port: server.port, UserName: "luma", Password: "password"}
+
+	// without known-hosts the host key is verified with ~/.ssh/known_hosts
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	_, err := NewSSH(option)
+	assert.ErrorContains(t, err, "known-hosts")
+
+	addr := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port)))
+	require.NoError(t, os.Mkdir(filepath.Join(home, ".ssh"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(knownhosts.Line([]string{addr}, server.hostKey)+"\n"), 0o600))
+	s, err := NewSSH(option)
+	require.NoError(t, err)
+	defer s.Close()
+	sshEcho(t, s, echoPort)
+}
+
+func TestParseSSH(t *testing.T) {
+	p, err := ParseProxy(map[string]any{
+		"name":                 "ssh",
+		"type":                 "ssh",
+		"server":               "bastion.example.com",
+		"port":                 22,
+		"username":             "luma",
+		"password":             "password",
+		"skip-host-key-verify": true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "bastion.example.com:22", p.Addr())
+	assert.False(t, p.SupportUDP())
+
+	_, err = ParseProxy(map[string]any{"name": "ssh", "type": "ssh", "server": "bastion.example.com", "port": 22, "username": "luma", "skip-host-key-verify": true})
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+
+	T "github.com/lumavpn/luma/common/tls"
+	"github.com/lumavpn/luma/dns"
+)
+
+// TLSOption is the TLS configuration of an outbound
+type TLSOption = T.Option
+
+// ECHOption is the Encrypted ClientHello configuration of an outbound
+type ECHOption = T.ECHOption
+
+// newTLSConfig returns the client TLS configuration of option for server, negotiating alpn
+// unless option has its own ALPN
+func newTLSConfig(option TLSOption, server string, alpn []string) (*tls.Config, error) {
+	return option.ClientConfig(server, alpn)
+}
+
+// echLookup returns the function looking up the ECHConfigList of the server name of config if
+// option enables ECH without a config, nil otherwise
+func echLookup(option TLSOption, config *tls.Config) func(ctx context.Context) ([]byte, error) {
+	if !option.ECHOpts.Enable || option.ECHOpts.Config != "" {
+		return nil
+	}
+	return func(ctx context.Context) ([]byte, error) {
+		return dns.LookupECHConfig(ctx, config.ServerName)
+	}
+}
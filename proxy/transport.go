@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lumavpn/luma/common/atomic"
+	"github.com/lumavpn/luma/common/dialer"
+	T "github.com/lumavpn/luma/common/tls"
+	"github.com/lumavpn/luma/transport/h2"
+	"github.com/lumavpn/luma/transport/quic"
+	"github.com/lumavpn/luma/transport/ws"
+	utls "github.com/refraction-networking/utls"
+)
+
+// quicKeepAlivePeriod keeps the QUIC connection and its NAT mappings alive
+const quicKeepAlivePeriod = 15 * time.Second
+
+// TransportOption selects the carrier of the connections of a stream outbound to its server
+type TransportOption struct {
+	// Network is tcp, ws, h2, grpc or quic, tcp if empty
+	Network  string      `yaml:"network"`
+	WSOpts   WSOption    `yaml:"ws-opts"`
+	H2Opts   HTTP2Option `yaml:"h2-opts"`
+	GrpcOpts GRPCOption  `yaml:"grpc-opts"`
+	QUICOpts QUICOption  `yaml:"quic-opts"`
+}
+
+// WSOption is the configuration of the WebSocket transport
+type WSOption struct {
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+	// MaxEarlyData is the size of the beginning of the stream sent in the handshake
+	MaxEarlyData        int    `yaml:"max-early-data"`
+	EarlyDataHeaderName string `yaml:"early-data-header-name"`
+}
+
+// HTTP2Option is the configuration of the HTTP/2 transport
+type HTTP2Option struct {
+	Host string `yaml:"host"`
+	Path string `yaml:"path"`
+}
+
+// GRPCOption is the configuration of the gRPC transport
+type GRPCOption struct {
+	GrpcServiceName string `yaml:"grpc-service-name"`
+}
+
+// QUICOption is the configuration of the QUIC transport
+type QUICOption struct {
+	// CongestionControl is rejected if set, quic-go only implements NewReno
+	CongestionControl string `yaml:"congestion-control"`
+	// IdleTimeout is how many seconds the connection is kept without activity
+	IdleTimeout int `yaml:"idle-timeout"`
+}
+
+// streamDialer opens the connections of a stream outbound to its server, secured by TLS if
+// configured and wrapped in the carrier of the transport
+type streamDialer struct {
+	server    string
+	port      int
+	netDialer *dialer.Dialer
+	tlsConfig *tls.Config
+	// clientHello is the browser whose ClientHello the TLS handshakes mimic if set
+	clientHello *utls.ClientHelloID
+	// wrap establishes the carrier over the connection, nil for plain TCP
+	wrap func(ctx context.Context, conn net.Conn) (net.Conn, error)
+	// quic carries the connections as streams of a QUIC connection instead of TCP if set
+	quic *quic.Client
+	// echConfig returns the ECHConfigList of each TLS handshake if set
+	echConfig func(ctx context.Context) ([]byte, error)
+	// echRetry is the ECHConfigList the server last sent on rejecting ECH, which replaces the
+	// configured one
+	echRetry atomic.TypedValue[[]byte]
+}
+
+// newStreamDialer returns the dialer of the transport option to server:port opening sockets
+// with netDialer. tlsConfig is nil without TLS, its ALPN is replaced by the one the carrier
+// requires. clientHello is the browser the handshakes mimic and echConfig looks up their
+// ECHConfigList if set
+func newStreamDialer(server string, port int, netDialer *dialer.Dialer, option TransportOption, tlsConfig *tls.Config,
+	clientHello *utls.ClientHelloID, echConfig func(ctx context.Context) ([]byte, error)) (*streamDialer, error) {
+	d := &streamDialer{server: server, port: port, netDialer: netDialer, tlsConfig: tlsConfig, clientHello: clientHello, echConfig: echConfig}
+	// the authority of HTTP based carriers
+	host := server
+	if tlsConfig != nil {
+		host = tlsConfig.ServerName
+	}
+
+	var alpn []string
+	switch option.Network {
+	case "", "tcp":
+	case "ws":
+		config := &ws.Config{
+			Host:                host,
+			Path:                option.WSOpts.Path,
+			Headers:             http.Header{},
+			MaxEarlyData:        option.WSOpts.MaxEarlyData,
+			EarlyDataHeaderName: option.WSOpts.EarlyDataHeaderName,
+		}
+		for k, v := range option.WSOpts.Headers {
+			config.Headers.Set(k, v)
+		}
+		if h := config.Headers.Get("Host"); h != "" {
+			config.Host = h
+		}
+		alpn = []string{"http/1.1"}
+		d.wrap = func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+			return ws.StreamConn(ctx, conn, config)
+		}
+	case "h2":
+		config := &h2.Config{Host: host, Path: option.H2Opts.Path}
+		if option.H2Opts.Host != "" {
+			config.Host = option.H2Opts.Host
+		}
+		alpn = []string{"h2"}
+		d.wrap = func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+			return h2.StreamConn(ctx, conn, config)
+		}
+	case "grpc":
+		config := &h2.GRPCConfig{Host: host, ServiceName: option.GrpcOpts.GrpcServiceName}
+		alpn = []string{"h2"}
+		d.wrap = func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+			return h2.GRPCStreamConn(ctx, conn, config)
+		}
+	case "quic":
+		if tlsConfig == nil {
+			return nil, errors.New("quic requires tls")
+		}
+		if echConfig != nil {
+			return nil, errors.New("quic requires the ech config to be set in ech-opts")
+		}
+		if clientHello != nil {
+			return nil, errors.New("quic does not support client-fingerprint")
+		}
+		if option.QUICOpts.CongestionControl != "" {
+			return nil, errors.New("quic congestion-control is not configurable")
+		}
+		client, err := quic.NewClient(func(ctx context.Context) (*net.UDPAddr, error) {
+			server, err := d.resolve(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return net.UDPAddrFromAddrPort(server), nil
+		}, tlsConfig, quic.Options{
+			IdleTimeout:     time.Duration(option.QUICOpts.IdleTimeout) * time.Second,
+			KeepAlivePeriod: quicKeepAlivePeriod,
+			ListenPacket:    d.ListenPacket,
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.quic = client
+	default:
+		return nil, fmt.Errorf("unsupported network %q", option.Network)
+	}
+
+	if tlsConfig != nil && alpn != nil && !slices.Equal(tlsConfig.NextProtos, alpn) {
+		d.tlsConfig = tlsConfig.Clone()
+		d.tlsConfig.NextProtos = alpn
+	}
+	return d, nil
+}
+
+// newOptionalTLSStreamDialer returns the dialer of the transport option to server:port, secured
+// by TLS if enabled or required by the transport
+func newOptionalTLSStreamDialer(server string, port int, netDialer *dialer.Dialer, enableTLS bool, tlsOption TLSOption, option TransportOption) (*streamDialer, error) {
+	if !enableTLS && option.Network != "quic" {
+		return newStreamDialer(server, port, netDialer, option, nil, nil, nil)
+	}
+	tlsConfig, err := newTLSConfig(tlsOption, server, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamDialer(server, port, netDialer, option, tlsConfig, tlsOption.ClientHelloID(), echLookup(tlsOption, tlsConfig))
+}
+
+// resolve returns the address of the server
+func (d *streamDialer) resolve(ctx context.Context) (netip.AddrPort, error) {
+	return resolveServer(ctx, d.netDialer, d.server, d.port)
+}
+
+// ListenPacket opens a UDP socket to exchange packets with the server
+func (d *streamDialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	return d.netDialer.ListenPacket(ctx, "udp", "")
+}
+
+// DialContext opens a connection to the server
+func (d *streamDialer) DialContext(ctx context.Context) (net.Conn, error) {
+	if d.quic != nil {
+		return d.quic.DialContext(ctx)
+	}
+	conn, err := d.dialTLS(ctx)
+	var rejection *tls.ECHRejectionError
+	if errors.As(err, &rejection) && len(rejection.RetryConfigList) > 0 {
+		// the server rotated its keys, reconnect with the ones it sent
+		d.echRetry.Store(rejection.RetryConfigList)
+		conn, err = d.dialTLS(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.wrap != nil {
+		wrapped, err := d.wrap(ctx, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = wrapped
+	}
+	return conn, nil
+}
+
+// dialTLS opens a TCP connection to the server, secured by TLS if configured
+func (d *streamDialer) dialTLS(ctx context.Context) (net.Conn, error) {
+	conn, err := d.netDialer.DialContext(ctx, "tcp", net.JoinHostPort(d.server, strconv.Itoa(d.port)))
+	if err != nil {
+		return nil, err
+	}
+	if d.tlsConfig == nil {
+		return conn, nil
+	}
+	tlsConfig := d.tlsConfig
+	if list := d.echRetry.Load(); list != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.EncryptedClientHelloConfigList = list
+	} else if d.echConfig != nil {
+		list, err := d.echConfig(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ech config: %w", err)
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.EncryptedClientHelloConfigList = list
+	}
+	if d.clientHello != nil {
+		tlsConn, err := T.UClient(ctx, conn, tlsConfig, *d.clientHello)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake: %w", err)
+		}
+		return tlsConn, nil
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	return tlsConn, nil
+}
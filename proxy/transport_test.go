@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/auth"
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/transport/h2"
+	"github.com/lumavpn/luma/transport/quic"
+	"github.com/lumavpn/luma/transport/shadowsocks"
+	"github.com/lumavpn/luma/transport/ws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTransportServer starts an HTTP server accepting WebSocket connections on /ws, HTTP/2
+// streams on /h2 and gRPC calls of the luma service, and serving them with serve. The server
+// speaks TLS and HTTP/2 if secure
+func startTransportServer(t *testing.T, secure bool, serve func(net.Conn)) int {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var conn net.Conn
+		var err error
+		switch r.URL.Path {
+		case "/ws":
+			conn, err = ws.Accept(w, r, "")
+		case "/h2":
+			conn, err = h2.ServerConn(w, r)
+		case h2.GRPCPath("luma"):
+			conn, err = h2.GRPCServerConn(w, r)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			return
+		}
+		serve(conn)
+	}))
+	if secure {
+		server.EnableHTTP2 = true
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// startQUICServer starts a QUIC transport server serving its streams with serve and its
+// datagram sessions with servePackets, and returns its port
+func startQUICServer(t *testing.T, serve func(net.Conn), servePackets func(net.PacketConn)) int {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	l, err := quic.Listen(pc, &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}, quic.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+	go func() {
+		for {
+			pc, err := l.AcceptPacket()
+			if err != nil {
+				return
+			}
+			go servePackets(pc)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+// testTCPEcho sends ping through p to the echo server on port and checks the answer
+func testTCPEcho(t *testing.T, p Proxy, port int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(port)}
+	c, err := p.DialContext(ctx, m)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	for _, payload := range []string{"ping", "pong"} {
+		_, err = c.Write([]byte(payload))
+		require.NoError(t, err)
+		buf := make([]byte, len(payload))
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(buf))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	echoPort := testutil.StartEchoServers(t)
+	cipher, err := shadowsocks.NewCipher(shadowsocks.MethodAES128GCM, "password")
+	require.NoError(t, err)
+	serveShadowsocks := func(c net.Conn) { serveShadowsocksConn(cipher, c) }
+	serveTrojan := func(c net.Conn) { serveTrojanConn(c, "password") }
+	authenticator := auth.NewAuthenticator(nil)
+	serveSocks5 := func(c net.Conn) { serveSocks5Conn(c, authenticator) }
+
+	for _, test := range []struct {
+		name   string
+		secure bool
+		serve  func(net.Conn)
+		proxy  map[string]any
+	}{
+		{
+			name:  "shadowsocks over ws",
+			serve: serveShadowsocks,
+			proxy: map[string]any{
+				"type": "shadowsocks", "cipher": "aes-128-gcm", "password": "password",
+				"network": "ws", "ws-opts": map[string]any{"path": "/ws", "headers": map[string]any{"Host": "cdn.example.com"}},
+			},
+		},
+		{
+			name:   "shadowsocks over h2",
+			secure: true,
+			serve:  serveShadowsocks,
+			proxy: map[string]any{
+				"type": "shadowsocks", "cipher": "aes-128-gcm", "password": "password",
+				"tls": true, "skip-cert-verify": true, "network": "h2", "h2-opts": map[string]any{"path": "/h2"},
+			},
+		},
+		{
+			name:   "trojan over ws with early data",
+			secure: true,
+			serve:  serveTrojan,
+			proxy: map[string]any{
+				"type": "trojan", "password": "password", "skip-cert-verify": true,
+				"network": "ws", "ws-opts": map[string]any{"path": "/ws", "max-early-data": 2048},
+			},
+		},
+		{
+			name:   "trojan over grpc",
+			secure: true,
+			serve:  serveTrojan,
+			proxy: map[string]any{
+				"type": "trojan", "password": "password", "sni": "example.com", "skip-cert-verify": true,
+				"network": "grpc", "grpc-opts": map[string]any{"grpc-service-name": "luma"},
+			},
+		},
+		{
+			name:   "trojan over ws with a chrome fingerprint",
+			secure: true,
+			serve:  serveTrojan,
+			proxy: map[string]any{
+				"type": "trojan", "password": "password", "skip-cert-verify": true, "client-fingerprint": "chrome",
+				"network": "ws", "ws-opts": map[string]any{"path": "/ws"},
+			},
+		},
+		{
+			name:   "trojan over h2 with a firefox fingerprint",
+			secure: true,
+			serve:  serveTrojan,
+			proxy: map[string]any{
+				"type": "trojan", "password": "password", "skip-cert-verify": true, "client-fingerprint": "firefox",
+				"network": "h2", "h2-opts": map[string]any{"path": "/h2"},
+			},
+		},
+		{
+			name:   "trojan over grpc with a random fingerprint",
+			secure: true,
+			serve:  serveTrojan,
+			proxy: map[string]any{
+				"type": "trojan", "password": "password", "skip-cert-verify": true, "client-fingerprint": "random",
+				"network": "grpc", "grpc-opts": map[string]any{"grpc-service-name": "luma"},
+			},
+		},
+		{
+			name:   "socks5 over ws",
+			secure: true,
+			serve:  serveSocks5,
+			proxy: map[string]any{
+				"type": "socks5", "tls": true, "skip-cert-verify": true,
+				"network": "ws", "ws-opts": map[string]any{"path": "/ws"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.proxy["name"] = test.name
+			test.proxy["server"] = "127.0.0.1"
+			test.proxy["port"] = startTransportServer(t, test.secure, test.serve)
+			p, err := ParseProxy(test.proxy)
+			require.NoError(t, err)
+			testTCPEcho(t, p, echoPort)
+		})
+	}
+}
+
+func TestTransportTrojanUDP(t *testing.T) {
+	echoPort := testutil.StartEchoServers(t)
+	port := startTransportServer(t, true, func(c net.Conn) { serveTrojanConn(c, "password") })
+	tr, err := NewTrojan(TrojanOption{
+		Server:          "127.0.0.1",
+		Port:            port,
+		Password:        "password",
+		UDP:             true,
+		TLSOption:       TLSOption{SkipCertVerify: true},
+		TransportOption: TransportOption{Network: "ws", WSOpts: WSOption{Path: "/ws"}},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := &metadata.Metadata{Network: metadata.UDP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	pc, err := tr.ListenPacketContext(ctx, m)
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = pc.WriteTo([]byte("query"), m.UDPAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+}
+
+func TestTransportQUIC(t *testing.T) {
+	echoPort := testutil.StartEchoServers(t)
+	cipher, err := shadowsocks.NewCipher(shadowsocks.MethodAES128GCM, "password")
+	require.NoError(t, err)
+	port := startQUICServer(t, func(c net.Conn) {
+		serveShadowsocksConn(cipher, c)
+	}, func(pc net.PacketConn) {
+		serveShadowsocksPackets(cipher, pc)
+	})
+
+	p, err := ParseProxy(map[string]any{
+		"name": "ss", "type": "shadowsocks", "server": "127.0.0.1", "port": port,
+		"cipher": "aes-128-gcm", "password": "password", "udp": true, "skip-cert-verify": true,
+		"network": "quic", "quic-opts": map[string]any{"idle-timeout": 30},
+	})
+	require.NoError(t, err)
+	for range 2 {
+		testTCPEcho(t, p, echoPort)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := &metadata.Metadata{Network: metadata.UDP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	pc, err := p.ListenPacketContext(ctx, m)
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = pc.WriteTo([]byte("query"), m.UDPAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+	assert.Equal(t, m.UDPAddr().String(), from.String())
+
+	_, err = ParseProxy(map[string]any{
+		"name": "ss", "type": "shadowsocks", "server": "127.0.0.1", "port": port, "cipher": "aes-128-gcm", "password": "password",
+		"network": "quic", "quic-opts": map[string]any{"congestion-control": "reno"},
+	})
+	assert.ErrorContains(t, err, "congestion-control is not configurable")
+}
+
+func TestTransportOptions(t *testing.T) {
+	_, err := NewSocks5(Socks5Option{Server: "127.0.0.1", Port: 1080, TransportOption: TransportOption{Network: "kcp"}})
+	assert.ErrorContains(t, err, "unsupported network")
+	_, err = NewSocks5(Socks5Option{Server: "127.0.0.1", Port: 1080, UDP: true, TransportOption: TransportOption{Network: "ws"}})
+	assert.Error(t, err)
+	_, err = NewSocks5(Socks5Option{Server: "127.0.0.1", Port: 1080, UDP: true, TransportOption: TransportOption{Network: "quic"}})
+	assert.Error(t, err)
+
+	tr, err := NewTrojan(TrojanOption{
+		Server:          "127.0.0.1",
+		Port:            443,
+		Password:        "password",
+		TLSOption:       TLSOption{SNI: "example.com"},
+		TransportOption: TransportOption{Network: "grpc"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"h2"}, tr.dialer.tlsConfig.NextProtos)
+	assert.Equal(t, "example.com", tr.dialer.tlsConfig.ServerName)
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/transport/trojan"
+)
+
+// defaultTrojanALPN is what Trojan servers usually serve as fallback
+var defaultTrojanALPN = []string{"h2", "http/1.1"}
+
+// TrojanOption is the configuration of a Trojan outbound
+type TrojanOption struct {
+	Name            string `yaml:"name"`
+	Server          string `yaml:"server"`
+	Port            int    `yaml:"port"`
+	Password        string `yaml:"password"`
+	UDP             bool   `yaml:"udp"`
+	TLSOption       `yaml:",inline"`
+	TransportOption `yaml:",inline"`
+	DialerOption    `yaml:",inline"`
+}
+
+// Trojan connects to destinations through a Trojan server
+type Trojan struct {
+	*Base
+	key    [trojan.KeyLength]byte
+	dialer *streamDialer
+}
+
+// NewTrojan returns a new Trojan proxy
+func NewTrojan(option TrojanOption) (*Trojan, error) {
+	if option.Server == "" || option.Port <= 0 || option.Port > 65535 {
+		return nil, errors.New("invalid server")
+	}
+	if option.Password == "" {
+		return nil, errors.New("missing password")
+	}
+	tlsConfig, err := newTLSConfig(option.TLSOption, option.Server, defaultTrojanALPN)
+	if err != nil {
+		return nil, err
+	}
+	netDialer, err := dialer.New(option.DialerOption)
+	if err != nil {
+		return nil, err
+	}
+	streamDialer, err := newStreamDialer(option.Server, option.Port, netDialer, option.TransportOption, tlsConfig, option.ClientHelloID(), echLookup(option.TLSOption, tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+	return &Trojan{
+		Base:   NewBase(option.Name, addr, proto.Protocol_TROJAN, option.UDP),
+		key:    trojan.Key(option.Password),
+		dialer: streamDialer,
+	}, nil
+}
+
+func (t *Trojan) DialContext(ctx context.Context, m *metadata.Metadata) (net.Conn, error) {
+	conn, err := t.dialer.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return trojan.NewConn(conn, t.key, m.SocksAddr()), nil
+}
+
+func (t *Trojan) ListenPacketContext(ctx context.Context, m *metadata.Metadata) (net.PacketConn, error) {
+	conn, err := t.dialer.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return trojan.NewPacketConn(conn, t.key), nil
+}
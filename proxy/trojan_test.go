@@ -0,0 +1,478 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/dns"
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/transport/trojan"
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// testCertificate returns a self-signed certificate for example.com
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com", "public.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startTrojanServer starts a Trojan server relaying TCP and UDP to any destination and returns
+// its port. The ALPN negotiated by every connection is sent to alpn
+func startTrojanServer(t *testing.T, cert tls.Certificate, password string, alpn chan<- string) int {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				tlsConn := c.(*tls.Conn)
+				if err := tlsConn.Handshake(); err != nil {
+					c.Close()
+					return
+				}
+				select {
+				case alpn <- tlsConn.ConnectionState().NegotiatedProtocol:
+				default:
+				}
+				serveTrojanConn(c, password)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// serveTrojanConn relays the TCP or UDP request of the Trojan stream c
+func serveTrojanConn(c net.Conn, password string) {
+	defer c.Close()
+	key, command, target, err := trojan.ReadRequest(c)
+	if err != nil || key != trojan.Key(password) {
+		return
+	}
+
+	if command == trojan.CommandTCP {
+		relayTCP(c, target)
+		return
+	}
+
+	spc := trojan.ServerPacketConn(c)
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := spc.ReadPacket(buf)
+		if err != nil {
+			return
+		}
+		relay, err := net.Dial("udp", addr.String())
+		if err != nil {
+			return
+		}
+		relay.SetDeadline(time.Now().Add(time.Second))
+		if _, err := relay.Write(buf[:n]); err == nil {
+			if n, err = relay.Read(buf); err == nil {
+				spc.WritePacket(buf[:n], addr)
+			}
+		}
+		relay.Close()
+	}
+}
+
+func TestTrojan(t *testing.T) {
+	cert := testCertificate(t)
+	alpn := make(chan string, 1)
+	port := startTrojanServer(t, cert, "password", alpn)
+	echoPort := testutil.StartEchoServers(t)
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+
+	tr, err := NewTrojan(TrojanOption{
+		Name:     "trojan",
+		Server:   "127.0.0.1",
+		Port:     port,
+		Password: "password",
+		UDP:      true,
+		TLSOption: TLSOption{
+			SNI:         "example.com",
+			ALPN:        []string{"http/1.1"},
+			Fingerprint: hex.EncodeToString(fingerprint[:]),
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	c, err := tr.DialContext(ctx, m)
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	assert.Equal(t, "http/1.1", <-alpn)
+
+	m = &metadata.Metadata{Network: metadata.UDP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	pc, err := tr.ListenPacketContext(ctx, m)
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = pc.WriteTo([]byte("query"), m.UDPAddr())
+	require.NoError(t, err)
+	buf = make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+	assert.Equal(t, m.UDPAddr().String(), from.String())
+}
+
+func TestTrojanVerify(t *testing.T) {
+	port := startTrojanServer(t, testCertificate(t), "password", nil)
+	m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: 80}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, test := range []struct {
+		name   string
+		option TLSOption
+		ok     bool
+	}{
+		{"untrusted", TLSOption{SNI: "example.com"}, false},
+		{"skip-cert-verify", TLSOption{SkipCertVerify: true}, true},
+		{"wrong fingerprint", TLSOption{Fingerprint: hex.EncodeToString(make([]byte, 32))}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tr, err := NewTrojan(TrojanOption{Server: "127.0.0.1", Port: port, Password: "password", TLSOption: test.option})
+			require.NoError(t, err)
+			c, err := tr.DialContext(ctx, m)
+			if test.ok {
+				require.NoError(t, err)
+				c.Close()
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestParseTrojan(t *testing.T) {
+	p, err := ParseProxy(map[string]any{
+		"name":             "trojan",
+		"type":             "trojan",
+		"server":           "example.com",
+		"port":             443,
+		"password":         "password",
+		"alpn":             []any{"h2"},
+		"skip-cert-verify": true,
+	})
+	require.NoError(t, err)
+	tr := p.(*Trojan)
+	assert.Equal(t, "example.com", tr.dialer.tlsConfig.ServerName)
+	assert.Equal(t, []string{"h2"}, tr.dialer.tlsConfig.NextProtos)
+	assert.True(t, tr.dialer.tlsConfig.InsecureSkipVerify)
+
+	_, err = ParseProxy(map[string]any{"name": "trojan", "type": "trojan", "server": "example.com", "port": 443, "password": "password", "fingerprint": "invalid"})
+	assert.Error(t, err)
+}
+
+// testECHKey returns an ECH key of the client facing server publicName and its ECHConfigList
+func testECHKey(t *testing.T, publicName string) (tls.EncryptedClientHelloKey, []byte) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var contents cryptobyte.Builder
+	contents.AddUint8(1)       // config id
+	contents.AddUint16(0x0020) // DHKEM(X25519, HKDF-SHA256)
+	contents.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(key.PublicKey().Bytes()) })
+	contents.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(0x0001) // HKDF-SHA256
+		b.AddUint16(0x0001) // AES-128-GCM
+	})
+	contents.AddUint8(0) // maximum name length
+	contents.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(publicName)) })
+	contents.AddUint16(0) // extensions
+
+	var config cryptobyte.Builder
+	config.AddUint16(0xfe0d)
+	config.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(contents.BytesOrPanic()) })
+	var list cryptobyte.Builder
+	list.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(config.BytesOrPanic()) })
+
+	return tls.EncryptedClientHelloKey{Config: config.BytesOrPanic(), PrivateKey: key.Bytes()}, list.BytesOrPanic()
+}
+
+// startECHServer starts a Trojan server with cert accepting ECH with key and returns its port. Whether
+// every connection accepted ECH is sent to accepted
+func startECHServer(t *testing.T, cert tls.Certificate, key tls.EncryptedClientHelloKey, accepted chan<- bool) int {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{key},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				tlsConn := c.(*tls.Conn)
+				if err := tlsConn.Handshake(); err != nil {
+					c.Close()
+					return
+				}
+				accepted <- tlsConn.ConnectionState().ECHAccepted
+				serveTrojanConn(c, "password")
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startHTTPSRecordServer starts a nameserver answering HTTPS queries with list and sets it as
+// the default resolver
+func startHTTPSRecordServer(t *testing.T, list []byte) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &D.Server{PacketConn: pc, Handler: D.HandlerFunc(func(w D.ResponseWriter, r *D.Msg) {
+		msg := &D.Msg{}
+		msg.SetReply(r)
+		if q := r.Question[0]; q.Qtype == D.TypeHTTPS {
+			msg.Answer = append(msg.Answer, &D.HTTPS{SVCB: D.SVCB{
+				Hdr:      D.RR_Header{Name: q.Name, Rrtype: D.TypeHTTPS, Class: D.ClassINET, Ttl: 60},
+				Priority: 1,
+				Target:   ".",
+				Value:    []D.SVCBKeyValue{&D.SVCBECHConfig{ECH: list}},
+			}})
+		}
+		w.WriteMsg(msg)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	r, err := dns.NewResolver(dns.Config{Main: []dns.NameServer{{Net: "udp", Addr: pc.LocalAddr().String()}}})
+	require.NoError(t, err)
+	dns.SetDefaultResolver(r)
+	t.Cleanup(func() { dns.SetDefaultResolver(nil) })
+}
+
+func TestTrojanECH(t *testing.T) {
+	key, list := testECHKey(t, "public.example.com")
+	accepted := make(chan bool, 1)
+	port := startECHServer(t, testCertificate(t), key, accepted)
+	echoPort := testutil.StartEchoServers(t)
+	startHTTPSRecordServer(t, list)
+
+	m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, test := range []struct {
+		name   string
+		option ECHOption
+	}{
+		{"config", ECHOption{Enable: true, Config: base64.StdEncoding.EncodeToString(list)}},
+		{"https record", ECHOption{Enable: true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tr, err := NewTrojan(TrojanOption{
+				Server:    "127.0.0.1",
+				Port:      port,
+				Password:  "password",
+				TLSOption: TLSOption{SNI: "example.com", SkipCertVerify: true, ECHOpts: test.option},
+			})
+			require.NoError(t, err)
+			c, err := tr.DialContext(ctx, m)
+			require.NoError(t, err)
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			_, err = c.Write([]byte("ping"))
+			require.NoError(t, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(c, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(buf))
+			assert.True(t, <-accepted)
+		})
+	}
+
+	_, err := NewTrojan(TrojanOption{
+		Server:          "127.0.0.1",
+		Port:            port,
+		Password:        "password",
+		TLSOption:       TLSOption{ECHOpts: ECHOption{Enable: true}},
+		TransportOption: TransportOption{Network: "quic"},
+	})
+	assert.ErrorContains(t, err, "ech config")
+}
+
+func TestStreamDialerECHRetry(t *testing.T) {
+	key, _ := testECHKey(t, "public.example.com")
+	key.SendAsRetry = true
+	_, stale := testECHKey(t, "public.example.com")
+	cert := testCertificate(t)
+	accepted := make(chan bool, 2)
+	port := startECHServer(t, cert, key, accepted)
+
+	// the retry configs are only trusted if the certificate of the public name is verified
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	d, err := newStreamDialer("127.0.0.1", port, &dialer.Dialer{}, TransportOption{}, &tls.Config{
+		ServerName:                     "example.com",
+		RootCAs:                        roots,
+		MinVersion:                     tls.VersionTLS13,
+		EncryptedClientHelloConfigList: stale,
+	}, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// the first dial is rejected and retried with the keys of the server, the next one uses them
+	for i := 0; i < 2; i++ {
+		c, err := d.DialContext(ctx)
+		require.NoError(t, err)
+		assert.True(t, c.(*tls.Conn).ConnectionState().ECHAccepted)
+		if i == 0 {
+			assert.False(t, <-accepted)
+		}
+		assert.True(t, <-accepted)
+		c.Close()
+	}
+}
+
+// startHelloServer starts a Trojan server sending the extensions of the ClientHello of each
+// handshake to hellos
+func startHelloServer(t *testing.T, hellos chan<- []uint16) int {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t)},
+		NextProtos:   []string{"h2", "http/1.1"},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hellos <- hello.Extensions
+			return nil, nil
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveTrojanConn(c, "password")
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestTrojanClientFingerprint(t *testing.T) {
+	hellos := make(chan []uint16, 1)
+	port := startHelloServer(t, hellos)
+	echoPort := testutil.StartEchoServers(t)
+
+	dial := func(fingerprint string) []uint16 {
+		tr, err := NewTrojan(TrojanOption{
+			Server:    "127.0.0.1",
+			Port:      port,
+			Password:  "password",
+			TLSOption: TLSOption{SkipCertVerify: true, ClientFingerprint: fingerprint},
+		})
+		require.NoError(t, err)
+		testTCPEcho(t, tr, echoPort)
+		return <-hellos
+	}
+	// crypto/tls sends neither GREASE nor compressed certificates
+	isGREASE := func(extension uint16) bool { return extension&0x0f0f == 0x0a0a }
+	plain := dial("")
+	assert.False(t, slices.ContainsFunc(plain, isGREASE))
+	assert.NotContains(t, plain, uint16(27))
+
+	chrome := dial("chrome")
+	assert.True(t, slices.ContainsFunc(chrome, isGREASE))
+	assert.Contains(t, chrome, uint16(27))
+	for _, fingerprint := range []string{"firefox", "safari", "ios", "android", "edge"} {
+		assert.NotEqual(t, plain, dial(fingerprint), fingerprint)
+	}
+
+	_, err := NewTrojan(TrojanOption{
+		Server:          "127.0.0.1",
+		Port:            port,
+		Password:        "password",
+		TLSOption:       TLSOption{ClientFingerprint: "chrome"},
+		TransportOption: TransportOption{Network: "quic"},
+	})
+	assert.ErrorContains(t, err, "client-fingerprint")
+}
+
+func TestTrojanDialerOption(t *testing.T) {
+	port := startTrojanServer(t, testCertificate(t), "password", nil)
+	echoPort := testutil.StartEchoServers(t)
+	m := &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: uint16(echoPort)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := ParseProxy(map[string]any{
+		"name":             "trojan",
+		"type":             "trojan",
+		"server":           "127.0.0.1",
+		"port":             port,
+		"password":         "password",
+		"skip-cert-verify": true,
+		"ip-version":       "ipv6",
+	})
+	require.NoError(t, err)
+	_, err = p.DialContext(ctx, m)
+	assert.ErrorIs(t, err, dialer.ErrNoAddress)
+
+	tr, err := NewTrojan(TrojanOption{
+		Server:       "127.0.0.1",
+		Port:         port,
+		Password:     "password",
+		TLSOption:    TLSOption{SkipCertVerify: true},
+		DialerOption: DialerOption{IPVersion: "ipv4", TCPKeepAlive: 30},
+	})
+	require.NoError(t, err)
+	c, err := tr.DialContext(ctx, m)
+	require.NoError(t, err)
+	c.Close()
+
+	_, err = NewTrojan(TrojanOption{Server: "127.0.0.1", Port: port, Password: "password", DialerOption: DialerOption{IPVersion: "ipv5"}})
+	assert.Error(t, err)
+}
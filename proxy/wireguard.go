@@ -0,0 +1,328 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/log"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"golang.org/x/sync/singleflight"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+)
+
+const (
+	// defaultWireGuardMTU leaves room for the WireGuard and outer IPv6 headers on a 1500 bytes link
+	defaultWireGuardMTU = 1408
+	// wireGuardStartTimeout bounds bringing up the device shared by all dials
+	wireGuardStartTimeout = 10 * time.Second
+)
+
+// WireGuardOption is the configuration of a WireGuard outbound
+type WireGuardOption struct {
+	Name string `yaml:"name"`
+	// IP and IPv6 are the addresses of the local interface inside the tunnel
+	IP         string `yaml:"ip"`
+	IPv6       string `yaml:"ipv6"`
+	PrivateKey string `yaml:"private-key"`
+	// WireGuardPeerOption describes the only peer when Peers is empty
+	WireGuardPeerOption `yaml:",inline"`
+	Peers               []WireGuardPeerOption `yaml:"peers"`
+	MTU                 int                   `yaml:"mtu"`
+	UDP                 bool                  `yaml:"udp"`
+	// PersistentKeepalive is the interval in seconds of the keepalives sent to peers, zero to disable
+	PersistentKeepalive int `yaml:"persistent-keepalive"`
+	DialerOption        `yaml:",inline"`
+}
+
+// WireGuardPeerOption is the configuration of a WireGuard peer
+type WireGuardPeerOption struct {
+	Server       string `yaml:"server"`
+	Port         int    `yaml:"port"`
+	PublicKey    string `yaml:"public-key"`
+	PreSharedKey string `yaml:"pre-shared-key"`
+	// Reserved is written to the reserved bytes of every message sent to the peer
+	Reserved []uint8 `yaml:"reserved"`
+	// AllowedIPs are the destinations routed to the peer, all of them if empty. It is required
+	// when there are several peers
+	AllowedIPs []string `yaml:"allowed-ips"`
+}
+
+// WireGuard connects to destinations through a userspace WireGuard device. The device and its
+// network stack are started by the first connection
+type WireGuard struct {
+	*Base
+	option     WireGuardOption
+	peers      []WireGuardPeerOption
+	localAddrs []netip.Addr
+	netDialer  *dialer.Dialer
+	bind       *wgBind
+
+	mu     sync.Mutex
+	device *device.Device
+	tnet   *netstack.Net
+	group  singleflight.Group
+}
+
+// NewWireGuard returns a new WireGuard proxy
+func NewWireGuard(option WireGuardOption) (*WireGuard, error) {
+	if _, err := decodeWireGuardKey(option.PrivateKey); err != nil {
+		return nil, fmt.Errorf("invalid private-key: %w", err)
+	}
+
+	peers := option.Peers
+	if len(peers) == 0 {
+		peers = []WireGuardPeerOption{option.WireGuardPeerOption}
+	}
+	for i, peer := range peers {
+		if peer.Server == "" || peer.Port <= 0 || peer.Port > 65535 {
+			return nil, fmt.Errorf("invalid server of peer %d", i)
+		}
+		if _, err := decodeWireGuardKey(peer.PublicKey); err != nil {
+			return nil, fmt.Errorf("invalid public-key of peer %d: %w", i, err)
+		}
+		if peer.PreSharedKey != "" {
+			if _, err := decodeWireGuardKey(peer.PreSharedKey); err != nil {
+				return nil, fmt.Errorf("invalid pre-shared-key of peer %d: %w", i, err)
+			}
+		}
+		if len(peer.Reserved) != 0 && len(peer.Reserved) != 3 {
+			return nil, fmt.Errorf("invalid reserved of peer %d: must be 3 bytes", i)
+		}
+		if len(peers) > 1 && len(peer.AllowedIPs) == 0 {
+			// each peer would claim every destination, leaving all traffic to the last one
+			return nil, fmt.Errorf("allowed-ips of peer %d is required with several peers", i)
+		}
+		for _, allowedIP := range peer.AllowedIPs {
+			if _, err := netip.ParsePrefix(allowedIP); err != nil {
+				return nil, fmt.Errorf("invalid allowed-ips of peer %d: %w", i, err)
+			}
+		}
+	}
+
+	var localAddrs []netip.Addr
+	for _, s := range []string{option.IP, option.IPv6} {
+		if s == "" {
+			continue
+		}
+		// the addresses may be written with their prefix length
+		s, _, _ = strings.Cut(s, "/")
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %w", s, err)
+		}
+		localAddrs = append(localAddrs, addr)
+	}
+	if len(localAddrs) == 0 {
+		return nil, errors.New("missing ip or ipv6")
+	}
+	if option.MTU == 0 {
+		option.MTU = defaultWireGuardMTU
+	}
+	netDialer, err := dialer.New(option.DialerOption)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(peers[0].Server, strconv.Itoa(peers[0].Port))
+	return &WireGuard{
+		Base:       NewBase(option.Name, addr, proto.Protocol_WIREGUARD, option.UDP),
+		option:     option,
+		peers:      peers,
+		localAddrs: localAddrs,
+		netDialer:  netDialer,
+		bind:       newWGBind(netDialer),
+	}, nil
+}
+
+func (w *WireGuard) DialContext(ctx context.Context, m *metadata.Metadata) (net.Conn, error) {
+	tnet, err := w.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := resolveMetadata(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	return tnet.DialContextTCPAddrPort(ctx, netip.AddrPortFrom(ip, m.DstPort))
+}
+
+func (w *WireGuard) ListenPacketContext(ctx context.Context, m *metadata.Metadata) (net.PacketConn, error) {
+	tnet, err := w.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := resolveMetadata(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	var local netip.Addr
+	for _, addr := range w.localAddrs {
+		if addr.Is4() == ip.Is4() {
+			local = addr
+			break
+		}
+	}
+	if !local.IsValid() {
+		return nil, fmt.Errorf("no local address to reach %s", ip)
+	}
+	pc, err := tnet.ListenUDPAddrPort(netip.AddrPortFrom(local, 0))
+	if err != nil {
+		return nil, err
+	}
+	return &wgPacketConn{UDPConn: pc}, nil
+}
+
+// Close shuts the WireGuard device down
+func (w *WireGuard) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.device != nil {
+		w.device.Close()
+		w.device, w.tnet = nil, nil
+	}
+	return nil
+}
+
+// start brings the WireGuard device up if it is not running yet and returns its network stack.
+// Concurrent callers share a single startup which does not hold w.mu and is not canceled with ctx
+func (w *WireGuard) start(ctx context.Context) (*netstack.Net, error) {
+	w.mu.Lock()
+	tnet := w.tnet
+	w.mu.Unlock()
+	if tnet != nil {
+		return tnet, nil
+	}
+
+	ch := w.group.DoChan("", func() (any, error) {
+		return w.up(context.WithoutCancel(ctx))
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(*netstack.Net), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// up creates the WireGuard device and its network stack and makes them the current ones
+func (w *WireGuard) up(ctx context.Context) (*netstack.Net, error) {
+	w.mu.Lock()
+	tnet := w.tnet
+	w.mu.Unlock()
+	if tnet != nil {
+		// a startup finished since the caller checked
+		return tnet, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wireGuardStartTimeout)
+	defer cancel()
+	config, err := w.ipcConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tunDevice, tnet, err := netstack.CreateNetTUN(w.localAddrs, nil, w.option.MTU)
+	if err != nil {
+		return nil, err
+	}
+	logger := &device.Logger{
+		Verbosef: func(format string, args ...any) {
+			log.Debugf("[WireGuard] %s: "+format, append([]any{w.Name()}, args...)...)
+		},
+		Errorf: func(format string, args ...any) {
+			log.Warnf("[WireGuard] %s: "+format, append([]any{w.Name()}, args...)...)
+		},
+	}
+	dev := device.NewDevice(tunDevice, w.bind, logger)
+	if err := dev.IpcSet(config); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("configure wireguard device: %w", err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	w.mu.Lock()
+	w.device, w.tnet = dev, tnet
+	w.mu.Unlock()
+	return tnet, nil
+}
+
+// ipcConfig returns the configuration of the device in the WireGuard UAPI format, resolving the
+// servers of the peers
+func (w *WireGuard) ipcConfig(ctx context.Context) (string, error) {
+	var b strings.Builder
+	privateKey, _ := decodeWireGuardKey(w.option.PrivateKey)
+	fmt.Fprintf(&b, "private_key=%s\n", privateKey)
+
+	for _, peer := range w.peers {
+		endpoint, err := resolveServer(ctx, w.netDialer, peer.Server, peer.Port)
+		if err != nil {
+			return "", err
+		}
+		if len(peer.Reserved) == 3 {
+			w.bind.setReserved(endpoint, [3]byte(peer.Reserved))
+		}
+
+		publicKey, _ := decodeWireGuardKey(peer.PublicKey)
+		fmt.Fprintf(&b, "public_key=%s\n", publicKey)
+		if peer.PreSharedKey != "" {
+			preSharedKey, _ := decodeWireGuardKey(peer.PreSharedKey)
+			fmt.Fprintf(&b, "preshared_key=%s\n", preSharedKey)
+		}
+		fmt.Fprintf(&b, "endpoint=%s\n", endpoint)
+		if w.option.PersistentKeepalive > 0 {
+			fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", w.option.PersistentKeepalive)
+		}
+		allowedIPs := peer.AllowedIPs
+		if len(allowedIPs) == 0 {
+			allowedIPs = []string{"0.0.0.0/0", "::/0"}
+		}
+		for _, allowedIP := range allowedIPs {
+			fmt.Fprintf(&b, "allowed_ip=%s\n", allowedIP)
+		}
+	}
+	return b.String(), nil
+}
+
+// decodeWireGuardKey converts a base64 encoded key to the hex encoding used by the UAPI
+func decodeWireGuardKey(key string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	if len(b) != 32 {
+		return "", errors.New("key must be 32 bytes")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// wgPacketConn is a UDP socket of the WireGuard network stack
+type wgPacketConn struct {
+	*gonet.UDPConn
+}
+
+// WriteTo unmaps IPv4 destinations, which the network stack would otherwise treat as IPv6
+func (pc *wgPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		if ip4 := udpAddr.IP.To4(); ip4 != nil {
+			addr = &net.UDPAddr{IP: ip4, Port: udpAddr.Port}
+		}
+	}
+	return pc.UDPConn.WriteTo(b, addr)
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// wgBind is the UDP socket WireGuard messages are exchanged on. It is opened with the shared
+// dialer so that it bypasses the TUN inbound, and handles the reserved bytes of the messages
+type wgBind struct {
+	netDialer *dialer.Dialer
+
+	mu       sync.Mutex
+	conn     *net.UDPConn
+	reserved map[netip.AddrPort][3]byte
+}
+
+func newWGBind(netDialer *dialer.Dialer) *wgBind {
+	return &wgBind{netDialer: netDialer, reserved: make(map[netip.AddrPort][3]byte)}
+}
+
+// setReserved sets the reserved bytes of the messages sent to endpoint
+func (b *wgBind) setReserved(endpoint netip.AddrPort, reserved [3]byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserved[endpoint] = reserved
+}
+
+func (b *wgBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+	pc, err := b.netDialer.ListenPacket(context.Background(), "udp", ":"+strconv.Itoa(int(port)))
+	if err != nil {
+		return nil, 0, err
+	}
+	udpConn := pc.(*net.UDPConn)
+	b.conn = udpConn
+	return []conn.ReceiveFunc{b.receive(udpConn)}, uint16(udpConn.LocalAddr().(*net.UDPAddr).Port), nil
+}
+
+func (b *wgBind) receive(udpConn *net.UDPConn) conn.ReceiveFunc {
+	return func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		n, addr, err := udpConn.ReadFromUDPAddrPort(packets[0])
+		if err != nil {
+			return 0, err
+		}
+		// the device only accepts messages whose reserved bytes are zero
+		if n >= 4 {
+			clear(packets[0][1:4])
+		}
+		sizes[0] = n
+		eps[0] = wgEndpoint(netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()))
+		return 1, nil
+	}
+}
+
+func (b *wgBind) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+func (b *wgBind) SetMark(mark uint32) error {
+	return nil
+}
+
+func (b *wgBind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	endpoint, ok := ep.(wgEndpoint)
+	if !ok {
+		return conn.ErrWrongEndpointType
+	}
+	b.mu.Lock()
+	udpConn := b.conn
+	reserved, hasReserved := b.reserved[netip.AddrPort(endpoint)]
+	b.mu.Unlock()
+	if udpConn == nil {
+		return net.ErrClosed
+	}
+
+	for _, buf := range bufs {
+		if hasReserved && len(buf) >= 4 {
+			copy(buf[1:4], reserved[:])
+		}
+		if _, err := udpConn.WriteToUDPAddrPort(buf, netip.AddrPort(endpoint)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *wgBind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return nil, err
+	}
+	return wgEndpoint(netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())), nil
+}
+
+func (b *wgBind) BatchSize() int {
+	return 1
+}
+
+// wgEndpoint is the address of a WireGuard peer
+type wgEndpoint netip.AddrPort
+
+func (e wgEndpoint) ClearSrc() {}
+
+func (e wgEndpoint) SrcToString() string {
+	return ""
+}
+
+func (e wgEndpoint) DstToString() string {
+	return netip.AddrPort(e).String()
+}
+
+func (e wgEndpoint) DstToBytes() []byte {
+	b, _ := netip.AddrPort(e).MarshalBinary()
+	return b
+}
+
+func (e wgEndpoint) DstIP() netip.Addr {
+	return netip.AddrPort(e).Addr()
+}
+
+func (e wgEndpoint) SrcIP() netip.Addr {
+	return netip.Addr{}
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/internal/testutil"
+	"github.com/lumavpn/luma/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// wgKeyPair returns a new base64 encoded WireGuard key pair
+func wgKeyPair(t *testing.T) (privateKey, publicKey string) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+func wgHex(t *testing.T, key string) string {
+	b, err := base64.StdEncoding.DecodeString(key)
+	require.NoError(t, err)
+	return hex.EncodeToString(b)
+}
+
+// startWGPeer starts a WireGuard peer with the address addr inside the tunnel, which accepts the
+// client with clientAddr and echoes TCP port 80 and UDP port 53. It returns the UDP port of the peer
+func startWGPeer(t *testing.T, bind conn.Bind, addr, clientAddr netip.Addr, privateKey, clientPublicKey, preSharedKey string) int {
+	tunDevice, tnet, err := netstack.CreateNetTUN([]netip.Addr{addr}, nil, defaultWireGuardMTU)
+	require.NoError(t, err)
+	dev := device.NewDevice(tunDevice, bind, device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+
+	config := fmt.Sprintf("private_key=%s\nlisten_port=0\npublic_key=%s\nallowed_ip=%s/32\n",
+		wgHex(t, privateKey), wgHex(t, clientPublicKey), clientAddr)
+	if preSharedKey != "" {
+		config += fmt.Sprintf("preshared_key=%s\n", wgHex(t, preSharedKey))
+	}
+	require.NoError(t, dev.IpcSet(config))
+	require.NoError(t, dev.Up())
+
+	l, err := tnet.ListenTCPAddrPort(netip.AddrPortFrom(addr, 80))
+	require.NoError(t, err)
+	go testutil.ServeEcho(l)
+	pc, err := tnet.ListenUDPAddrPort(netip.AddrPortFrom(addr, 53))
+	require.NoError(t, err)
+	go testutil.ServeEchoPackets(pc)
+
+	state, err := dev.IpcGet()
+	require.NoError(t, err)
+	scanner := bufio.NewScanner(strings.NewReader(state))
+	for scanner.Scan() {
+		if port, ok := strings.CutPrefix(scanner.Text(), "listen_port="); ok {
+			p, err := strconv.Atoi(port)
+			require.NoError(t, err)
+			return p
+		}
+	}
+	t.Fatal("listen port not found")
+	return 0
+}
+
+func TestWireGuard(t *testing.T) {
+	clientPrivateKey, clientPublicKey := wgKeyPair(t)
+	peer1PrivateKey, peer1PublicKey := wgKeyPair(t)
+	peer2PrivateKey, peer2PublicKey := wgKeyPair(t)
+	preSharedKey, _ := wgKeyPair(t)
+	clientAddr := netip.MustParseAddr("10.0.0.1")
+
+	// the second peer strips the reserved bytes sent by the client like a WARP endpoint
+	port1 := startWGPeer(t, conn.NewDefaultBind(), netip.MustParseAddr("10.0.0.2"), clientAddr, peer1PrivateKey, clientPublicKey, preSharedKey)
+	port2 := startWGPeer(t, newWGBind(&dialer.Dialer{}), netip.MustParseAddr("10.0.0.3"), clientAddr, peer2PrivateKey, clientPublicKey, "")
+
+	wg, err := NewWireGuard(WireGuardOption{
+		Name:       "wg",
+		IP:         "10.0.0.1/32",
+		PrivateKey: clientPrivateKey,
+		Peers: []WireGuardPeerOption{
+			{Server: "127.0.0.1", Port: port1, PublicKey: peer1PublicKey, PreSharedKey: preSharedKey, AllowedIPs: []string{"10.0.0.2/32"}},
+			{Server: "127.0.0.1", Port: port2, PublicKey: peer2PublicKey, Reserved: []uint8{1, 2, 3}, AllowedIPs: []string{"10.0.0.3/32"}},
+		},
+		UDP:                 true,
+		PersistentKeepalive: 25,
+	})
+	require.NoError(t, err)
+	defer wg.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, dst := range []string{"10.0.0.2", "10.0.0.3"} {
+		c, err := wg.DialContext(ctx, &metadata.Metadata{Network: metadata.TCP, DstIP: net.ParseIP(dst), DstPort: 80})
+		require.NoError(t, err, dst)
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+		c.Close()
+	}
+
+	m := &metadata.Metadata{Network: metadata.UDP, DstIP: net.ParseIP("10.0.0.2"), DstPort: 53}
+	pc, err := wg.ListenPacketContext(ctx, m)
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = pc.WriteTo([]byte("query"), m.UDPAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+	assert.Equal(t, "10.0.0.2:53", from.String())
+}
+
+func TestWireGuardConcurrentStart(t *testing.T) {
+	privateKey, publicKey := wgKeyPair(t)
+	wg, err := NewWireGuard(WireGuardOption{
+		Name:                "wg",
+		IP:                  "10.0.0.1",
+		PrivateKey:          privateKey,
+		WireGuardPeerOption: WireGuardPeerOption{Server: "127.0.0.1", Port: 51820, PublicKey: publicKey},
+	})
+	require.NoError(t, err)
+	defer wg.Close()
+
+	// a caller giving up does not cancel the startup shared with the others
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	wg.start(canceled)
+
+	results := make([]*netstack.Net, 8)
+	var wait sync.WaitGroup
+	for i := range results {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			tnet, err := wg.start(context.Background())
+			assert.NoError(t, err)
+			results[i] = tnet
+		}()
+	}
+	wait.Wait()
+	require.NotNil(t, results[0])
+	for _, tnet := range results {
+		assert.Same(t, results[0], tnet)
+	}
+}
+
+func TestWireGuardPeersAllowedIPs(t *testing.T) {
+	privateKey, publicKey := wgKeyPair(t)
+	_, err := NewWireGuard(WireGuardOption{
+		Name:       "wg",
+		IP:         "10.0.0.1",
+		PrivateKey: privateKey,
+		Peers: []WireGuardPeerOption{
+			{Server: "127.0.0.1", Port: 51820, PublicKey: publicKey, AllowedIPs: []string{"10.0.0.2/32"}},
+			{Server: "127.0.0.1", Port: 51821, PublicKey: publicKey},
+		},
+	})
+	assert.EqualError(t, err, "allowed-ips of peer 1 is required with several peers")
+
+	// a single peer routes every destination by default
+	_, err = NewWireGuard(WireGuardOption{
+		Name:                "wg",
+		IP:                  "10.0.0.1",
+		PrivateKey:          privateKey,
+		WireGuardPeerOption: WireGuardPeerOption{Server: "127.0.0.1", Port: 51820, PublicKey: publicKey},
+	})
+	assert.NoError(t, err)
+}
+
+func TestWGBindReserved(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer peer.Close()
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+	peerAddr := peer.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	bind := newWGBind(&dialer.Dialer{})
+	bind.setReserved(peerAddr, [3]byte{1, 2, 3})
+	fns, port, err := bind.Open(0)
+	require.NoError(t, err)
+	defer bind.Close()
+
+	ep, err := bind.ParseEndpoint(peerAddr.String())
+	require.NoError(t, err)
+	require.NoError(t, bind.Send([][]byte{{4, 0, 0, 0, 9}}, ep))
+	buf := make([]byte, 16)
+	n, _, err := peer.ReadFromUDP(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{4, 1, 2, 3, 9}, buf[:n])
+
+	_, err = peer.WriteToUDP([]byte{4, 1, 2, 3, 9}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)})
+	require.NoError(t, err)
+	packets := [][]byte{make([]byte, 16)}
+	sizes := make([]int, 1)
+	eps := make([]conn.Endpoint, 1)
+	_, err = fns[0](packets, sizes, eps)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{4, 0, 0, 0, 9}, packets[0][:sizes[0]])
+	assert.Equal(t, peerAddr.String(), eps[0].DstToString())
+}
+
+func TestParseProxy(t *testing.T) {
+	privateKey, publicKey := wgKeyPair(t)
+	p, err := ParseProxy(map[string]any{
+		"name":        "wg",
+		"type":        "wireguard",
+		"server":      "127.0.0.1",
+		"port":        51820,
+		"ip":          "10.0.0.1",
+		"private-key": privateKey,
+		"public-key":  publicKey,
+		"reserved":    []any{1, 2, 3},
+		"udp":         true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "wg", p.Name())
+	assert.Equal(t, "127.0.0.1:51820", p.Addr())
+	assert.True(t, p.SupportUDP())
+	assert.Equal(t, []uint8{1, 2, 3}, p.(*WireGuard).peers[0].Reserved)
+
+	_, err = ParseProxy(map[string]any{"name": "unknown", "type": "unknown"})
+	assert.Error(t, err)
+	_, err = ParseProxy(map[string]any{"name": "wg", "type": "wireguard", "private-key": "invalid"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,165 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+)
+
+// DefaultTimeout is how long the dispatcher waits for the first bytes of a connection
+const DefaultTimeout = 100 * time.Millisecond
+
+// maxPeekSize is the largest number of bytes peeked from a connection, enough for a TLS record
+const maxPeekSize = 5 + 16384
+
+// Dispatcher runs the configured sniffers on new connections to recover their domain
+type Dispatcher struct {
+	sniffers []Sniffer
+	timeout  time.Duration
+}
+
+// NewDispatcher returns a new Dispatcher. A timeout of zero uses DefaultTimeout
+func NewDispatcher(sniffers []Sniffer, timeout time.Duration) *Dispatcher {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Dispatcher{
+		sniffers: sniffers,
+		timeout:  timeout,
+	}
+}
+
+// TCPSniff peeks at the first bytes of conn and sets metadata.Host to the domain found in them.
+// A TLS record is peeked until it is complete or the timeout fires. The returned connection
+// replays the peeked bytes and must be used instead of conn
+func (d *Dispatcher) TCPSniff(conn adapter.TCPConn, metadata *M.Metadata) adapter.TCPConn {
+	if !d.shouldSniff(metadata) {
+		return conn
+	}
+
+	buf := make([]byte, 0, maxPeekSize)
+	conn.SetReadDeadline(time.Now().Add(d.timeout))
+	for need := 1; len(buf) < need; {
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				log.Debugf("[Sniffer] peek %s: %v", metadata.RemoteAddress(), err)
+			}
+			break
+		}
+		if d.sniff(buf, metadata) {
+			break
+		}
+		need = tlsRecordSize(buf)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if len(buf) == 0 {
+		return conn
+	}
+	return &bufferedConn{TCPConn: conn, buf: buf}
+}
+
+// UDPSniff reads the first packet of uc and sets metadata.Host to the domain found in it.
+// The returned connection replays the packet and must be used instead of uc
+func (d *Dispatcher) UDPSniff(uc adapter.UDPConn, metadata *M.Metadata) adapter.UDPConn {
+	if !d.shouldSniff(metadata) {
+		return uc
+	}
+
+	buf := make([]byte, 65535)
+	uc.SetReadDeadline(time.Now().Add(d.timeout))
+	n, addr, err := uc.ReadFrom(buf)
+	uc.SetReadDeadline(time.Time{})
+	if err != nil {
+		return uc
+	}
+
+	d.sniff(buf[:n], metadata)
+	return &bufferedPacketConn{UDPConn: uc, packet: buf[:n], addr: addr}
+}
+
+func (d *Dispatcher) shouldSniff(metadata *M.Metadata) bool {
+	if metadata.Host != "" {
+		return false
+	}
+	for _, s := range d.sniffers {
+		if s.SupportNetwork() == metadata.Network && s.SupportPort(metadata.DstPort) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniff sets metadata.Host to the domain found in data and reports whether one was found
+func (d *Dispatcher) sniff(data []byte, metadata *M.Metadata) bool {
+	for _, s := range d.sniffers {
+		if s.SupportNetwork() != metadata.Network || !s.SupportPort(metadata.DstPort) {
+			continue
+		}
+		host, err := s.SniffData(data)
+		if err != nil {
+			continue
+		}
+		log.Debugf("[Sniffer] sniffed %s domain %s for %s", s.Protocol(), host, metadata.RemoteAddress())
+		metadata.Host = host
+		return true
+	}
+	return false
+}
+
+// tlsRecordSize returns the size of the TLS handshake record starting data, capped at
+// maxPeekSize, or 0 if data does not start one
+func tlsRecordSize(data []byte) int {
+	if len(data) == 0 || data[0] != recordTypeHandshake {
+		return 0
+	}
+	if len(data) < 5 {
+		return 5
+	}
+	return min(5+int(binary.BigEndian.Uint16(data[3:5])), maxPeekSize)
+}
+
+// bufferedConn replays the bytes peeked by the dispatcher before reading from the connection
+type bufferedConn struct {
+	adapter.TCPConn
+	buf []byte
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.TCPConn.Read(b)
+}
+
+// bufferedPacketConn replays the packet read by the dispatcher before reading from the connection
+type bufferedPacketConn struct {
+	adapter.UDPConn
+	packet []byte
+	addr   net.Addr
+}
+
+func (c *bufferedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.packet != nil {
+		n := copy(b, c.packet)
+		addr := c.addr
+		c.packet, c.addr = nil, nil
+		return n, addr, nil
+	}
+	return c.UDPConn.ReadFrom(b)
+}
+
+func (c *bufferedPacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
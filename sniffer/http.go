@@ -0,0 +1,78 @@
+package sniffer
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("HEAD "), []byte("DELETE "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+// httpSniffer finds the Host header of a plain HTTP/1.x request
+type httpSniffer struct {
+	ports PortRanges
+}
+
+func (hs *httpSniffer) Protocol() string {
+	return "http"
+}
+
+func (hs *httpSniffer) SupportNetwork() M.Network {
+	return M.TCP
+}
+
+func (hs *httpSniffer) SupportPort(port uint16) bool {
+	return hs.ports.Contains(port)
+}
+
+func (hs *httpSniffer) SniffData(b []byte) (string, error) {
+	if !isHTTPRequest(b) {
+		return "", errNotHTTP
+	}
+
+	// skip the request line
+	idx := bytes.Index(b, []byte("\r\n"))
+	if idx < 0 {
+		return "", errNotFound
+	}
+	b = b[idx+2:]
+
+	for len(b) > 0 {
+		idx = bytes.Index(b, []byte("\r\n"))
+		if idx <= 0 {
+			// end of headers or header cut off
+			return "", errNotFound
+		}
+		line := b[:idx]
+		b = b[idx+2:]
+
+		key, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok || !strings.EqualFold(string(bytes.TrimSpace(key)), "host") {
+			continue
+		}
+		host := string(bytes.TrimSpace(value))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			return "", errNotFound
+		}
+		return host, nil
+	}
+	return "", errNotFound
+}
+
+func isHTTPRequest(b []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(b, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package sniffer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type portRange struct {
+	start, end uint16
+}
+
+// PortRanges is a list of ports and port ranges. An empty PortRanges matches every port
+type PortRanges []portRange
+
+// ParsePortRanges parses ports of the form 443 or 8000-9000
+func ParsePortRanges(ports []string) (PortRanges, error) {
+	ranges := make(PortRanges, 0, len(ports))
+	for _, port := range ports {
+		start, end, isRange := strings.Cut(strings.TrimSpace(port), "-")
+		if !isRange {
+			end = start
+		}
+		s, err := strconv.ParseUint(strings.TrimSpace(start), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s: %w", port, err)
+		}
+		e, err := strconv.ParseUint(strings.TrimSpace(end), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s: %w", port, err)
+		}
+		if s > e {
+			return nil, fmt.Errorf("invalid port range %s", port)
+		}
+		ranges = append(ranges, portRange{start: uint16(s), end: uint16(e)})
+	}
+	return ranges, nil
+}
+
+// Contains reports whether port is part of one of the ranges
+func (pr PortRanges) Contains(port uint16) bool {
+	if len(pr) == 0 {
+		return true
+	}
+	for _, r := range pr {
+		if port >= r.start && port <= r.end {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package sniffer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+const (
+	quicVersion1 = 0x00000001
+
+	frameTypePadding = 0x00
+	frameTypePing    = 0x01
+	frameTypeCrypto  = 0x06
+)
+
+// quicSaltV1 is the initial salt of QUIC version 1 (RFC 9001 section 5.2)
+var quicSaltV1 = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+// quicSniffer finds the server name of the TLS ClientHello carried in a QUIC v1 Initial packet.
+// Initial packets are protected with keys derived from the destination connection ID only, so
+// they can be decrypted by anyone on the path
+type quicSniffer struct {
+	ports PortRanges
+}
+
+func (qs *quicSniffer) Protocol() string {
+	return "quic"
+}
+
+func (qs *quicSniffer) SupportNetwork() M.Network {
+	return M.UDP
+}
+
+func (qs *quicSniffer) SupportPort(port uint16) bool {
+	return qs.ports.Contains(port)
+}
+
+func (qs *quicSniffer) SniffData(b []byte) (string, error) {
+	payload, err := decryptInitial(b)
+	if err != nil {
+		return "", err
+	}
+	crypto, err := readCryptoFrames(payload)
+	if err != nil {
+		return "", err
+	}
+
+	// handshake header: type(1) | length(3)
+	if len(crypto) < 4 || crypto[0] != handshakeTypeClientHello {
+		return "", errNotQUIC
+	}
+	helloLen := int(crypto[1])<<16 | int(crypto[2])<<8 | int(crypto[3])
+	if len(crypto)-4 < helloLen {
+		return "", errIncompleteQUIC
+	}
+	return sniffClientHello(crypto[4 : 4+helloLen])
+}
+
+// decryptInitial removes the header protection of the Initial packet b and returns its
+// decrypted payload
+func decryptInitial(b []byte) ([]byte, error) {
+	// long header with the fixed bit set and packet type Initial
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 || b[0]&0x30 != 0 {
+		return nil, errNotQUIC
+	}
+	if binary.BigEndian.Uint32(b[1:5]) != quicVersion1 {
+		return nil, errNotQUIC
+	}
+
+	offset := 5
+	dcidLen := int(b[offset])
+	offset++
+	if dcidLen > 20 || len(b) < offset+dcidLen+1 {
+		return nil, errNotQUIC
+	}
+	dcid := b[offset : offset+dcidLen]
+	offset += dcidLen
+
+	scidLen := int(b[offset])
+	offset += 1 + scidLen
+	if scidLen > 20 || len(b) < offset {
+		return nil, errNotQUIC
+	}
+
+	tokenLen, n := readVarint(b[offset:])
+	if n == 0 {
+		return nil, errNotQUIC
+	}
+	offset += n + int(tokenLen)
+	if len(b) < offset {
+		return nil, errNotQUIC
+	}
+
+	length, n := readVarint(b[offset:])
+	if n == 0 {
+		return nil, errNotQUIC
+	}
+	offset += n
+	pnOffset := offset
+	if uint64(len(b)-pnOffset) < length || pnOffset+4+16 > len(b) {
+		return nil, errNotQUIC
+	}
+
+	key, iv, hp, err := initialClientKeys(dcid)
+	if err != nil {
+		return nil, err
+	}
+
+	// remove header protection
+	hpBlock, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	hpBlock.Encrypt(mask, b[pnOffset+4:pnOffset+4+16])
+
+	header := make([]byte, pnOffset+4)
+	copy(header, b[:pnOffset+4])
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLen]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+
+	ciphertext := b[pnOffset+pnLen : pnOffset+int(length)]
+	payload, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, errNotQUIC
+	}
+	return payload, nil
+}
+
+// initialClientKeys derives the client Initial packet protection keys (RFC 9001 section 5.2)
+func initialClientKeys(dcid []byte) (key, iv, hp []byte, err error) {
+	initialSecret, err := hkdf.Extract(sha256.New, dcid, quicSaltV1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientSecret, err := hkdfExpandLabel(initialSecret, "client in", sha256.Size)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if key, err = hkdfExpandLabel(clientSecret, "quic key", 16); err != nil {
+		return nil, nil, nil, err
+	}
+	if iv, err = hkdfExpandLabel(clientSecret, "quic iv", 12); err != nil {
+		return nil, nil, nil, err
+	}
+	if hp, err = hkdfExpandLabel(clientSecret, "quic hp", 16); err != nil {
+		return nil, nil, nil, err
+	}
+	return key, iv, hp, nil
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label of TLS 1.3 with an empty context
+func hkdfExpandLabel(secret []byte, label string, length int) ([]byte, error) {
+	fullLabel := "tls13 " + label
+	info := make([]byte, 0, 2+1+len(fullLabel)+1)
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, 0)
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// readCryptoFrames returns the contiguous CRYPTO stream data starting at offset 0
+func readCryptoFrames(payload []byte) ([]byte, error) {
+	type fragment struct {
+		offset uint64
+		data   []byte
+	}
+	var fragments []fragment
+
+	for len(payload) > 0 {
+		frameType := payload[0]
+		payload = payload[1:]
+
+		switch frameType {
+		case frameTypePadding, frameTypePing:
+		case frameTypeCrypto:
+			offset, n := readVarint(payload)
+			if n == 0 {
+				return nil, errNotQUIC
+			}
+			payload = payload[n:]
+			length, n := readVarint(payload)
+			if n == 0 || uint64(len(payload)-n) < length {
+				return nil, errNotQUIC
+			}
+			payload = payload[n:]
+			fragments = append(fragments, fragment{offset: offset, data: payload[:length]})
+			payload = payload[length:]
+		default:
+			// clients only send PADDING, PING and CRYPTO frames before the ClientHello is complete
+			return nil, errNotQUIC
+		}
+	}
+
+	sort.Slice(fragments, func(i, j int) bool { return fragments[i].offset < fragments[j].offset })
+	var crypto []byte
+	for _, f := range fragments {
+		if f.offset > uint64(len(crypto)) {
+			break
+		}
+		if end := f.offset + uint64(len(f.data)); end > uint64(len(crypto)) {
+			crypto = append(crypto, f.data[uint64(len(crypto))-f.offset:]...)
+		}
+	}
+	if len(crypto) == 0 {
+		return nil, errNotQUIC
+	}
+	return crypto, nil
+}
+
+// readVarint reads a QUIC variable-length integer and returns it with the number of bytes read,
+// which is zero if b is too short
+func readVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n
+}
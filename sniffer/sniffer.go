@@ -0,0 +1,43 @@
+package sniffer
+
+import (
+	"errors"
+	"strings"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+var (
+	errNotFound        = errors.New("domain not found")
+	errNotTLS          = errors.New("not a tls client hello")
+	errNotHTTP         = errors.New("not an http request")
+	errNotQUIC         = errors.New("not a quic initial packet")
+	errIncompleteQUIC  = errors.New("quic client hello spans multiple packets")
+	errUnknownProtocol = errors.New("unknown sniffer protocol")
+)
+
+// Sniffer extracts the domain of a connection from the first bytes a client sends
+type Sniffer interface {
+	// Protocol returns the name of the protocol the sniffer understands
+	Protocol() string
+	// SupportNetwork returns the network the protocol runs on
+	SupportNetwork() M.Network
+	// SupportPort reports whether connections to port should be sniffed
+	SupportPort(port uint16) bool
+	// SniffData returns the domain found in b
+	SniffData(b []byte) (string, error)
+}
+
+// New returns the Sniffer for protocol, sniffing connections to the given ports
+func New(protocol string, ports PortRanges) (Sniffer, error) {
+	switch strings.ToLower(protocol) {
+	case "tls":
+		return &tlsSniffer{ports: ports}, nil
+	case "http":
+		return &httpSniffer{ports: ports}, nil
+	case "quic":
+		return &quicSniffer{ports: ports}, nil
+	default:
+		return nil, errUnknownProtocol
+	}
+}
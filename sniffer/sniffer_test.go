@@ -0,0 +1,182 @@
+package sniffer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	M "github.com/lumavpn/luma/metadata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientHello returns the first TLS record a crypto/tls client sends for serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+		client.Close()
+	}()
+
+	buf := make([]byte, 4096)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	return buf[:n]
+}
+
+func TestTLSSniffer(t *testing.T) {
+	s := &tlsSniffer{}
+	host, err := s.SniffData(clientHello(t, "www.example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", host)
+
+	_, err = s.SniffData([]byte("GET / HTTP/1.1\r\n"))
+	assert.Error(t, err)
+}
+
+func TestTCPSniffSplitRecord(t *testing.T) {
+	record := clientHello(t, "split.example.com")
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		// the record header and the ClientHello arrive in separate reads
+		client.Write(record[:5])
+		time.Sleep(20 * time.Millisecond)
+		client.Write(record[5:])
+		client.Write([]byte("after"))
+		client.Close()
+	}()
+
+	metadata := &M.Metadata{Network: M.TCP, DstPort: 443}
+	d := NewDispatcher([]Sniffer{&tlsSniffer{}}, time.Second)
+	conn := d.TCPSniff(adapter.NewTCPConn(server, metadata), metadata)
+	assert.Equal(t, "split.example.com", metadata.Host)
+
+	// the peeked bytes are replayed before the rest of the stream
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, append(record, "after"...), data)
+}
+
+func TestTCPSniffTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		client.Write([]byte{recordTypeHandshake, 0x03, 0x01})
+		time.Sleep(50 * time.Millisecond)
+		client.Write([]byte("rest"))
+		client.Close()
+	}()
+
+	metadata := &M.Metadata{Network: M.TCP, DstPort: 443}
+	d := NewDispatcher([]Sniffer{&tlsSniffer{}}, 10*time.Millisecond)
+	conn := d.TCPSniff(adapter.NewTCPConn(server, metadata), metadata)
+	assert.Empty(t, metadata.Host)
+
+	// the read deadline of the incomplete peek is not returned to the outbound
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{recordTypeHandshake, 0x03, 0x01, 'r', 'e', 's', 't'}, data)
+}
+
+func TestHTTPSniffer(t *testing.T) {
+	s := &httpSniffer{}
+	host, err := s.SniffData([]byte("GET /index.html HTTP/1.1\r\nUser-Agent: curl\r\nhost: example.com:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", host)
+
+	_, err = s.SniffData([]byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\n\r\n"))
+	assert.Error(t, err)
+	_, err = s.SniffData(clientHello(t, "example.com"))
+	assert.Error(t, err)
+}
+
+func TestQUICInitialKeys(t *testing.T) {
+	// RFC 9001 appendix A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	key, iv, hp, err := initialClientKeys(dcid)
+	require.NoError(t, err)
+	assert.Equal(t, "1f369613dd76d5467730efcbe3b1a22d", hex.EncodeToString(key))
+	assert.Equal(t, "fa044b2f42a3fd3b46fb255c", hex.EncodeToString(iv))
+	assert.Equal(t, "9f50449e04a0e810283a1e9933adedd2", hex.EncodeToString(hp))
+}
+
+func TestQUICSniffer(t *testing.T) {
+	record := clientHello(t, "quic.example.com")
+	hello := record[5:]
+
+	// CRYPTO frame carrying the ClientHello, split in two out of order fragments
+	half := len(hello) / 2
+	var payload []byte
+	payload = appendCryptoFrame(payload, uint64(half), hello[half:])
+	payload = appendCryptoFrame(payload, 0, hello[:half])
+	if len(payload) < 1200 {
+		payload = append(payload, make([]byte, 1200-len(payload))...)
+	}
+
+	s := &quicSniffer{}
+	host, err := s.SniffData(sealInitial(t, payload))
+	require.NoError(t, err)
+	assert.Equal(t, "quic.example.com", host)
+
+	_, err = s.SniffData(record)
+	assert.Error(t, err)
+}
+
+func TestPortRanges(t *testing.T) {
+	ports, err := ParsePortRanges([]string{"443", "8000-8080"})
+	require.NoError(t, err)
+	assert.True(t, ports.Contains(443))
+	assert.True(t, ports.Contains(8010))
+	assert.False(t, ports.Contains(80))
+	assert.True(t, PortRanges(nil).Contains(80))
+
+	_, err = ParsePortRanges([]string{"9000-8000"})
+	assert.Error(t, err)
+}
+
+func appendCryptoFrame(b []byte, offset uint64, data []byte) []byte {
+	b = append(b, frameTypeCrypto)
+	b = binary.BigEndian.AppendUint32(b, uint32(offset)|0x80000000)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data))|0x80000000)
+	return append(b, data...)
+}
+
+// sealInitial protects payload as a QUIC v1 client Initial packet with a 2 byte packet number
+func sealInitial(t *testing.T, payload []byte) []byte {
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	key, iv, hp, err := initialClientKeys(dcid)
+	require.NoError(t, err)
+
+	const pn = 2
+	header := []byte{0xc1, 0, 0, 0, 1, byte(len(dcid))}
+	header = append(header, dcid...)
+	header = append(header, 0, 0) // empty source connection id and token
+	length := 2 + len(payload) + 16
+	header = binary.BigEndian.AppendUint16(header, uint16(length)|0x4000)
+	pnOffset := len(header)
+	header = binary.BigEndian.AppendUint16(header, pn)
+
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := append([]byte(nil), iv...)
+	nonce[len(nonce)-1] ^= pn
+	packet := aead.Seal(append([]byte(nil), header...), nonce, payload, header)
+
+	hpBlock, _ := aes.NewCipher(hp)
+	mask := make([]byte, aes.BlockSize)
+	hpBlock.Encrypt(mask, packet[pnOffset+4:pnOffset+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	packet[pnOffset] ^= mask[1]
+	packet[pnOffset+1] ^= mask[2]
+	return packet
+}
@@ -0,0 +1,141 @@
+package sniffer
+
+import (
+	"encoding/binary"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+	extensionServerName      = 0x0000
+	serverNameTypeHostName   = 0x00
+)
+
+// tlsSniffer finds the server name indication of a TLS ClientHello
+type tlsSniffer struct {
+	ports PortRanges
+}
+
+func (ts *tlsSniffer) Protocol() string {
+	return "tls"
+}
+
+func (ts *tlsSniffer) SupportNetwork() M.Network {
+	return M.TCP
+}
+
+func (ts *tlsSniffer) SupportPort(port uint16) bool {
+	return ts.ports.Contains(port)
+}
+
+func (ts *tlsSniffer) SniffData(b []byte) (string, error) {
+	// record header: type(1) | version(2) | length(2)
+	if len(b) < 5 || b[0] != recordTypeHandshake || b[1] != 0x03 {
+		return "", errNotTLS
+	}
+	recordLen := int(binary.BigEndian.Uint16(b[3:5]))
+	b = b[5:]
+	if len(b) > recordLen {
+		b = b[:recordLen]
+	}
+
+	// handshake header: type(1) | length(3)
+	if len(b) < 4 || b[0] != handshakeTypeClientHello {
+		return "", errNotTLS
+	}
+	return sniffClientHello(b[4:])
+}
+
+// sniffClientHello returns the server name of the ClientHello body b. It is shared by the
+// TLS and QUIC sniffers
+func sniffClientHello(b []byte) (string, error) {
+	// client_version(2) | random(32)
+	if len(b) < 34 {
+		return "", errNotTLS
+	}
+	b = b[34:]
+
+	// legacy_session_id
+	b, ok := skipVector(b, 1)
+	if !ok {
+		return "", errNotTLS
+	}
+	// cipher_suites
+	if b, ok = skipVector(b, 2); !ok {
+		return "", errNotTLS
+	}
+	// legacy_compression_methods
+	if b, ok = skipVector(b, 1); !ok {
+		return "", errNotTLS
+	}
+
+	if len(b) < 2 {
+		return "", errNotFound
+	}
+	extensionsLen := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) > extensionsLen {
+		b = b[:extensionsLen]
+	}
+
+	for len(b) >= 4 {
+		extType := binary.BigEndian.Uint16(b)
+		extLen := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		if len(b) < extLen {
+			return "", errNotTLS
+		}
+		if extType == extensionServerName {
+			return parseServerName(b[:extLen])
+		}
+		b = b[extLen:]
+	}
+	return "", errNotFound
+}
+
+func parseServerName(b []byte) (string, error) {
+	if len(b) < 2 {
+		return "", errNotTLS
+	}
+	listLen := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < listLen {
+		return "", errNotTLS
+	}
+	b = b[:listLen]
+
+	for len(b) >= 3 {
+		nameType := b[0]
+		nameLen := int(binary.BigEndian.Uint16(b[1:]))
+		b = b[3:]
+		if len(b) < nameLen {
+			return "", errNotTLS
+		}
+		if nameType == serverNameTypeHostName {
+			if nameLen == 0 {
+				return "", errNotFound
+			}
+			return string(b[:nameLen]), nil
+		}
+		b = b[nameLen:]
+	}
+	return "", errNotFound
+}
+
+// skipVector skips a vector with a length prefix of lenSize bytes
+func skipVector(b []byte, lenSize int) ([]byte, bool) {
+	if len(b) < lenSize {
+		return nil, false
+	}
+	var n int
+	for i := 0; i < lenSize; i++ {
+		n = n<<8 | int(b[i])
+	}
+	b = b[lenSize:]
+	if len(b) < n {
+		return nil, false
+	}
+	return b[n:], true
+}
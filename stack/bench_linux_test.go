@@ -0,0 +1,146 @@
+//go:build linux
+
+package stack
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/tun"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// benchStacks lists the stacks compared by BenchmarkStackThroughput
+var benchStacks = []StackType{TunSystem}
+
+// discardHandler drops everything received on TCP connections
+type discardHandler struct{}
+
+func (discardHandler) HandleTCP(conn adapter.TCPConn) {
+	go func() {
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+}
+
+func (discardHandler) HandleUDP(conn adapter.UDPConn) {
+	conn.Close()
+}
+
+// setupVeth connects a client network namespace to the current one with a veth pair and
+// forwards the traffic of the client
+func setupVeth(b *testing.B) netns.NsHandle {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	require.NoError(b, err)
+	defer origin.Close()
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "veth1"}
+	require.NoError(b, netlink.LinkAdd(veth))
+	require.NoError(b, netlink.AddrAdd(veth, mustParseAddr(b, "10.10.0.1/24")))
+	require.NoError(b, netlink.LinkSetUp(veth))
+
+	client, err := netns.New()
+	require.NoError(b, err)
+	require.NoError(b, netns.Set(origin))
+	b.Cleanup(func() { client.Close() })
+
+	peer, err := netlink.LinkByName("veth1")
+	require.NoError(b, err)
+	require.NoError(b, netlink.LinkSetNsFd(peer, int(client)))
+
+	handle, err := netlink.NewHandleAt(client)
+	require.NoError(b, err)
+	defer handle.Close()
+	peer, err = handle.LinkByName("veth1")
+	require.NoError(b, err)
+	require.NoError(b, handle.AddrAdd(peer, mustParseAddr(b, "10.10.0.2/24")))
+	require.NoError(b, handle.LinkSetUp(peer))
+	require.NoError(b, handle.RouteAdd(&netlink.Route{LinkIndex: peer.Attrs().Index, Gw: net.IPv4(10, 10, 0, 1)}))
+
+	require.NoError(b, os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0o644))
+	return client
+}
+
+// startBenchStack starts a stack of stackType on a new TUN device routing 10.20.0.0/16. Each
+// stack gets its own device as a closed stack may still consume one packet from it
+func startBenchStack(b *testing.B, stackType StackType) (Stack, tun.Device) {
+	inet4Address := []netip.Prefix{netip.MustParsePrefix("198.18.0.1/30")}
+	device, err := tun.New(tun.Options{Name: "luma-bench", MTU: 1500, Inet4Address: inet4Address})
+	require.NoError(b, err)
+
+	link, err := netlink.LinkByName("luma-bench")
+	require.NoError(b, err)
+	_, dst, _ := net.ParseCIDR("10.20.0.0/16")
+	require.NoError(b, netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst}))
+
+	s, err := New(stackType, Config{Device: device, Handler: discardHandler{}, Inet4Address: inet4Address, MTU: 1500})
+	require.NoError(b, err)
+	require.NoError(b, s.Start())
+	return s, device
+}
+
+func mustParseAddr(b *testing.B, s string) *netlink.Addr {
+	addr, err := netlink.ParseAddr(s)
+	require.NoError(b, err)
+	return addr
+}
+
+// dialIn opens a TCP connection from the network namespace ns
+func dialIn(ns netns.NsHandle, address string) (net.Conn, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer origin.Close()
+	if err := netns.Set(ns); err != nil {
+		return nil, err
+	}
+	defer netns.Set(origin)
+	return net.Dial("tcp", address)
+}
+
+// BenchmarkStackThroughput measures the TCP upload throughput of each stack for traffic
+// forwarded into the TUN device from another network namespace over a veth pair
+func BenchmarkStackThroughput(b *testing.B) {
+	if !runInNetns(b) {
+		return
+	}
+
+	lo, err := netlink.LinkByName("lo")
+	require.NoError(b, err)
+	require.NoError(b, netlink.LinkSetUp(lo))
+
+	client := setupVeth(b)
+	chunk := make([]byte, 32*1024)
+	for _, stackType := range benchStacks {
+		s, device := startBenchStack(b, stackType)
+		conn, err := dialIn(client, "10.20.0.1:80")
+		require.NoError(b, err)
+
+		b.Run(stackType.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(chunk)))
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		conn.Close()
+		s.Close()
+		device.Close()
+	}
+}
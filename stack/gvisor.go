@@ -0,0 +1,278 @@
+//go:build with_gvisor
+
+package stack
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/tun"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	gstack "gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// defaultNIC is the ID of the NIC of the TUN device
+	defaultNIC tcpip.NICID = 1
+	// outboundQueueSize is the number of packets queued for the device
+	outboundQueueSize = 512
+	// tcpMaxInFlight is the number of TCP connections that may be in the handshake at once
+	tcpMaxInFlight = 1024
+)
+
+// gVisor is a Stack that terminates the flows of the device with the userspace TCP/IP stack of gVisor
+type gVisor struct {
+	device  tun.Device
+	handler adapter.TransportHandler
+	mtu     uint32
+
+	tcpReceiveBufferSize int
+	tcpSendBufferSize    int
+
+	// udpOnly leaves TCP and reading the device to another stack, which injects UDP packets
+	udpOnly bool
+
+	stack    *gstack.Stack
+	endpoint *channel.Endpoint
+	udpNat   *adapter.NatTable
+	cancel   context.CancelFunc
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newGVisor(cfg Config) (Stack, error) {
+	return newGVisorStack(cfg), nil
+}
+
+func newGVisorStack(cfg Config) *gVisor {
+	mtu := cfg.MTU
+	if mtu == 0 {
+		mtu = cfg.Device.MTU()
+	}
+	return &gVisor{
+		device:               cfg.Device,
+		handler:              cfg.Handler,
+		mtu:                  mtu,
+		tcpReceiveBufferSize: cfg.TCPReceiveBufferSize,
+		tcpSendBufferSize:    cfg.TCPSendBufferSize,
+		udpNat:               adapter.NewNatTable(),
+		done:                 make(chan struct{}),
+	}
+}
+
+func (t *gVisor) Start() error {
+	s := gstack.New(gstack.Options{
+		NetworkProtocols: []gstack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []gstack.TransportProtocolFactory{
+			tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4, icmp.NewProtocol6,
+		},
+	})
+	if err := t.setTCPOptions(s); err != nil {
+		s.Close()
+		return err
+	}
+
+	endpoint := channel.New(outboundQueueSize, t.mtu, "")
+	if err := s.CreateNIC(defaultNIC, endpoint); err != nil {
+		s.Close()
+		return errors.New(err.String())
+	}
+	// accept packets to and send packets from any address, like the destinations of the flows
+	if err := s.SetPromiscuousMode(defaultNIC, true); err != nil {
+		s.Close()
+		return errors.New(err.String())
+	}
+	if err := s.SetSpoofing(defaultNIC, true); err != nil {
+		s.Close()
+		return errors.New(err.String())
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: defaultNIC},
+		{Destination: header.IPv6EmptySubnet, NIC: defaultNIC},
+	})
+
+	if !t.udpOnly {
+		tcpForwarder := tcp.NewForwarder(s, 0, tcpMaxInFlight, t.handleTCP)
+		s.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpForwarder.HandlePacket)
+	}
+	s.SetTransportProtocolHandler(udp.ProtocolNumber, t.handleUDP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stack = s
+	t.endpoint = endpoint
+	t.cancel = cancel
+	if !t.udpOnly {
+		go t.inboundLoop()
+	}
+	go t.outboundLoop(ctx)
+	return nil
+}
+
+func (t *gVisor) Close() error {
+	t.once.Do(func() {
+		close(t.done)
+		t.udpNat.Range(func(_ string, conn *adapter.PacketConn) bool {
+			conn.Close()
+			return true
+		})
+		if t.stack == nil {
+			return
+		}
+		t.cancel()
+		t.endpoint.Close()
+		t.stack.Close()
+	})
+	return nil
+}
+
+func (t *gVisor) setTCPOptions(s *gstack.Stack) error {
+	sackEnabled := tcpip.TCPSACKEnabled(true)
+	if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &sackEnabled); err != nil {
+		return errors.New(err.String())
+	}
+	if t.tcpReceiveBufferSize > 0 {
+		rcvOpt := tcpip.TCPReceiveBufferSizeRangeOption{
+			Min:     tcp.MinBufferSize,
+			Default: t.tcpReceiveBufferSize,
+			Max:     max(t.tcpReceiveBufferSize, tcp.MaxBufferSize),
+		}
+		if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &rcvOpt); err != nil {
+			return errors.New(err.String())
+		}
+	}
+	if t.tcpSendBufferSize > 0 {
+		sndOpt := tcpip.TCPSendBufferSizeRangeOption{
+			Min:     tcp.MinBufferSize,
+			Default: t.tcpSendBufferSize,
+			Max:     max(t.tcpSendBufferSize, tcp.MaxBufferSize),
+		}
+		if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &sndOpt); err != nil {
+			return errors.New(err.String())
+		}
+	}
+	return nil
+}
+
+// inboundLoop injects the packets read from the device into the stack
+func (t *gVisor) inboundLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, err := t.device.Read(buf)
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			log.Debugf("[TUN] read packet: %v", err)
+			continue
+		}
+		t.inject(buf[:n])
+	}
+}
+
+// inject injects the IP packet b into the stack
+func (t *gVisor) inject(b []byte) {
+	var protocol tcpip.NetworkProtocolNumber
+	switch header.IPVersion(b) {
+	case header.IPv4Version:
+		protocol = header.IPv4ProtocolNumber
+	case header.IPv6Version:
+		protocol = header.IPv6ProtocolNumber
+	default:
+		return
+	}
+	pkt := gstack.NewPacketBuffer(gstack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(b),
+	})
+	t.endpoint.InjectInbound(protocol, pkt)
+	pkt.DecRef()
+}
+
+// outboundLoop writes the packets sent by the stack to the device
+func (t *gVisor) outboundLoop(ctx context.Context) {
+	for {
+		pkt := t.endpoint.ReadContext(ctx)
+		if pkt == nil {
+			return
+		}
+		view := pkt.ToView()
+		if _, err := t.device.Write(view.AsSlice()); err != nil {
+			log.Debugf("[TUN] write packet: %v", err)
+		}
+		view.Release()
+		pkt.DecRef()
+	}
+}
+
+func (t *gVisor) handleTCP(r *tcp.ForwarderRequest) {
+	// the request is invalid once completed
+	id := r.ID()
+	var wq waiter.Queue
+	ep, err := r.CreateEndpoint(&wq)
+	if err != nil {
+		log.Debugf("[TUN] create tcp endpoint: %s", err)
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+	ep.SocketOptions().SetKeepAlive(true)
+
+	conn := gonet.NewTCPConn(&wq, ep)
+	t.handler.HandleTCP(adapter.NewTCPConn(conn, &M.Metadata{},
+		adapter.WithInType(proto.Inbound_TUN),
+		adapter.WithSrcAddr(net.TCPAddrFromAddrPort(toAddrPort(id.RemoteAddress, id.RemotePort))),
+		adapter.WithDstAddr(net.TCPAddrFromAddrPort(toAddrPort(id.LocalAddress, id.LocalPort)))))
+}
+
+// handleUDP hands the UDP packets of the stack to the session of their source. Replies are
+// written to the device from the address they come from, which the stack would not send
+func (t *gVisor) handleUDP(id gstack.TransportEndpointID, pkt *gstack.PacketBuffer) bool {
+	hdr := header.UDP(pkt.TransportHeader().Slice())
+	netHdr := pkt.Network()
+	lengthValid, csumValid := header.UDPValid(
+		hdr,
+		func() uint16 { return pkt.Data().Checksum() },
+		uint16(pkt.Data().Size()),
+		pkt.NetworkProtocolNumber,
+		netHdr.SourceAddress(),
+		netHdr.DestinationAddress(),
+		pkt.RXChecksumValidated)
+	if !lengthValid || !csumValid {
+		return true
+	}
+
+	source := toAddrPort(id.RemoteAddress, id.RemotePort)
+	destination := toAddrPort(id.LocalAddress, id.LocalPort)
+	if destination.Addr().IsMulticast() || destination.Addr() == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return true
+	}
+	handleUDPPacket(t.udpNat, t.handler, t.device, source, destination, pkt.Data().AsRange().ToSlice())
+	return true
+}
+
+func toAddrPort(addr tcpip.Address, port uint16) netip.AddrPort {
+	ip, _ := netip.AddrFromSlice(addr.AsSlice())
+	return netip.AddrPortFrom(ip.Unmap(), port)
+}
@@ -0,0 +1,21 @@
+//go:build linux && with_gvisor
+
+package stack
+
+import "testing"
+
+func init() {
+	benchStacks = append(benchStacks, TunGVisor, TunMixed)
+}
+
+func TestGVisorStack(t *testing.T) {
+	if runInNetns(t) {
+		testStack(t, TunGVisor)
+	}
+}
+
+func TestMixedStack(t *testing.T) {
+	if runInNetns(t) {
+		testStack(t, TunMixed)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build with_gvisor
+
+package stack
+
+// mixed is a Stack that terminates TCP with the kernel like the system stack, for throughput,
+// and UDP with gVisor
+type mixed struct {
+	*system
+	gvisor *gVisor
+}
+
+func newMixed(cfg Config) (Stack, error) {
+	system, err := newSystem(cfg)
+	if err != nil {
+		return nil, err
+	}
+	gvisor := newGVisorStack(cfg)
+	gvisor.udpOnly = true
+	system.injectUDP = gvisor.inject
+	return &mixed{
+		system: system,
+		gvisor: gvisor,
+	}, nil
+}
+
+func (m *mixed) Start() error {
+	if err := m.gvisor.Start(); err != nil {
+		return err
+	}
+	if err := m.system.Start(); err != nil {
+		m.gvisor.Close()
+		return err
+	}
+	return nil
+}
+
+func (m *mixed) Close() error {
+	m.system.Close()
+	return m.gvisor.Close()
+}
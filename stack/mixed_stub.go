@@ -0,0 +1,9 @@
+//go:build !with_gvisor
+
+package stack
+
+import "fmt"
+
+func newMixed(cfg Config) (Stack, error) {
+	return nil, fmt.Errorf("%w: mixed stack requires building with the with_gvisor tag", ErrStackNotSupported)
+}
@@ -0,0 +1,210 @@
+package stack
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	tcpHeaderLen  = 20
+
+	// offsets of the checksums in the transport headers
+	tcpChecksumOffset = 16
+	udpChecksumOffset = 6
+)
+
+// ipPacket is a parsed IPv4 or IPv6 packet. Its slices point into the packet it was parsed from
+type ipPacket struct {
+	// raw is the whole packet
+	raw []byte
+	// header is the IP header, options included
+	header []byte
+	// payload is the transport segment
+	payload  []byte
+	protocol uint8
+}
+
+// parseIPPacket parses b. It reports false for packets that are malformed, fragmented or
+// carry IPv6 extension headers
+func parseIPPacket(b []byte) (ipPacket, bool) {
+	if len(b) == 0 {
+		return ipPacket{}, false
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < ipv4HeaderLen {
+			return ipPacket{}, false
+		}
+		headerLen := int(b[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(b[2:4]))
+		if headerLen < ipv4HeaderLen || totalLen < headerLen || totalLen > len(b) {
+			return ipPacket{}, false
+		}
+		// more fragments flag or fragment offset
+		if binary.BigEndian.Uint16(b[6:8])&0x3fff != 0 {
+			return ipPacket{}, false
+		}
+		return ipPacket{
+			raw:      b[:totalLen],
+			header:   b[:headerLen],
+			payload:  b[headerLen:totalLen],
+			protocol: b[9],
+		}, true
+	case 6:
+		if len(b) < ipv6HeaderLen {
+			return ipPacket{}, false
+		}
+		totalLen := ipv6HeaderLen + int(binary.BigEndian.Uint16(b[4:6]))
+		if totalLen > len(b) {
+			return ipPacket{}, false
+		}
+		return ipPacket{
+			raw:      b[:totalLen],
+			header:   b[:ipv6HeaderLen],
+			payload:  b[ipv6HeaderLen:totalLen],
+			protocol: b[6],
+		}, true
+	default:
+		return ipPacket{}, false
+	}
+}
+
+func (p *ipPacket) is4() bool {
+	return p.header[0]>>4 == 4
+}
+
+func (p *ipPacket) src() netip.Addr {
+	if p.is4() {
+		return netip.AddrFrom4([4]byte(p.header[12:16]))
+	}
+	return netip.AddrFrom16([16]byte(p.header[8:24]))
+}
+
+func (p *ipPacket) dst() netip.Addr {
+	if p.is4() {
+		return netip.AddrFrom4([4]byte(p.header[16:20]))
+	}
+	return netip.AddrFrom16([16]byte(p.header[24:40]))
+}
+
+func (p *ipPacket) srcPort() uint16 {
+	return binary.BigEndian.Uint16(p.payload[0:2])
+}
+
+func (p *ipPacket) dstPort() uint16 {
+	return binary.BigEndian.Uint16(p.payload[2:4])
+}
+
+// validTransport reports whether the payload is long enough for the header of its protocol
+func (p *ipPacket) validTransport() bool {
+	switch p.protocol {
+	case protocolTCP:
+		return len(p.payload) >= tcpHeaderLen
+	case protocolUDP:
+		return len(p.payload) >= udpHeaderLen
+	default:
+		return false
+	}
+}
+
+// rewrite sets the addresses and ports of the packet and updates its checksums. The new
+// addresses must be of the same family as the packet
+func (p *ipPacket) rewrite(src, dst netip.AddrPort) {
+	if p.is4() {
+		s, d := src.Addr().As4(), dst.Addr().As4()
+		copy(p.header[12:16], s[:])
+		copy(p.header[16:20], d[:])
+		setIPv4Checksum(p.header)
+	} else {
+		s, d := src.Addr().As16(), dst.Addr().As16()
+		copy(p.header[8:24], s[:])
+		copy(p.header[24:40], d[:])
+	}
+	binary.BigEndian.PutUint16(p.payload[0:2], src.Port())
+	binary.BigEndian.PutUint16(p.payload[2:4], dst.Port())
+	setTransportChecksum(p.header, p.payload, p.protocol)
+}
+
+// buildUDPPacket returns an IP packet carrying payload from src to dst
+func buildUDPPacket(src, dst netip.AddrPort, payload []byte) []byte {
+	headerLen := ipv6HeaderLen
+	if src.Addr().Is4() {
+		headerLen = ipv4HeaderLen
+	}
+	udpLen := udpHeaderLen + len(payload)
+	b := make([]byte, headerLen+udpLen)
+	header, segment := b[:headerLen], b[headerLen:]
+
+	if src.Addr().Is4() {
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(b)))
+		header[8] = 64
+		header[9] = protocolUDP
+	} else {
+		header[0] = 0x60
+		binary.BigEndian.PutUint16(header[4:6], uint16(udpLen))
+		header[6] = protocolUDP
+		header[7] = 64
+	}
+	binary.BigEndian.PutUint16(segment[4:6], uint16(udpLen))
+	copy(segment[udpHeaderLen:], payload)
+
+	p := ipPacket{raw: b, header: header, payload: segment, protocol: protocolUDP}
+	p.rewrite(src, dst)
+	return b
+}
+
+func setIPv4Checksum(header []byte) {
+	header[10], header[11] = 0, 0
+	binary.BigEndian.PutUint16(header[10:12], ^foldChecksum(sumBytes(0, header)))
+}
+
+// setTransportChecksum computes the TCP or UDP checksum of segment, pseudo-header included
+func setTransportChecksum(header, segment []byte, protocol uint8) {
+	offset := tcpChecksumOffset
+	if protocol == protocolUDP {
+		offset = udpChecksumOffset
+	}
+	segment[offset], segment[offset+1] = 0, 0
+
+	var sum uint32
+	if header[0]>>4 == 4 {
+		sum = sumBytes(sum, header[12:20])
+	} else {
+		sum = sumBytes(sum, header[8:40])
+	}
+	sum += uint32(protocol) + uint32(len(segment))
+	sum = sumBytes(sum, segment)
+
+	checksum := ^foldChecksum(sum)
+	if checksum == 0 && protocol == protocolUDP {
+		// a zero UDP checksum means no checksum
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[offset:offset+2], checksum)
+}
+
+// sumBytes adds b to the one's complement sum as a sequence of 16 bit words
+func sumBytes(sum uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+func foldChecksum(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return uint16(sum)
+}
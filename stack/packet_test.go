@@ -0,0 +1,47 @@
+package stack
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildUDPPacket(t *testing.T) {
+	for _, tt := range []struct {
+		src, dst string
+	}{
+		{"10.0.0.1:53", "198.18.0.2:40000"},
+		{"[2001:db8::1]:53", "[fd00::2]:40000"},
+	} {
+		src, dst := netip.MustParseAddrPort(tt.src), netip.MustParseAddrPort(tt.dst)
+		b := buildUDPPacket(src, dst, []byte("payload"))
+
+		p, ok := parseIPPacket(b)
+		require.True(t, ok)
+		require.True(t, p.validTransport())
+		assert.Equal(t, uint8(protocolUDP), p.protocol)
+		assert.Equal(t, src, netip.AddrPortFrom(p.src(), p.srcPort()))
+		assert.Equal(t, dst, netip.AddrPortFrom(p.dst(), p.dstPort()))
+		assert.Equal(t, "payload", string(p.payload[udpHeaderLen:]))
+
+		// a valid checksum sums to 0xffff once included
+		var sum uint32
+		if p.is4() {
+			assert.Equal(t, uint16(0xffff), foldChecksum(sumBytes(0, p.header)))
+			sum = sumBytes(sum, p.header[12:20])
+		} else {
+			sum = sumBytes(sum, p.header[8:40])
+		}
+		sum += protocolUDP + uint32(len(p.payload))
+		assert.Equal(t, uint16(0xffff), foldChecksum(sumBytes(sum, p.payload)))
+	}
+}
+
+func TestParseIPPacket_Fragment(t *testing.T) {
+	b := buildUDPPacket(netip.MustParseAddrPort("10.0.0.1:53"), netip.MustParseAddrPort("10.0.0.2:53"), nil)
+	b[6] = 0x20 // more fragments
+	_, ok := parseIPPacket(b)
+	assert.False(t, ok)
+}
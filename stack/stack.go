@@ -0,0 +1,53 @@
+package stack
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/tun"
+)
+
+var ErrStackNotSupported = errors.New("tun stack not supported")
+
+// Stack terminates the TCP and UDP flows of a TUN device and hands them to a TransportHandler
+type Stack interface {
+	// Start starts processing the packets of the device
+	Start() error
+	// Close stops the stack. It does not close the device
+	Close() error
+}
+
+// Config is the configuration of a Stack
+type Config struct {
+	// Device is the TUN device the stack reads packets from
+	Device tun.Device
+	// Handler receives the connections terminated by the stack
+	Handler adapter.TransportHandler
+	// Inet4Address and Inet6Address are the addresses of the device
+	Inet4Address []netip.Prefix
+	Inet6Address []netip.Prefix
+	// MTU is the MTU of the userspace stack, the MTU of the device if it is zero
+	MTU uint32
+	// TCPReceiveBufferSize and TCPSendBufferSize are the default TCP buffer sizes of the
+	// userspace stack, its defaults if they are zero
+	TCPReceiveBufferSize int
+	TCPSendBufferSize    int
+}
+
+// New returns a Stack of the given type
+func New(stackType StackType, cfg Config) (Stack, error) {
+	if cfg.Device == nil || cfg.Handler == nil {
+		return nil, errors.New("tun stack requires a device and a handler")
+	}
+	switch stackType {
+	case TunSystem:
+		return newSystem(cfg)
+	case TunGVisor:
+		return newGVisor(cfg)
+	case TunMixed:
+		return newMixed(cfg)
+	default:
+		return nil, ErrStackNotSupported
+	}
+}
@@ -0,0 +1,190 @@
+//go:build linux
+
+package stack
+
+import (
+	"flag"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/tun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+const netnsEnv = "LUMA_TEST_NETNS"
+
+// runInNetns runs the calling test or benchmark again in a new network namespace. It reports
+// true in the namespace, where it must run, and false in the parent, which is done
+func runInNetns(tb testing.TB) bool {
+	if os.Getenv(netnsEnv) != "" {
+		return true
+	}
+	if os.Getuid() != 0 {
+		tb.Skip("requires root")
+	}
+	if _, err := os.Stat("/dev/net/tun"); err != nil {
+		tb.Skip("requires /dev/net/tun")
+	}
+	unshare, err := exec.LookPath("unshare")
+	if err != nil {
+		tb.Skip("requires unshare")
+	}
+
+	args := []string{"--net", os.Args[0], "-test.run=^" + tb.Name() + "$", "-test.v"}
+	if _, ok := tb.(*testing.B); ok {
+		args = []string{"--net", os.Args[0], "-test.run=^$", "-test.bench=^" + tb.Name() + "$", "-test.benchmem"}
+		if f := flag.Lookup("test.benchtime"); f != nil {
+			args = append(args, "-test.benchtime="+f.Value.String())
+		}
+	}
+	cmd := exec.Command(unshare, args...)
+	cmd.Env = append(os.Environ(), netnsEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(tb, err, string(out))
+	if _, ok := tb.(*testing.B); ok {
+		os.Stdout.Write(out)
+	}
+	return false
+}
+
+// coneAddr is the address a second remote replies "cone" packets from
+var coneAddr = &net.UDPAddr{IP: net.IPv4(10, 9, 9, 9), Port: 9999}
+
+// echoHandler echoes the data of TCP and UDP connections back to their client. UDP packets
+// containing "cone" are also sent back from coneAddr
+type echoHandler struct {
+	metadata chan *M.Metadata
+}
+
+func (h *echoHandler) HandleTCP(conn adapter.TCPConn) {
+	h.metadata <- conn.Metadata()
+	go func() {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+}
+
+func (h *echoHandler) HandleUDP(conn adapter.UDPConn) {
+	h.metadata <- conn.Metadata()
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 65535)
+		for {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+			if string(buf[:n]) == "cone" {
+				conn.WriteTo(buf[:n], coneAddr)
+			}
+		}
+	}()
+}
+
+// testStack starts a stack of stackType on a TUN device routing 10.0.0.0/8 and checks that TCP
+// and UDP flows to that range reach the handler with the right metadata
+func testStack(t *testing.T, stackType StackType) {
+	lo, err := netlink.LinkByName("lo")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetUp(lo))
+
+	inet4Address := []netip.Prefix{netip.MustParsePrefix("198.18.0.1/30")}
+	device, err := tun.New(tun.Options{Name: "luma-test", MTU: 1500, Inet4Address: inet4Address})
+	require.NoError(t, err)
+	defer device.Close()
+
+	link, err := netlink.LinkByName("luma-test")
+	require.NoError(t, err)
+	_, dst, _ := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst}))
+
+	handler := &echoHandler{metadata: make(chan *M.Metadata, 4)}
+	s, err := New(stackType, Config{Device: device, Handler: handler, Inet4Address: inet4Address})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	t.Run("tcp", func(t *testing.T) {
+		conn, err := net.DialTimeout("tcp", "10.1.2.3:80", 5*time.Second)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		metadata := <-handler.metadata
+		assert.Equal(t, M.TCP, metadata.Network)
+		assert.Equal(t, "10.1.2.3:80", metadata.RemoteAddress())
+		assert.Equal(t, conn.LocalAddr().String(), metadata.SourceAddress())
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.Dial("udp", "10.1.2.3:53")
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("query"))
+		require.NoError(t, err)
+		metadata := <-handler.metadata
+		assert.Equal(t, M.UDP, metadata.Network)
+		assert.Equal(t, "10.1.2.3:53", metadata.RemoteAddress())
+
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "query", string(buf[:n]))
+	})
+
+	t.Run("udp full cone", func(t *testing.T) {
+		conn, err := net.ListenUDP("udp4", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.WriteTo([]byte("cone"), &net.UDPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 53})
+		require.NoError(t, err)
+		<-handler.metadata
+
+		// one client port receives from two remotes
+		received := make(map[string]string)
+		buf := make([]byte, 64)
+		for range 2 {
+			n, from, err := conn.ReadFrom(buf)
+			require.NoError(t, err)
+			received[from.String()] = string(buf[:n])
+		}
+		assert.Equal(t, map[string]string{"10.1.2.3:53": "cone", coneAddr.String(): "cone"}, received)
+
+		// packets to another destination stay in the session of the port
+		_, err = conn.WriteTo([]byte("ping"), coneAddr)
+		require.NoError(t, err)
+		n, from, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, coneAddr.String(), from.String())
+		assert.Equal(t, "ping", string(buf[:n]))
+		assert.Empty(t, handler.metadata)
+	})
+}
+
+func TestSystemStack(t *testing.T) {
+	if runInNetns(t) {
+		testStack(t, TunSystem)
+	}
+}
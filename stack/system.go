@@ -0,0 +1,284 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy/proto"
+	"github.com/lumavpn/luma/tun"
+)
+
+// system is a Stack that lets the TCP/IP stack of the kernel terminate TCP flows. Packets of a
+// flow are NATed between the TUN device and a local listener bound to the address of the device,
+// so the kernel accepts the flow on behalf of the original destination. UDP is handled in userspace
+type system struct {
+	device  tun.Device
+	handler adapter.TransportHandler
+
+	// inet4ServerAddress is the address of the device the TCP listener is bound to, flows are
+	// NATed to it from inet4Address
+	inet4ServerAddress netip.Addr
+	inet4Address       netip.Addr
+	inet6ServerAddress netip.Addr
+	inet6Address       netip.Addr
+
+	tcpListener  net.Listener
+	tcpListener6 net.Listener
+	tcpPort      uint16
+	tcpPort6     uint16
+	tcpNat       *tcpNat
+	udpNat       *adapter.NatTable
+
+	// injectUDP, if set, receives the UDP packets of the device instead of the userspace handling
+	injectUDP func(b []byte)
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSystem(cfg Config) (*system, error) {
+	s := &system{
+		device:  cfg.Device,
+		handler: cfg.Handler,
+		tcpNat:  newTCPNat(),
+		udpNat:  adapter.NewNatTable(),
+		done:    make(chan struct{}),
+	}
+	if len(cfg.Inet4Address) > 0 {
+		prefix := cfg.Inet4Address[0]
+		s.inet4ServerAddress = prefix.Addr()
+		s.inet4Address = prefix.Addr().Next()
+		if !prefix.Contains(s.inet4Address) {
+			return nil, fmt.Errorf("need one more IPv4 address in %s for system stack", prefix)
+		}
+	}
+	if len(cfg.Inet6Address) > 0 {
+		prefix := cfg.Inet6Address[0]
+		s.inet6ServerAddress = prefix.Addr()
+		s.inet6Address = prefix.Addr().Next()
+		if !prefix.Contains(s.inet6Address) {
+			return nil, fmt.Errorf("need one more IPv6 address in %s for system stack", prefix)
+		}
+	}
+	if !s.inet4ServerAddress.IsValid() && !s.inet6ServerAddress.IsValid() {
+		return nil, errors.New("missing interface address")
+	}
+	return s, nil
+}
+
+func (s *system) Start() error {
+	if s.inet4ServerAddress.IsValid() {
+		l, err := net.Listen("tcp4", netip.AddrPortFrom(s.inet4ServerAddress, 0).String())
+		if err != nil {
+			return err
+		}
+		s.tcpListener = l
+		s.tcpPort = uint16(l.Addr().(*net.TCPAddr).Port)
+		go s.acceptLoop(l)
+	}
+	if s.inet6ServerAddress.IsValid() {
+		l, err := net.Listen("tcp6", netip.AddrPortFrom(s.inet6ServerAddress, 0).String())
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.tcpListener6 = l
+		s.tcpPort6 = uint16(l.Addr().(*net.TCPAddr).Port)
+		go s.acceptLoop(l)
+	}
+	go s.tunLoop()
+	go s.cleanupLoop()
+	return nil
+}
+
+func (s *system) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.tcpListener != nil {
+			s.tcpListener.Close()
+		}
+		if s.tcpListener6 != nil {
+			s.tcpListener6.Close()
+		}
+		s.udpNat.Range(func(_ string, conn *adapter.PacketConn) bool {
+			conn.Close()
+			return true
+		})
+	})
+	return nil
+}
+
+func (s *system) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *system) tunLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, err := s.device.Read(buf)
+		if err != nil {
+			if s.closed() || errors.Is(err, os.ErrClosed) {
+				return
+			}
+			log.Debugf("[TUN] read packet: %v", err)
+			continue
+		}
+		s.processPacket(buf[:n])
+	}
+}
+
+func (s *system) cleanupLoop() {
+	ticker := time.NewTicker(tcpNatTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.tcpNat.cleanup()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *system) processPacket(b []byte) {
+	p, ok := parseIPPacket(b)
+	if !ok || !p.validTransport() {
+		return
+	}
+	switch p.protocol {
+	case protocolTCP:
+		if s.processTCP(&p) {
+			if _, err := s.device.Write(p.raw); err != nil {
+				log.Debugf("[TUN] write packet: %v", err)
+			}
+		}
+	case protocolUDP:
+		if s.injectUDP != nil {
+			s.injectUDP(p.raw)
+			return
+		}
+		s.processUDP(&p)
+	}
+}
+
+// processTCP rewrites p between the original flow and the local listener. It reports whether
+// the packet must be written back to the device
+func (s *system) processTCP(p *ipPacket) bool {
+	source := netip.AddrPortFrom(p.src(), p.srcPort())
+	destination := netip.AddrPortFrom(p.dst(), p.dstPort())
+
+	serverAddr, natAddr, port := s.inet4ServerAddress, s.inet4Address, s.tcpPort
+	if !p.is4() {
+		serverAddr, natAddr, port = s.inet6ServerAddress, s.inet6Address, s.tcpPort6
+	}
+	if !serverAddr.IsValid() {
+		return false
+	}
+
+	if source == netip.AddrPortFrom(serverAddr, port) && destination.Addr() == natAddr {
+		// reply of the listener to a NATed flow
+		session := s.tcpNat.LookupBack(destination.Port())
+		if session == nil {
+			return false
+		}
+		p.rewrite(session.Destination, session.Source)
+		return true
+	}
+
+	natPort, err := s.tcpNat.Lookup(source, destination)
+	if err != nil {
+		log.Warnf("[TUN] %s --> %s: %v", source, destination, err)
+		return false
+	}
+	p.rewrite(netip.AddrPortFrom(natAddr, natPort), netip.AddrPortFrom(serverAddr, port))
+	return true
+}
+
+func (s *system) acceptLoop(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.closed() {
+				return
+			}
+			continue
+		}
+		port := uint16(conn.RemoteAddr().(*net.TCPAddr).Port)
+		session := s.tcpNat.LookupBack(port)
+		if session == nil {
+			conn.Close()
+			continue
+		}
+		s.handler.HandleTCP(adapter.NewTCPConn(conn, &M.Metadata{},
+			adapter.WithInType(proto.Inbound_TUN),
+			adapter.WithSrcAddr(net.TCPAddrFromAddrPort(session.Source)),
+			adapter.WithDstAddr(net.TCPAddrFromAddrPort(session.Destination))))
+	}
+}
+
+func (s *system) processUDP(p *ipPacket) {
+	source := netip.AddrPortFrom(p.src(), p.srcPort())
+	destination := netip.AddrPortFrom(p.dst(), p.dstPort())
+	if destination.Addr().IsMulticast() || destination.Addr() == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return
+	}
+	udpLen := int(p.payload[4])<<8 | int(p.payload[5])
+	if udpLen < udpHeaderLen || udpLen > len(p.payload) {
+		return
+	}
+
+	handleUDPPacket(s.udpNat, s.handler, s.device, source, destination, p.payload[udpHeaderLen:udpLen])
+}
+
+// handleUDPPacket delivers the payload of a UDP packet of the device to the session of its
+// source in nat. Sessions are keyed on the source alone so that a client port keeps its
+// session, and its mapping, whatever the destination, like behind a full cone NAT
+func handleUDPPacket(nat *adapter.NatTable, handler adapter.TransportHandler, device tun.Device, source, destination netip.AddrPort, payload []byte) {
+	conn, created := nat.GetOrCreate(source.String(), func() *adapter.PacketConn {
+		writeBack := &udpWriteBack{device: device, source: source, destination: destination}
+		return adapter.NewPacketConn(&M.Metadata{}, net.UDPAddrFromAddrPort(destination), net.UDPAddrFromAddrPort(source),
+			writeBack, adapter.WithInType(proto.Inbound_TUN), adapter.WithDstAddr(net.UDPAddrFromAddrPort(destination)))
+	})
+	conn.DeliverTo(payload, net.UDPAddrFromAddrPort(destination))
+	if created {
+		handler.HandleUDP(conn)
+	}
+}
+
+// udpWriteBack writes the replies of a UDP session to the device as packets from the address
+// they come from, the first destination of the session if unknown
+type udpWriteBack struct {
+	device      tun.Device
+	source      netip.AddrPort
+	destination netip.AddrPort
+}
+
+func (w *udpWriteBack) WriteBack(b []byte, addr net.Addr) (int, error) {
+	from := w.destination
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr != nil {
+		if ap := udpAddr.AddrPort(); ap.Addr().IsValid() {
+			from = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+		}
+	}
+	if from.Addr().Is4() != w.source.Addr().Is4() {
+		// the client cannot receive packets of the other family, drop the reply
+		return len(b), nil
+	}
+	if _, err := w.device.Write(buildUDPPacket(from, w.source, b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,111 @@
+package stack
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// tcpNatPortMin is the first port allocated to NATed TCP flows
+	tcpNatPortMin = 10000
+	// tcpNatTimeout is how long a NATed TCP flow may stay idle before it is forgotten
+	tcpNatTimeout = 10 * time.Minute
+)
+
+var errTCPNatFull = errors.New("tcp nat table full")
+
+// tcpSession is a TCP flow NATed to the local listener of the system stack
+type tcpSession struct {
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+	lastActive  atomic.Int64
+}
+
+func (s *tcpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+type tcpFlow struct {
+	source      netip.AddrPort
+	destination netip.AddrPort
+}
+
+// tcpNat maps TCP flows of the TUN device to the ports they use towards the local listener
+type tcpNat struct {
+	mu        sync.RWMutex
+	portIndex uint16
+	flows     map[tcpFlow]uint16
+	sessions  map[uint16]*tcpSession
+}
+
+func newTCPNat() *tcpNat {
+	return &tcpNat{
+		portIndex: tcpNatPortMin,
+		flows:     make(map[tcpFlow]uint16),
+		sessions:  make(map[uint16]*tcpSession),
+	}
+}
+
+// Lookup returns the port of the flow from source to destination, allocating one if needed
+func (n *tcpNat) Lookup(source, destination netip.AddrPort) (uint16, error) {
+	flow := tcpFlow{source: source, destination: destination}
+	n.mu.RLock()
+	port, ok := n.flows[flow]
+	if ok {
+		n.sessions[port].touch()
+	}
+	n.mu.RUnlock()
+	if ok {
+		return port, nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if port, ok := n.flows[flow]; ok {
+		return port, nil
+	}
+	for range 65536 - tcpNatPortMin {
+		port = n.portIndex
+		if n.portIndex == 65535 {
+			n.portIndex = tcpNatPortMin
+		} else {
+			n.portIndex++
+		}
+		if _, used := n.sessions[port]; used {
+			continue
+		}
+		session := &tcpSession{Source: source, Destination: destination}
+		session.touch()
+		n.flows[flow] = port
+		n.sessions[port] = session
+		return port, nil
+	}
+	return 0, errTCPNatFull
+}
+
+// LookupBack returns the flow that was allocated port, or nil if there is none
+func (n *tcpNat) LookupBack(port uint16) *tcpSession {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	session := n.sessions[port]
+	if session != nil {
+		session.touch()
+	}
+	return session
+}
+
+// cleanup forgets the flows idle for longer than tcpNatTimeout
+func (n *tcpNat) cleanup() {
+	deadline := time.Now().Add(-tcpNatTimeout).UnixNano()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for port, session := range n.sessions {
+		if session.lastActive.Load() < deadline {
+			delete(n.sessions, port)
+			delete(n.flows, tcpFlow{source: session.Source, destination: session.Destination})
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package h2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// DefaultServiceName is the gRPC service of the tunnel when none is configured
+const DefaultServiceName = "GunService"
+
+var ErrBadGRPCMessage = errors.New("bad grpc message")
+
+// GRPCConfig is the configuration of a gRPC stream
+type GRPCConfig struct {
+	// Host is the authority of the request
+	Host        string
+	ServiceName string
+}
+
+// GRPCPath returns the path of the Tun method of the gRPC service
+func GRPCPath(serviceName string) string {
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	return "/" + serviceName + "/Tun"
+}
+
+// GRPCStreamConn returns a stream carried by the messages of a bidirectional gRPC call over
+// conn, compatible with the gun protocol of V2Ray based servers
+func GRPCStreamConn(ctx context.Context, conn net.Conn, config *GRPCConfig) (net.Conn, error) {
+	stream, err := streamConn(ctx, conn, http.MethodPost, &Config{
+		Host: config.Host,
+		Path: GRPCPath(config.ServiceName),
+		Headers: http.Header{
+			"Content-Type": {"application/grpc"},
+			"Te":           {"trailers"},
+			"User-Agent":   {"grpc-go/1.64.0"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newGRPCConn(stream), nil
+}
+
+// GRPCServerConn answers the gRPC call r and returns the stream carried by its messages
+func GRPCServerConn(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	stream, err := serverConn(w, r, http.Header{"Content-Type": {"application/grpc"}})
+	if err != nil {
+		return nil, err
+	}
+	return newGRPCConn(stream), nil
+}
+
+// grpcConn frames what is written into gRPC messages holding a protobuf message with a single
+// bytes field, and reads the payload of such messages
+type grpcConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	rmu sync.Mutex
+	// remaining is the size of the payload of the current message not read yet
+	remaining int
+
+	wmu sync.Mutex
+}
+
+func newGRPCConn(stream net.Conn) *grpcConn {
+	return &grpcConn{Conn: stream, reader: bufio.NewReader(stream)}
+}
+
+func (c *grpcConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for c.remaining == 0 {
+		if err := c.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.reader.Read(b[:min(len(b), c.remaining)])
+	c.remaining -= n
+	return n, err
+}
+
+// readHeader reads the gRPC and protobuf headers of the next message
+func (c *grpcConn) readHeader() error {
+	// compressed flag | length
+	var header [5]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint32(header[1:]))
+	if length == 0 {
+		return nil
+	}
+	// field 1 with wire type 2 | varint length
+	tag, err := c.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if tag != 0x0a {
+		return ErrBadGRPCMessage
+	}
+	size, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return err
+	}
+	if 1+uvarintLen(size)+int(size) != length {
+		return ErrBadGRPCMessage
+	}
+	c.remaining = int(size)
+	return nil
+}
+
+func (c *grpcConn) Write(b []byte) (int, error) {
+	protoLength := 1 + uvarintLen(uint64(len(b))) + len(b)
+	out := make([]byte, 0, 5+protoLength)
+	out = append(out, 0)
+	out = binary.BigEndian.AppendUint32(out, uint32(protoLength))
+	out = append(out, 0x0a)
+	out = binary.AppendUvarint(out, uint64(len(b)))
+	out = append(out, b...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func uvarintLen(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
@@ -0,0 +1,208 @@
+package h2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Config is the configuration of an HTTP/2 stream
+type Config struct {
+	// Host is the authority of the request
+	Host    string
+	Path    string
+	Headers http.Header
+}
+
+// StreamConn returns a bidirectional HTTP/2 stream over conn, on which the server must speak
+// HTTP/2, usually after negotiating h2 with TLS ALPN. The request body carries what is
+// written and the response body what is read
+func StreamConn(ctx context.Context, conn net.Conn, config *Config) (net.Conn, error) {
+	return streamConn(ctx, conn, http.MethodPut, config)
+}
+
+func streamConn(ctx context.Context, conn net.Conn, method string, config *Config) (net.Conn, error) {
+	cc, err := (&http2.Transport{}).NewClientConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	path := config.Path
+	if path == "" {
+		path = "/"
+	}
+	pr, pw := io.Pipe()
+	req := &http.Request{
+		Method:        method,
+		URL:           &url.URL{Scheme: "https", Host: config.Host, Path: path},
+		Host:          config.Host,
+		Header:        config.Headers.Clone(),
+		Body:          pr,
+		ContentLength: -1,
+		Proto:         "HTTP/2",
+		ProtoMajor:    2,
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+
+	// the stream must outlive ctx, which only bounds waiting for the response
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := cc.RoundTrip(req)
+		done <- result{resp, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		cc.Close()
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		cc.Close()
+		return nil, r.err
+	}
+	if r.resp.StatusCode != http.StatusOK {
+		r.resp.Body.Close()
+		cc.Close()
+		return nil, fmt.Errorf("http2 stream: %s", r.resp.Status)
+	}
+	return &clientConn{raw: conn, cc: cc, body: r.resp.Body, pw: pw}, nil
+}
+
+// clientConn is the client side of an HTTP/2 stream
+type clientConn struct {
+	raw  net.Conn
+	cc   *http2.ClientConn
+	body io.ReadCloser
+	pw   *io.PipeWriter
+}
+
+func (c *clientConn) Read(b []byte) (int, error) {
+	return c.body.Read(b)
+}
+
+func (c *clientConn) Write(b []byte) (int, error) {
+	return c.pw.Write(b)
+}
+
+func (c *clientConn) Close() error {
+	c.pw.Close()
+	c.body.Close()
+	return c.cc.Close()
+}
+
+func (c *clientConn) LocalAddr() net.Addr {
+	return c.raw.LocalAddr()
+}
+
+func (c *clientConn) RemoteAddr() net.Addr {
+	return c.raw.RemoteAddr()
+}
+
+// SetDeadline sets the deadlines of the underlying connection, which only carries this stream
+func (c *clientConn) SetDeadline(t time.Time) error {
+	return c.raw.SetDeadline(t)
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	return c.raw.SetReadDeadline(t)
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	return c.raw.SetWriteDeadline(t)
+}
+
+// ServerConn answers the HTTP/2 stream request r and returns the stream. It must be used
+// before the handler of r returns
+func ServerConn(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	return serverConn(w, r, nil)
+}
+
+func serverConn(w http.ResponseWriter, r *http.Request, header http.Header) (net.Conn, error) {
+	if r.ProtoMajor != 2 {
+		return nil, fmt.Errorf("http2 stream: unexpected %s request", r.Proto)
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+	return &serverStream{r: r, w: w, rc: rc}, nil
+}
+
+// serverStream is the server side of an HTTP/2 stream
+type serverStream struct {
+	r  *http.Request
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	wmu sync.Mutex
+}
+
+func (s *serverStream) Read(b []byte) (int, error) {
+	return s.r.Body.Read(b)
+}
+
+func (s *serverStream) Write(b []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	n, err := s.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, s.rc.Flush()
+}
+
+func (s *serverStream) Close() error {
+	return s.r.Body.Close()
+}
+
+func (s *serverStream) LocalAddr() net.Addr {
+	return addr(s.r.Context().Value(http.LocalAddrContextKey))
+}
+
+func (s *serverStream) RemoteAddr() net.Addr {
+	remote, err := net.ResolveTCPAddr("tcp", s.r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return remote
+}
+
+func (s *serverStream) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
+
+func (s *serverStream) SetReadDeadline(t time.Time) error {
+	return s.rc.SetReadDeadline(t)
+}
+
+func (s *serverStream) SetWriteDeadline(t time.Time) error {
+	return s.rc.SetWriteDeadline(t)
+}
+
+// addr returns v if it is a net.Addr, or an empty TCP address
+func addr(v any) net.Addr {
+	if a, ok := v.(net.Addr); ok && a != nil {
+		return a
+	}
+	return &net.TCPAddr{}
+}
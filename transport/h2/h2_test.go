@@ -0,0 +1,103 @@
+package h2
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer starts an HTTP/2 server over TLS echoing the streams it accepts
+func startServer(t *testing.T, accept func(http.ResponseWriter, *http.Request) (net.Conn, error)) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := accept(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialTLS opens a TLS connection negotiating h2 to server
+func dialTLS(t *testing.T, server *httptest.Server) net.Conn {
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol)
+	return conn
+}
+
+// echo writes through conn and checks the answer
+func echo(t *testing.T, conn net.Conn, payload string) {
+	_, err := conn.Write([]byte(payload))
+	require.NoError(t, err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(buf))
+}
+
+func TestStreamConn(t *testing.T) {
+	paths := make(chan string, 1)
+	server := startServer(t, func(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+		paths <- r.Host + r.URL.Path
+		return ServerConn(w, r)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := StreamConn(ctx, dialTLS(t, server), &Config{Host: "example.com", Path: "/tunnel"})
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.Equal(t, "example.com/tunnel", <-paths)
+
+	echo(t, conn, "ping")
+	echo(t, conn, strings.Repeat("x", 100*1024))
+}
+
+func TestGRPCStreamConn(t *testing.T) {
+	paths := make(chan string, 1)
+	server := startServer(t, func(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+		paths <- r.URL.Path
+		if r.Header.Get("Content-Type") != "application/grpc" {
+			return nil, ErrBadGRPCMessage
+		}
+		return GRPCServerConn(w, r)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := GRPCStreamConn(ctx, dialTLS(t, server), &GRPCConfig{Host: "example.com", ServiceName: "luma"})
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.Equal(t, "/luma/Tun", <-paths)
+
+	echo(t, conn, "ping")
+	echo(t, conn, strings.Repeat("x", 100*1024))
+}
+
+func TestStreamConnRejected(t *testing.T) {
+	server := startServer(t, func(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+		return nil, ErrBadGRPCMessage
+	})
+	_, err := StreamConn(context.Background(), dialTLS(t, server), &Config{Host: "example.com"})
+	assert.ErrorContains(t, err, "400")
+}
@@ -0,0 +1,275 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+)
+
+const (
+	defaultMaxConnections = 4
+	defaultMinStreams     = 4
+	defaultIdleTimeout    = 60 * time.Second
+)
+
+var ErrClientClosed = errors.New("mux client closed")
+
+// Options are the settings of a multiplexing client
+type Options struct {
+	Protocol Protocol
+	// MaxConnections is the number of underlying connections opened before streams are spread
+	// over the existing ones
+	MaxConnections int
+	// MinStreams is the number of streams a connection carries before another one is opened
+	MinStreams int
+	// MaxStreams is the maximum number of streams of a connection. If set, it replaces
+	// MaxConnections and MinStreams and a connection is opened whenever all are full
+	MaxStreams int
+	// Padding pads the first frames of each connection
+	Padding bool
+	// IdleTimeout is how long a connection without streams is kept open
+	IdleTimeout time.Duration
+}
+
+// Stats is the usage of the connection pool of a client
+type Stats struct {
+	// Sessions and Streams are the open underlying connections and streams
+	Sessions int
+	Streams  int
+	// TotalSessions and TotalStreams count all connections and streams opened by the client
+	TotalSessions uint64
+	TotalStreams  uint64
+}
+
+// Client multiplexes streams over a pool of underlying connections
+type Client struct {
+	dial    func(context.Context) (net.Conn, error)
+	options Options
+
+	mu       sync.Mutex
+	sessions []*clientSession
+	// dialing is the number of connections being opened
+	dialing int
+	closed  bool
+	done    chan struct{}
+
+	totalSessions atomic.Uint64
+	totalStreams  atomic.Uint64
+}
+
+type clientSession struct {
+	session
+	// lastUsed is when the last stream was opened or closed, guarded by Client.mu
+	lastUsed time.Time
+}
+
+// NewClient returns a client opening underlying connections with dial
+func NewClient(dial func(context.Context) (net.Conn, error), options Options) *Client {
+	if options.MaxConnections <= 0 {
+		options.MaxConnections = defaultMaxConnections
+	}
+	if options.MinStreams <= 0 {
+		options.MinStreams = defaultMinStreams
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = defaultIdleTimeout
+	}
+	c := &Client{dial: dial, options: options, done: make(chan struct{})}
+	go c.evictIdle()
+	return c
+}
+
+// DialContext opens a stream to target
+func (c *Client) DialContext(ctx context.Context, target M.SocksAddr) (net.Conn, error) {
+	s, err := c.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.Open()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	request := make([]byte, 0, 1+len(target))
+	request = append(request, networkTCP)
+	request = append(request, target...)
+	if _, err := stream.Write(request); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	c.totalStreams.Add(1)
+	return &streamConn{Conn: stream, client: c, session: s}, nil
+}
+
+// pick returns the session the next stream is opened on, opening a new one if needed
+func (c *Client) pick(ctx context.Context) (*clientSession, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.removeClosed()
+	if s := c.selectSession(); s != nil {
+		s.lastUsed = time.Now()
+		c.mu.Unlock()
+		return s, nil
+	}
+	c.dialing++
+	c.mu.Unlock()
+
+	s, err := c.open(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialing--
+	if err != nil {
+		return nil, err
+	}
+	if c.closed {
+		s.Close()
+		return nil, ErrClientClosed
+	}
+	c.sessions = append(c.sessions, s)
+	return s, nil
+}
+
+// selectSession returns an open session with room for a stream, or nil if a new one should be
+// opened. It must be called with mu held
+func (c *Client) selectSession() *clientSession {
+	var least *clientSession
+	for _, s := range c.sessions {
+		if least == nil || s.NumStreams() < least.NumStreams() {
+			least = s
+		}
+	}
+	if c.options.MaxStreams > 0 {
+		if least != nil && least.NumStreams() < c.options.MaxStreams {
+			return least
+		}
+		return nil
+	}
+	if least == nil {
+		return nil
+	}
+	if least.NumStreams() >= c.options.MinStreams && len(c.sessions)+c.dialing < c.options.MaxConnections {
+		return nil
+	}
+	return least
+}
+
+// open opens an underlying connection and starts a session over it
+func (c *Client) open(ctx context.Context) (*clientSession, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var flags byte
+	if c.options.Padding {
+		flags |= flagPadding
+	}
+	if _, err := conn.Write([]byte{version, byte(c.options.Protocol), flags}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.options.Padding {
+		conn = newPaddingConn(conn)
+	}
+	s, err := newSession(conn, c.options.Protocol, true)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.totalSessions.Add(1)
+	log.Debugf("[Mux] opened %s session to %s", c.options.Protocol, conn.RemoteAddr())
+	return &clientSession{session: s, lastUsed: time.Now()}, nil
+}
+
+// removeClosed drops the sessions closed by the server or a failure. It must be called with
+// mu held
+func (c *Client) removeClosed() {
+	sessions := c.sessions[:0]
+	for _, s := range c.sessions {
+		if !s.IsClosed() {
+			sessions = append(sessions, s)
+		}
+	}
+	clear(c.sessions[len(sessions):])
+	c.sessions = sessions
+}
+
+// evictIdle periodically closes the sessions without streams for longer than the idle timeout
+func (c *Client) evictIdle() {
+	ticker := time.NewTicker(max(c.options.IdleTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			for _, s := range c.sessions {
+				if s.NumStreams() == 0 && now.Sub(s.lastUsed) >= c.options.IdleTimeout {
+					log.Debugf("[Mux] closing idle %s session", c.options.Protocol)
+					s.Close()
+				}
+			}
+			c.removeClosed()
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Stats returns the usage of the connection pool
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := Stats{TotalSessions: c.totalSessions.Load(), TotalStreams: c.totalStreams.Load()}
+	for _, s := range c.sessions {
+		if s.IsClosed() {
+			continue
+		}
+		stats.Sessions++
+		stats.Streams += s.NumStreams()
+	}
+	return stats
+}
+
+// Close closes all sessions and their streams
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	for _, s := range c.sessions {
+		s.Close()
+	}
+	c.sessions = nil
+	return nil
+}
+
+// streamConn is a stream of a client session
+type streamConn struct {
+	net.Conn
+	client  *Client
+	session *clientSession
+	once    sync.Once
+}
+
+func (c *streamConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.client.mu.Lock()
+		c.session.lastUsed = time.Now()
+		c.client.mu.Unlock()
+	})
+	return err
+}
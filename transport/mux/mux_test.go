@@ -0,0 +1,148 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer starts a mux server echoing the streams after writing their destination, and
+// returns a client dialing it
+func startServer(t *testing.T, options Options) *Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ServeConn(c, func(stream net.Conn, target M.SocksAddr) {
+				defer stream.Close()
+				stream.Write([]byte(target.String() + "\n"))
+				io.Copy(stream, stream)
+			})
+		}
+	}()
+
+	client := NewClient(func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", l.Addr().String())
+	}, options)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// testStream opens a stream to target and checks its echo
+func testStream(t *testing.T, client *Client, target string) net.Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.DialContext(ctx, M.ParseSocksAddr(target))
+	require.NoError(t, err)
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	header := make([]byte, len(target)+1)
+	_, err = io.ReadFull(c, header)
+	require.NoError(t, err)
+	assert.Equal(t, target+"\n", string(header))
+
+	payload := make([]byte, 100*1024)
+	rand.Read(payload)
+	go c.Write(payload)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(payload, buf))
+	return c
+}
+
+func TestClient(t *testing.T) {
+	for _, options := range []Options{
+		{Protocol: ProtocolSmux},
+		{Protocol: ProtocolYamux},
+		{Protocol: ProtocolSmux, Padding: true},
+		{Protocol: ProtocolYamux, Padding: true},
+	} {
+		t.Run(options.Protocol.String(), func(t *testing.T) {
+			client := startServer(t, options)
+			first := testStream(t, client, "example.com:443")
+			defer first.Close()
+			second := testStream(t, client, "1.2.3.4:80")
+			defer second.Close()
+
+			stats := client.Stats()
+			assert.Equal(t, 1, stats.Sessions)
+			assert.Equal(t, 2, stats.Streams)
+			assert.Equal(t, uint64(1), stats.TotalSessions)
+			assert.Equal(t, uint64(2), stats.TotalStreams)
+		})
+	}
+}
+
+func TestClientPool(t *testing.T) {
+	client := startServer(t, Options{MaxConnections: 2, MinStreams: 2})
+	for range 6 {
+		c := testStream(t, client, "example.com:443")
+		defer c.Close()
+	}
+	stats := client.Stats()
+	assert.Equal(t, 2, stats.Sessions)
+	assert.Equal(t, 6, stats.Streams)
+
+	client = startServer(t, Options{MaxStreams: 2})
+	for range 5 {
+		c := testStream(t, client, "example.com:443")
+		defer c.Close()
+	}
+	assert.Equal(t, 3, client.Stats().Sessions)
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	client := startServer(t, Options{IdleTimeout: 100 * time.Millisecond})
+	c := testStream(t, client, "example.com:443")
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 1, client.Stats().Sessions)
+
+	c.Close()
+	assert.Eventually(t, func() bool { return client.Stats().Sessions == 0 }, 2*time.Second, 50*time.Millisecond)
+
+	c = testStream(t, client, "example.com:443")
+	defer c.Close()
+	assert.Equal(t, uint64(2), client.Stats().TotalSessions)
+}
+
+func TestPaddingConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	payload := make([]byte, 200*1024)
+	rand.Read(payload)
+	go func() {
+		pc := newPaddingConn(client)
+		for b := payload; len(b) > 0; b = b[min(len(b), 1000):] {
+			pc.Write(b[:min(len(b), 1000)])
+		}
+	}()
+	buf := make([]byte, len(payload))
+	_, err := io.ReadFull(newPaddingConn(server), buf)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(payload, buf))
+}
+
+func TestServeConnVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte{1, byte(ProtocolSmux), 0})
+	err := ServeConn(server, func(net.Conn, M.SocksAddr) {})
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
@@ -0,0 +1,105 @@
+package mux
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+)
+
+const (
+	// paddingFrames is the number of writes in each direction padded, which hides the sizes of
+	// the handshakes of the first streams
+	paddingFrames = 16
+	// maxPadding is the maximum padding of a frame
+	maxPadding = 256
+	// maxFrameData is the maximum data of a padded frame
+	maxFrameData = 0xffff
+)
+
+// paddingConn frames the first writes with random padding and strips it from the first
+// reads. A frame is data length | padding length | data | padding
+type paddingConn struct {
+	net.Conn
+
+	rmu        sync.Mutex
+	readFrames int
+	// readRemaining and readPadding are what is left of the current frame
+	readRemaining int
+	readPadding   int
+
+	wmu         sync.Mutex
+	writeFrames int
+}
+
+func newPaddingConn(conn net.Conn) *paddingConn {
+	return &paddingConn{Conn: conn}
+}
+
+func (c *paddingConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for {
+		if c.readRemaining > 0 {
+			n, err := c.Conn.Read(b[:min(len(b), c.readRemaining)])
+			c.readRemaining -= n
+			if err == nil && c.readRemaining == 0 {
+				err = c.discardPadding()
+			}
+			return n, err
+		}
+		if c.readFrames >= paddingFrames {
+			return c.Conn.Read(b)
+		}
+
+		var header [4]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		c.readFrames++
+		c.readRemaining = int(binary.BigEndian.Uint16(header[:2]))
+		c.readPadding = int(binary.BigEndian.Uint16(header[2:]))
+		if c.readRemaining == 0 {
+			if err := c.discardPadding(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// discardPadding skips the padding of the current frame
+func (c *paddingConn) discardPadding() error {
+	_, err := io.CopyN(io.Discard, c.Conn, int64(c.readPadding))
+	c.readPadding = 0
+	return err
+}
+
+func (c *paddingConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var written int
+	for len(b) > 0 && c.writeFrames < paddingFrames {
+		n := min(len(b), maxFrameData)
+		padding, _ := rand.Int(rand.Reader, big.NewInt(maxPadding))
+		paddingLen := int(padding.Int64())
+
+		frame := make([]byte, 4+n+paddingLen)
+		binary.BigEndian.PutUint16(frame, uint16(n))
+		binary.BigEndian.PutUint16(frame[2:], uint16(paddingLen))
+		copy(frame[4:], b[:n])
+		rand.Read(frame[4+n:])
+		if _, err := c.Conn.Write(frame); err != nil {
+			return written, err
+		}
+		c.writeFrames++
+		written += n
+		b = b[n:]
+	}
+	if len(b) == 0 {
+		return written, nil
+	}
+	n, err := c.Conn.Write(b)
+	return written + n, err
+}
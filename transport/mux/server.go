@@ -0,0 +1,76 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+const (
+	// DestinationHost and DestinationPort are the destination an outbound is asked to connect to
+	// for an underlying connection, which the server detects with IsDestination
+	DestinationHost = "sp.mux.luma.arpa"
+	DestinationPort = 444
+
+	version     = 0
+	flagPadding = 1 << 0
+	networkTCP  = 0
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported mux version")
+
+// IsDestination reports whether target is the destination of multiplexed connections
+func IsDestination(target M.SocksAddr) bool {
+	return target.String() == net.JoinHostPort(DestinationHost, strconv.Itoa(DestinationPort))
+}
+
+// ServeConn serves the session started by a client over conn, calling handle in a new
+// goroutine for each stream with the destination of the stream. It returns when the session
+// ends
+func ServeConn(conn net.Conn, handle func(stream net.Conn, target M.SocksAddr)) error {
+	defer conn.Close()
+	var header [3]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[0] != version {
+		return fmt.Errorf("%w %d", ErrUnsupportedVersion, header[0])
+	}
+	if header[2]&flagPadding != 0 {
+		conn = newPaddingConn(conn)
+	}
+	s, err := newSession(conn, Protocol(header[1]), false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	for {
+		stream, err := s.Accept()
+		if err != nil {
+			if s.IsClosed() {
+				return nil
+			}
+			return err
+		}
+		go serveStream(stream, handle)
+	}
+}
+
+// serveStream reads the request of stream and hands it to handle
+func serveStream(stream net.Conn, handle func(net.Conn, M.SocksAddr)) {
+	var network [1]byte
+	if _, err := io.ReadFull(stream, network[:]); err != nil || network[0] != networkTCP {
+		stream.Close()
+		return
+	}
+	target, err := M.ReadSocksAddr(stream)
+	if err != nil {
+		stream.Close()
+		return
+	}
+	handle(stream, target)
+}
@@ -0,0 +1,106 @@
+package mux
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/hashicorp/yamux"
+	"github.com/xtaci/smux"
+)
+
+// Protocol is the multiplexing protocol of a session
+type Protocol byte
+
+const (
+	ProtocolSmux  Protocol = 0
+	ProtocolYamux Protocol = 1
+)
+
+// ParseProtocol returns the protocol named s, smux if empty
+func ParseProtocol(s string) (Protocol, error) {
+	switch s {
+	case "", "smux":
+		return ProtocolSmux, nil
+	case "yamux":
+		return ProtocolYamux, nil
+	default:
+		return 0, fmt.Errorf("unsupported multiplexing protocol %q", s)
+	}
+}
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolSmux:
+		return "smux"
+	case ProtocolYamux:
+		return "yamux"
+	default:
+		return fmt.Sprintf("protocol(%d)", byte(p))
+	}
+}
+
+// session is a multiplexed session of either protocol
+type session interface {
+	Open() (net.Conn, error)
+	Accept() (net.Conn, error)
+	NumStreams() int
+	IsClosed() bool
+	Close() error
+}
+
+// newSession starts a session of protocol over conn
+func newSession(conn net.Conn, protocol Protocol, isClient bool) (session, error) {
+	switch protocol {
+	case ProtocolSmux:
+		config := smux.DefaultConfig()
+		config.Version = 2
+		var s *smux.Session
+		var err error
+		if isClient {
+			s, err = smux.Client(conn, config)
+		} else {
+			s, err = smux.Server(conn, config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return smuxSession{s}, nil
+	case ProtocolYamux:
+		config := yamux.DefaultConfig()
+		config.LogOutput = io.Discard
+		var s *yamux.Session
+		var err error
+		if isClient {
+			s, err = yamux.Client(conn, config)
+		} else {
+			s, err = yamux.Server(conn, config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported multiplexing protocol %s", protocol)
+	}
+}
+
+type smuxSession struct {
+	*smux.Session
+}
+
+func (s smuxSession) Open() (net.Conn, error) {
+	stream, err := s.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s smuxSession) Accept() (net.Conn, error) {
+	stream, err := s.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
@@ -0,0 +1,141 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/log"
+	quicgo "github.com/quic-go/quic-go"
+)
+
+var ErrClientClosed = errors.New("quic client closed")
+
+// Client opens streams and datagram sessions over a QUIC connection to a server, which is
+// reopened when lost
+type Client struct {
+	resolve   func(ctx context.Context) (*net.UDPAddr, error)
+	listen    func(ctx context.Context) (net.PacketConn, error)
+	tlsConfig *tls.Config
+	config    *quicgo.Config
+
+	mu     sync.Mutex
+	conn   *quicgo.Conn
+	closed bool
+	// sessions are the datagram sessions of conn
+	sessions map[uint32]*PacketConn
+	nextID   uint32
+}
+
+// NewClient returns a client connecting to the address returned by resolve. The ALPN of
+// tlsConfig is replaced by the one of the transport
+func NewClient(resolve func(ctx context.Context) (*net.UDPAddr, error), tlsConfig *tls.Config, options Options) (*Client, error) {
+	config, err := options.config()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{ALPN}
+	listen := options.ListenPacket
+	if listen == nil {
+		listen = func(ctx context.Context) (net.PacketConn, error) {
+			return dialer.ListenPacket(ctx, "udp", "")
+		}
+	}
+	return &Client{resolve: resolve, listen: listen, tlsConfig: tlsConfig, config: config}, nil
+}
+
+// DialContext opens a stream
+func (c *Client) DialContext(ctx context.Context) (net.Conn, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{Stream: stream, conn: conn}, nil
+}
+
+// ListenPacket opens a datagram session
+func (c *Client) ListenPacket(ctx context.Context) (*PacketConn, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		// the connection was lost meanwhile
+		return nil, net.ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	pc := newPacketConn(conn, id, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn == conn {
+			delete(c.sessions, id)
+		}
+	})
+	c.sessions[id] = pc
+	return pc, nil
+}
+
+// connect returns the connection to the server, opening it if needed
+func (c *Client) connect(ctx context.Context) (*quicgo.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if c.conn != nil && c.conn.Context().Err() == nil {
+		return c.conn, nil
+	}
+
+	addr, err := c.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := quicgo.Dial(ctx, pc, addr, c.tlsConfig, c.config)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	log.Debugf("[QUIC] connected to %s", addr)
+	c.conn = conn
+	c.sessions = make(map[uint32]*PacketConn)
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+		log.Debugf("[QUIC] connection to %s closed: %v", addr, context.Cause(conn.Context()))
+	}()
+	go receiveDatagrams(conn, func(id uint32) *PacketConn {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn != conn {
+			return nil
+		}
+		return c.sessions[id]
+	})
+	return conn, nil
+}
+
+// Close closes the connection and its streams
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.CloseWithError(0, "")
+}
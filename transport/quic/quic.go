@@ -0,0 +1,313 @@
+// Package quic carries the streams of an outbound over QUIC streams of a shared connection
+// and its packets over QUIC datagrams
+package quic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+// ALPN is the protocol negotiated by the transport
+const ALPN = "h3"
+
+const (
+	// headerLen is the size of the header of datagrams: the session ID, the packet ID, the
+	// index of the fragment and the number of fragments of the packet
+	headerLen = 8
+	// packetQueueSize is the number of packets queued for a packet connection
+	packetQueueSize = 64
+)
+
+// Options are the settings of the QUIC connection, whose congestion control is NewReno
+type Options struct {
+	// IdleTimeout closes the connection after that long without activity
+	IdleTimeout time.Duration
+	// KeepAlivePeriod is the period of keep-alive packets, none if zero
+	KeepAlivePeriod time.Duration
+	// ListenPacket opens the socket of a client connection, dialer.ListenPacket if nil
+	ListenPacket func(ctx context.Context) (net.PacketConn, error)
+}
+
+// config returns the quic-go configuration of the options
+func (o Options) config() (*quicgo.Config, error) {
+	return &quicgo.Config{
+		MaxIdleTimeout:  o.IdleTimeout,
+		KeepAlivePeriod: o.KeepAlivePeriod,
+		EnableDatagrams: true,
+	}, nil
+}
+
+// streamConn is a QUIC stream as a net.Conn
+type streamConn struct {
+	*quicgo.Stream
+	conn *quicgo.Conn
+}
+
+func (c *streamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// PacketConn is a session of datagrams over a QUIC connection. The destination of writes is
+// ignored, every packet goes to the peer. Packets larger than a datagram are fragmented
+type PacketConn struct {
+	conn *quicgo.Conn
+	id   uint32
+	in   chan []byte
+	// packetID numbers the packets written
+	packetID atomic.Uint32
+	// defrag reassembles the fragmented packets received, only used by receiveDatagrams
+	defrag defragmenter
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	onClose   func()
+
+	readDeadline deadline
+}
+
+func newPacketConn(conn *quicgo.Conn, id uint32, onClose func()) *PacketConn {
+	return &PacketConn{
+		conn:         conn,
+		id:           id,
+		in:           make(chan []byte, packetQueueSize),
+		closed:       make(chan struct{}),
+		onClose:      onClose,
+		readDeadline: makeDeadline(),
+	}
+}
+
+// deliver queues the datagram payload p, dropping it if the queue is full
+func (pc *PacketConn) deliver(p []byte) {
+	select {
+	case pc.in <- p:
+	default:
+	}
+}
+
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-pc.in:
+		return copy(b, p), pc.conn.RemoteAddr(), nil
+	case <-pc.closed:
+		return 0, nil, net.ErrClosed
+	case <-pc.conn.Context().Done():
+		return 0, nil, context.Cause(pc.conn.Context())
+	case <-pc.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (pc *PacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	select {
+	case <-pc.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	packetID := uint16(pc.packetID.Add(1))
+	err := pc.conn.SendDatagram(pc.datagram(packetID, 0, 1, b))
+	var tooLarge *quicgo.DatagramTooLargeError
+	if !errors.As(err, &tooLarge) {
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	// the packet exceeds the datagram size of the path, send it in fragments
+	size := int(tooLarge.MaxDatagramPayloadSize) - headerLen
+	if size <= 0 {
+		return 0, err
+	}
+	count := (len(b) + size - 1) / size
+	if count > math.MaxUint8 {
+		return 0, err
+	}
+	for i := range count {
+		fragment := b[i*size : min((i+1)*size, len(b))]
+		if err := pc.conn.SendDatagram(pc.datagram(packetID, uint8(i), uint8(count), fragment)); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// datagram returns the datagram carrying fragment index of the count ones of packet packetID
+func (pc *PacketConn) datagram(packetID uint16, index, count uint8, fragment []byte) []byte {
+	datagram := make([]byte, headerLen+len(fragment))
+	binary.BigEndian.PutUint32(datagram, pc.id)
+	binary.BigEndian.PutUint16(datagram[4:], packetID)
+	datagram[6] = index
+	datagram[7] = count
+	copy(datagram[headerLen:], fragment)
+	return datagram
+}
+
+func (pc *PacketConn) Close() error {
+	pc.closeOnce.Do(func() {
+		close(pc.closed)
+		if pc.onClose != nil {
+			pc.onClose()
+		}
+	})
+	return nil
+}
+
+func (pc *PacketConn) LocalAddr() net.Addr {
+	return pc.conn.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer
+func (pc *PacketConn) RemoteAddr() net.Addr {
+	return pc.conn.RemoteAddr()
+}
+
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	return pc.SetReadDeadline(t)
+}
+
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	pc.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing, datagrams are sent without blocking
+func (pc *PacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// receiveDatagrams hands the packets of the datagrams of conn to the packet connection of their
+// session returned by lookup until conn is closed
+func receiveDatagrams(conn *quicgo.Conn, lookup func(id uint32) *PacketConn) {
+	for {
+		datagram, err := conn.ReceiveDatagram(conn.Context())
+		if err != nil {
+			return
+		}
+		if len(datagram) < headerLen {
+			continue
+		}
+		pc := lookup(binary.BigEndian.Uint32(datagram))
+		if pc == nil {
+			continue
+		}
+		packetID, index, count := binary.BigEndian.Uint16(datagram[4:]), datagram[6], datagram[7]
+		if count == 1 {
+			pc.deliver(datagram[headerLen:])
+		} else if packet := pc.defrag.add(packetID, index, count, datagram[headerLen:]); packet != nil {
+			pc.deliver(packet)
+		}
+	}
+}
+
+// defragmenter reassembles the packet being received. Only the packet of the last fragment
+// received is kept, packets whose fragments interleave are dropped
+type defragmenter struct {
+	packetID  uint16
+	fragments [][]byte
+	received  int
+	size      int
+}
+
+// add adds fragment index of the count ones of packet packetID and returns the packet once
+// complete
+func (d *defragmenter) add(packetID uint16, index, count uint8, fragment []byte) []byte {
+	if index >= count {
+		return nil
+	}
+	if d.fragments == nil || d.packetID != packetID || len(d.fragments) != int(count) {
+		d.packetID = packetID
+		d.fragments = make([][]byte, count)
+		d.received = 0
+		d.size = 0
+	}
+	if d.fragments[index] != nil {
+		return nil
+	}
+	d.fragments[index] = fragment
+	d.received++
+	d.size += len(fragment)
+	if d.received < len(d.fragments) {
+		return nil
+	}
+	packet := make([]byte, 0, d.size)
+	for _, fragment := range d.fragments {
+		packet = append(packet, fragment...)
+	}
+	d.fragments = nil
+	return packet
+}
+
+// deadline is a deadline which can be changed while being waited for
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline to t, no deadline if zero
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer fired, wait on a new channel
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel closed when the deadline is exceeded
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,170 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lumavpn/luma/internal/testutil"
+)
+
+// startServer starts a server echoing streams and datagram sessions and returns a client
+// connected to it
+func startServer(t *testing.T, options Options) *Client {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	l, err := Listen(pc, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, options)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go testutil.ServeEcho(l)
+	go func() {
+		for {
+			pc, err := l.AcceptPacket()
+			if err != nil {
+				return
+			}
+			go testutil.ServeEchoPackets(pc)
+		}
+	}()
+
+	addr := l.Addr().(*net.UDPAddr)
+	client, err := NewClient(func(context.Context) (*net.UDPAddr, error) { return addr, nil }, &tls.Config{InsecureSkipVerify: true}, options)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestStreams(t *testing.T) {
+	client := startServer(t, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conn *quicgo.Conn
+	for range 3 {
+		c, err := client.DialContext(ctx)
+		require.NoError(t, err)
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+
+		payload := make([]byte, 200*1024)
+		rand.Read(payload)
+		go c.Write(payload)
+		buf := make([]byte, len(payload))
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(payload, buf))
+
+		// all streams share a connection
+		if conn != nil {
+			assert.Same(t, conn, client.conn)
+		}
+		conn = client.conn
+	}
+}
+
+func TestPackets(t *testing.T) {
+	client := startServer(t, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := client.ListenPacket(ctx)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := client.ListenPacket(ctx)
+	require.NoError(t, err)
+	defer second.Close()
+
+	for _, test := range []struct {
+		pc      *PacketConn
+		payload string
+	}{{first, "first"}, {second, "second"}} {
+		test.pc.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = test.pc.WriteTo([]byte(test.payload), nil)
+		require.NoError(t, err)
+		buf := make([]byte, 64)
+		n, _, err := test.pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, test.payload, string(buf[:n]))
+	}
+
+	first.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = first.ReadFrom(make([]byte, 64))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestReconnect(t *testing.T) {
+	client := startServer(t, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := client.DialContext(ctx)
+	require.NoError(t, err)
+	c.Close()
+	conn := client.conn
+	conn.CloseWithError(0, "")
+	<-conn.Context().Done()
+
+	c, err = client.DialContext(ctx)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.NotSame(t, conn, client.conn)
+}
+
+func TestLargePackets(t *testing.T) {
+	client := startServer(t, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pc, err := client.ListenPacket(ctx)
+	require.NoError(t, err)
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// packets larger than a datagram are fragmented both ways
+	for _, size := range []int{1400, 9000, 65000} {
+		payload := make([]byte, size)
+		rand.Read(payload)
+		_, err = pc.WriteTo(payload, nil)
+		require.NoError(t, err)
+		buf := make([]byte, 65535)
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(payload, buf[:n]), size)
+	}
+}
+
+func TestDefragmenter(t *testing.T) {
+	var d defragmenter
+	assert.Nil(t, d.add(1, 0, 2, []byte("ab")))
+	// a fragment of another packet drops the one being reassembled
+	assert.Nil(t, d.add(2, 1, 2, []byte("cd")))
+	assert.Nil(t, d.add(1, 1, 2, []byte("xx")))
+	assert.Nil(t, d.add(2, 1, 2, []byte("cd")))
+	assert.Nil(t, d.add(2, 2, 2, []byte("ef")))
+	assert.Equal(t, []byte("abcd"), d.add(2, 0, 2, []byte("ab")))
+}
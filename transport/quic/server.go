@@ -0,0 +1,140 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+// Listener accepts the streams and datagram sessions of clients
+type Listener struct {
+	listener *quicgo.Listener
+	streams  chan net.Conn
+	packets  chan *PacketConn
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu    sync.Mutex
+	conns map[*quicgo.Conn]struct{}
+}
+
+// Listen listens on the packet connection pc. The ALPN of tlsConfig is replaced by the one of
+// the transport
+func Listen(pc net.PacketConn, tlsConfig *tls.Config, options Options) (*Listener, error) {
+	config, err := options.config()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{ALPN}
+	listener, err := quicgo.Listen(pc, tlsConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{
+		listener: listener,
+		streams:  make(chan net.Conn),
+		packets:  make(chan *PacketConn),
+		ctx:      ctx,
+		cancel:   cancel,
+		conns:    make(map[*quicgo.Conn]struct{}),
+	}
+	go l.acceptConns()
+	return l, nil
+}
+
+func (l *Listener) acceptConns() {
+	for {
+		conn, err := l.listener.Accept(l.ctx)
+		if err != nil {
+			l.cancel()
+			return
+		}
+		l.mu.Lock()
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+		go func() {
+			<-conn.Context().Done()
+			l.mu.Lock()
+			delete(l.conns, conn)
+			l.mu.Unlock()
+		}()
+		go l.acceptStreams(conn)
+		go l.acceptPackets(conn)
+	}
+}
+
+func (l *Listener) acceptStreams(conn *quicgo.Conn) {
+	for {
+		stream, err := conn.AcceptStream(l.ctx)
+		if err != nil {
+			return
+		}
+		select {
+		case l.streams <- &streamConn{Stream: stream, conn: conn}:
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// acceptPackets starts a packet connection for each new session of conn
+func (l *Listener) acceptPackets(conn *quicgo.Conn) {
+	var mu sync.Mutex
+	sessions := make(map[uint32]*PacketConn)
+	receiveDatagrams(conn, func(id uint32) *PacketConn {
+		mu.Lock()
+		defer mu.Unlock()
+		if pc, ok := sessions[id]; ok {
+			return pc
+		}
+		pc := newPacketConn(conn, id, nil)
+		select {
+		case l.packets <- pc:
+		case <-l.ctx.Done():
+			return nil
+		}
+		sessions[id] = pc
+		return pc
+	})
+}
+
+// Accept returns the next stream
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case stream := <-l.streams:
+		return stream, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// AcceptPacket returns the next datagram session
+func (l *Listener) AcceptPacket() (*PacketConn, error) {
+	select {
+	case pc := <-l.packets:
+		return pc, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close stops listening and closes the connections of the clients
+func (l *Listener) Close() error {
+	l.cancel()
+	err := l.listener.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		conn.CloseWithError(0, "")
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package shadowsocks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+// Supported methods
+const (
+	MethodAES128GCM                  = "aes-128-gcm"
+	MethodAES256GCM                  = "aes-256-gcm"
+	MethodChacha20IETFPoly1305       = "chacha20-ietf-poly1305"
+	Method2022Blake3AES128GCM        = "2022-blake3-aes-128-gcm"
+	Method2022Blake3AES256GCM        = "2022-blake3-aes-256-gcm"
+	Method2022Blake3Chacha20Poly1305 = "2022-blake3-chacha20-poly1305"
+)
+
+const (
+	subkeyInfo        = "ss-subkey"
+	subkeyContext2022 = "shadowsocks 2022 session subkey"
+)
+
+var ErrUnsupportedMethod = errors.New("unsupported shadowsocks method")
+
+// Cipher holds the key of a Shadowsocks method and creates the AEADs protecting its sessions
+type Cipher struct {
+	method string
+	key    []byte
+	// is2022 reports whether the method is one of the Shadowsocks 2022 edition
+	is2022 bool
+	// newAEAD returns the AEAD of the method keyed with key
+	newAEAD func(key []byte) (cipher.AEAD, error)
+	// block encrypts the separate header of UDP packets of the 2022 AES methods
+	block cipher.Block
+	// xaead seals the UDP packets of the 2022 ChaCha20 method
+	xaead cipher.AEAD
+	// salts rejects replayed requests of servers
+	salts *saltPool
+	// now returns the time written to and checked against the headers of the 2022 methods
+	now func() time.Time
+}
+
+// NewCipher returns the cipher of method. The password of the 2022 methods is the base64
+// encoded key, the password of the others is stretched into the key
+func NewCipher(method, password string) (*Cipher, error) {
+	c := &Cipher{method: strings.ToLower(method), salts: newSaltPool(), now: time.Now}
+	var keySize int
+	switch c.method {
+	case MethodAES128GCM, Method2022Blake3AES128GCM:
+		keySize, c.newAEAD = 16, newGCM
+	case MethodAES256GCM, Method2022Blake3AES256GCM:
+		keySize, c.newAEAD = 32, newGCM
+	case MethodChacha20IETFPoly1305, Method2022Blake3Chacha20Poly1305:
+		keySize, c.newAEAD = 32, chacha20poly1305.New
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, method)
+	}
+	c.is2022 = strings.HasPrefix(c.method, "2022-")
+
+	if !c.is2022 {
+		if password == "" {
+			return nil, errors.New("missing password")
+		}
+		c.key = evpBytesToKey(password, keySize)
+		return c, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(password)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 2022 key: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid 2022 key: must be %d bytes", keySize)
+	}
+	c.key = key
+	if c.method == Method2022Blake3Chacha20Poly1305 {
+		c.xaead, err = chacha20poly1305.NewX(key)
+	} else {
+		c.block, err = aes.NewCipher(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Method returns the name of the method of c
+func (c *Cipher) Method() string {
+	return c.method
+}
+
+// saltSize returns the size of the salt starting every stream and AEAD packet
+func (c *Cipher) saltSize() int {
+	return len(c.key)
+}
+
+// maxPayload returns the maximum size of the payload of a stream chunk
+func (c *Cipher) maxPayload() int {
+	if c.is2022 {
+		return 0xffff
+	}
+	return 0x3fff
+}
+
+// sessionAEAD returns the AEAD of the session identified by salt, or by the session ID of a
+// 2022 UDP session
+func (c *Cipher) sessionAEAD(salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, len(c.key))
+	if c.is2022 {
+		material := make([]byte, 0, len(c.key)+len(salt))
+		material = append(material, c.key...)
+		material = append(material, salt...)
+		blake3.DeriveKey(subkey, subkeyContext2022, material)
+	} else {
+		var err error
+		if subkey, err = hkdf.Key(sha1.New, c.key, salt, subkeyInfo, len(c.key)); err != nil {
+			return nil, err
+		}
+	}
+	return c.newAEAD(subkey)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// evpBytesToKey derives a key from password like OpenSSL EVP_BytesToKey with MD5 and no salt
+func evpBytesToKey(password string, keySize int) []byte {
+	var key, prev []byte
+	for len(key) < keySize {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:keySize]
+}
+
+// increment increments the little-endian nonce
+func increment(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package shadowsocks
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+const (
+	maxPacketSize = 65535
+	// udpSessionTimeout is how long servers keep the state of idle 2022 UDP sessions
+	udpSessionTimeout = 5 * time.Minute
+)
+
+var ErrBadPacket = errors.New("bad shadowsocks packet")
+
+// udpSession is the sending side of a 2022 UDP session
+type udpSession struct {
+	id       uint64
+	packetID atomic.Uint64
+	// aead seals the body of the packets of the AES methods
+	aead cipher.AEAD
+}
+
+func (c *Cipher) newUDPSession() (*udpSession, error) {
+	var id [8]byte
+	rand.Read(id[:])
+	s := &udpSession{id: binary.BigEndian.Uint64(id[:])}
+	if c.block != nil {
+		aead, err := c.sessionAEAD(id[:])
+		if err != nil {
+			return nil, err
+		}
+		s.aead = aead
+	}
+	return s, nil
+}
+
+// remoteSession is the receiving side of a 2022 UDP session
+type remoteSession struct {
+	id     uint64
+	aead   cipher.AEAD
+	window slidingWindow
+}
+
+// decodedPacket is a decoded UDP packet
+type decodedPacket struct {
+	// sessionID and packetID identify 2022 packets
+	sessionID uint64
+	packetID  uint64
+	// clientSessionID is the session of the client a 2022 server packet answers
+	clientSessionID uint64
+	addr            M.SocksAddr
+	payload         []byte
+}
+
+// encodePacket seals payload for addr, which is the destination of client packets and the
+// source of server packets. session is the sending session and clientSessionID the session
+// answered by server packets of the 2022 methods
+func (c *Cipher) encodePacket(session *udpSession, clientSessionID uint64, isServer bool, addr M.SocksAddr, payload []byte) ([]byte, error) {
+	if !c.is2022 {
+		salt := make([]byte, c.saltSize())
+		rand.Read(salt)
+		aead, err := c.sessionAEAD(salt)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, 0, len(addr)+len(payload))
+		plaintext = append(plaintext, addr...)
+		plaintext = append(plaintext, payload...)
+		return aead.Seal(salt, make([]byte, aead.NonceSize()), plaintext, nil), nil
+	}
+
+	// session ID | packet ID
+	header := make([]byte, 0, 16)
+	header = binary.BigEndian.AppendUint64(header, session.id)
+	header = binary.BigEndian.AppendUint64(header, session.packetID.Add(1)-1)
+
+	// type | timestamp | client session ID (server only) | padding length | addr | payload
+	body := make([]byte, 0, 16+1+8+8+2+len(addr)+len(payload))
+	if c.xaead != nil {
+		body = append(body, header...)
+	}
+	if isServer {
+		body = append(body, headerTypeServer)
+	} else {
+		body = append(body, headerTypeClient)
+	}
+	body = binary.BigEndian.AppendUint64(body, uint64(c.now().Unix()))
+	if isServer {
+		body = binary.BigEndian.AppendUint64(body, clientSessionID)
+	}
+	body = binary.BigEndian.AppendUint16(body, 0)
+	body = append(body, addr...)
+	body = append(body, payload...)
+
+	if c.xaead != nil {
+		nonce := make([]byte, c.xaead.NonceSize())
+		rand.Read(nonce)
+		return c.xaead.Seal(nonce, nonce, body, nil), nil
+	}
+	packet := session.aead.Seal(header, header[4:16], body, nil)
+	c.block.Encrypt(packet[:16], packet[:16])
+	return packet, nil
+}
+
+// decodePacket opens packet, sent by a server if fromServer is set. remoteAEAD returns the
+// AEAD of a remote 2022 session of the AES methods
+func (c *Cipher) decodePacket(packet []byte, fromServer bool, remoteAEAD func(id uint64) (cipher.AEAD, error)) (*decodedPacket, error) {
+	if !c.is2022 {
+		if len(packet) < c.saltSize() {
+			return nil, ErrBadPacket
+		}
+		aead, err := c.sessionAEAD(packet[:c.saltSize()])
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := aead.Open(nil, make([]byte, aead.NonceSize()), packet[c.saltSize():], nil)
+		if err != nil {
+			return nil, err
+		}
+		addr := M.SplitSocksAddr(plaintext)
+		if addr == nil {
+			return nil, ErrBadPacket
+		}
+		return &decodedPacket{addr: addr, payload: plaintext[len(addr):]}, nil
+	}
+
+	p := &decodedPacket{}
+	var body []byte
+	if c.xaead != nil {
+		nonceSize := c.xaead.NonceSize()
+		if len(packet) < nonceSize {
+			return nil, ErrBadPacket
+		}
+		plaintext, err := c.xaead.Open(nil, packet[:nonceSize], packet[nonceSize:], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(plaintext) < 16 {
+			return nil, ErrBadPacket
+		}
+		p.sessionID = binary.BigEndian.Uint64(plaintext)
+		p.packetID = binary.BigEndian.Uint64(plaintext[8:])
+		body = plaintext[16:]
+	} else {
+		if len(packet) < 16 {
+			return nil, ErrBadPacket
+		}
+		header := make([]byte, 16)
+		c.block.Decrypt(header, packet[:16])
+		p.sessionID = binary.BigEndian.Uint64(header)
+		p.packetID = binary.BigEndian.Uint64(header[8:])
+		aead, err := remoteAEAD(p.sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = aead.Open(nil, header[4:16], packet[16:], nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// type | timestamp | client session ID (server only) | padding length | padding
+	headerLen := 1 + 8 + 2
+	if fromServer {
+		headerLen += 8
+	}
+	if len(body) < headerLen {
+		return nil, ErrBadPacket
+	}
+	if (body[0] == headerTypeServer) != fromServer {
+		return nil, ErrBadPacket
+	}
+	if err := c.checkTimestamp(body[1:9]); err != nil {
+		return nil, err
+	}
+	body = body[9:]
+	if fromServer {
+		p.clientSessionID = binary.BigEndian.Uint64(body)
+		body = body[8:]
+	}
+	paddingLen := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	if len(body) < paddingLen {
+		return nil, ErrBadPacket
+	}
+	body = body[paddingLen:]
+
+	p.addr = M.SplitSocksAddr(body)
+	if p.addr == nil {
+		return nil, ErrBadPacket
+	}
+	p.payload = body[len(p.addr):]
+	return p, nil
+}
+
+// packetConn is the client side of a Shadowsocks UDP association
+type packetConn struct {
+	net.PacketConn
+	cipher  *Cipher
+	server  net.Addr
+	session *udpSession
+
+	mu     sync.Mutex
+	remote *remoteSession
+}
+
+// PacketConn returns a packet connection relaying packets through the Shadowsocks server
+// over pc. Its WriteTo and ReadFrom take and return the addresses of the destinations
+func (c *Cipher) PacketConn(pc net.PacketConn, server net.Addr) (net.PacketConn, error) {
+	conn := &packetConn{PacketConn: pc, cipher: c, server: server}
+	if c.is2022 {
+		session, err := c.newUDPSession()
+		if err != nil {
+			return nil, err
+		}
+		conn.session = session
+	}
+	return conn, nil
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := M.ParseSocksAddr(addr.String())
+	if target == nil {
+		return 0, M.ErrInvalidSocksAddr
+	}
+	packet, err := pc.cipher.encodePacket(pc.session, 0, false, target, b)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := pc.PacketConn.WriteTo(packet, pc.server); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := pc.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		p, err := pc.cipher.decodePacket(buf[:n], true, pc.remoteAEAD)
+		if err != nil {
+			// drop packets not sent by the server
+			continue
+		}
+		if pc.cipher.is2022 && (p.clientSessionID != pc.session.id || !pc.checkRemote(p)) {
+			continue
+		}
+		return copy(b, p.payload), p.addr.UDPAddr(), nil
+	}
+}
+
+// remoteAEAD returns the AEAD of the server session id
+func (pc *packetConn) remoteAEAD(id uint64) (cipher.AEAD, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.remote != nil && pc.remote.id == id {
+		return pc.remote.aead, nil
+	}
+	return pc.cipher.sessionAEAD(binary.BigEndian.AppendUint64(nil, id))
+}
+
+// checkRemote reports whether p is not a replayed packet of the server session
+func (pc *packetConn) checkRemote(p *decodedPacket) bool {
+	pc.mu.Lock()
+	if pc.remote == nil || pc.remote.id != p.sessionID {
+		// the server started a new session
+		aead, _ := pc.cipher.sessionAEAD(binary.BigEndian.AppendUint64(nil, p.sessionID))
+		pc.remote = &remoteSession{id: p.sessionID, aead: aead}
+	}
+	remote := pc.remote
+	pc.mu.Unlock()
+	return remote.window.check(p.packetID)
+}
+
+// ServerPacketConn is the server side of Shadowsocks UDP relaying for any number of clients
+type ServerPacketConn struct {
+	net.PacketConn
+	cipher *Cipher
+
+	mu        sync.Mutex
+	sessions  map[uint64]*serverSession
+	clients   map[string]*serverSession
+	lastClean time.Time
+}
+
+// serverSession is the state of a 2022 UDP session of a client
+type serverSession struct {
+	remote   remoteSession
+	local    *udpSession
+	lastSeen time.Time
+}
+
+// ServerPacketConn returns the server side of Shadowsocks UDP relaying over pc
+func (c *Cipher) ServerPacketConn(pc net.PacketConn) *ServerPacketConn {
+	return &ServerPacketConn{
+		PacketConn: pc,
+		cipher:     c,
+		sessions:   make(map[uint64]*serverSession),
+		clients:    make(map[string]*serverSession),
+	}
+}
+
+// ReadPacket reads the next valid packet of a client into b. It returns the size of the
+// payload, the address of the client and the destination of the payload
+func (s *ServerPacketConn) ReadPacket(b []byte) (int, net.Addr, M.SocksAddr, error) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, client, err := s.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		p, err := s.cipher.decodePacket(buf[:n], false, s.remoteAEAD)
+		if err != nil {
+			continue
+		}
+		if s.cipher.is2022 && !s.checkSession(p, client) {
+			continue
+		}
+		return copy(b, p.payload), client, p.addr, nil
+	}
+}
+
+// WritePacket sends b from source to client
+func (s *ServerPacketConn) WritePacket(b []byte, client net.Addr, source M.SocksAddr) error {
+	var local *udpSession
+	var clientSessionID uint64
+	if s.cipher.is2022 {
+		s.mu.Lock()
+		session, ok := s.clients[client.String()]
+		s.mu.Unlock()
+		if !ok {
+			return errors.New("no shadowsocks session for " + client.String())
+		}
+		local, clientSessionID = session.local, session.remote.id
+	}
+	packet, err := s.cipher.encodePacket(local, clientSessionID, true, source, b)
+	if err != nil {
+		return err
+	}
+	_, err = s.PacketConn.WriteTo(packet, client)
+	return err
+}
+
+func (s *ServerPacketConn) remoteAEAD(id uint64) (cipher.AEAD, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	s.mu.Unlock()
+	if ok {
+		return session.remote.aead, nil
+	}
+	return s.cipher.sessionAEAD(binary.BigEndian.AppendUint64(nil, id))
+}
+
+// checkSession records the session of p sent by client and reports whether p is not replayed
+func (s *ServerPacketConn) checkSession(p *decodedPacket, client net.Addr) bool {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.lastClean) > udpSessionTimeout {
+		for id, session := range s.sessions {
+			if now.Sub(session.lastSeen) > udpSessionTimeout {
+				delete(s.sessions, id)
+			}
+		}
+		for addr, session := range s.clients {
+			if now.Sub(session.lastSeen) > udpSessionTimeout {
+				delete(s.clients, addr)
+			}
+		}
+		s.lastClean = now
+	}
+
+	session, ok := s.sessions[p.sessionID]
+	if !ok {
+		local, err := s.cipher.newUDPSession()
+		if err != nil {
+			s.mu.Unlock()
+			return false
+		}
+		aead, _ := s.cipher.sessionAEAD(binary.BigEndian.AppendUint64(nil, p.sessionID))
+		session = &serverSession{remote: remoteSession{id: p.sessionID, aead: aead}, local: local}
+		s.sessions[p.sessionID] = session
+	}
+	session.lastSeen = now
+	s.clients[client.String()] = session
+	s.mu.Unlock()
+	return session.remote.window.check(p.packetID)
+}
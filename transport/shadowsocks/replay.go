@@ -0,0 +1,81 @@
+package shadowsocks
+
+import (
+	"sync"
+	"time"
+)
+
+// saltTTL is how long salts are remembered, longer than the accepted clock skew of 2022 requests
+const saltTTL = 60 * time.Second
+
+// saltPool remembers the salts of recent sessions to reject replayed ones
+type saltPool struct {
+	mu        sync.Mutex
+	salts     map[string]time.Time
+	lastClean time.Time
+}
+
+func newSaltPool() *saltPool {
+	return &saltPool{salts: make(map[string]time.Time)}
+}
+
+// check records salt and reports whether it was not seen in the last saltTTL
+func (p *saltPool) check(salt []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.lastClean) > saltTTL {
+		for s, expiry := range p.salts {
+			if now.After(expiry) {
+				delete(p.salts, s)
+			}
+		}
+		p.lastClean = now
+	}
+	if expiry, ok := p.salts[string(salt)]; ok && now.Before(expiry) {
+		return false
+	}
+	p.salts[string(salt)] = now.Add(saltTTL)
+	return true
+}
+
+const (
+	windowBlockBits = 64
+	windowBlocks    = 32
+	windowSize      = (windowBlocks - 1) * windowBlockBits
+)
+
+// slidingWindow rejects replayed and too old packet IDs of a UDP session, like the replay
+// filter of WireGuard
+type slidingWindow struct {
+	mu     sync.Mutex
+	last   uint64
+	blocks [windowBlocks]uint64
+}
+
+// check records id and reports whether it was not seen before and is recent enough
+func (w *slidingWindow) check(id uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if id+windowSize < w.last {
+		return false
+	}
+	index := id / windowBlockBits
+	if id > w.last {
+		current := w.last / windowBlockBits
+		diff := min(index-current, windowBlocks)
+		for i := uint64(1); i <= diff; i++ {
+			w.blocks[(current+i)%windowBlocks] = 0
+		}
+		w.last = id
+	}
+	block := &w.blocks[index%windowBlocks]
+	bit := uint64(1) << (id % windowBlockBits)
+	if *block&bit != 0 {
+		return false
+	}
+	*block |= bit
+	return true
+}
@@ -0,0 +1,302 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCiphers returns a client and a server cipher of every supported method
+func testCiphers(t *testing.T) map[string][2]*Cipher {
+	ciphers := make(map[string][2]*Cipher)
+	for method, keySize := range map[string]int{
+		MethodAES128GCM:                  0,
+		MethodAES256GCM:                  0,
+		MethodChacha20IETFPoly1305:       0,
+		Method2022Blake3AES128GCM:        16,
+		Method2022Blake3AES256GCM:        32,
+		Method2022Blake3Chacha20Poly1305: 32,
+	} {
+		password := "password"
+		if keySize > 0 {
+			key := make([]byte, keySize)
+			rand.Read(key)
+			password = base64.StdEncoding.EncodeToString(key)
+		}
+		client, err := NewCipher(method, password)
+		require.NoError(t, err)
+		server, err := NewCipher(method, password)
+		require.NoError(t, err)
+		ciphers[method] = [2]*Cipher{client, server}
+	}
+	return ciphers
+}
+
+func TestNewCipher(t *testing.T) {
+	c, err := NewCipher("AES-256-GCM", "password")
+	require.NoError(t, err)
+	assert.Equal(t, "5f4dcc3b5aa765d61d8327deb882cf992b95990a9151374abd8ff8c5a7a0fe08", hex.EncodeToString(c.key))
+
+	_, err = NewCipher("rc4-md5", "password")
+	assert.ErrorIs(t, err, ErrUnsupportedMethod)
+	_, err = NewCipher(Method2022Blake3AES256GCM, base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	assert.Error(t, err)
+}
+
+func TestStream(t *testing.T) {
+	for method, ciphers := range testCiphers(t) {
+		t.Run(method, func(t *testing.T) {
+			client, server := ciphers[0], ciphers[1]
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer l.Close()
+
+			large := make([]byte, 200*1024)
+			rand.Read(large)
+			done := make(chan error, 1)
+			go func() {
+				c, err := l.Accept()
+				if err != nil {
+					done <- err
+					return
+				}
+				defer c.Close()
+				sc, err := server.ServerConn(c)
+				if err != nil {
+					done <- err
+					return
+				}
+				if sc.Target().String() != "example.com:443" {
+					done <- io.ErrUnexpectedEOF
+					return
+				}
+				// the server speaks first, then echoes
+				if _, err := sc.Write([]byte("banner")); err != nil {
+					done <- err
+					return
+				}
+				_, err = io.CopyN(sc, sc, int64(len(large)))
+				done <- err
+			}()
+
+			c, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(t, err)
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			cc := client.StreamConn(c, M.ParseSocksAddr("example.com:443"))
+
+			buf := make([]byte, 6)
+			_, err = io.ReadFull(cc, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "banner", string(buf))
+
+			go cc.Write(large)
+			echo := make([]byte, len(large))
+			_, err = io.ReadFull(cc, echo)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(large, echo))
+			require.NoError(t, <-done)
+		})
+	}
+}
+
+// captureConn records what is written to it
+type captureConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	return c.buf.Write(b)
+}
+
+// replayConn reads a recorded stream
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func TestStreamReplay(t *testing.T) {
+	for method, ciphers := range testCiphers(t) {
+		t.Run(method, func(t *testing.T) {
+			capture := &captureConn{}
+			_, err := ciphers[0].StreamConn(capture, M.ParseSocksAddr("1.2.3.4:80")).Write([]byte("GET /"))
+			require.NoError(t, err)
+			request := capture.buf.Bytes()
+
+			sc, err := ciphers[1].ServerConn(&replayConn{r: bytes.NewReader(request)})
+			require.NoError(t, err)
+			assert.Equal(t, "1.2.3.4:80", sc.Target().String())
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(sc, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "GET /", string(buf))
+
+			_, err = ciphers[1].ServerConn(&replayConn{r: bytes.NewReader(request)})
+			assert.ErrorIs(t, err, ErrReplay)
+		})
+	}
+}
+
+func TestPacket(t *testing.T) {
+	for method, ciphers := range testCiphers(t) {
+		t.Run(method, func(t *testing.T) {
+			client, server := ciphers[0], ciphers[1]
+			serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer serverConn.Close()
+			spc := server.ServerPacketConn(serverConn)
+
+			clientConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer clientConn.Close()
+			pc, err := client.PacketConn(clientConn, serverConn.LocalAddr())
+			require.NoError(t, err)
+			pc.SetDeadline(time.Now().Add(5 * time.Second))
+			serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			target := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 53}
+			_, err = pc.WriteTo([]byte("query"), target)
+			require.NoError(t, err)
+
+			buf := make([]byte, 64)
+			n, from, addr, err := spc.ReadPacket(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "query", string(buf[:n]))
+			assert.Equal(t, "1.2.3.4:53", addr.String())
+			require.NoError(t, spc.WritePacket([]byte("answer"), from, addr))
+
+			n, src, err := pc.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "answer", string(buf[:n]))
+			assert.Equal(t, "1.2.3.4:53", src.String())
+		})
+	}
+}
+
+func TestPacketReplay(t *testing.T) {
+	for method, ciphers := range testCiphers(t) {
+		if !ciphers[0].is2022 {
+			continue
+		}
+		t.Run(method, func(t *testing.T) {
+			client, server := ciphers[0], ciphers[1]
+			serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer serverConn.Close()
+			serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+			spc := server.ServerPacketConn(serverConn)
+
+			session, err := client.newUDPSession()
+			require.NoError(t, err)
+			first, err := client.encodePacket(session, 0, false, M.ParseSocksAddr("1.2.3.4:53"), []byte("first"))
+			require.NoError(t, err)
+			second, err := client.encodePacket(session, 0, false, M.ParseSocksAddr("1.2.3.4:53"), []byte("second"))
+			require.NoError(t, err)
+
+			c, err := net.Dial("udp", serverConn.LocalAddr().String())
+			require.NoError(t, err)
+			defer c.Close()
+			for _, packet := range [][]byte{first, first, second} {
+				_, err := c.Write(packet)
+				require.NoError(t, err)
+			}
+
+			buf := make([]byte, 64)
+			for _, expected := range []string{"first", "second"} {
+				n, _, _, err := spc.ReadPacket(buf)
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(buf[:n]))
+			}
+		})
+	}
+}
+
+// vectorCipher returns the cipher of method of the known-answer vectors, which were written by
+// the 2022 client of sing-shadowsocks. Its key is 0x00, 0x01, ... and its clock is stopped at
+// the timestamp of the vectors
+func vectorCipher(t *testing.T, method string, keySize int) *Cipher {
+	key := make([]byte, keySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	c, err := NewCipher(method, base64.StdEncoding.EncodeToString(key))
+	require.NoError(t, err)
+	c.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return c
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestStreamVector(t *testing.T) {
+	c := vectorCipher(t, Method2022Blake3AES128GCM, 16)
+	// request to example.com:443 with 3 bytes of padding and GET / as initial payload
+	request := decodeHex(t, "67f7f18c9eb0de87d0602c07f7b5635123395027197f304503b540fa06b043c1b5e723e02113df090d567980aa2a2f654634ba3f5294e25ae0ae1253624f068e26950b6a2ed1f524e924d97fddc124bcca3c")
+
+	sc, err := c.ServerConn(&replayConn{r: bytes.NewReader(request)})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", sc.Target().String())
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(sc, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /", string(buf))
+
+	c.now = time.Now
+	_, err = c.ServerConn(&replayConn{r: bytes.NewReader(request)})
+	assert.ErrorIs(t, err, ErrBadTimestamp)
+}
+
+func TestPacketVectors(t *testing.T) {
+	for _, test := range []struct {
+		method  string
+		keySize int
+		packet  string
+	}{
+		// separate header encrypted with AES-ECB, body sealed with the session subkey
+		{Method2022Blake3AES128GCM, 16, "baf1f7508b6b3ee45eb7186d147b9f2ad06ba380172e2f53dca1d360d479e8672745f59be04ee45af9f7f29329df89a4be847c62f63098"},
+		// whole packet sealed with XChaCha20-Poly1305 under the key
+		{Method2022Blake3Chacha20Poly1305, 32, "3890a9cec0e93a178737aed8a33c0227d69209dee6991322a6e65d31767fa21cc6545136dea82a017eb9ae86b90486ea183660d59015f28717b2b888947068193fb9e9f640124564ca435af4834ade"},
+	} {
+		t.Run(test.method, func(t *testing.T) {
+			c := vectorCipher(t, test.method, test.keySize)
+			p, err := c.decodePacket(decodeHex(t, test.packet), false, func(id uint64) (cipher.AEAD, error) {
+				return c.sessionAEAD(binary.BigEndian.AppendUint64(nil, id))
+			})
+			require.NoError(t, err)
+			assert.Equal(t, uint64(0), p.packetID)
+			assert.Equal(t, "1.2.3.4:443", p.addr.String())
+			assert.Equal(t, "query", string(p.payload))
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	var w slidingWindow
+	assert.True(t, w.check(0))
+	assert.False(t, w.check(0))
+	assert.True(t, w.check(5))
+	assert.True(t, w.check(3))
+	assert.False(t, w.check(3))
+	assert.True(t, w.check(windowSize+100))
+	assert.False(t, w.check(5))
+	assert.True(t, w.check(windowSize+99))
+}
@@ -0,0 +1,346 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+const (
+	headerTypeClient = 0
+	headerTypeServer = 1
+
+	// maxTimeDiff is the clock skew accepted in the headers of 2022 sessions
+	maxTimeDiff = 30 * time.Second
+	// maxPaddingLength is the maximum padding of a 2022 request without initial payload
+	maxPaddingLength = 900
+)
+
+var (
+	ErrBadHeader    = errors.New("bad shadowsocks header")
+	ErrReplay       = errors.New("replayed shadowsocks session")
+	ErrBadTimestamp = errors.New("shadowsocks header timestamp out of range")
+)
+
+// Conn is a Shadowsocks stream. The header of the session is sent along with the first write,
+// or before the first read of a client which has not written anything yet
+type Conn struct {
+	net.Conn
+	cipher   *Cipher
+	isServer bool
+	// target is the destination of the session
+	target M.SocksAddr
+	// requestSalt is the salt of the request, which the response header of 2022 sessions echoes
+	requestSalt []byte
+
+	wmu    sync.Mutex
+	writer *chunkWriter
+
+	rmu    sync.Mutex
+	reader *chunkReader
+	// buf is the plaintext not returned by Read yet
+	buf []byte
+}
+
+// StreamConn returns a client stream over conn requesting a connection to target
+func (c *Cipher) StreamConn(conn net.Conn, target M.SocksAddr) *Conn {
+	return &Conn{Conn: conn, cipher: c, target: target}
+}
+
+// ServerConn reads the request header of a client stream over conn and returns the stream,
+// whose Target is the requested destination
+func (c *Cipher) ServerConn(conn net.Conn) (*Conn, error) {
+	salt := make([]byte, c.saltSize())
+	if _, err := io.ReadFull(conn, salt); err != nil {
+		return nil, err
+	}
+	aead, err := c.sessionAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	reader := newChunkReader(conn, aead, c.maxPayload())
+
+	var header []byte
+	if c.is2022 {
+		// type | timestamp | length of the variable header
+		fixed, err := reader.open(1 + 8 + 2)
+		if err != nil {
+			return nil, err
+		}
+		if fixed[0] != headerTypeClient {
+			return nil, ErrBadHeader
+		}
+		if err := c.checkTimestamp(fixed[1:9]); err != nil {
+			return nil, err
+		}
+		if header, err = reader.open(int(binary.BigEndian.Uint16(fixed[9:]))); err != nil {
+			return nil, err
+		}
+	} else if header, err = reader.readChunk(); err != nil {
+		return nil, err
+	}
+	// salts are only recorded once authenticated so that garbage does not fill the pool
+	if !c.salts.check(salt) {
+		return nil, ErrReplay
+	}
+
+	target := M.SplitSocksAddr(header)
+	if target == nil {
+		return nil, ErrBadHeader
+	}
+	payload := header[len(target):]
+	if c.is2022 {
+		// padding length | padding
+		if len(payload) < 2 {
+			return nil, ErrBadHeader
+		}
+		paddingLen := int(binary.BigEndian.Uint16(payload))
+		if len(payload) < 2+paddingLen {
+			return nil, ErrBadHeader
+		}
+		payload = payload[2+paddingLen:]
+	}
+	return &Conn{
+		Conn:        conn,
+		cipher:      c,
+		isServer:    true,
+		target:      bytes.Clone(target),
+		requestSalt: salt,
+		reader:      reader,
+		buf:         payload,
+	}, nil
+}
+
+// Target returns the destination of the session
+func (c *Conn) Target() M.SocksAddr {
+	return c.target
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// write seals b, preceded by the header of the session on the first call. It must be called
+// with c.wmu held
+func (c *Conn) write(b []byte) error {
+	if c.writer != nil {
+		_, err := c.Conn.Write(c.writer.appendChunks(nil, b))
+		return err
+	}
+
+	salt := make([]byte, c.cipher.saltSize())
+	rand.Read(salt)
+	aead, err := c.cipher.sessionAEAD(salt)
+	if err != nil {
+		return err
+	}
+	writer := newChunkWriter(aead, c.cipher.maxPayload())
+	out := salt
+
+	switch {
+	case !c.cipher.is2022 && c.isServer:
+		out = writer.appendChunks(out, b)
+	case !c.cipher.is2022:
+		out = writer.appendChunks(out, append(bytes.Clone(c.target), b...))
+	case c.isServer:
+		// type | timestamp | request salt | length of the first chunk
+		n := min(len(b), c.cipher.maxPayload())
+		fixed := make([]byte, 0, 1+8+len(c.requestSalt)+2)
+		fixed = append(fixed, headerTypeServer)
+		fixed = binary.BigEndian.AppendUint64(fixed, uint64(c.cipher.now().Unix()))
+		fixed = append(fixed, c.requestSalt...)
+		fixed = binary.BigEndian.AppendUint16(fixed, uint16(n))
+		out = writer.seal(out, fixed)
+		out = writer.seal(out, b[:n])
+		out = writer.appendChunks(out, b[n:])
+	default:
+		// target | padding length | padding | initial payload
+		var paddingLen int
+		if len(b) == 0 {
+			var p [2]byte
+			rand.Read(p[:])
+			paddingLen = 1 + int(binary.BigEndian.Uint16(p[:]))%maxPaddingLength
+		}
+		n := min(len(b), c.cipher.maxPayload()-len(c.target)-2-paddingLen)
+		header := make([]byte, 0, len(c.target)+2+paddingLen+n)
+		header = append(header, c.target...)
+		header = binary.BigEndian.AppendUint16(header, uint16(paddingLen))
+		header = append(header, make([]byte, paddingLen)...)
+		header = append(header, b[:n]...)
+
+		// type | timestamp | length of the variable header
+		fixed := make([]byte, 0, 1+8+2)
+		fixed = append(fixed, headerTypeClient)
+		fixed = binary.BigEndian.AppendUint64(fixed, uint64(c.cipher.now().Unix()))
+		fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(header)))
+		out = writer.seal(out, fixed)
+		out = writer.seal(out, header)
+		out = writer.appendChunks(out, b[n:])
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return err
+	}
+	c.writer = writer
+	if !c.isServer {
+		c.requestSalt = salt
+	}
+	return nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.reader == nil {
+		if err := c.readResponseHeader(); err != nil {
+			return 0, err
+		}
+	}
+	for len(c.buf) == 0 {
+		payload, err := c.reader.readChunk()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = payload
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// readResponseHeader sends the request if it was not yet, then reads the response header of
+// the server. It must be called with c.rmu held
+func (c *Conn) readResponseHeader() error {
+	c.wmu.Lock()
+	var err error
+	if c.writer == nil {
+		err = c.write(nil)
+	}
+	requestSalt := c.requestSalt
+	c.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, c.cipher.saltSize())
+	if _, err := io.ReadFull(c.Conn, salt); err != nil {
+		return err
+	}
+	aead, err := c.cipher.sessionAEAD(salt)
+	if err != nil {
+		return err
+	}
+	reader := newChunkReader(c.Conn, aead, c.cipher.maxPayload())
+
+	if c.cipher.is2022 {
+		// type | timestamp | request salt | length of the first chunk
+		fixed, err := reader.open(1 + 8 + len(requestSalt) + 2)
+		if err != nil {
+			return err
+		}
+		if fixed[0] != headerTypeServer {
+			return ErrBadHeader
+		}
+		if err := c.cipher.checkTimestamp(fixed[1:9]); err != nil {
+			return err
+		}
+		if !bytes.Equal(fixed[9:9+len(requestSalt)], requestSalt) {
+			return ErrBadHeader
+		}
+		if c.buf, err = reader.open(int(binary.BigEndian.Uint16(fixed[9+len(requestSalt):]))); err != nil {
+			return err
+		}
+	}
+	c.reader = reader
+	return nil
+}
+
+// checkTimestamp checks that the big-endian Unix timestamp b is close to the current time
+func (c *Cipher) checkTimestamp(b []byte) error {
+	diff := c.now().Sub(time.Unix(int64(binary.BigEndian.Uint64(b)), 0))
+	if diff > maxTimeDiff || diff < -maxTimeDiff {
+		return ErrBadTimestamp
+	}
+	return nil
+}
+
+// chunkWriter seals the chunks of one direction of a stream
+type chunkWriter struct {
+	aead       cipher.AEAD
+	nonce      []byte
+	maxPayload int
+}
+
+func newChunkWriter(aead cipher.AEAD, maxPayload int) *chunkWriter {
+	return &chunkWriter{aead: aead, nonce: make([]byte, aead.NonceSize()), maxPayload: maxPayload}
+}
+
+// seal appends plaintext sealed with the next nonce to dst
+func (w *chunkWriter) seal(dst, plaintext []byte) []byte {
+	dst = w.aead.Seal(dst, w.nonce, plaintext, nil)
+	increment(w.nonce)
+	return dst
+}
+
+// appendChunks appends b split into sealed length and payload chunks to dst
+func (w *chunkWriter) appendChunks(dst, b []byte) []byte {
+	for len(b) > 0 {
+		n := min(len(b), w.maxPayload)
+		dst = w.seal(dst, binary.BigEndian.AppendUint16(nil, uint16(n)))
+		dst = w.seal(dst, b[:n])
+		b = b[n:]
+	}
+	return dst
+}
+
+// chunkReader opens the chunks of one direction of a stream
+type chunkReader struct {
+	r          io.Reader
+	aead       cipher.AEAD
+	nonce      []byte
+	maxPayload int
+}
+
+func newChunkReader(r io.Reader, aead cipher.AEAD, maxPayload int) *chunkReader {
+	return &chunkReader{r: r, aead: aead, nonce: make([]byte, aead.NonceSize()), maxPayload: maxPayload}
+}
+
+// open reads a chunk with n bytes of plaintext and returns the plaintext
+func (r *chunkReader) open(n int) ([]byte, error) {
+	buf := make([]byte, n+r.aead.Overhead())
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+	plaintext, err := r.aead.Open(buf[:0], r.nonce, buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	increment(r.nonce)
+	return plaintext, nil
+}
+
+// readChunk reads a length chunk and the payload chunk it announces
+func (r *chunkReader) readChunk() ([]byte, error) {
+	length, err := r.open(2)
+	if err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(length))
+	if n > r.maxPayload {
+		return nil, ErrBadHeader
+	}
+	return r.open(n)
+}
@@ -0,0 +1,141 @@
+package socks4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/lumavpn/luma/common/auth"
+	M "github.com/lumavpn/luma/metadata"
+)
+
+// Version is the SOCKS protocol version
+const Version = 0x04
+
+// Command is request commands as defined in the SOCKS4 protocol
+type Command = uint8
+
+// SOCKS4 request commands
+const (
+	CmdConnect Command = 0x01
+	CmdBind    Command = 0x02
+)
+
+// SOCKS4 reply codes
+const (
+	RequestGranted          = 0x5a
+	RequestRejected         = 0x5b
+	RequestIdentdFailed     = 0x5c
+	RequestIdentdMismatched = 0x5d
+)
+
+// maxFieldLen bounds the user ID and host name fields, which are null-terminated
+const maxFieldLen = 255
+
+var (
+	ErrVersionMismatched   = errors.New("version code mismatched")
+	ErrCommandNotSupported = errors.New("command not supported")
+	ErrRequestRejected     = errors.New("request rejected or failed")
+	ErrFieldTooLong        = errors.New("field too long")
+)
+
+// ServerHandshake reads a SOCKS4 or SOCKS4a CONNECT request from rw and replies to it. Requests
+// are rejected with an authenticator since SOCKS4 carries no password
+func ServerHandshake(rw io.ReadWriter, authenticator auth.Authenticator) (addr M.SocksAddr, command Command, err error) {
+	var req [8]byte
+	if _, err = io.ReadFull(rw, req[:]); err != nil {
+		return
+	}
+	if req[0] != Version {
+		err = ErrVersionMismatched
+		return
+	}
+	if command = req[1]; command != CmdConnect {
+		err = ErrCommandNotSupported
+		return
+	}
+
+	var (
+		dstIP   = req[4:8]
+		dstPort = binary.BigEndian.Uint16(req[2:4])
+		host    string
+		code    byte
+	)
+
+	br := &byteReader{r: rw}
+	if _, err = readUntilNull(br); err != nil {
+		return
+	}
+
+	if isReservedIP(dstIP) {
+		// SOCKS4a: the domain name follows the user ID
+		var target []byte
+		if target, err = readUntilNull(br); err != nil {
+			return
+		}
+		host = string(target)
+	} else {
+		host = netip.AddrFrom4([4]byte(dstIP)).String()
+	}
+
+	addr = M.ParseSocksAddr(net.JoinHostPort(host, strconv.Itoa(int(dstPort))))
+	switch {
+	case addr == nil:
+		code = RequestRejected
+		err = ErrRequestRejected
+	case authenticator != nil:
+		code = RequestRejected
+		err = ErrRequestRejected
+	default:
+		code = RequestGranted
+	}
+
+	var reply [8]byte
+	reply[1] = code
+	copy(reply[2:4], req[2:4])
+	copy(reply[4:8], dstIP)
+	if _, wErr := rw.Write(reply[:]); err == nil {
+		err = wErr
+	}
+	return
+}
+
+// isReservedIP reports whether ip is of the form 0.0.0.x with x non-zero, which marks a
+// SOCKS4a request
+func isReservedIP(ip []byte) bool {
+	return bytes.Equal(ip[:3], []byte{0, 0, 0}) && ip[3] != 0
+}
+
+func readUntilNull(r io.ByteReader) ([]byte, error) {
+	var buf []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return buf, nil
+		}
+		if len(buf) >= maxFieldLen {
+			return nil, ErrFieldTooLong
+		}
+		buf = append(buf, b)
+	}
+}
+
+// byteReader reads single bytes without buffering, so no data past the request is consumed
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(br.r, br.buf[:]); err != nil {
+		return 0, err
+	}
+	return br.buf[0], nil
+}
@@ -0,0 +1,261 @@
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/lumavpn/luma/common/auth"
+	M "github.com/lumavpn/luma/metadata"
+)
+
+// Version is the SOCKS protocol version
+const Version = 5
+
+// Command is request commands as defined in RFC 1928 section 4.
+type Command = uint8
+
+// SOCKS request commands as defined in RFC 1928 section 4.
+const (
+	CmdConnect      Command = 1
+	CmdBind         Command = 2
+	CmdUDPAssociate Command = 3
+)
+
+// Authentication methods as defined in RFC 1928 section 3 and RFC 1929
+const (
+	MethodNoAuth       = 0x00
+	MethodUserPass     = 0x02
+	MethodNoAcceptable = 0xff
+)
+
+// Error represents a SOCKS error
+type Error byte
+
+func (err Error) Error() string {
+	return "SOCKS error: " + strconv.Itoa(int(err))
+}
+
+// SOCKS errors as defined in RFC 1928 section 6.
+const (
+	ErrGeneralFailure       = Error(1)
+	ErrConnectionNotAllowed = Error(2)
+	ErrNetworkUnreachable   = Error(3)
+	ErrHostUnreachable      = Error(4)
+	ErrConnectionRefused    = Error(5)
+	ErrTTLExpired           = Error(6)
+	ErrCommandNotSupported  = Error(7)
+	ErrAddressNotSupported  = Error(8)
+)
+
+var (
+	ErrAuth         = errors.New("auth failed")
+	ErrBadVersion   = errors.New("unsupported socks version")
+	ErrNoAcceptable = errors.New("no acceptable authentication method")
+)
+
+// ServerHandshake fast-tracks SOCKS initialization to get target address to connect on server side.
+// The returned user is empty when no authentication took place
+func ServerHandshake(rw net.Conn, authenticator auth.Authenticator) (addr M.SocksAddr, command Command, user string, err error) {
+	// Read RFC 1928 for request and reply structure and sizes.
+	buf := make([]byte, M.MaxSocksAddrLen)
+	// read VER, NMETHODS
+	if _, err = io.ReadFull(rw, buf[:2]); err != nil {
+		return
+	}
+	if buf[0] != Version {
+		err = ErrBadVersion
+		return
+	}
+	nmethods := buf[1]
+	if _, err = io.ReadFull(rw, buf[:nmethods]); err != nil {
+		return
+	}
+	methods := buf[:nmethods]
+
+	if authenticator != nil {
+		if !bytes.Contains(methods, []byte{MethodUserPass}) {
+			rw.Write([]byte{Version, MethodNoAcceptable})
+			err = ErrNoAcceptable
+			return
+		}
+		if _, err = rw.Write([]byte{Version, MethodUserPass}); err != nil {
+			return
+		}
+		if user, err = readUserPass(rw, authenticator); err != nil {
+			return
+		}
+	} else {
+		// write VER METHOD
+		if _, err = rw.Write([]byte{Version, MethodNoAuth}); err != nil {
+			return
+		}
+	}
+
+	// read VER CMD RSV ATYP DST.ADDR DST.PORT
+	if _, err = io.ReadFull(rw, buf[:3]); err != nil {
+		return
+	}
+	if buf[0] != Version {
+		err = ErrBadVersion
+		return
+	}
+
+	command = buf[1]
+	addr, err = M.ReadSocksAddr(rw)
+	if err != nil {
+		return
+	}
+
+	switch command {
+	case CmdConnect, CmdUDPAssociate:
+		// Acquire server listened address info
+		localAddr := M.ParseSocksAddr(rw.LocalAddr().String())
+		if localAddr == nil {
+			err = ErrAddressNotSupported
+		} else {
+			// write VER REP RSV ATYP BND.ADDR BND.PORT
+			_, err = rw.Write(bytes.Join([][]byte{{Version, 0, 0}, localAddr}, []byte{}))
+		}
+	default:
+		err = ErrCommandNotSupported
+		rw.Write([]byte{Version, byte(err.(Error)), 0, M.AtypIPv4, 0, 0, 0, 0, 0, 0})
+	}
+
+	return
+}
+
+// User is the username and password of the RFC 1929 authentication
+type User struct {
+	Username string
+	Password string
+}
+
+// ClientHandshake fast-tracks SOCKS initialization on the client side, authenticating as user
+// if not nil, and returns the address bound by the server
+func ClientHandshake(rw io.ReadWriter, addr M.SocksAddr, command Command, user *User) (M.SocksAddr, error) {
+	method := byte(MethodNoAuth)
+	if user != nil {
+		method = MethodUserPass
+	}
+	// VER, NMETHODS, METHODS
+	if _, err := rw.Write([]byte{Version, 1, method}); err != nil {
+		return nil, err
+	}
+	// VER, METHOD
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != Version {
+		return nil, ErrBadVersion
+	}
+	if buf[1] != method {
+		return nil, ErrNoAcceptable
+	}
+
+	if user != nil {
+		// VER, ULEN, UNAME, PLEN, PASSWD of RFC 1929
+		auth := make([]byte, 0, 3+len(user.Username)+len(user.Password))
+		auth = append(auth, 1, byte(len(user.Username)))
+		auth = append(auth, user.Username...)
+		auth = append(auth, byte(len(user.Password)))
+		auth = append(auth, user.Password...)
+		if _, err := rw.Write(auth); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(rw, buf); err != nil {
+			return nil, err
+		}
+		if buf[1] != 0 {
+			return nil, ErrAuth
+		}
+	}
+
+	// VER, CMD, RSV, ATYP, DST.ADDR, DST.PORT
+	if _, err := rw.Write(bytes.Join([][]byte{{Version, command, 0}, addr}, []byte{})); err != nil {
+		return nil, err
+	}
+	// VER, REP, RSV
+	buf = make([]byte, 3)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != Version {
+		return nil, ErrBadVersion
+	}
+	if buf[1] != 0 {
+		return nil, Error(buf[1])
+	}
+	return M.ReadSocksAddr(rw)
+}
+
+// readUserPass performs the username/password sub-negotiation of RFC 1929
+func readUserPass(rw io.ReadWriter, authenticator auth.Authenticator) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(rw, header); err != nil {
+		return "", err
+	}
+	user := make([]byte, header[1])
+	if _, err := io.ReadFull(rw, user); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(rw, header[:1]); err != nil {
+		return "", err
+	}
+	pass := make([]byte, header[0])
+	if _, err := io.ReadFull(rw, pass); err != nil {
+		return "", err
+	}
+
+	if !authenticator.Verify(string(user), string(pass)) {
+		rw.Write([]byte{1, 1})
+		return "", ErrAuth
+	}
+	if _, err := rw.Write([]byte{1, 0}); err != nil {
+		return "", err
+	}
+	return string(user), nil
+}
+
+// DecodeUDPPacket splits a SOCKS5 UDP datagram into its destination and payload
+func DecodeUDPPacket(packet []byte) (addr M.SocksAddr, payload []byte, err error) {
+	if len(packet) < 5 {
+		err = errors.New("insufficient length of packet")
+		return
+	}
+
+	// packet[0] and packet[1] are reserved
+	if !bytes.Equal(packet[:2], []byte{0, 0}) {
+		err = errors.New("reserved fields should be zero")
+		return
+	}
+
+	// The FRAG field is not supported
+	if packet[2] != 0 {
+		err = errors.New("discarding fragmented payload")
+		return
+	}
+
+	addr = M.SplitSocksAddr(packet[3:])
+	if addr == nil {
+		err = M.ErrInvalidSocksAddr
+		return
+	}
+
+	payload = packet[3+len(addr):]
+	return
+}
+
+// EncodeUDPPacket prepends the SOCKS5 UDP request header for addr to payload
+func EncodeUDPPacket(addr M.SocksAddr, payload []byte) ([]byte, error) {
+	if addr == nil {
+		return nil, M.ErrInvalidSocksAddr
+	}
+	packet := make([]byte, 0, 3+len(addr)+len(payload))
+	packet = append(packet, 0, 0, 0)
+	packet = append(packet, addr...)
+	return append(packet, payload...), nil
+}
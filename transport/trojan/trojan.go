@@ -0,0 +1,241 @@
+package trojan
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	M "github.com/lumavpn/luma/metadata"
+)
+
+// KeyLength is the length of the hex encoded password hash starting every request
+const KeyLength = 56
+
+// Command is a Trojan request command, the same as the SOCKS5 ones
+type Command = uint8
+
+const (
+	CommandTCP Command = 1
+	CommandUDP Command = 3
+)
+
+// maxPayloadLength is the maximum payload of a UDP packet over the stream
+const maxPayloadLength = 0xffff
+
+var crlf = []byte{'\r', '\n'}
+
+var (
+	ErrBadRequest     = errors.New("bad trojan request")
+	ErrPacketTooLarge = errors.New("trojan packet too large")
+)
+
+// Key returns the hex encoded SHA224 hash of password authenticating the requests
+func Key(password string) [KeyLength]byte {
+	var key [KeyLength]byte
+	hash := sha256.Sum224([]byte(password))
+	hex.Encode(key[:], hash[:])
+	return key
+}
+
+// appendRequest appends the request header of command to target to b
+func appendRequest(b []byte, key [KeyLength]byte, command Command, target M.SocksAddr) []byte {
+	b = append(b, key[:]...)
+	b = append(b, crlf...)
+	b = append(b, command)
+	b = append(b, target...)
+	return append(b, crlf...)
+}
+
+// ReadRequest reads the request header of a client stream from r
+func ReadRequest(r io.Reader) (key [KeyLength]byte, command Command, target M.SocksAddr, err error) {
+	buf := make([]byte, KeyLength+2+1)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+	if !bytes.Equal(buf[KeyLength:KeyLength+2], crlf) {
+		err = ErrBadRequest
+		return
+	}
+	copy(key[:], buf)
+	command = buf[KeyLength+2]
+	if command != CommandTCP && command != CommandUDP {
+		err = ErrBadRequest
+		return
+	}
+	if target, err = M.ReadSocksAddr(r); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(r, buf[:2]); err != nil {
+		return
+	}
+	if !bytes.Equal(buf[:2], crlf) {
+		err = ErrBadRequest
+	}
+	return
+}
+
+// Conn is the client side of a Trojan stream. The request header is sent along with the
+// first write
+type Conn struct {
+	net.Conn
+	key     [KeyLength]byte
+	target  M.SocksAddr
+	command Command
+
+	mu          sync.Mutex
+	wroteHeader bool
+}
+
+// NewConn returns a client stream over conn, usually a TLS connection, requesting a
+// connection to target
+func NewConn(conn net.Conn, key [KeyLength]byte, target M.SocksAddr) *Conn {
+	return &Conn{Conn: conn, key: key, target: target, command: CommandTCP}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.wroteHeader {
+		return c.Conn.Write(b)
+	}
+	out := appendRequest(make([]byte, 0, KeyLength+len(c.target)+5+len(b)), c.key, c.command, c.target)
+	if _, err := c.Conn.Write(append(out, b...)); err != nil {
+		return 0, err
+	}
+	c.wroteHeader = true
+	return len(b), nil
+}
+
+// writeHeader sends the request header if nothing was written yet
+func (c *Conn) writeHeader() error {
+	c.mu.Lock()
+	wroteHeader := c.wroteHeader
+	c.mu.Unlock()
+	if wroteHeader {
+		return nil
+	}
+	_, err := c.Write(nil)
+	return err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	// servers wait for the request before answering
+	if err := c.writeHeader(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// PacketConn relays UDP packets over a Trojan stream
+type PacketConn struct {
+	conn *Conn
+
+	rmu sync.Mutex
+	wmu sync.Mutex
+}
+
+// NewPacketConn returns a client packet connection relaying UDP packets over conn
+func NewPacketConn(conn net.Conn, key [KeyLength]byte) *PacketConn {
+	// the target of the request is unused, the destination of every packet is in its header
+	target := M.ParseSocksAddr("0.0.0.0:0")
+	return &PacketConn{conn: &Conn{Conn: conn, key: key, target: target, command: CommandUDP}}
+}
+
+// ServerPacketConn returns the server side of a UDP request whose header was read from conn
+func ServerPacketConn(conn net.Conn) *PacketConn {
+	return &PacketConn{conn: &Conn{Conn: conn, wroteHeader: true}}
+}
+
+// WritePacket writes b with the address addr, the destination of clients and the source of
+// servers
+func (pc *PacketConn) WritePacket(b []byte, addr M.SocksAddr) error {
+	if len(b) > maxPayloadLength {
+		return ErrPacketTooLarge
+	}
+	out := make([]byte, 0, len(addr)+4+len(b))
+	out = append(out, addr...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(b)))
+	out = append(out, crlf...)
+	out = append(out, b...)
+
+	pc.wmu.Lock()
+	defer pc.wmu.Unlock()
+	_, err := pc.conn.Write(out)
+	return err
+}
+
+// ReadPacket reads a packet into b and returns its length and address
+func (pc *PacketConn) ReadPacket(b []byte) (int, M.SocksAddr, error) {
+	if err := pc.conn.writeHeader(); err != nil {
+		return 0, nil, err
+	}
+	pc.rmu.Lock()
+	defer pc.rmu.Unlock()
+	addr, err := M.ReadSocksAddr(pc.conn.Conn)
+	if err != nil {
+		return 0, nil, err
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(pc.conn.Conn, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if !bytes.Equal(header[2:], crlf) {
+		return 0, nil, ErrBadRequest
+	}
+	length := int(binary.BigEndian.Uint16(header[:2]))
+	if length > len(b) {
+		// drop what does not fit
+		if _, err := io.ReadFull(pc.conn.Conn, b); err != nil {
+			return 0, nil, err
+		}
+		_, err := io.CopyN(io.Discard, pc.conn.Conn, int64(length-len(b)))
+		return len(b), addr, err
+	}
+	n, err := io.ReadFull(pc.conn.Conn, b[:length])
+	return n, addr, err
+}
+
+func (pc *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := M.ParseSocksAddr(addr.String())
+	if target == nil {
+		return 0, M.ErrInvalidSocksAddr
+	}
+	if err := pc.WritePacket(b, target); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := pc.ReadPacket(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, addr.UDPAddr(), nil
+}
+
+func (pc *PacketConn) Close() error {
+	return pc.conn.Close()
+}
+
+func (pc *PacketConn) LocalAddr() net.Addr {
+	return pc.conn.LocalAddr()
+}
+
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	return pc.conn.SetDeadline(t)
+}
+
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	return pc.conn.SetReadDeadline(t)
+}
+
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error {
+	return pc.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,62 @@
+package trojan
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	key := Key("password")
+	assert.Equal(t, "d63dc919e201d7bc4c825630d2cf25fdc93d4b2f0d46706d29038d01", string(key[:]))
+}
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go NewConn(client, Key("password"), M.ParseSocksAddr("example.com:443")).Write([]byte("hello"))
+
+	key, command, target, err := ReadRequest(server)
+	require.NoError(t, err)
+	assert.Equal(t, Key("password"), key)
+	assert.Equal(t, CommandTCP, command)
+	assert.Equal(t, "example.com:443", target.String())
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(server, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestPacketConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	pc := NewPacketConn(client, Key("password"))
+	go pc.WriteTo([]byte("query"), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 53})
+
+	_, command, _, err := ReadRequest(server)
+	require.NoError(t, err)
+	assert.Equal(t, CommandUDP, command)
+
+	spc := ServerPacketConn(server)
+	buf := make([]byte, 64)
+	n, addr, err := spc.ReadPacket(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+	assert.Equal(t, "1.2.3.4:53", addr.String())
+
+	go spc.WritePacket([]byte("answer"), M.ParseSocksAddr("1.2.3.4:53"))
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "answer", string(buf[:n]))
+	assert.Equal(t, "1.2.3.4:53", from.String())
+
+	assert.ErrorIs(t, spc.WritePacket(make([]byte, maxPayloadLength+1), nil), ErrPacketTooLarge)
+}
@@ -0,0 +1,337 @@
+package ws
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultEarlyDataHeaderName is the header carrying early data when none is configured
+const DefaultEarlyDataHeaderName = "Sec-WebSocket-Protocol"
+
+// handshakeTimeout bounds the handshakes delayed until the first write
+const handshakeTimeout = 10 * time.Second
+
+// Config is the configuration of a WebSocket client
+type Config struct {
+	// Host is the Host header of the handshake
+	Host    string
+	Path    string
+	Headers http.Header
+	// MaxEarlyData is the maximum size of the first write sent in the handshake request, which
+	// is then delayed until the first write. Zero disables early data
+	MaxEarlyData        int
+	EarlyDataHeaderName string
+}
+
+// StreamConn returns a WebSocket connection over conn, which is usually a TLS connection
+func StreamConn(ctx context.Context, conn net.Conn, config *Config) (net.Conn, error) {
+	if config.MaxEarlyData > 0 {
+		return newEarlyConn(conn, config), nil
+	}
+	return handshake(ctx, conn, config, nil)
+}
+
+// handshake upgrades conn to a WebSocket connection sending earlyData in the request
+func handshake(ctx context.Context, conn net.Conn, config *Config, earlyData []byte) (net.Conn, error) {
+	dialer := &websocket.Dialer{
+		NetDialContext: func(context.Context, string, string) (net.Conn, error) {
+			return conn, nil
+		},
+	}
+	header := config.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if config.Host != "" {
+		header.Set("Host", config.Host)
+	}
+	if len(earlyData) > 0 {
+		header.Set(earlyDataHeaderName(config.EarlyDataHeaderName), base64.RawURLEncoding.EncodeToString(earlyData))
+	}
+	// the path may carry a query
+	path := config.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid websocket path %q: %w", config.Path, err)
+	}
+	// the scheme is plain ws as conn is already secured if needed
+	u.Scheme = "ws"
+	u.Host = conn.RemoteAddr().String()
+	wsConn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("websocket handshake: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("websocket handshake: %w", err)
+	}
+	return &Conn{Conn: wsConn}, nil
+}
+
+func earlyDataHeaderName(name string) string {
+	if name == "" {
+		return DefaultEarlyDataHeaderName
+	}
+	return name
+}
+
+// Accept upgrades the WebSocket request r and returns the connection. Early data sent in the
+// header earlyDataHeaderName, or the default one if empty, is returned by the first reads
+func Accept(w http.ResponseWriter, r *http.Request, earlyDataHeaderName string) (net.Conn, error) {
+	name := earlyDataHeaderName
+	if name == "" {
+		name = DefaultEarlyDataHeaderName
+	}
+	var earlyData []byte
+	var responseHeader http.Header
+	if value := r.Header.Get(name); value != "" {
+		if data, err := base64.RawURLEncoding.DecodeString(value); err == nil {
+			earlyData = data
+			if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(DefaultEarlyDataHeaderName) {
+				// clients expect the protocol they requested
+				responseHeader = http.Header{DefaultEarlyDataHeaderName: {value}}
+			}
+		}
+	}
+	upgrader := &websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	wsConn, err := upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: wsConn, early: earlyData}, nil
+}
+
+// Conn is a stream over the binary messages of a WebSocket connection
+type Conn struct {
+	*websocket.Conn
+	// early is the early data not read yet
+	early []byte
+
+	rmu    sync.Mutex
+	reader io.Reader
+	wmu    sync.Mutex
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if len(c.early) > 0 {
+		n := copy(b, c.early)
+		c.early = c.early[n:]
+		return n, nil
+	}
+	for {
+		if c.reader == nil {
+			_, reader, err := c.Conn.NextReader()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) Close() error {
+	c.wmu.Lock()
+	c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.wmu.Unlock()
+	return c.Conn.Close()
+}
+
+// earlyConn delays the handshake until the first write to send the beginning of it along
+type earlyConn struct {
+	raw    net.Conn
+	config *Config
+
+	mu   sync.Mutex
+	conn net.Conn
+	// err is why the connection could not be established
+	err error
+	// established is closed once the handshake has been done or has failed, or conn is closed
+	established chan struct{}
+	// deadlines are applied to conn once established
+	readDeadline, writeDeadline time.Time
+	// readDeadlineChanged is closed when the read deadline changes
+	readDeadlineChanged chan struct{}
+}
+
+func newEarlyConn(raw net.Conn, config *Config) *earlyConn {
+	return &earlyConn{
+		raw:                 raw,
+		config:              config,
+		established:         make(chan struct{}),
+		readDeadlineChanged: make(chan struct{}),
+	}
+}
+
+// isEstablished reports whether the handshake is over. It must be called with c.mu held
+func (c *earlyConn) isEstablished() bool {
+	return c.conn != nil || c.err != nil
+}
+
+// handshake establishes the WebSocket connection sending the beginning of b along and
+// returns the connection and how much of b was sent
+func (c *earlyConn) handshake(b []byte) (net.Conn, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isEstablished() {
+		return c.conn, 0, c.err
+	}
+	n := min(len(b), c.config.MaxEarlyData)
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	defer close(c.established)
+	conn, err := handshake(ctx, c.raw, c.config, b[:n])
+	if err != nil {
+		c.err = err
+		return nil, 0, err
+	}
+	conn.SetReadDeadline(c.readDeadline)
+	conn.SetWriteDeadline(c.writeDeadline)
+	c.conn = conn
+	return conn, n, nil
+}
+
+// wait returns the connection once established by the first write, or an error if the read
+// deadline passes before
+func (c *earlyConn) wait() (net.Conn, error) {
+	for {
+		c.mu.Lock()
+		if c.isEstablished() {
+			c.mu.Unlock()
+			return c.conn, c.err
+		}
+		deadline, changed := c.readDeadline, c.readDeadlineChanged
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case <-c.established:
+		case <-changed:
+		case <-timeout:
+			return nil, os.ErrDeadlineExceeded
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Read waits for the first write, as the peer may not send anything before receiving it
+func (c *earlyConn) Read(b []byte) (int, error) {
+	conn, err := c.wait()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(b)
+}
+
+func (c *earlyConn) Write(b []byte) (int, error) {
+	conn, n, err := c.handshake(b)
+	if err != nil {
+		return 0, err
+	}
+	if n == len(b) {
+		return n, nil
+	}
+	written, err := conn.Write(b[n:])
+	return n + written, err
+}
+
+func (c *earlyConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	if c.err == nil {
+		c.err = net.ErrClosed
+		close(c.established)
+	}
+	return c.raw.Close()
+}
+
+func (c *earlyConn) LocalAddr() net.Addr {
+	return c.raw.LocalAddr()
+}
+
+func (c *earlyConn) RemoteAddr() net.Addr {
+	return c.raw.RemoteAddr()
+}
+
+func (c *earlyConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *earlyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if c.conn != nil {
+		return c.conn.SetReadDeadline(t)
+	}
+	close(c.readDeadlineChanged)
+	c.readDeadlineChanged = make(chan struct{})
+	return nil
+}
+
+func (c *earlyConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	if c.conn != nil {
+		return c.conn.SetWriteDeadline(t)
+	}
+	return nil
+}
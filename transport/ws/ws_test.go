@@ -0,0 +1,171 @@
+package ws
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer starts a WebSocket echo server. The headers of every request are sent to headers
+func startServer(t *testing.T, earlyDataHeaderName string, headers chan<- http.Header) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tunnel" {
+			http.NotFound(w, r)
+			return
+		}
+		headers <- r.Header
+		conn, err := Accept(w, r, earlyDataHeaderName)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func TestStreamConn(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config Config
+		// early is the early data expected in the header
+		early string
+	}{
+		{"plain", Config{Path: "/tunnel"}, ""},
+		{"early data", Config{Path: "/tunnel", MaxEarlyData: 2}, "cG4"},
+		{"early data header", Config{Path: "/tunnel", MaxEarlyData: 2048, EarlyDataHeaderName: "X-Early"}, "cGluZw"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			headers := make(chan http.Header, 1)
+			addr := startServer(t, test.config.EarlyDataHeaderName, headers)
+			test.config.Host = "example.com"
+			test.config.Headers = http.Header{"User-Agent": {"luma"}}
+
+			raw, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := StreamConn(ctx, raw, &test.config)
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			payload := "ping"
+			if test.config.MaxEarlyData == 2 {
+				payload = "pn"
+			}
+			_, err = conn.Write([]byte(payload))
+			require.NoError(t, err)
+			buf := make([]byte, len(payload))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(buf))
+
+			header := <-headers
+			assert.Equal(t, "luma", header.Get("User-Agent"))
+			assert.Equal(t, test.early, header.Get(earlyDataHeaderName(test.config.EarlyDataHeaderName)))
+		})
+	}
+}
+
+func TestStreamConnPathQuery(t *testing.T) {
+	uris := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uris <- r.RequestURI
+		conn, err := Accept(w, r, "")
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		path string
+		uri  string
+	}{
+		{"", "/"},
+		{"tunnel", "/tunnel"},
+		{"/tunnel?ed=2048&key=a%2Fb", "/tunnel?ed=2048&key=a%2Fb"},
+	} {
+		raw, err := net.Dial("tcp", server.Listener.Addr().String())
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := StreamConn(ctx, raw, &Config{Path: test.path})
+		cancel()
+		require.NoError(t, err, test.path)
+		conn.Close()
+		assert.Equal(t, test.uri, <-uris)
+	}
+}
+
+func TestEarlyDataReadFirst(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	addr := startServer(t, "", headers)
+	raw, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	conn, err := StreamConn(context.Background(), raw, &Config{Path: "/tunnel", MaxEarlyData: 2048})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// a read before any write waits for it instead of handshaking without early data
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	select {
+	case <-headers:
+		t.Fatal("handshake before the first write")
+	default:
+	}
+
+	// a relay reads the connection while the other direction writes the first data
+	conn.SetReadDeadline(time.Time{})
+	read := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			read <- err.Error()
+			return
+		}
+		read <- string(buf)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	select {
+	case s := <-read:
+		assert.Equal(t, "ping", s)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read timed out")
+	}
+	assert.Equal(t, "cGluZw", (<-headers).Get(DefaultEarlyDataHeaderName))
+}
+
+func TestEarlyDataClose(t *testing.T) {
+	raw, _ := net.Pipe()
+	conn, err := StreamConn(context.Background(), raw, &Config{MaxEarlyData: 2048})
+	require.NoError(t, err)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read not unblocked by close")
+	}
+}
@@ -0,0 +1,151 @@
+package luma
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/lumavpn/luma/common/dialer"
+	"github.com/lumavpn/luma/config"
+	"github.com/lumavpn/luma/log"
+	"github.com/lumavpn/luma/stack"
+	"github.com/lumavpn/luma/tun"
+)
+
+// startTun creates the TUN device and starts the stack terminating its flows if TUN is enabled
+func (lu *Luma) startTun(cfg config.Tun) error {
+	if !cfg.Enable {
+		return nil
+	}
+	inet4Address, err := parsePrefixes(cfg.Inet4Address)
+	if err != nil {
+		return err
+	}
+	inet6Address, err := parsePrefixes(cfg.Inet6Address)
+	if err != nil {
+		return err
+	}
+
+	device, err := tun.New(tun.Options{
+		Name:         cfg.Device,
+		MTU:          cfg.MTU,
+		Inet4Address: inet4Address,
+		Inet6Address: inet6Address,
+	})
+	if err != nil {
+		return err
+	}
+	tunStack, err := stack.New(cfg.Stack, stack.Config{
+		Device:       device,
+		Handler:      lu.tunnel,
+		Inet4Address: inet4Address,
+		Inet6Address: inet6Address,
+		MTU:          cfg.MTU,
+
+		TCPReceiveBufferSize: cfg.TCPReceiveBufferSize,
+		TCPSendBufferSize:    cfg.TCPSendBufferSize,
+	})
+	if err != nil {
+		device.Close()
+		return err
+	}
+	if err := tunStack.Start(); err != nil {
+		device.Close()
+		return err
+	}
+
+	lu.mu.Lock()
+	defer lu.mu.Unlock()
+	lu.tunDevice = device
+	lu.tunStack = tunStack
+	log.Infof("TUN device %s started with %s stack", device.Name(), cfg.Stack)
+
+	if cfg.AutoDetectInterface {
+		iface, err := tun.DefaultRouteInterface(device.Name())
+		if err != nil {
+			lu.closeTun()
+			return fmt.Errorf("detect interface: %w", err)
+		}
+		dialer.SetDefaultInterface(iface)
+		lu.tunInterface = iface
+		log.Infof("Outbound interface %s detected", iface)
+	}
+	if cfg.AutoRoute {
+		if err := lu.startAutoRoute(cfg, device, inet4Address, inet6Address); err != nil {
+			lu.closeTun()
+			return err
+		}
+	}
+	return nil
+}
+
+// startAutoRoute routes the traffic through device, which outbound sockets bypass by being bound
+// to the detected interface. Rules left behind by a previous run that did not stop cleanly are
+// removed first. It must be called with lu.mu held
+func (lu *Luma) startAutoRoute(cfg config.Tun, device tun.Device, inet4Address, inet6Address []netip.Prefix) error {
+	excludeAddress, err := parsePrefixes(cfg.RouteExcludeAddress)
+	if err != nil {
+		return err
+	}
+	excludeUID := make([]tun.UIDRange, 0, len(cfg.ExcludeUID))
+	for _, s := range cfg.ExcludeUID {
+		uid, err := tun.ParseUIDRange(s)
+		if err != nil {
+			return err
+		}
+		excludeUID = append(excludeUID, uid)
+	}
+
+	if err := tun.CleanupRoutes(cfg.RouteStateFile); err != nil {
+		log.Warnf("Failed to clean up previous routes: %v", err)
+	}
+	routes, err := tun.AutoRoute(device, tun.RouteOptions{
+		IPv4:             len(inet4Address) > 0,
+		IPv6:             len(inet6Address) > 0,
+		TableIndex:       cfg.TableIndex,
+		ExcludeInterface: lu.tunInterface,
+		ExcludeAddress:   excludeAddress,
+		ExcludeUID:       excludeUID,
+		StateFile:        cfg.RouteStateFile,
+	})
+	if err != nil {
+		return fmt.Errorf("auto-route: %w", err)
+	}
+	lu.tunRoutes = routes
+	log.Infof("Routes through TUN device %s installed", device.Name())
+	return nil
+}
+
+// closeTun removes the routes through the TUN device, stops the stack and closes the device.
+// It must be called with lu.mu held
+func (lu *Luma) closeTun() {
+	if lu.tunRoutes != nil {
+		if err := lu.tunRoutes.Close(); err != nil {
+			log.Warnf("Failed to remove routes: %v", err)
+		}
+		lu.tunRoutes = nil
+	}
+	if lu.tunInterface != "" {
+		dialer.SetDefaultInterface("")
+		lu.tunInterface = ""
+	}
+	if lu.tunStack != nil {
+		lu.tunStack.Close()
+		lu.tunStack = nil
+	}
+	if lu.tunDevice != nil {
+		lu.tunDevice.Close()
+		lu.tunDevice = nil
+	}
+}
+
+func parsePrefixes(prefixes []string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0, len(prefixes))
+	for _, s := range prefixes {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %s: %w", s, err)
+		}
+		result = append(result, prefix)
+	}
+	return result, nil
+}
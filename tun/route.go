@@ -0,0 +1,89 @@
+package tun
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultTableIndex is the routing table holding the routes through the device
+	DefaultTableIndex = 2022
+	// DefaultRulePriority is the priority of the first ip rule installed by AutoRoute
+	DefaultRulePriority = 9000
+	// DefaultRouteStateFile is where the installed rules are recorded, in a directory only
+	// writable by root
+	DefaultRouteStateFile = "/run/luma/route.json"
+)
+
+// RouteOptions are the options used to route traffic through a TUN device
+type RouteOptions struct {
+	// IPv4 and IPv6 select the address families routed through the device
+	IPv4 bool
+	IPv6 bool
+	// TableIndex is the routing table holding the default routes, DefaultTableIndex if it is zero
+	TableIndex int
+	// RulePriority is the priority of the first ip rule, DefaultRulePriority if it is zero
+	RulePriority int
+	// ExcludeInterface is the outbound interface whose traffic bypasses the device
+	ExcludeInterface string
+	// ExcludeAddress are the destinations bypassing the device
+	ExcludeAddress []netip.Prefix
+	// ExcludeUID are the users whose traffic bypasses the device
+	ExcludeUID []UIDRange
+	// StateFile records the installed rules so that CleanupRoutes can remove them even after a crash
+	StateFile string
+}
+
+// UIDRange is an inclusive range of user IDs
+type UIDRange struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+// ParseUIDRange parses a single user ID or a range of them written as start-end
+func ParseUIDRange(s string) (UIDRange, error) {
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		end = start
+	}
+	first, err := strconv.ParseUint(strings.TrimSpace(start), 10, 32)
+	if err != nil {
+		return UIDRange{}, fmt.Errorf("invalid uid range %s: %w", s, err)
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(end), 10, 32)
+	if err != nil {
+		return UIDRange{}, fmt.Errorf("invalid uid range %s: %w", s, err)
+	}
+	if first > last {
+		return UIDRange{}, fmt.Errorf("invalid uid range %s: start is greater than end", s)
+	}
+	return UIDRange{Start: uint32(first), End: uint32(last)}, nil
+}
+
+// routeState is the content of the state file, listing what AutoRoute installed
+type routeState struct {
+	TableIndex int         `json:"table-index"`
+	Rules      []ruleState `json:"rules"`
+}
+
+// ruleState identifies an installed ip rule by all its attributes, so that only that rule is
+// removed and not another one sharing its priority
+type ruleState struct {
+	Family   int    `json:"family"`
+	Priority int    `json:"priority"`
+	Table    int    `json:"table"`
+	Dst      string `json:"dst,omitempty"`
+	OifName  string `json:"oif,omitempty"`
+	// UID is the range of users the rule applies to, nil for all of them
+	UID *UIDRange `json:"uid,omitempty"`
+	// SuppressDefault ignores the default routes of the table
+	SuppressDefault bool `json:"suppress-default,omitempty"`
+}
+
+// Routes are the rules and routes installed by AutoRoute
+type Routes struct {
+	state     *routeState
+	stateFile string
+}
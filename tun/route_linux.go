@@ -0,0 +1,291 @@
+//go:build linux
+
+package tun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// AutoRoute routes the traffic not excluded by options through the device. Excluded traffic
+// keeps using the main routing table, as do destinations with a more specific route than the
+// default one, so that local networks stay reachable. The installed rules are recorded in
+// options.StateFile before they are added, and removed by closing the returned Routes
+func AutoRoute(device Device, options RouteOptions) (*Routes, error) {
+	if options.TableIndex == 0 {
+		options.TableIndex = DefaultTableIndex
+	}
+	if options.RulePriority == 0 {
+		options.RulePriority = DefaultRulePriority
+	}
+	link, err := netlink.LinkByName(device.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	var families []int
+	if options.IPv4 {
+		families = append(families, unix.AF_INET)
+	}
+	if options.IPv6 {
+		families = append(families, unix.AF_INET6)
+	}
+	state := &routeState{TableIndex: options.TableIndex}
+	var rules []*netlink.Rule
+	for _, family := range families {
+		for _, rule := range buildRules(family, options) {
+			rules = append(rules, rule)
+			state.Rules = append(state.Rules, newRuleState(rule))
+		}
+	}
+	if options.StateFile != "" {
+		if err := writeRouteState(options.StateFile, state); err != nil {
+			return nil, fmt.Errorf("write route state: %w", err)
+		}
+	}
+
+	routes := &Routes{state: state, stateFile: options.StateFile}
+	if err := addRoutes(link, families, options.TableIndex); err != nil {
+		routes.Close()
+		return nil, err
+	}
+	for _, rule := range rules {
+		if err := netlink.RuleAdd(rule); err != nil {
+			routes.Close()
+			return nil, fmt.Errorf("add rule %s: %w", rule, err)
+		}
+	}
+	return routes, nil
+}
+
+// Close removes the rules and routes, then the state file
+func (r *Routes) Close() error {
+	cleanupState(r.state)
+	if r.stateFile == "" {
+		return nil
+	}
+	if err := os.Remove(r.stateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// CleanupRoutes removes the rules and routes recorded in stateFile by AutoRoute, then the file
+// itself. It does nothing if the file does not exist
+func CleanupRoutes(stateFile string) error {
+	state, err := readRouteState(stateFile)
+	if err != nil || state == nil {
+		return err
+	}
+	return (&Routes{state: state, stateFile: stateFile}).Close()
+}
+
+// DefaultRouteInterface returns the interface of the default route of the main routing table,
+// ignoring the interface exclude
+func DefaultRouteInterface(exclude string) (string, error) {
+	for _, family := range []int{unix.AF_INET, unix.AF_INET6} {
+		routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: unix.RT_TABLE_MAIN}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return "", err
+		}
+		for _, route := range routes {
+			if route.Dst != nil {
+				if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+					continue
+				}
+			}
+			index := route.LinkIndex
+			if index == 0 && len(route.MultiPath) > 0 {
+				index = route.MultiPath[0].LinkIndex
+			}
+			link, err := netlink.LinkByIndex(index)
+			if err != nil {
+				continue
+			}
+			if name := link.Attrs().Name; name != exclude {
+				return name, nil
+			}
+		}
+	}
+	return "", errors.New("no default route found")
+}
+
+// buildRules returns the rules of family in priority order. Exclusions look up the main table
+// first, then the main table without its default routes, then the table of the device
+func buildRules(family int, options RouteOptions) []*netlink.Rule {
+	priority := options.RulePriority
+	newRule := func(table int) *netlink.Rule {
+		rule := netlink.NewRule()
+		rule.Family = family
+		rule.Priority = priority
+		rule.Table = table
+		return rule
+	}
+
+	var rules []*netlink.Rule
+	for _, uid := range options.ExcludeUID {
+		rule := newRule(unix.RT_TABLE_MAIN)
+		rule.UIDRange = netlink.NewRuleUIDRange(uid.Start, uid.End)
+		rules = append(rules, rule)
+	}
+	for _, prefix := range options.ExcludeAddress {
+		if prefix.Addr().Is4() != (family == unix.AF_INET) {
+			continue
+		}
+		rule := newRule(unix.RT_TABLE_MAIN)
+		rule.Dst = prefixToIPNet(prefix.Masked())
+		rules = append(rules, rule)
+	}
+	if options.ExcludeInterface != "" {
+		rule := newRule(unix.RT_TABLE_MAIN)
+		rule.OifName = options.ExcludeInterface
+		rules = append(rules, rule)
+	}
+
+	priority++
+	rule := newRule(unix.RT_TABLE_MAIN)
+	rule.SuppressPrefixlen = 0
+	rules = append(rules, rule)
+
+	priority++
+	rules = append(rules, newRule(options.TableIndex))
+	return rules
+}
+
+// addRoutes adds default routes of families through link to table
+func addRoutes(link netlink.Link, families []int, table int) error {
+	for _, family := range families {
+		dst := &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+		if family == unix.AF_INET6 {
+			dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		}
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Table: table}
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("add route %s: %w", dst, err)
+		}
+	}
+	return nil
+}
+
+// newRuleState returns the state identifying rule
+func newRuleState(rule *netlink.Rule) ruleState {
+	r := ruleState{
+		Family:          rule.Family,
+		Priority:        rule.Priority,
+		Table:           rule.Table,
+		OifName:         rule.OifName,
+		SuppressDefault: rule.SuppressPrefixlen == 0,
+	}
+	if rule.Dst != nil {
+		r.Dst = rule.Dst.String()
+	}
+	if rule.UIDRange != nil {
+		r.UID = &UIDRange{Start: rule.UIDRange.Start, End: rule.UIDRange.End}
+	}
+	return r
+}
+
+// rule returns the rule identified by the state
+func (r ruleState) rule() (*netlink.Rule, error) {
+	rule := netlink.NewRule()
+	rule.Family = r.Family
+	rule.Priority = r.Priority
+	rule.Table = r.Table
+	rule.OifName = r.OifName
+	if r.SuppressDefault {
+		rule.SuppressPrefixlen = 0
+	}
+	if r.Dst != "" {
+		_, dst, err := net.ParseCIDR(r.Dst)
+		if err != nil {
+			return nil, err
+		}
+		rule.Dst = dst
+	}
+	if r.UID != nil {
+		rule.UIDRange = netlink.NewRuleUIDRange(r.UID.Start, r.UID.End)
+	}
+	return rule, nil
+}
+
+// cleanupState removes the rules and the routes of the table recorded in state, ignoring those
+// already gone
+func cleanupState(state *routeState) {
+	for _, r := range state.Rules {
+		if r.Table == 0 {
+			// an unspecified table would match the rules of every table
+			continue
+		}
+		rule, err := r.rule()
+		if err != nil {
+			continue
+		}
+		// the same rule may have been added more than once, delete it until none is left
+		for netlink.RuleDel(rule) == nil {
+		}
+	}
+	if state.TableIndex == 0 {
+		// an unspecified table would match the routes of every table
+		return
+	}
+	for _, family := range []int{unix.AF_INET, unix.AF_INET6} {
+		routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: state.TableIndex}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			continue
+		}
+		for _, route := range routes {
+			netlink.RouteDel(&route)
+		}
+	}
+}
+
+// readRouteState reads the state file at path, nil if it does not exist. A symlink is not followed
+func readRouteState(path string) (*routeState, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	state := &routeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parse route state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// writeRouteState creates the state file at path and its directory. It fails if the file
+// exists, so that a file or symlink planted in its place is never written through
+func writeRouteState(path string, state *routeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,174 @@
+//go:build linux
+
+package tun
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const netnsEnv = "LUMA_TEST_NETNS"
+
+// runInNetns runs the calling test again in a new network namespace. It reports true in the
+// namespace, where the test must run, and false in the parent, which is done
+func runInNetns(t *testing.T) bool {
+	if os.Getenv(netnsEnv) != "" {
+		return true
+	}
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+	if _, err := os.Stat(cloneDevicePath); err != nil {
+		t.Skip("requires " + cloneDevicePath)
+	}
+	unshare, err := exec.LookPath("unshare")
+	if err != nil {
+		t.Skip("requires unshare")
+	}
+
+	cmd := exec.Command(unshare, "--net", os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), netnsEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return false
+}
+
+// routeLink returns the name of the interface the kernel routes dst through
+func routeLink(t *testing.T, dst string, options *netlink.RouteGetOptions) string {
+	if options == nil {
+		options = &netlink.RouteGetOptions{}
+	}
+	routes, err := netlink.RouteGetWithOptions(net.ParseIP(dst), options)
+	require.NoError(t, err)
+	require.NotEmpty(t, routes)
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	require.NoError(t, err)
+	return link.Attrs().Name
+}
+
+// rulesAt returns the rules of family with the given priority
+func rulesAt(t *testing.T, family, priority int) []netlink.Rule {
+	rules, err := netlink.RuleListFiltered(family, &netlink.Rule{Priority: priority}, netlink.RT_FILTER_PRIORITY)
+	require.NoError(t, err)
+	return rules
+}
+
+func TestAutoRoute(t *testing.T) {
+	if !runInNetns(t) {
+		return
+	}
+
+	// an uplink with the default route of the main table
+	uplink := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}
+	require.NoError(t, netlink.LinkAdd(uplink))
+	addr, err := netlink.ParseAddr("10.99.0.1/24")
+	require.NoError(t, err)
+	require.NoError(t, netlink.AddrAdd(uplink, addr))
+	require.NoError(t, netlink.LinkSetUp(uplink))
+	peer, err := netlink.LinkByName("uplink1")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetUp(peer))
+	require.NoError(t, netlink.RouteAdd(&netlink.Route{LinkIndex: uplink.Attrs().Index, Gw: net.IPv4(10, 99, 0, 254)}))
+
+	device, err := New(Options{Name: "luma-route", MTU: 1500, Inet4Address: []netip.Prefix{netip.MustParsePrefix("198.18.0.1/30")}})
+	require.NoError(t, err)
+
+	iface, err := DefaultRouteInterface(device.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "uplink0", iface)
+
+	// a rule of another program sharing the priority of the first rule is left untouched
+	foreign := netlink.NewRule()
+	foreign.Family = unix.AF_INET
+	foreign.Priority = DefaultRulePriority
+	foreign.Table = 100
+	require.NoError(t, netlink.RuleAdd(foreign))
+
+	stateFile := filepath.Join(t.TempDir(), "route.json")
+	uid := uint32(1500)
+	options := RouteOptions{
+		IPv4:             true,
+		ExcludeInterface: iface,
+		ExcludeAddress:   []netip.Prefix{netip.MustParsePrefix("192.168.50.0/24")},
+		ExcludeUID:       []UIDRange{{Start: 1000, End: 2000}},
+		StateFile:        stateFile,
+	}
+	_, err = AutoRoute(device, options)
+	require.NoError(t, err)
+	assert.FileExists(t, stateFile)
+
+	assert.Equal(t, "luma-route", routeLink(t, "1.1.1.1", nil))
+	assert.Equal(t, "uplink0", routeLink(t, "1.1.1.1", &netlink.RouteGetOptions{Oif: "uplink0"}))
+	assert.Equal(t, "uplink0", routeLink(t, "1.1.1.1", &netlink.RouteGetOptions{UID: &uid}))
+	assert.Equal(t, "uplink0", routeLink(t, "192.168.50.1", nil))
+	// more specific routes of the main table still apply
+	assert.Equal(t, "uplink0", routeLink(t, "10.99.0.5", nil))
+
+	// leave the rules behind as a crash would, the device and its routes go away with the process
+	require.NoError(t, device.Close())
+	assert.NotEmpty(t, rulesAt(t, unix.AF_INET, DefaultRulePriority+2))
+
+	require.NoError(t, CleanupRoutes(stateFile))
+	assert.Len(t, rulesAt(t, unix.AF_INET, DefaultRulePriority), 1)
+	assert.Equal(t, 100, rulesAt(t, unix.AF_INET, DefaultRulePriority)[0].Table)
+	for priority := DefaultRulePriority + 1; priority <= DefaultRulePriority+2; priority++ {
+		assert.Empty(t, rulesAt(t, unix.AF_INET, priority))
+	}
+	assert.NoFileExists(t, stateFile)
+	assert.Equal(t, "uplink0", routeLink(t, "1.1.1.1", nil))
+
+	// nothing is left to clean up
+	require.NoError(t, CleanupRoutes(stateFile))
+
+	// without a state file the routes are removed by closing them
+	device, err = New(Options{Name: "luma-route", MTU: 1500, Inet4Address: []netip.Prefix{netip.MustParsePrefix("198.18.0.1/30")}})
+	require.NoError(t, err)
+	defer device.Close()
+	options.StateFile = ""
+	routes, err := AutoRoute(device, options)
+	require.NoError(t, err)
+	assert.Equal(t, "luma-route", routeLink(t, "1.1.1.1", nil))
+	require.NoError(t, routes.Close())
+	for priority := DefaultRulePriority + 1; priority <= DefaultRulePriority+2; priority++ {
+		assert.Empty(t, rulesAt(t, unix.AF_INET, priority))
+	}
+	assert.Len(t, rulesAt(t, unix.AF_INET, DefaultRulePriority), 1)
+	assert.Equal(t, "uplink0", routeLink(t, "1.1.1.1", nil))
+}
+
+func TestWriteRouteState(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "luma", "route.json")
+	state := &routeState{TableIndex: DefaultTableIndex}
+	require.NoError(t, writeRouteState(stateFile, state))
+	info, err := os.Stat(filepath.Dir(stateFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	read, err := readRouteState(stateFile)
+	require.NoError(t, err)
+	assert.Equal(t, state, read)
+
+	// an existing file is never overwritten
+	assert.Error(t, writeRouteState(stateFile, state))
+
+	// nor is the target of a symlink planted in place of the file
+	target := filepath.Join(dir, "target")
+	require.NoError(t, os.WriteFile(target, []byte("keep"), 0o644))
+	link := filepath.Join(dir, "link.json")
+	require.NoError(t, os.Symlink(target, link))
+	assert.Error(t, writeRouteState(link, state))
+	_, err = readRouteState(link)
+	assert.Error(t, err)
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "keep", string(data))
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package tun
+
+import "errors"
+
+var errAutoRouteNotSupported = errors.New("auto-route not supported on current platform")
+
+// AutoRoute routes the traffic not excluded by options through the device
+func AutoRoute(device Device, options RouteOptions) (*Routes, error) {
+	return nil, errAutoRouteNotSupported
+}
+
+// Close removes the rules and routes
+func (r *Routes) Close() error {
+	return nil
+}
+
+// CleanupRoutes removes the rules and routes recorded in stateFile
+func CleanupRoutes(stateFile string) error {
+	return nil
+}
+
+// DefaultRouteInterface returns the interface of the default route, ignoring the interface exclude
+func DefaultRouteInterface(exclude string) (string, error) {
+	return "", errAutoRouteNotSupported
+}
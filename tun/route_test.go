@@ -0,0 +1,23 @@
+package tun
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUIDRange(t *testing.T) {
+	r, err := ParseUIDRange("1000")
+	require.NoError(t, err)
+	assert.Equal(t, UIDRange{Start: 1000, End: 1000}, r)
+
+	r, err = ParseUIDRange("1000-2000")
+	require.NoError(t, err)
+	assert.Equal(t, UIDRange{Start: 1000, End: 2000}, r)
+
+	for _, s := range []string{"", "a", "1000-", "2000-1000", "-1"} {
+		_, err := ParseUIDRange(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,30 @@
+package tun
+
+import (
+	"io"
+	"net/netip"
+)
+
+// DefaultMTU is the MTU of devices created without one
+const DefaultMTU = 9000
+
+// Device is a TUN device. Each Read and Write transfers a single IP packet
+type Device interface {
+	io.ReadWriteCloser
+	// Name returns the name of the interface
+	Name() string
+	// MTU returns the MTU of the interface
+	MTU() uint32
+}
+
+// Options are the options used to create a TUN device
+type Options struct {
+	// Name is the name of the interface, the kernel picks one if it is empty
+	Name string
+	// MTU is the MTU of the interface, DefaultMTU if it is zero
+	MTU uint32
+	// Inet4Address are the IPv4 addresses assigned to the interface
+	Inet4Address []netip.Prefix
+	// Inet6Address are the IPv6 addresses assigned to the interface
+	Inet6Address []netip.Prefix
+}
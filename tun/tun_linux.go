@@ -0,0 +1,99 @@
+//go:build linux
+
+package tun
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const cloneDevicePath = "/dev/net/tun"
+
+type nativeTun struct {
+	*os.File
+	name string
+	mtu  uint32
+}
+
+// New creates a TUN device, assigns its addresses and brings it up
+func New(options Options) (Device, error) {
+	if options.MTU == 0 {
+		options.MTU = DefaultMTU
+	}
+
+	fd, err := unix.Open(cloneDevicePath, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", cloneDevicePath, err)
+	}
+	ifr, err := unix.NewIfreq(options.Name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("create tun: %w", err)
+	}
+	// the runtime poller makes reads interruptible by Close
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	t := &nativeTun{
+		File: os.NewFile(uintptr(fd), cloneDevicePath),
+		name: ifr.Name(),
+		mtu:  options.MTU,
+	}
+	if err := t.configure(options); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *nativeTun) Name() string {
+	return t.name
+}
+
+func (t *nativeTun) MTU() uint32 {
+	return t.mtu
+}
+
+// configure sets the MTU and addresses of the interface and brings it up
+func (t *nativeTun) configure(options Options) error {
+	link, err := netlink.LinkByName(t.name)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetMTU(link, int(t.mtu)); err != nil {
+		return fmt.Errorf("set mtu: %w", err)
+	}
+	for _, prefix := range append(options.Inet4Address, options.Inet6Address...) {
+		addr := &netlink.Addr{IPNet: prefixToIPNet(prefix)}
+		if prefix.Addr().Is6() {
+			// skip duplicate address detection so the address can be bound right away
+			addr.Flags = unix.IFA_F_NODAD
+		}
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("add address %s: %w", prefix, err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("set link up: %w", err)
+	}
+	return nil
+}
+
+func prefixToIPNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package tun
+
+import "errors"
+
+// New creates a TUN device, assigns its addresses and brings it up
+func New(options Options) (Device, error) {
+	return nil, errors.New("tun not supported on current platform")
+}
@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/dns"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+	D "github.com/miekg/dns"
+)
+
+// dnsHijackTimeout is how long a hijacked DNS flow may stay idle before it is closed
+const dnsHijackTimeout = 30 * time.Second
+
+// ParseDNSHijack parses hijack addresses of the form ip:port or any:port. A returned
+// AddrPort with an invalid Addr matches every destination address on the port
+func ParseDNSHijack(addrs []string) ([]netip.AddrPort, error) {
+	hijack := make([]netip.AddrPort, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimPrefix(strings.TrimPrefix(addr, "udp://"), "tcp://")
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns hijack address %s: %w", addr, err)
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns hijack port %s: %w", addr, err)
+		}
+		if host == "any" {
+			hijack = append(hijack, netip.AddrPortFrom(netip.Addr{}, uint16(p)))
+			continue
+		}
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns hijack address %s: %w", addr, err)
+		}
+		hijack = append(hijack, netip.AddrPortFrom(ip.Unmap(), uint16(p)))
+	}
+	return hijack, nil
+}
+
+func (t *tunnel) SetDNSHijack(addrs []netip.AddrPort, handler dns.Exchanger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dnsHijack = addrs
+	t.dnsHandler = handler
+}
+
+// shouldHijackDNS returns the handler that should answer the flow described by metadata,
+// or nil if the flow is not DNS traffic that must be hijacked
+func (t *tunnel) shouldHijackDNS(metadata *M.Metadata) dns.Exchanger {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.dnsHandler == nil || len(t.dnsHijack) == 0 {
+		return nil
+	}
+	dstIP := metadata.DstAddr()
+	for _, addrPort := range t.dnsHijack {
+		if addrPort.Port() != metadata.DstPort {
+			continue
+		}
+		if !addrPort.Addr().IsValid() || addrPort.Addr() == dstIP {
+			return t.dnsHandler
+		}
+	}
+	return nil
+}
+
+// hijackTCPDNS answers DNS over TCP queries on conn until it is closed or idle
+func hijackTCPDNS(conn adapter.TCPConn, handler dns.Exchanger) {
+	defer conn.Close()
+
+	dc := &D.Conn{Conn: conn}
+	for {
+		conn.SetReadDeadline(time.Now().Add(dnsHijackTimeout))
+		req, err := dc.ReadMsg()
+		if err != nil {
+			return
+		}
+		msg, err := dns.HandleMsg(context.Background(), handler, req)
+		if err != nil {
+			log.Debugf("[DNS Hijack] tcp query from %s: %v", conn.RemoteAddr(), err)
+		}
+		if err := dc.WriteMsg(msg); err != nil {
+			return
+		}
+	}
+}
+
+// hijackUDPDNS answers DNS queries read from uc until it is closed or idle
+func hijackUDPDNS(uc adapter.UDPConn, handler dns.Exchanger) {
+	defer uc.Close()
+
+	buf := make([]byte, D.MaxMsgSize)
+	for {
+		uc.SetReadDeadline(time.Now().Add(dnsHijackTimeout))
+		n, addr, err := uc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := &D.Msg{}
+		if err := req.Unpack(buf[:n]); err != nil {
+			log.Debugf("[DNS Hijack] invalid udp query from %s: %v", uc.RemoteAddr(), err)
+			continue
+		}
+
+		go func(req *D.Msg, addr net.Addr) {
+			msg, err := dns.HandleMsg(context.Background(), handler, req)
+			if err != nil {
+				log.Debugf("[DNS Hijack] udp query from %s: %v", uc.RemoteAddr(), err)
+			}
+			msg.Truncate(dns.UDPSize(req))
+			b, err := msg.Pack()
+			if err != nil {
+				return
+			}
+			uc.WriteTo(b, addr)
+		}(req, addr)
+	}
+}
@@ -0,0 +1,143 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/internal/testutil"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy"
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticExchanger answers every A query with ip
+type staticExchanger struct {
+	ip net.IP
+}
+
+func (e *staticExchanger) ExchangeContext(_ context.Context, m *D.Msg) (*D.Msg, error) {
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	msg.Answer = append(msg.Answer, &D.A{
+		Hdr: D.RR_Header{Name: m.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+		A:   e.ip,
+	})
+	return msg, nil
+}
+
+func newHijackTunnel(t *testing.T, hijack ...string) Tunnel {
+	addrs, err := ParseDNSHijack(hijack)
+	require.NoError(t, err)
+	tun := New()
+	tun.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+	tun.SetDNSHijack(addrs, &staticExchanger{ip: net.IPv4(1, 2, 3, 4)})
+	return tun
+}
+
+// hijackedUDPSession returns a session to dst whose replies are sent to replies
+func hijackedUDPSession(dst *net.UDPAddr, replies chan<- []byte) *adapter.PacketConn {
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	return adapter.NewPacketConn(&M.Metadata{}, dst, source, adapter.WriteBackFunc(func(b []byte, _ net.Addr) (int, error) {
+		replies <- append([]byte(nil), b...)
+		return len(b), nil
+	}), adapter.WithDstAddr(dst))
+}
+
+func TestParseDNSHijack(t *testing.T) {
+	hijack, err := ParseDNSHijack([]string{"any:53", "udp://198.18.0.2:53", "tcp://[::ffff:8.8.8.8]:5353"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.AddrPort{
+		netip.AddrPortFrom(netip.Addr{}, 53),
+		netip.MustParseAddrPort("198.18.0.2:53"),
+		netip.MustParseAddrPort("8.8.8.8:5353"),
+	}, hijack)
+
+	for _, addr := range []string{"any", "example.com:53", "any:70000"} {
+		_, err := ParseDNSHijack([]string{addr})
+		assert.Error(t, err, addr)
+	}
+}
+
+func TestHijackUDPDNS(t *testing.T) {
+	tun := newHijackTunnel(t, "198.18.0.2:53")
+	replies := make(chan []byte, 1)
+	conn := hijackedUDPSession(&net.UDPAddr{IP: net.IPv4(198, 18, 0, 2), Port: 53}, replies)
+	defer conn.Close()
+	tun.HandleUDP(conn)
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+	b, err := query.Pack()
+	require.NoError(t, err)
+	conn.Deliver(b)
+
+	select {
+	case b := <-replies:
+		msg := &D.Msg{}
+		require.NoError(t, msg.Unpack(b))
+		assert.Equal(t, query.Id, msg.Id)
+		require.Len(t, msg.Answer, 1)
+		assert.Equal(t, "1.2.3.4", msg.Answer[0].(*D.A).A.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("query was not answered")
+	}
+}
+
+func TestHijackTCPDNS(t *testing.T) {
+	tun := newHijackTunnel(t, "any:53")
+	client, server := net.Pipe()
+	defer client.Close()
+	tun.HandleTCP(adapter.NewTCPConn(server, &M.Metadata{},
+		adapter.WithDstAddr(&net.TCPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53})))
+
+	dc := &D.Conn{Conn: client}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	for range 2 {
+		query := &D.Msg{}
+		query.SetQuestion("example.com.", D.TypeA)
+		require.NoError(t, dc.WriteMsg(query))
+		msg, err := dc.ReadMsg()
+		require.NoError(t, err)
+		assert.Equal(t, query.Id, msg.Id)
+		require.Len(t, msg.Answer, 1)
+	}
+}
+
+func TestHijackUDPDNSDoesNotBlockWorkers(t *testing.T) {
+	echo := testutil.StartUDPEcho(t)
+	tun := newHijackTunnel(t, "any:53")
+
+	replies := make(chan []byte, 1)
+	conn := hijackedUDPSession(echo, replies)
+	defer conn.Close()
+	done := make(chan struct{})
+	go func() {
+		// idle hijacked sessions outnumbering the UDP workers come first
+		for range max(4, runtime.GOMAXPROCS(0)) + 1 {
+			hijacked := hijackedUDPSession(&net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}, make(chan []byte, 1))
+			defer hijacked.Close()
+			tun.HandleUDP(hijacked)
+		}
+		tun.HandleUDP(conn)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hijacked sessions block the UDP workers")
+	}
+	conn.Deliver([]byte("ping"))
+	select {
+	case b := <-replies:
+		assert.Equal(t, "ping", string(b))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+}
@@ -0,0 +1,28 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// dialTimeout is how long the tunnel waits for an outbound connection
+	dialTimeout = 5 * time.Second
+	// maxUDPPacketSize is the largest UDP payload relayed by the tunnel
+	maxUDPPacketSize = 65535
+)
+
+// relay copies data between leftConn and rightConn in both directions until either side is done
+func relay(leftConn, rightConn net.Conn) {
+	ch := make(chan struct{})
+	go func() {
+		io.Copy(leftConn, rightConn)
+		leftConn.SetReadDeadline(time.Now())
+		close(ch)
+	}()
+
+	io.Copy(rightConn, leftConn)
+	rightConn.SetReadDeadline(time.Now())
+	<-ch
+}
@@ -1,7 +1,44 @@
 package tunnel
 
-import "github.com/lumavpn/luma/adapter"
+import (
+	"context"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/log"
+)
 
 func (t *tunnel) handleTCPConn(originConn adapter.TCPConn) {
+	metadata := originConn.Metadata()
+	if handler := t.shouldHijackDNS(metadata); handler != nil {
+		hijackTCPDNS(originConn, handler)
+		return
+	}
+	if err := t.preHandleMetadata(metadata); err != nil {
+		log.Debugf("[Metadata PreHandle] error: %s", err)
+		originConn.Close()
+		return
+	}
+
+	if dispatcher := t.snifferDispatcher(); dispatcher != nil {
+		originConn = dispatcher.TCPSniff(originConn, metadata)
+	}
+	defer originConn.Close()
+
+	proxy, err := t.resolveProxy(metadata)
+	if err != nil {
+		log.Warnf("[TCP] %s --> %s: %v", metadata.SourceAddress(), metadata.RemoteAddress(), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	remoteConn, err := proxy.DialContext(ctx, metadata)
+	if err != nil {
+		log.Warnf("[TCP] dial %s --> %s using %s: %v", metadata.SourceAddress(), metadata.RemoteAddress(), proxy.Name(), err)
+		return
+	}
+	defer remoteConn.Close()
 
+	log.Infof("[TCP] %s --> %s using %s", metadata.SourceAddress(), metadata.RemoteAddress(), proxy.Name())
+	relay(originConn, remoteConn)
 }
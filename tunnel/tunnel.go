@@ -1,22 +1,44 @@
 package tunnel
 
 import (
+	"fmt"
 	"net/netip"
 	"runtime"
+	"sync"
 
 	"github.com/lumavpn/luma/adapter"
 	"github.com/lumavpn/luma/common/atomic"
+	"github.com/lumavpn/luma/dns"
+	"github.com/lumavpn/luma/dns/fakeip"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy"
+	"github.com/lumavpn/luma/sniffer"
 )
 
 type tunnel struct {
 	fakeIPRange netip.Prefix
+	fakeIPPool  *fakeip.Pool
+	dnsHijack   []netip.AddrPort
+	dnsHandler  dns.Exchanger
+	sniffer     *sniffer.Dispatcher
+	proxies     map[string]proxy.Proxy
 	status      atomic.TypedValue[TunnelStatus]
 	tcpQueue    chan adapter.TCPConn
 	udpQueue    chan adapter.UDPConn
+
+	mu sync.RWMutex
 }
 
 type Tunnel interface {
 	adapter.TransportHandler
+	// SetFakeIPPool sets the pool used to map fake IPs back to domains. A nil pool disables the mapping
+	SetFakeIPPool(*fakeip.Pool)
+	// SetDNSHijack answers DNS flows to any of the given addresses with handler instead of forwarding them
+	SetDNSHijack([]netip.AddrPort, dns.Exchanger)
+	// SetSniffer sets the dispatcher used to sniff the domain of new connections. A nil dispatcher disables sniffing
+	SetSniffer(*sniffer.Dispatcher)
+	// SetProxies sets the proxies connections are forwarded through
+	SetProxies(map[string]proxy.Proxy)
 }
 
 // New returns a new instance of Tunnel
@@ -38,6 +60,54 @@ func (t *tunnel) HandleUDP(conn adapter.UDPConn) {
 	t.UDPIn() <- conn
 }
 
+func (t *tunnel) SetFakeIPPool(pool *fakeip.Pool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fakeIPPool = pool
+	if pool != nil {
+		t.fakeIPRange = pool.IPNet()
+	} else {
+		t.fakeIPRange = netip.Prefix{}
+	}
+}
+
+func (t *tunnel) SetSniffer(dispatcher *sniffer.Dispatcher) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sniffer = dispatcher
+}
+
+func (t *tunnel) SetProxies(proxies map[string]proxy.Proxy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.proxies = proxies
+}
+
+// resolveProxy returns the proxy the session described by metadata is forwarded through
+func (t *tunnel) resolveProxy(metadata *M.Metadata) (proxy.Proxy, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	name := metadata.SpecialProxy
+	if name == "" {
+		name = "DIRECT"
+	}
+	p, ok := t.proxies[name]
+	if !ok {
+		return nil, fmt.Errorf("proxy %s not found", name)
+	}
+	if metadata.Network == M.UDP && !p.SupportUDP() {
+		return nil, fmt.Errorf("proxy %s does not support UDP", name)
+	}
+	return p, nil
+}
+
+func (t *tunnel) snifferDispatcher() *sniffer.Dispatcher {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sniffer
+}
+
 // TCPIn return fan-in TCP queue.
 func (t *tunnel) TCPIn() chan<- adapter.TCPConn {
 	return t.tcpQueue
@@ -70,3 +140,31 @@ func (t *tunnel) process() {
 		go t.handleTCPConn(conn)
 	}
 }
+
+// preHandleMetadata validates metadata and restores the domain of connections that target a fake IP
+func (t *tunnel) preHandleMetadata(metadata *M.Metadata) error {
+	if !metadata.Valid() {
+		return fmt.Errorf("invalid metadata: %s", metadata.RemoteAddress())
+	}
+	if !metadata.Resolved() {
+		return nil
+	}
+	dstIP := metadata.DstAddr()
+
+	t.mu.RLock()
+	pool, fakeIPRange := t.fakeIPPool, t.fakeIPRange
+	t.mu.RUnlock()
+
+	if pool == nil || !fakeIPRange.Contains(dstIP) || dstIP == pool.Gateway() {
+		return nil
+	}
+	host, exist := pool.LookBack(dstIP)
+	if !exist {
+		return fmt.Errorf("fake DNS record %s missing", dstIP)
+	}
+	metadata.Host = host
+	metadata.DNSMode = M.DNSFakeIP
+	// the fake IP means nothing to the outbound, which must resolve the domain itself
+	metadata.DstIP = nil
+	return nil
+}
@@ -1,7 +1,144 @@
 package tunnel
 
-import "github.com/lumavpn/luma/adapter"
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/dns"
+	"github.com/lumavpn/luma/log"
+	M "github.com/lumavpn/luma/metadata"
+)
+
+// udpTimeout is how long a UDP session may stay idle before it is closed
+const udpTimeout = 60 * time.Second
 
 func (t *tunnel) handleUDPConn(uc adapter.UDPConn) {
+	metadata := uc.Metadata()
+	if handler := t.shouldHijackDNS(metadata); handler != nil {
+		go hijackUDPDNS(uc, handler)
+		return
+	}
+	if err := t.preHandleMetadata(metadata); err != nil {
+		log.Debugf("[Metadata PreHandle] error: %s", err)
+		uc.Close()
+		return
+	}
+
+	go func() {
+		if dispatcher := t.snifferDispatcher(); dispatcher != nil {
+			uc = dispatcher.UDPSniff(uc, metadata)
+		}
+		t.relayUDP(uc, metadata)
+	}()
+}
+
+// relayUDP forwards the packets of the session uc to its destination through the selected proxy
+func (t *tunnel) relayUDP(uc adapter.UDPConn, metadata *M.Metadata) {
+	defer uc.Close()
+
+	proxy, err := t.resolveProxy(metadata)
+	if err != nil {
+		log.Warnf("[UDP] %s --> %s: %v", metadata.SourceAddress(), metadata.RemoteAddress(), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	pc, err := proxy.ListenPacketContext(ctx, metadata)
+	if err != nil {
+		log.Warnf("[UDP] dial %s --> %s using %s: %v", metadata.SourceAddress(), metadata.RemoteAddress(), proxy.Name(), err)
+		return
+	}
+	defer pc.Close()
+
+	dstAddr, err := resolveUDPAddr(ctx, metadata)
+	if err != nil {
+		log.Warnf("[UDP] resolve %s: %v", metadata.RemoteAddress(), err)
+		return
+	}
+
+	log.Infof("[UDP] %s --> %s using %s", metadata.SourceAddress(), metadata.RemoteAddress(), proxy.Name())
+
+	// origins maps the addresses packets are sent to with the proxy to the ones the client sent
+	// them to, which replies must come from
+	var origins sync.Map
+	go func() {
+		defer uc.Close()
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			pc.SetReadDeadline(time.Now().Add(udpTimeout))
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if origin, ok := origins.Load(from.String()); ok {
+				from = origin.(net.Addr)
+			}
+			if _, err := uc.WriteTo(buf[:n], from); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the client may send packets to other destinations in the session, they go through the
+	// same proxy so that the mapping of the client does not depend on the destination
+	var sessionAddr net.Addr
+	buf := make([]byte, maxUDPPacketSize)
+	for first := true; ; first = false {
+		uc.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, addr, err := uc.ReadFrom(buf)
+		if err != nil {
+			pc.SetReadDeadline(time.Now())
+			return
+		}
+		var to net.Addr = dstAddr
+		switch {
+		case first:
+			sessionAddr = addr
+			if addr != nil {
+				origins.Store(dstAddr.String(), addr)
+			}
+		case addr != nil && sessionAddr != nil && addr.String() != sessionAddr.String():
+			if to, err = t.resolvePacketAddr(addr); err != nil {
+				log.Debugf("[UDP] %s --> %s: %v", metadata.SourceAddress(), addr, err)
+				continue
+			}
+			origins.Store(to.String(), addr)
+		}
+		if _, err := pc.WriteTo(buf[:n], to); err != nil {
+			log.Debugf("[UDP] write to %s: %v", to, err)
+		}
+	}
+}
+
+// resolvePacketAddr returns where a packet the client sent to addr must be sent, resolving
+// the domain of a fake IP
+func (t *tunnel) resolvePacketAddr(addr net.Addr) (*net.UDPAddr, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("invalid destination %s", addr)
+	}
+	metadata := &M.Metadata{Network: M.UDP, DstIP: udpAddr.IP, DstPort: uint16(udpAddr.Port)}
+	if err := t.preHandleMetadata(metadata); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	return resolveUDPAddr(ctx, metadata)
+}
 
+// resolveUDPAddr returns the destination of the UDP session metadata, resolving its host if needed
+func resolveUDPAddr(ctx context.Context, metadata *M.Metadata) (*net.UDPAddr, error) {
+	if metadata.Resolved() {
+		return metadata.UDPAddr(), nil
+	}
+	ip, err := dns.ResolveIP(ctx, metadata.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip.AsSlice(), Port: int(metadata.DstPort)}, nil
 }
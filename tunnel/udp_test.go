@@ -0,0 +1,48 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lumavpn/luma/adapter"
+	"github.com/lumavpn/luma/internal/testutil"
+	M "github.com/lumavpn/luma/metadata"
+	"github.com/lumavpn/luma/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// reply is a packet sent back to the client of a session
+type reply struct {
+	data string
+	from string
+}
+
+func TestRelayUDPDestinations(t *testing.T) {
+	first, second := testutil.StartUDPEcho(t), testutil.StartUDPEcho(t)
+	tun := New()
+	tun.SetProxies(map[string]proxy.Proxy{"DIRECT": proxy.NewDirect()})
+
+	replies := make(chan reply, 4)
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	conn := adapter.NewPacketConn(&M.Metadata{}, first, source, adapter.WriteBackFunc(func(b []byte, addr net.Addr) (int, error) {
+		replies <- reply{data: string(b), from: addr.String()}
+		return len(b), nil
+	}), adapter.WithDstAddr(first))
+	defer conn.Close()
+	tun.HandleUDP(conn)
+
+	// packets to another destination go through the session and replies come from it
+	conn.Deliver([]byte("first"))
+	conn.DeliverTo([]byte("second"), second)
+	var received []reply
+	for range 2 {
+		select {
+		case r := <-replies:
+			received = append(received, r)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply")
+		}
+	}
+	assert.ElementsMatch(t, []reply{{"first", first.String()}, {"second", second.String()}}, received)
+}